@@ -7,7 +7,14 @@ type Connection interface {
 	GetDBPwd() string
 	Write([]byte) (int, error)
 
+	GetID() int64
+	GetProtocol() int
+	SetProtocol(int)
+	GetName() string
+	SetName(string)
+
 	IsClosed() bool
+	Done() <-chan struct{}
 	Subscribe(channel string)
 	UnSubscribe(channel string)
 	SubscribeCount() int
@@ -15,6 +22,8 @@ type Connection interface {
 
 	IsTransaction() bool
 	SetTransaction(bool)
+	IsTxRollback() bool
+	SetTxRollback(bool)
 
 	EnqueueCmd([][]byte)
 	GetQueueCmd() [][][]byte
@@ -23,5 +32,5 @@ type Connection interface {
 	CleanWatchKey()
 
 	AddTxErr(error)
-	GetTxErr(error)
+	GetTxErr() []error
 }
@@ -1,12 +1,14 @@
 package abs
 
 import (
+	"github.com/issimo1/redis-issimo/engine/payload"
 	"github.com/issimo1/redis-issimo/redis/protocol"
 	"time"
 )
 
 type Engine interface {
 	Exec(Connection, [][]byte) protocol.Reply
-	ForEach(int, func(key string, data string, expiration *time.Time) bool)
+	ForEach(int, func(key string, data *payload.DataEntity, expiration *time.Time) bool)
+	AfterClientClose(Connection)
 	Close()
 }
@@ -0,0 +1,12 @@
+package abs
+
+import (
+	"context"
+	"net"
+)
+
+// Handler serves a single accepted tcp connection until it is closed
+type Handler interface {
+	Handle(ctx context.Context, conn net.Conn)
+	Close() error
+}
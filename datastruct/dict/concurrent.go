@@ -2,6 +2,7 @@ package dict
 
 import (
 	"github.com/issimo1/redis-issimo/utils"
+	"math/rand"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -57,8 +58,8 @@ func (c *ConcurrentDict) getShard(key string) *shard {
 
 func (c *ConcurrentDict) AddVersion(key string, delta int64) (val interface{}, exist bool) {
 	shard := c.getShard(key)
-	shard.mu.RLock()
-	defer shard.mu.RUnlock()
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 	val, exist = shard.m[key]
 	if !exist {
 		shard.m[key] = delta
@@ -106,8 +107,8 @@ func (c *ConcurrentDict) subCount() {
 
 func (c *ConcurrentDict) Delete(key string) (interface{}, int) {
 	shd := c.getShard(key)
-	shd.mu.RLock()
-	defer shd.mu.RUnlock()
+	shd.mu.Lock()
+	defer shd.mu.Unlock()
 
 	if val, ok := shd.m[key]; ok {
 		delete(shd.m, key)
@@ -164,8 +165,8 @@ func (c *ConcurrentDict) PutIfAbsent(key string, val interface{}) int {
 	return 1
 }
 
-// PutIfAbsentWithLock 保存key( only insert)
-func (c *ConcurrentDict) PutIfAbsentWithLock(key string, val interface{}) int {
+// PutIfAbsentWithoutLock 保存key( only insert)
+func (c *ConcurrentDict) PutIfAbsentWithoutLock(key string, val interface{}) int {
 	shd := c.getShard(key)
 	if _, ok := shd.m[key]; ok {
 		return 0
@@ -208,6 +209,73 @@ func (c *ConcurrentDict) ForEach(consumer Consumer) {
 	}
 }
 
+// ForEachWithoutLock traverses the dict, the caller must hold the locks of every shard
+func (c *ConcurrentDict) ForEachWithoutLock(consumer Consumer) {
+	for _, sh := range c.shards {
+		for k, v := range sh.m {
+			if !consumer(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Keys returns all keys
+func (c *ConcurrentDict) Keys() []string {
+	keys := make([]string, 0, c.Count())
+	c.ForEach(func(key string, val interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// RandomKeyWithoutLock returns a random key, the caller must hold the locks of every shard
+func (c *ConcurrentDict) RandomKeyWithoutLock() (string, bool) {
+	if c.Count() == 0 {
+		return "", false
+	}
+	start := rand.Intn(len(c.shards))
+	for i := 0; i < len(c.shards); i++ {
+		sh := c.shards[(start+i)%len(c.shards)]
+		for key := range sh.m {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// ClearWithoutLock removes all keys, the caller must hold the write locks of every shard
+func (c *ConcurrentDict) ClearWithoutLock() {
+	for _, sh := range c.shards {
+		if len(sh.m) > 0 {
+			sh.m = make(map[string]interface{})
+		}
+	}
+	c.count.Store(0)
+}
+
+// LockAll locks every shard, write locks if write is true
+func (c *ConcurrentDict) LockAll(write bool) {
+	for _, sh := range c.shards {
+		if write {
+			sh.mu.Lock()
+		} else {
+			sh.mu.RLock()
+		}
+	}
+}
+
+func (c *ConcurrentDict) UnLockAll(write bool) {
+	for _, sh := range c.shards {
+		if write {
+			sh.mu.Unlock()
+		} else {
+			sh.mu.RUnlock()
+		}
+	}
+}
+
 // RWLock add only write lock
 func (c *ConcurrentDict) RWLock(readKeys, writeKeys []string) {
 	keys := append(readKeys, writeKeys...)
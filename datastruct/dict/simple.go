@@ -0,0 +1,209 @@
+package dict
+
+import (
+	"math/bits"
+	"math/rand"
+
+	"github.com/issimo1/redis-issimo/utils"
+)
+
+const (
+	simpleDictMinSize = 4
+	// simpleDictMinFill shrinks the table once it is filled less than 1/simpleDictMinFill
+	simpleDictMinFill = 8
+)
+
+// SimpleDict is a hash table for a single goroutine. Unlike a map, it supports picking random keys
+// and the cursor based Scan of redis, which returns every key that exists during the whole scan.
+type SimpleDict struct {
+	buckets []*entry
+	count   int
+}
+
+type entry struct {
+	key  string
+	val  interface{}
+	next *entry
+}
+
+func NewSimple() *SimpleDict {
+	return &SimpleDict{buckets: make([]*entry, simpleDictMinSize)}
+}
+
+func (d *SimpleDict) mask() uint64 {
+	return uint64(len(d.buckets) - 1)
+}
+
+func (d *SimpleDict) bucket(key string) int {
+	return int(uint64(utils.Fnv32(key)) & d.mask())
+}
+
+func (d *SimpleDict) find(key string) *entry {
+	for e := d.buckets[d.bucket(key)]; e != nil; e = e.next {
+		if e.key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+// resize moves every entry to a table of size buckets, size must be a power of 2
+func (d *SimpleDict) resize(size int) {
+	old := d.buckets
+	d.buckets = make([]*entry, size)
+	for _, e := range old {
+		for e != nil {
+			next := e.next
+			idx := d.bucket(e.key)
+			e.next = d.buckets[idx]
+			d.buckets[idx] = e
+			e = next
+		}
+	}
+}
+
+func (d *SimpleDict) Len() int {
+	return d.count
+}
+
+func (d *SimpleDict) Get(key string) (val interface{}, exists bool) {
+	e := d.find(key)
+	if e == nil {
+		return nil, false
+	}
+	return e.val, true
+}
+
+// Put binds val to key, returns 1 if key is new
+func (d *SimpleDict) Put(key string, val interface{}) int {
+	if e := d.find(key); e != nil {
+		e.val = val
+		return 0
+	}
+	if d.count >= len(d.buckets) {
+		d.resize(2 * len(d.buckets))
+	}
+	idx := d.bucket(key)
+	d.buckets[idx] = &entry{key: key, val: val, next: d.buckets[idx]}
+	d.count++
+	return 1
+}
+
+// PutIfAbsent binds val to key only if key does not exist, returns 1 if val is put
+func (d *SimpleDict) PutIfAbsent(key string, val interface{}) int {
+	if d.find(key) != nil {
+		return 0
+	}
+	return d.Put(key, val)
+}
+
+// Remove deletes key, returns the removed value and 1 if key exists
+func (d *SimpleDict) Remove(key string) (val interface{}, result int) {
+	idx := d.bucket(key)
+	for p := &d.buckets[idx]; *p != nil; p = &(*p).next {
+		if e := *p; e.key == key {
+			*p = e.next
+			d.count--
+			if len(d.buckets) > simpleDictMinSize && d.count*simpleDictMinFill < len(d.buckets) {
+				d.resize(len(d.buckets) / 2)
+			}
+			return e.val, 1
+		}
+	}
+	return nil, 0
+}
+
+func (d *SimpleDict) ForEach(consumer Consumer) {
+	for _, e := range d.buckets {
+		for ; e != nil; e = e.next {
+			if !consumer(e.key, e.val) {
+				return
+			}
+		}
+	}
+}
+
+func (d *SimpleDict) Keys() []string {
+	keys := make([]string, 0, d.count)
+	d.ForEach(func(key string, val interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// RandomKey returns a random key, a key in a longer chain is a bit less likely to be chosen as redis does
+func (d *SimpleDict) RandomKey() (string, bool) {
+	if d.count == 0 {
+		return "", false
+	}
+	var head *entry
+	for head == nil {
+		head = d.buckets[rand.Intn(len(d.buckets))]
+	}
+	length := 0
+	for e := head; e != nil; e = e.next {
+		length++
+	}
+	e := head
+	for i := rand.Intn(length); i > 0; i-- {
+		e = e.next
+	}
+	return e.key, true
+}
+
+// RandomKeys returns count random keys which may repeat
+func (d *SimpleDict) RandomKeys(count int) []string {
+	if d.count == 0 {
+		return nil
+	}
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i], _ = d.RandomKey()
+	}
+	return keys
+}
+
+// RandomDistinctKeys returns at most count distinct random keys
+func (d *SimpleDict) RandomDistinctKeys(count int) []string {
+	if count >= d.count {
+		keys := d.Keys()
+		rand.Shuffle(len(keys), func(i, j int) {
+			keys[i], keys[j] = keys[j], keys[i]
+		})
+		return keys
+	}
+	picked := make(map[string]struct{}, count)
+	keys := make([]string, 0, count)
+	for len(keys) < count {
+		key, _ := d.RandomKey()
+		if _, ok := picked[key]; !ok {
+			picked[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Scan visits the buckets from cursor until about count keys are visited, and returns the cursor of the next call,
+// 0 means the scan is finished. The cursor is increased on its reversed bits, so the buckets visited before a
+// resize map to buckets which are visited before too, and no key existing during the whole scan is missed.
+func (d *SimpleDict) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	if d.count == 0 {
+		return 0
+	}
+	mask := d.mask()
+	visited := 0
+	for {
+		for e := d.buckets[cursor&mask]; e != nil; e = e.next {
+			consumer(e.key, e.val)
+			visited++
+		}
+		// increase the reversed cursor
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+		if cursor == 0 || visited >= count {
+			return cursor
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package geohash
+
+import (
+	"math"
+)
+
+// Points are encoded the way redis encodes them: the longitude and the latitude are scaled to 26 bits each
+// and interleaved into a 52 bits integer, with the latitude on the even bits. The integer is exact as a float64
+// and so is used as the score of a sorted set.
+const (
+	// StepMax is the number of bits of each coordinate
+	StepMax = 26
+
+	LongitudeMin = -180.0
+	LongitudeMax = 180.0
+	// LatitudeMin and LatitudeMax are the limits of the EPSG:3857 projection
+	LatitudeMin = -85.05112878
+	LatitudeMax = 85.05112878
+
+	// EarthRadius is the radius in meters used for distances
+	EarthRadius = 6372797.560856
+	// mercatorMax is the half width of the EPSG:3857 projection in meters
+	mercatorMax = 20037726.37
+
+	base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+type coordRange struct {
+	min, max float64
+}
+
+var (
+	longitudeRange = coordRange{LongitudeMin, LongitudeMax}
+	latitudeRange  = coordRange{LatitudeMin, LatitudeMax}
+)
+
+// Hash is a cell of the grid dividing each coordinate into 2^Step parts
+type Hash struct {
+	Bits uint64
+	Step uint
+}
+
+func (h Hash) isZero() bool {
+	return h.Bits == 0 && h.Step == 0
+}
+
+// ScoreRange returns the scores [min, max) of the points within the cell
+func (h Hash) ScoreRange() (min, max uint64) {
+	shift := 2 * (StepMax - h.Step)
+	return h.Bits << shift, (h.Bits + 1) << shift
+}
+
+// area is a cell in coordinates
+type area struct {
+	longitude, latitude coordRange
+}
+
+// spread moves the bits of a 32 bits integer to the even bits of a 64 bits integer
+func spread(x uint32) uint64 {
+	v := uint64(x)
+	v = (v | v<<16) & 0x0000ffff0000ffff
+	v = (v | v<<8) & 0x00ff00ff00ff00ff
+	v = (v | v<<4) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+// squash is the reverse of spread, it collects the even bits
+func squash(v uint64) uint32 {
+	v &= 0x5555555555555555
+	v = (v | v>>1) & 0x3333333333333333
+	v = (v | v>>2) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v>>4) & 0x00ff00ff00ff00ff
+	v = (v | v>>8) & 0x0000ffff0000ffff
+	v = (v | v>>16) & 0x00000000ffffffff
+	return uint32(v)
+}
+
+func encode(lonRange, latRange coordRange, longitude, latitude float64, step uint) (Hash, bool) {
+	if longitude > LongitudeMax || longitude < LongitudeMin || latitude > LatitudeMax || latitude < LatitudeMin {
+		return Hash{}, false
+	}
+	if latitude < latRange.min || latitude > latRange.max || longitude < lonRange.min || longitude > lonRange.max {
+		return Hash{}, false
+	}
+	latOffset := (latitude - latRange.min) / (latRange.max - latRange.min)
+	lonOffset := (longitude - lonRange.min) / (lonRange.max - lonRange.min)
+	latOffset *= float64(uint64(1) << step)
+	lonOffset *= float64(uint64(1) << step)
+	return Hash{Bits: spread(uint32(latOffset)) | spread(uint32(lonOffset))<<1, Step: step}, true
+}
+
+func decode(lonRange, latRange coordRange, h Hash) area {
+	lat := squash(h.Bits)
+	lon := squash(h.Bits >> 1)
+	cells := float64(uint64(1) << h.Step)
+	latScale := latRange.max - latRange.min
+	lonScale := lonRange.max - lonRange.min
+	return area{
+		latitude: coordRange{
+			min: latRange.min + float64(lat)/cells*latScale,
+			max: latRange.min + float64(lat+1)/cells*latScale,
+		},
+		longitude: coordRange{
+			min: lonRange.min + float64(lon)/cells*lonScale,
+			max: lonRange.min + float64(lon+1)/cells*lonScale,
+		},
+	}
+}
+
+// Encode returns the 52 bits hash of a point, ok is false if the point is out of the supported range
+func Encode(longitude, latitude float64) (uint64, bool) {
+	h, ok := encode(longitudeRange, latitudeRange, longitude, latitude, StepMax)
+	return h.Bits, ok
+}
+
+// Decode returns the center of the cell of a 52 bits hash
+func Decode(bits uint64) (longitude, latitude float64) {
+	a := decode(longitudeRange, latitudeRange, Hash{Bits: bits, Step: StepMax})
+	longitude = min(max((a.longitude.min+a.longitude.max)/2, LongitudeMin), LongitudeMax)
+	latitude = min(max((a.latitude.min+a.latitude.max)/2, LatitudeMin), LatitudeMax)
+	return longitude, latitude
+}
+
+// ToString returns the standard 11 characters geohash of a 52 bits hash, whose latitude ranges in [-90, 90]
+func ToString(bits uint64) string {
+	longitude, latitude := Decode(bits)
+	h, _ := encode(coordRange{-180, 180}, coordRange{-90, 90}, longitude, latitude, StepMax)
+	buf := make([]byte, 11)
+	for i := range buf {
+		// the last character lacks 3 bits
+		idx := 0
+		if i < 10 {
+			idx = int(h.Bits >> (52 - (i+1)*5) & 0x1f)
+		}
+		buf[i] = base32[idx]
+	}
+	return string(buf)
+}
+
+func degToRad(deg float64) float64 {
+	return deg * (math.Pi / 180)
+}
+
+func radToDeg(rad float64) float64 {
+	return rad / (math.Pi / 180)
+}
+
+// LatitudeDistance returns the distance in meters between two latitudes on a meridian
+func LatitudeDistance(lat1, lat2 float64) float64 {
+	return EarthRadius * math.Abs(degToRad(lat2)-degToRad(lat1))
+}
+
+// Distance returns the haversine distance in meters between two points
+func Distance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lon1r := degToRad(lat1), degToRad(lon1)
+	lat2r, lon2r := degToRad(lat2), degToRad(lon2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	// the points are on the same meridian
+	if v == 0 {
+		return LatitudeDistance(lat1, lat2)
+	}
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * EarthRadius * math.Asin(math.Sqrt(a))
+}
+
+// Shape is an area to search, whose sizes are in meters
+type Shape interface {
+	// Center returns the longitude and the latitude of the center
+	Center() (float64, float64)
+	// Contains returns the distance of a point to the center, ok is false if the point is outside
+	Contains(longitude, latitude float64) (distance float64, ok bool)
+	// halfSizes returns the half width and the half height
+	halfSizes() (float64, float64)
+	// radius returns the distance from the center to the farthest point
+	radius() float64
+}
+
+// Circle is a shape given by a radius in meters
+type Circle struct {
+	Longitude, Latitude, Radius float64
+}
+
+func (c *Circle) Center() (float64, float64) {
+	return c.Longitude, c.Latitude
+}
+
+func (c *Circle) Contains(longitude, latitude float64) (float64, bool) {
+	distance := Distance(c.Longitude, c.Latitude, longitude, latitude)
+	return distance, distance <= c.Radius
+}
+
+func (c *Circle) halfSizes() (float64, float64) {
+	return c.Radius, c.Radius
+}
+
+func (c *Circle) radius() float64 {
+	return c.Radius
+}
+
+// Box is a shape given by a width and a height in meters
+type Box struct {
+	Longitude, Latitude, Width, Height float64
+}
+
+func (b *Box) Center() (float64, float64) {
+	return b.Longitude, b.Latitude
+}
+
+func (b *Box) Contains(longitude, latitude float64) (float64, bool) {
+	// the latitude distance is cheaper, so it is checked first
+	if LatitudeDistance(latitude, b.Latitude) > b.Height/2 {
+		return 0, false
+	}
+	if Distance(longitude, latitude, b.Longitude, latitude) > b.Width/2 {
+		return 0, false
+	}
+	return Distance(b.Longitude, b.Latitude, longitude, latitude), true
+}
+
+func (b *Box) halfSizes() (float64, float64) {
+	return b.Width / 2, b.Height / 2
+}
+
+func (b *Box) radius() float64 {
+	return math.Sqrt(b.Width*b.Width/4 + b.Height*b.Height/4)
+}
+
+// boundingBox returns the min longitude, min latitude, max longitude and max latitude of s
+func boundingBox(s Shape) (float64, float64, float64, float64) {
+	longitude, latitude := s.Center()
+	halfWidth, halfHeight := s.halfSizes()
+	latDelta := radToDeg(halfHeight / EarthRadius)
+	lonDeltaTop := radToDeg(halfWidth / EarthRadius / math.Cos(degToRad(latitude+latDelta)))
+	lonDeltaBottom := radToDeg(halfWidth / EarthRadius / math.Cos(degToRad(latitude-latDelta)))
+	// the widest side is toward the equator
+	lonDelta := lonDeltaTop
+	if latitude < 0 {
+		lonDelta = lonDeltaBottom
+	}
+	return longitude - lonDelta, latitude - latDelta, longitude + lonDelta, latitude + latDelta
+}
+
+// estimateStep returns the step whose cells are large enough for the 9 cells around a point to cover radius
+func estimateStep(radius, latitude float64) uint {
+	if radius == 0 {
+		return StepMax
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	// make sure the radius is included in most of the base cases
+	step -= 2
+	// the cells are narrower toward the poles
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), StepMax))
+}
+
+func moveX(h Hash, d int) Hash {
+	x := h.Bits & 0xaaaaaaaaaaaaaaaa
+	y := h.Bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - h.Step*2)
+	if d > 0 {
+		x += zz + 1
+	} else {
+		x |= zz
+		x -= zz + 1
+	}
+	x &= 0xaaaaaaaaaaaaaaaa >> (64 - h.Step*2)
+	return Hash{Bits: x | y, Step: h.Step}
+}
+
+func moveY(h Hash, d int) Hash {
+	x := h.Bits & 0xaaaaaaaaaaaaaaaa
+	y := h.Bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - h.Step*2)
+	if d > 0 {
+		y += zz + 1
+	} else {
+		y |= zz
+		y -= zz + 1
+	}
+	y &= 0x5555555555555555 >> (64 - h.Step*2)
+	return Hash{Bits: x | y, Step: h.Step}
+}
+
+// neighbors returns the cell of h followed by the north, south, east, west, north east, north west,
+// south east and south west cells
+func neighbors(h Hash) []Hash {
+	return []Hash{
+		h,
+		moveY(h, 1),
+		moveY(h, -1),
+		moveX(h, 1),
+		moveX(h, -1),
+		moveY(moveX(h, 1), 1),
+		moveY(moveX(h, -1), 1),
+		moveY(moveX(h, 1), -1),
+		moveY(moveX(h, -1), -1),
+	}
+}
+
+// SearchCells returns the cells covering s, the points within them are to be checked by s.Contains
+func SearchCells(s Shape) []Hash {
+	minLon, minLat, maxLon, maxLat := boundingBox(s)
+	longitude, latitude := s.Center()
+	step := estimateStep(s.radius(), latitude)
+	h, _ := encode(longitudeRange, latitudeRange, longitude, latitude, step)
+	cells := neighbors(h)
+
+	// the step may be too large if the shape is near the edge of the cell
+	north := decode(longitudeRange, latitudeRange, cells[1])
+	south := decode(longitudeRange, latitudeRange, cells[2])
+	east := decode(longitudeRange, latitudeRange, cells[3])
+	west := decode(longitudeRange, latitudeRange, cells[4])
+	if step > 1 && (north.latitude.max < maxLat || south.latitude.min > minLat ||
+		east.longitude.max < maxLon || west.longitude.min > minLon) {
+		step--
+		h, _ = encode(longitudeRange, latitudeRange, longitude, latitude, step)
+		cells = neighbors(h)
+	}
+
+	// the cells out of the bounding box are useless
+	if step >= 2 {
+		a := decode(longitudeRange, latitudeRange, h)
+		exclude := func(indexes ...int) {
+			for _, i := range indexes {
+				cells[i] = Hash{}
+			}
+		}
+		if a.latitude.min < minLat {
+			exclude(2, 7, 8)
+		}
+		if a.latitude.max > maxLat {
+			exclude(1, 5, 6)
+		}
+		if a.longitude.min < minLon {
+			exclude(4, 8, 6)
+		}
+		if a.longitude.max > maxLon {
+			exclude(3, 7, 5)
+		}
+	}
+
+	result := make([]Hash, 0, len(cells))
+	for _, cell := range cells {
+		if cell.isZero() {
+			continue
+		}
+		// adjacent cells may be the same for a huge radius
+		if len(result) > 0 && result[len(result)-1] == cell {
+			continue
+		}
+		result = append(result, cell)
+	}
+	return result
+}
@@ -0,0 +1,105 @@
+package geohash
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		longitude, latitude float64
+		bits                uint64
+		str                 string
+	}{
+		{13.361389, 38.115556, 3479099956230698, "sqc8b49rny0"},
+		{15.087269, 37.502669, 3479447370796909, "sqdtr74hyu0"},
+	}
+	for _, tt := range tests {
+		bits, ok := Encode(tt.longitude, tt.latitude)
+		if !ok || bits != tt.bits {
+			t.Errorf("Encode(%v, %v) = %d, want %d", tt.longitude, tt.latitude, bits, tt.bits)
+		}
+		if s := ToString(bits); s != tt.str {
+			t.Errorf("ToString(%d) = %s, want %s", bits, s, tt.str)
+		}
+	}
+	longitude, latitude := Decode(3479099956230698)
+	if s := fmt.Sprintf("%.17f %.17f", longitude, latitude); s != "13.36138933897018433 38.11555639549629859" {
+		t.Errorf("Decode returns %s", s)
+	}
+	for _, point := range [][2]float64{{181, 0}, {0, 85.06}, {-180.1, 0}, {0, -86}} {
+		if _, ok := Encode(point[0], point[1]); ok {
+			t.Errorf("Encode(%v, %v) is ok", point[0], point[1])
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		longitude := rand.Float64()*360 - 180
+		latitude := rand.Float64()*2*LatitudeMax - LatitudeMax
+		bits, ok := Encode(longitude, latitude)
+		if !ok {
+			t.Fatalf("Encode(%v, %v) fails", longitude, latitude)
+		}
+		lon, lat := Decode(bits)
+		// the cells of 26 bits are smaller than one meter
+		if d := Distance(longitude, latitude, lon, lat); d > 1 {
+			t.Fatalf("(%v, %v) is decoded as (%v, %v)", longitude, latitude, lon, lat)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	// redis measures the distance between the stored points
+	d := Distance(13.36138933897018433, 38.11555639549629859, 15.08726745843887329, 37.50266842333162032)
+	if math.Abs(d-166274.1516) > 0.01 {
+		t.Errorf("got distance %.4f", d)
+	}
+	if d := Distance(10, 20, 10, 21); d != LatitudeDistance(20, 21) {
+		t.Errorf("got distance %.4f on a meridian", d)
+	}
+}
+
+// searchPoints returns the points found by scanning the cells of s
+func searchPoints(s Shape, points map[uint64]bool) map[uint64]bool {
+	found := make(map[uint64]bool)
+	for _, cell := range SearchCells(s) {
+		lo, hi := cell.ScoreRange()
+		for bits := range points {
+			if bits < lo || bits >= hi {
+				continue
+			}
+			if _, ok := s.Contains(Decode(bits)); ok {
+				found[bits] = true
+			}
+		}
+	}
+	return found
+}
+
+func TestSearchCells(t *testing.T) {
+	points := make(map[uint64]bool)
+	for i := 0; i < 5000; i++ {
+		bits, _ := Encode(rand.Float64()*40-20, rand.Float64()*40-20)
+		points[bits] = true
+	}
+	shapes := []Shape{
+		&Circle{Longitude: 0, Latitude: 0, Radius: 500000},
+		&Circle{Longitude: 5.5, Latitude: -3.2, Radius: 1000},
+		&Circle{Longitude: -10, Latitude: 10, Radius: 2000000},
+		&Box{Longitude: 3, Latitude: 4, Width: 800000, Height: 300000},
+		&Box{Longitude: -15, Latitude: -15, Width: 100000, Height: 900000},
+	}
+	for _, s := range shapes {
+		found := searchPoints(s, points)
+		for bits := range points {
+			_, ok := s.Contains(Decode(bits))
+			if ok != found[bits] {
+				t.Fatalf("point %d is in %+v: %v, found: %v", bits, s, ok, found[bits])
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package hash
+
+import (
+	"time"
+
+	"github.com/issimo1/redis-issimo/datastruct/dict"
+)
+
+// Dict is a Hash backed by a hash table, it is used once a hash is too large for ListPack
+type Dict struct {
+	fieldTTL
+	m *dict.SimpleDict
+}
+
+func NewDict() *Dict {
+	return &Dict{m: dict.NewSimple()}
+}
+
+func (d *Dict) Get(field string) ([]byte, bool) {
+	val, ok := d.m.Get(field)
+	if !ok || d.isExpired(field, clock()) {
+		return nil, false
+	}
+	return val.([]byte), true
+}
+
+func (d *Dict) Set(field string, val []byte) int {
+	result := d.m.Put(field, val)
+	if d.isExpired(field, clock()) {
+		result = 1
+	}
+	delete(d.expires, field)
+	return result
+}
+
+func (d *Dict) Delete(field string) int {
+	_, result := d.m.Remove(field)
+	if d.isExpired(field, clock()) {
+		result = 0
+	}
+	delete(d.expires, field)
+	return result
+}
+
+func (d *Dict) Len() int {
+	return d.m.Len() - d.expiredCount()
+}
+
+func (d *Dict) ForEach(consumer Consumer) {
+	consumer = d.filter(consumer)
+	d.m.ForEach(func(key string, val interface{}) bool {
+		return consumer(key, val.([]byte))
+	})
+}
+
+// liveCount returns the number of fields which are not expired at now
+func (d *Dict) liveCount(now time.Time) int {
+	return d.m.Len() - len(d.expired(now))
+}
+
+// randomField returns a random field which is not expired at now, some field must be alive at now
+func (d *Dict) randomField(now time.Time) string {
+	for {
+		field, _ := d.m.RandomKey()
+		if !d.isExpired(field, now) {
+			return field
+		}
+	}
+}
+
+func (d *Dict) RandomFields(count int) []string {
+	if len(d.expires) == 0 {
+		return d.m.RandomKeys(count)
+	}
+	// the live fields are counted and sampled at the same now, or the sampling may never end
+	// once they expire in between
+	now := clock()
+	if d.liveCount(now) == 0 {
+		return nil
+	}
+	fields := make([]string, count)
+	for i := range fields {
+		fields[i] = d.randomField(now)
+	}
+	return fields
+}
+
+func (d *Dict) RandomDistinctFields(count int) []string {
+	if len(d.expires) == 0 {
+		return d.m.RandomDistinctKeys(count)
+	}
+	// live fields are counted and picked at the same now, so the loop below ends while fields expire
+	now := clock()
+	if live := d.liveCount(now); count >= live {
+		fields := make([]string, 0, live)
+		d.m.ForEach(func(field string, val interface{}) bool {
+			if !d.isExpired(field, now) {
+				fields = append(fields, field)
+			}
+			return true
+		})
+		return fields
+	}
+	picked := make(map[string]struct{}, count)
+	fields := make([]string, 0, count)
+	for len(fields) < count {
+		field := d.randomField(now)
+		if _, ok := picked[field]; !ok {
+			picked[field] = struct{}{}
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func (d *Dict) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	consumer = d.filter(consumer)
+	return d.m.Scan(cursor, count, func(key string, val interface{}) bool {
+		return consumer(key, val.([]byte))
+	})
+}
+
+func (d *Dict) Expire(field string, expireTime time.Time) bool {
+	if _, ok := d.Get(field); !ok {
+		return false
+	}
+	d.setExpire(field, expireTime)
+	return true
+}
+
+func (d *Dict) RemoveExpired(now time.Time) []string {
+	removed := d.expired(now)
+	for _, field := range removed {
+		d.m.Remove(field)
+		delete(d.expires, field)
+	}
+	return removed
+}
@@ -0,0 +1,123 @@
+package hash
+
+import (
+	"time"
+)
+
+type Consumer func(field string, val []byte) bool
+
+// Hash is the field-value collection used by the hash commands.
+// The values returned must not be modified, and the values given are kept without copying.
+// A field which has passed its expire time is treated as absent, until RemoveExpired deletes it.
+type Hash interface {
+	Get(field string) ([]byte, bool)
+	// Set binds val to field and removes the ttl of field, returns 1 if field is new
+	Set(field string, val []byte) int
+	// Delete removes field, returns 1 if field exists
+	Delete(field string) int
+	Len() int
+	ForEach(consumer Consumer)
+	// RandomFields returns count random fields which may repeat
+	RandomFields(count int) []string
+	// RandomDistinctFields returns at most count distinct random fields
+	RandomDistinctFields(count int) []string
+	// Scan visits about count fields from cursor, and returns the cursor of the next call, 0 means the end
+	Scan(cursor uint64, count int, consumer Consumer) uint64
+
+	// Expire sets the expire time of field, returns false if field does not exist
+	Expire(field string, expireTime time.Time) bool
+	// Persist removes the ttl of field, returns false if field has no ttl
+	Persist(field string) bool
+	// GetExpireTime returns the expire time of field, ok is false if field has no ttl
+	GetExpireTime(field string) (expireTime time.Time, ok bool)
+	// NextExpireTime returns the earliest expire time of the fields, ok is false if no field has ttl
+	NextExpireTime() (expireTime time.Time, ok bool)
+	// RemoveExpired deletes the fields expired at now and returns them
+	RemoveExpired(now time.Time) []string
+}
+
+// clock returns the time fields expire against, tests replace it to expire fields at will
+var clock = time.Now
+
+// fieldTTL keeps the expire time of the fields of a hash which have ttl
+type fieldTTL struct {
+	expires map[string]time.Time
+}
+
+func (t *fieldTTL) isExpired(field string, now time.Time) bool {
+	expireTime, ok := t.expires[field]
+	return ok && !now.Before(expireTime)
+}
+
+// expired returns the fields expired at now
+func (t *fieldTTL) expired(now time.Time) []string {
+	var fields []string
+	for field, expireTime := range t.expires {
+		if !now.Before(expireTime) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func (t *fieldTTL) expiredCount() int {
+	if len(t.expires) == 0 {
+		return 0
+	}
+	return len(t.expired(clock()))
+}
+
+// filter wraps consumer to skip the expired fields
+func (t *fieldTTL) filter(consumer Consumer) Consumer {
+	if len(t.expires) == 0 {
+		return consumer
+	}
+	now := clock()
+	return func(field string, val []byte) bool {
+		if t.isExpired(field, now) {
+			return true
+		}
+		return consumer(field, val)
+	}
+}
+
+func (t *fieldTTL) setExpire(field string, expireTime time.Time) {
+	if t.expires == nil {
+		t.expires = make(map[string]time.Time)
+	}
+	t.expires[field] = expireTime
+}
+
+func (t *fieldTTL) Persist(field string) bool {
+	if _, ok := t.expires[field]; !ok {
+		return false
+	}
+	delete(t.expires, field)
+	return true
+}
+
+func (t *fieldTTL) GetExpireTime(field string) (time.Time, bool) {
+	expireTime, ok := t.expires[field]
+	return expireTime, ok
+}
+
+func (t *fieldTTL) NextExpireTime() (time.Time, bool) {
+	var next time.Time
+	for _, expireTime := range t.expires {
+		if next.IsZero() || expireTime.Before(next) {
+			next = expireTime
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// CopyTo sets the fields of src with their ttl to dst
+func CopyTo(dst, src Hash) {
+	src.ForEach(func(field string, val []byte) bool {
+		dst.Set(field, val)
+		if expireTime, ok := src.GetExpireTime(field); ok {
+			dst.Expire(field, expireTime)
+		}
+		return true
+	})
+}
@@ -0,0 +1,210 @@
+package hash
+
+import (
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHash(t *testing.T) {
+	for name, h := range map[string]Hash{"listpack": NewListPack(), "dict": NewDict()} {
+		expected := make(map[string]string)
+		for i := 0; i < 2000; i++ {
+			field := strconv.Itoa(rand.Intn(200))
+			switch rand.Intn(3) {
+			case 0, 1:
+				val := strconv.Itoa(rand.Int())
+				_, exists := expected[field]
+				if got := h.Set(field, []byte(val)); got != 1 && !exists || got != 0 && exists {
+					t.Fatalf("%s: set %s returns %d", name, field, got)
+				}
+				expected[field] = val
+			case 2:
+				_, exists := expected[field]
+				if got := h.Delete(field); got != 1 && exists || got != 0 && !exists {
+					t.Fatalf("%s: delete %s returns %d", name, field, got)
+				}
+				delete(expected, field)
+			}
+		}
+		if h.Len() != len(expected) {
+			t.Fatalf("%s: len %d, want %d", name, h.Len(), len(expected))
+		}
+		for field, val := range expected {
+			if got, ok := h.Get(field); !ok || string(got) != val {
+				t.Fatalf("%s: get %s returns %q", name, field, got)
+			}
+		}
+		visited := 0
+		h.ForEach(func(field string, val []byte) bool {
+			if expected[field] != string(val) {
+				t.Fatalf("%s: %s is %q, want %q", name, field, val, expected[field])
+			}
+			visited++
+			return true
+		})
+		if visited != len(expected) {
+			t.Fatalf("%s: visited %d fields, want %d", name, visited, len(expected))
+		}
+		distinct := h.RandomDistinctFields(10)
+		seen := make(map[string]bool)
+		for _, field := range distinct {
+			if _, ok := expected[field]; !ok || seen[field] {
+				t.Fatalf("%s: random field %s", name, field)
+			}
+			seen[field] = true
+		}
+		if len(distinct) != min(10, len(expected)) || len(h.RandomFields(300)) != 300 {
+			t.Fatalf("%s: wrong number of random fields", name)
+		}
+	}
+}
+
+func TestListPackValues(t *testing.T) {
+	lp := NewListPack()
+	lp.Set("a", []byte("1"))
+	lp.Set("b", []byte(""))
+	val, _ := lp.Get("a")
+	// values returned stay valid after changes
+	lp.Set("a", []byte("2"))
+	lp.Delete("b")
+	if string(val) != "1" {
+		t.Fatalf("got %q", val)
+	}
+	if _, ok := lp.Get("b"); ok {
+		t.Fatal("b is deleted")
+	}
+	lp.Set("b", []byte(""))
+	if val, ok := lp.Get("b"); !ok || len(val) != 0 {
+		t.Fatalf("got %q", val)
+	}
+}
+
+func TestDictScan(t *testing.T) {
+	d := NewDict()
+	for i := 0; i < 1000; i++ {
+		d.Set(strconv.Itoa(i), nil)
+	}
+	// every field existing during the whole scan is returned, though the table grows and shrinks meanwhile
+	visited := make(map[string]bool)
+	cursor := uint64(0)
+	added := 1000
+	for {
+		cursor = d.Scan(cursor, 10, func(field string, val []byte) bool {
+			visited[field] = true
+			return true
+		})
+		if cursor == 0 {
+			break
+		}
+		if added < 3000 {
+			for i := 0; i < 50; i++ {
+				d.Set(strconv.Itoa(added), nil)
+				added++
+			}
+		} else {
+			for i := 0; i < 100; i++ {
+				d.Delete(strconv.Itoa(added - 1))
+				added--
+			}
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if !visited[strconv.Itoa(i)] {
+			t.Fatalf("%d is missed", i)
+		}
+	}
+}
+
+func TestFieldTTL(t *testing.T) {
+	for name, h := range map[string]Hash{"listpack": NewListPack(), "dict": NewDict()} {
+		h.Set("a", []byte("1"))
+		h.Set("b", []byte("2"))
+		h.Set("c", []byte("3"))
+		if h.Expire("x", time.Now()) {
+			t.Fatalf("%s: expire a missing field", name)
+		}
+		past := time.Now().Add(-time.Second)
+		future := time.Now().Add(time.Hour)
+		h.Expire("a", past)
+		h.Expire("b", future)
+		// an expired field is absent before it is removed
+		if _, ok := h.Get("a"); ok || h.Len() != 2 {
+			t.Fatalf("%s: expired field is visible", name)
+		}
+		if next, ok := h.NextExpireTime(); !ok || !next.Equal(past) {
+			t.Fatalf("%s: next expire time %s", name, next)
+		}
+		for _, field := range append(h.RandomFields(20), h.RandomDistinctFields(1)...) {
+			if field == "a" {
+				t.Fatalf("%s: expired field is picked", name)
+			}
+		}
+		if removed := h.RemoveExpired(time.Now()); len(removed) != 1 || removed[0] != "a" {
+			t.Fatalf("%s: removed %q", name, removed)
+		}
+		// setting a field removes its ttl
+		h.Set("b", []byte("4"))
+		if _, ok := h.GetExpireTime("b"); ok {
+			t.Fatalf("%s: ttl is kept by Set", name)
+		}
+		h.Expire("c", past)
+		if h.Set("c", []byte("5")) != 1 || h.Len() != 2 {
+			t.Fatalf("%s: expired field is not replaced as a new one", name)
+		}
+		h.Expire("c", future)
+		if !h.Persist("c") || h.Persist("c") {
+			t.Fatalf("%s: persist", name)
+		}
+		if _, ok := h.NextExpireTime(); ok {
+			t.Fatalf("%s: no field has ttl", name)
+		}
+	}
+}
+
+func TestRandomFieldsWhileExpiring(t *testing.T) {
+	start := time.Now()
+	var ticking atomic.Bool
+	var reads atomic.Int64
+	// once ticking, every read of the clock is a second later than the one before
+	clock = func() time.Time {
+		if !ticking.Load() {
+			return start
+		}
+		return start.Add(time.Duration(reads.Add(1)-1) * time.Second)
+	}
+	defer func() { clock = time.Now }()
+
+	d := NewDict()
+	for i := 0; i < 10; i++ {
+		d.Set(strconv.Itoa(i), []byte("v"))
+	}
+	// fields 1 to 9 are alive at the first read of the clock and expired at the next ones
+	for i := 1; i < 10; i++ {
+		d.Expire(strconv.Itoa(i), start.Add(time.Second))
+	}
+	done := make(chan []string)
+	go func() {
+		ticking.Store(true)
+		if distinct := d.RandomDistinctFields(5); len(distinct) > 5 {
+			t.Errorf("got %d distinct fields", len(distinct))
+		}
+		done <- d.RandomFields(3)
+	}()
+	select {
+	case fields := <-done:
+		for _, field := range fields {
+			if field != "0" {
+				t.Fatalf("got expired field %q", field)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("random fields are still sampled after the fields expire")
+	}
+	d.Delete("0")
+	if fields := d.RandomFields(1); fields != nil {
+		t.Fatalf("got %q from a hash of expired fields", fields)
+	}
+}
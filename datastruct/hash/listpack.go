@@ -0,0 +1,178 @@
+package hash
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"time"
+)
+
+// ListPack stores fields and values one after another in a byte slice, every one of them is encoded as
+// <uvarint length> <bytes>. It costs a few bytes for each field of a small hash, and lookups are linear.
+// data is never modified in place, so the values returned stay valid after changes.
+type ListPack struct {
+	fieldTTL
+	data []byte
+	// count includes the expired fields not removed yet
+	count int
+}
+
+func NewListPack() *ListPack {
+	return &ListPack{}
+}
+
+func appendString(buf []byte, s []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func readString(data []byte, p int) ([]byte, int) {
+	l, n := binary.Uvarint(data[p:])
+	start := p + n
+	end := start + int(l)
+	return data[start:end:end], end
+}
+
+// find returns the offset of the entry of field, and the offset of the entry after it, start is -1 if not found
+func (lp *ListPack) find(field string) (start, end int, val []byte) {
+	for p := 0; p < len(lp.data); {
+		var f []byte
+		start = p
+		f, p = readString(lp.data, p)
+		val, p = readString(lp.data, p)
+		if string(f) == field {
+			return start, p, val
+		}
+	}
+	return -1, -1, nil
+}
+
+func (lp *ListPack) Get(field string) ([]byte, bool) {
+	start, _, val := lp.find(field)
+	if start < 0 || lp.isExpired(field, clock()) {
+		return nil, false
+	}
+	return val, true
+}
+
+func (lp *ListPack) Set(field string, val []byte) int {
+	start, end, _ := lp.find(field)
+	if start < 0 {
+		// appending never changes the bytes before len
+		lp.data = appendString(appendString(lp.data, []byte(field)), val)
+		lp.count++
+		return 1
+	}
+	result := 0
+	if lp.isExpired(field, clock()) {
+		result = 1
+	}
+	delete(lp.expires, field)
+	data := make([]byte, 0, len(lp.data)+len(val))
+	data = append(data, lp.data[:start]...)
+	data = appendString(appendString(data, []byte(field)), val)
+	lp.data = append(data, lp.data[end:]...)
+	return result
+}
+
+func (lp *ListPack) Delete(field string) int {
+	start, end, _ := lp.find(field)
+	if start < 0 {
+		return 0
+	}
+	result := 1
+	if lp.isExpired(field, clock()) {
+		result = 0
+	}
+	delete(lp.expires, field)
+	data := make([]byte, 0, len(lp.data)-(end-start))
+	data = append(data, lp.data[:start]...)
+	lp.data = append(data, lp.data[end:]...)
+	lp.count--
+	return result
+}
+
+func (lp *ListPack) Len() int {
+	return lp.count - lp.expiredCount()
+}
+
+func (lp *ListPack) forEach(consumer Consumer) {
+	for p := 0; p < len(lp.data); {
+		var f, val []byte
+		f, p = readString(lp.data, p)
+		val, p = readString(lp.data, p)
+		if !consumer(string(f), val) {
+			return
+		}
+	}
+}
+
+func (lp *ListPack) ForEach(consumer Consumer) {
+	lp.forEach(lp.filter(consumer))
+}
+
+func (lp *ListPack) fields() []string {
+	fields := make([]string, 0, lp.count)
+	lp.ForEach(func(field string, val []byte) bool {
+		fields = append(fields, field)
+		return true
+	})
+	return fields
+}
+
+func (lp *ListPack) RandomFields(count int) []string {
+	fields := lp.fields()
+	if len(fields) == 0 {
+		return nil
+	}
+	result := make([]string, count)
+	for i := range result {
+		result[i] = fields[rand.Intn(len(fields))]
+	}
+	return result
+}
+
+func (lp *ListPack) RandomDistinctFields(count int) []string {
+	fields := lp.fields()
+	rand.Shuffle(len(fields), func(i, j int) {
+		fields[i], fields[j] = fields[j], fields[i]
+	})
+	return fields[:min(count, len(fields))]
+}
+
+// Scan visits every field at once as redis does for a small hash
+func (lp *ListPack) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	lp.ForEach(consumer)
+	return 0
+}
+
+func (lp *ListPack) Expire(field string, expireTime time.Time) bool {
+	if _, ok := lp.Get(field); !ok {
+		return false
+	}
+	lp.setExpire(field, expireTime)
+	return true
+}
+
+func (lp *ListPack) RemoveExpired(now time.Time) []string {
+	removed := lp.expired(now)
+	if len(removed) == 0 {
+		return nil
+	}
+	for _, field := range removed {
+		delete(lp.expires, field)
+	}
+	// rebuild data once for all the removed fields
+	data := make([]byte, 0, len(lp.data))
+	lp.forEach(func(field string, val []byte) bool {
+		for _, f := range removed {
+			if f == field {
+				return true
+			}
+		}
+		data = appendString(appendString(data, []byte(field)), val)
+		return true
+	})
+	lp.data = data
+	lp.count -= len(removed)
+	return removed
+}
@@ -0,0 +1,380 @@
+package hyperloglog
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// The layout is the string format of redis, so that values written by either side can be read by the other:
+//
+//	+------+---+-----+----------+
+//	| HYLL | E | N/U | Cardin.  |
+//	+------+---+-----+----------+
+//
+// the 4 bytes magic, 1 byte encoding, 3 unused bytes and the cached cardinality as 8 bytes little endian,
+// whose most significant bit set means the cache is invalid. The registers follow the 16 bytes header.
+const (
+	// P is the number of bits of the hash used to select a register
+	P = 14
+	// Registers is the number of registers
+	Registers = 1 << P
+	// q is the number of bits of the hash used to count the leading zeros
+	q = 64 - P
+
+	registerBits = 6
+	registerMax  = 1<<registerBits - 1
+
+	HeaderSize = 16
+	// DenseSize is the length of a dense HyperLogLog
+	DenseSize = HeaderSize + (Registers*registerBits+7)/8
+
+	encodingDense  = 0
+	encodingSparse = 1
+
+	cacheOffset = 8
+	magic       = "HYLL"
+
+	// the opcodes of the sparse encoding are ZERO 00xxxxxx, XZERO 01xxxxxx yyyyyyyy and VAL 1vvvvvxx
+	sparseZeroMaxLen  = 64
+	sparseXZeroMaxLen = 16384
+	sparseValMaxValue = 32
+	sparseValMaxLen   = 4
+
+	alphaInf = 0.721347520444481703680 // 1/(2*ln(2))
+	seed     = 0xadc83b19
+)
+
+var (
+	// ErrInvalid means the value is not a HyperLogLog
+	ErrInvalid = errors.New("invalid HyperLogLog")
+	// ErrCorrupted means the sparse registers of the value are broken
+	ErrCorrupted = errors.New("corrupted HyperLogLog")
+)
+
+// HLL is a HyperLogLog using the dense or the sparse representation of redis.
+// The registers of a sparse HLL are decoded on Parse and encoded again by Bytes.
+type HLL struct {
+	// data is the whole value, only its header is used if the HLL is sparse
+	data []byte
+	// regs holds the decoded registers of a sparse HLL, nil if the HLL is dense
+	regs     []uint8
+	modified bool
+}
+
+// New returns an empty sparse HLL
+func New() *HLL {
+	data := make([]byte, HeaderSize)
+	copy(data, magic)
+	data[4] = encodingSparse
+	return &HLL{data: data, regs: make([]uint8, Registers), modified: true}
+}
+
+// Parse reads a HyperLogLog from data without copying it, the dense registers are changed in place
+func Parse(data []byte) (*HLL, error) {
+	if len(data) < HeaderSize || string(data[:4]) != magic {
+		return nil, ErrInvalid
+	}
+	switch data[4] {
+	case encodingDense:
+		if len(data) != DenseSize {
+			return nil, ErrInvalid
+		}
+		return &HLL{data: data}, nil
+	case encodingSparse:
+		regs, ok := decodeSparse(data[HeaderSize:])
+		if !ok {
+			return nil, ErrCorrupted
+		}
+		return &HLL{data: data, regs: regs}, nil
+	default:
+		return nil, ErrInvalid
+	}
+}
+
+// IsDense returns whether h uses the dense representation
+func (h *HLL) IsDense() bool {
+	return h.regs == nil
+}
+
+// murmurHash64A is the MurmurHash64A used by redis, reading the blocks as little endian
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ uint64(len(key))*m
+	for len(key) >= 8 {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		key = key[8:]
+	}
+	if len(key) > 0 {
+		for i := len(key) - 1; i >= 0; i-- {
+			h ^= uint64(key[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// patLen returns the register of element and the length of the pattern 000..1 of its hash
+func patLen(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, seed)
+	index := int(hash & (Registers - 1))
+	hash >>= P
+	// the sentinel makes the count at most q+1
+	hash |= 1 << q
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+func denseGet(registers []byte, i int) uint8 {
+	pos := i * registerBits
+	b, fb := pos/8, uint(pos%8)
+	v := uint(registers[b]) >> fb
+	if b+1 < len(registers) {
+		v |= uint(registers[b+1]) << (8 - fb)
+	}
+	return uint8(v & registerMax)
+}
+
+func denseSet(registers []byte, i int, val uint8) {
+	pos := i * registerBits
+	b, fb := pos/8, uint(pos%8)
+	v := uint(val)
+	registers[b] &^= byte(registerMax << fb)
+	registers[b] |= byte(v << fb)
+	if b+1 < len(registers) {
+		registers[b+1] &^= byte(registerMax >> (8 - fb))
+		registers[b+1] |= byte(v >> (8 - fb))
+	}
+}
+
+// decodeSparse returns the registers of the sparse opcodes, ok is false if they don't cover exactly all registers
+func decodeSparse(body []byte) ([]uint8, bool) {
+	regs := make([]uint8, Registers)
+	idx := 0
+	for p := 0; p < len(body); {
+		op := body[p]
+		switch {
+		case op&0xc0 == 0x00: // ZERO
+			idx += int(op&0x3f) + 1
+			p++
+		case op&0xc0 == 0x40: // XZERO
+			if p+1 >= len(body) {
+				return nil, false
+			}
+			idx += (int(op&0x3f)<<8 | int(body[p+1])) + 1
+			p += 2
+		default: // VAL
+			val := (op>>2)&0x1f + 1
+			n := int(op&0x03) + 1
+			if idx+n > Registers {
+				return nil, false
+			}
+			for i := idx; i < idx+n; i++ {
+				regs[i] = val
+			}
+			idx += n
+			p++
+		}
+		if idx > Registers {
+			return nil, false
+		}
+	}
+	return regs, idx == Registers
+}
+
+// encodeSparse returns the sparse opcodes of regs, ok is false if a register is too large for the sparse encoding
+func encodeSparse(regs []uint8) ([]byte, bool) {
+	body := make([]byte, 0, 64)
+	for i := 0; i < len(regs); {
+		val := regs[i]
+		j := i + 1
+		for j < len(regs) && regs[j] == val {
+			j++
+		}
+		for run := j - i; run > 0; {
+			var n int
+			switch {
+			case val > sparseValMaxValue:
+				return nil, false
+			case val > 0:
+				n = min(run, sparseValMaxLen)
+				body = append(body, 0x80|(val-1)<<2|byte(n-1))
+			case run <= sparseZeroMaxLen:
+				n = run
+				body = append(body, byte(n-1))
+			default:
+				n = min(run, sparseXZeroMaxLen)
+				body = append(body, 0x40|byte((n-1)>>8), byte(n-1))
+			}
+			run -= n
+		}
+		i = j
+	}
+	return body, true
+}
+
+func (h *HLL) invalidateCache() {
+	h.data[cacheOffset+7] |= 1 << 7
+}
+
+func (h *HLL) get(i int) uint8 {
+	if h.regs != nil {
+		return h.regs[i]
+	}
+	return denseGet(h.data[HeaderSize:], i)
+}
+
+// set raises register i to val, it returns false if the register is not less than val
+func (h *HLL) set(i int, val uint8) bool {
+	if h.get(i) >= val {
+		return false
+	}
+	if h.regs != nil {
+		h.regs[i] = val
+	} else {
+		denseSet(h.data[HeaderSize:], i, val)
+	}
+	h.modified = true
+	h.invalidateCache()
+	return true
+}
+
+// Add adds element, it returns true if a register is changed and so may the cardinality
+func (h *HLL) Add(element []byte) bool {
+	return h.set(patLen(element))
+}
+
+// ToDense converts h to the dense representation
+func (h *HLL) ToDense() {
+	if h.regs == nil {
+		return
+	}
+	data := make([]byte, DenseSize)
+	copy(data, h.data[:HeaderSize])
+	data[4] = encodingDense
+	for i, val := range h.regs {
+		if val > 0 {
+			denseSet(data[HeaderSize:], i, val)
+		}
+	}
+	h.data = data
+	h.regs = nil
+	h.modified = true
+}
+
+// Union raises each of regs to the register of h, so that regs becomes the union of both
+func (h *HLL) Union(regs []uint8) {
+	for i := range regs {
+		if val := h.get(i); val > regs[i] {
+			regs[i] = val
+		}
+	}
+}
+
+// Merge raises each register of h to the one of regs
+func (h *HLL) Merge(regs []uint8) {
+	for i, val := range regs {
+		h.set(i, val)
+	}
+}
+
+// Count returns the estimated cardinality, which is cached in the header.
+// updated is true if the cache is refreshed, that is h is changed.
+func (h *HLL) Count() (card uint64, updated bool) {
+	cache := h.data[cacheOffset : cacheOffset+8]
+	if cache[7]&(1<<7) == 0 {
+		return binary.LittleEndian.Uint64(cache), false
+	}
+	var histogram [q + 2]int
+	for i := 0; i < Registers; i++ {
+		histogram[h.get(i)]++
+	}
+	card = estimate(&histogram)
+	binary.LittleEndian.PutUint64(cache, card)
+	h.modified = true
+	return card, true
+}
+
+// CountRegisters returns the estimated cardinality of raw registers
+func CountRegisters(regs []uint8) uint64 {
+	var histogram [q + 2]int
+	for _, val := range regs {
+		histogram[val]++
+	}
+	return estimate(&histogram)
+}
+
+// estimate is the improved estimator of Otmar Ertl given the histogram of register values
+func estimate(histogram *[q + 2]int) uint64 {
+	m := float64(Registers)
+	z := m * tau((m-float64(histogram[q+1]))/m)
+	for j := q; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * sigma(float64(histogram[0])/m)
+	return uint64(math.Round(alphaInf * m * m / z))
+}
+
+func sigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func tau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
+// Bytes returns the value of h. A sparse HLL whose encoding exceeds sparseMaxBytes, including the header,
+// or holds a register too large for the sparse encoding is converted to the dense representation.
+func (h *HLL) Bytes(sparseMaxBytes int) []byte {
+	if h.regs == nil || !h.modified {
+		return h.data
+	}
+	body, ok := encodeSparse(h.regs)
+	if !ok || HeaderSize+len(body) > sparseMaxBytes {
+		h.ToDense()
+		return h.data
+	}
+	data := make([]byte, HeaderSize+len(body))
+	copy(data, h.data[:HeaderSize])
+	copy(data[HeaderSize:], body)
+	h.data = data
+	h.modified = false
+	return data
+}
@@ -0,0 +1,114 @@
+package hyperloglog
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestAccuracy(t *testing.T) {
+	for _, n := range []int{1000, 1000000} {
+		h := New()
+		for i := 0; i < n; i++ {
+			h.Add([]byte("element:" + strconv.Itoa(i)))
+		}
+		card, _ := h.Count()
+		// the standard error is 0.81%
+		if e := math.Abs(float64(card)-float64(n)) / float64(n); e > 0.02 {
+			t.Errorf("count %d elements: got %d, error %.4f", n, card, e)
+		}
+	}
+}
+
+func TestSparse(t *testing.T) {
+	empty := New().Bytes(3000)
+	if !bytes.Equal(empty, []byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff")) {
+		t.Fatalf("empty HLL %q", empty)
+	}
+
+	sparse := New()
+	dense := New()
+	dense.ToDense()
+	for i := 0; i < 2000; i++ {
+		element := []byte(strconv.Itoa(i))
+		if sparse.Add(element) != dense.Add(element) {
+			t.Fatalf("add %d: the representations disagree", i)
+		}
+		if i%100 != 0 {
+			continue
+		}
+		data := sparse.Bytes(math.MaxInt)
+		parsed, err := Parse(data)
+		if err != nil || parsed.IsDense() {
+			t.Fatalf("parse sparse %q: %v", data, err)
+		}
+		sparse = parsed
+	}
+	for i := 0; i < Registers; i++ {
+		if sparse.get(i) != dense.get(i) {
+			t.Fatalf("register %d: sparse %d, dense %d", i, sparse.get(i), dense.get(i))
+		}
+	}
+	c1, _ := sparse.Count()
+	c2, _ := dense.Count()
+	if c1 != c2 {
+		t.Fatalf("sparse counts %d, dense counts %d", c1, c2)
+	}
+	if _, updated := dense.Count(); updated {
+		t.Fatal("cached cardinality is not used")
+	}
+
+	// a sparse HLL exceeding the limit is promoted
+	if data := sparse.Bytes(100); len(data) != DenseSize || !sparse.IsDense() {
+		t.Fatalf("promoted HLL is %d bytes", len(data))
+	}
+	parsed, err := Parse(sparse.Bytes(100))
+	if err != nil || !parsed.IsDense() {
+		t.Fatalf("parse dense: %v", err)
+	}
+	if c, _ := parsed.Count(); c != c2 {
+		t.Fatalf("dense counts %d, want %d", c, c2)
+	}
+}
+
+func TestUnion(t *testing.T) {
+	a, b := New(), New()
+	for i := 0; i < 3000; i++ {
+		a.Add([]byte(strconv.Itoa(i)))
+		b.Add([]byte(strconv.Itoa(i + 2000)))
+	}
+	regs := make([]uint8, Registers)
+	a.Union(regs)
+	b.Union(regs)
+	c := New()
+	c.Merge(regs)
+	card, _ := c.Count()
+	if card != CountRegisters(regs) || math.Abs(float64(card)-5000) > 100 {
+		t.Fatalf("union counts %d", card)
+	}
+}
+
+func TestParse(t *testing.T) {
+	valid := New().Bytes(3000)
+	cases := []struct {
+		data []byte
+		err  error
+	}{
+		{[]byte("hello"), ErrInvalid},
+		{append([]byte("HYLX"), valid[4:]...), ErrInvalid},
+		{append(append([]byte{}, valid[:4]...), append([]byte{2}, valid[5:]...)...), ErrInvalid},
+		{append(append([]byte{}, valid[:4]...), append([]byte{0}, valid[5:]...)...), ErrInvalid},
+		{append(append([]byte{}, valid...), 0x00), ErrCorrupted},
+		{valid[:HeaderSize+1], ErrCorrupted},
+		{append(append([]byte{}, valid[:HeaderSize]...), 0x7f, 0xfe, 0xff), ErrCorrupted},
+	}
+	for _, c := range cases {
+		if _, err := Parse(c.data); err != c.err {
+			t.Errorf("parse %q: got %v, want %v", c.data, err, c.err)
+		}
+	}
+	if _, err := Parse(append(append([]byte{}, valid[:HeaderSize]...), 0x7f, 0xfe, 0x80)); err != nil {
+		t.Errorf("parse valid sparse HLL: %v", err)
+	}
+}
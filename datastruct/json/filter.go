@@ -0,0 +1,302 @@
+package json
+
+import (
+	"regexp"
+	"strings"
+)
+
+// expr is the expression of a filter, current is the value tested, which is @ in the expression
+type expr interface {
+	eval(current, root any) bool
+}
+
+type orExpr struct {
+	left, right expr
+}
+
+func (e *orExpr) eval(current, root any) bool {
+	return e.left.eval(current, root) || e.right.eval(current, root)
+}
+
+type andExpr struct {
+	left, right expr
+}
+
+func (e *andExpr) eval(current, root any) bool {
+	return e.left.eval(current, root) && e.right.eval(current, root)
+}
+
+type notExpr struct {
+	e expr
+}
+
+func (e *notExpr) eval(current, root any) bool {
+	return !e.e.eval(current, root)
+}
+
+// operand is a literal or a path relative to @ or $ in a filter
+type operand interface {
+	// value returns the first value of the operand, ok is false if a path selects nothing
+	value(current, root any) (any, bool)
+}
+
+type literal struct {
+	v any
+}
+
+func (l *literal) value(current, root any) (any, bool) {
+	return l.v, true
+}
+
+type pathOperand struct {
+	relative bool
+	segments []*segment
+}
+
+func (o *pathOperand) value(current, root any) (any, bool) {
+	start := root
+	if o.relative {
+		start = current
+	}
+	nodes := selectSegments(&Node{Value: start}, root, o.segments)
+	if len(nodes) == 0 {
+		return nil, false
+	}
+	return nodes[0].Value, true
+}
+
+// existExpr tests whether a path selects anything, or whether a literal is neither false nor null
+type existExpr struct {
+	o operand
+}
+
+func (e *existExpr) eval(current, root any) bool {
+	v, ok := e.o.value(current, root)
+	if _, isPath := e.o.(*pathOperand); isPath {
+		return ok
+	}
+	return v != nil && v != false
+}
+
+type compareExpr struct {
+	op          string
+	left, right operand
+	// re is compiled once if the pattern of =~ is a literal
+	re *regexp.Regexp
+}
+
+func (e *compareExpr) eval(current, root any) bool {
+	a, aok := e.left.value(current, root)
+	b, bok := e.right.value(current, root)
+	switch e.op {
+	case "==":
+		return equalOrMissing(a, aok, b, bok)
+	case "!=":
+		return !equalOrMissing(a, aok, b, bok)
+	case "=~":
+		s, ok1 := a.(string)
+		pattern, ok2 := b.(string)
+		if !aok || !bok || !ok1 || !ok2 {
+			return false
+		}
+		re := e.re
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile(pattern); err != nil {
+				return false
+			}
+		}
+		return re.MatchString(s)
+	}
+	if !aok || !bok {
+		return false
+	}
+	c, ok := compareValues(a, b)
+	if !ok {
+		return false
+	}
+	switch e.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+// equalOrMissing compares two operands, two paths selecting nothing are equal
+func equalOrMissing(a any, aok bool, b any, bok bool) bool {
+	if !aok || !bok {
+		return aok == bok
+	}
+	return Equal(a, b)
+}
+
+// compareValues orders numbers and strings, ok is false for other values
+func compareValues(a, b any) (int, bool) {
+	if x, ok := a.(int64); ok {
+		if y, ok := b.(int64); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	x, ok1 := a.(string)
+	y, ok2 := b.(string)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+// the grammar of filters, from the lowest precedence:
+// or := and ('||' and)*
+// and := not ('&&' not)*
+// not := '!' not | '(' or ')' | operand [op operand]
+// operand := '@' segments | '$' segments | string | number | true | false | null
+
+func (p *pathParser) consume(token string) bool {
+	p.skipSpaces()
+	if strings.HasPrefix(p.text[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *pathParser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *pathParser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *pathParser) parseNot() (expr, error) {
+	p.skipSpaces()
+	if p.peek() == '!' && !strings.HasPrefix(p.text[p.pos:], "!=") {
+		p.pos++
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{e: e}, nil
+	}
+	if p.consume("(") {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return e, nil
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	op := ""
+	// the two characters operators are tried first
+	for _, candidate := range []string{"==", "!=", "<=", ">=", "=~", "<", ">"} {
+		if p.consume(candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return &existExpr{o: left}, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	e := &compareExpr{op: op, left: left, right: right}
+	if l, ok := right.(*literal); ok && op == "=~" {
+		if pattern, ok := l.v.(string); ok {
+			e.re, _ = regexp.Compile(pattern)
+		}
+	}
+	return e, nil
+}
+
+func (p *pathParser) parseOperand() (operand, error) {
+	p.skipSpaces()
+	switch c := p.peek(); {
+	case c == '@' || c == '$':
+		p.pos++
+		segments, err := p.parseSegments(true)
+		if err != nil {
+			return nil, err
+		}
+		return &pathOperand{relative: c == '@', segments: segments}, nil
+	case c == '\'' || c == '"':
+		s, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return &literal{v: s}, nil
+	case c == '-' || c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.text) && strings.IndexByte("0123456789+-.eE", p.text[p.pos]) >= 0 {
+			p.pos++
+		}
+		v, err := Parse([]byte(p.text[start:p.pos]))
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("invalid number")
+		}
+		return &literal{v: v}, nil
+	}
+	for _, word := range []string{"true", "false", "null"} {
+		if strings.HasPrefix(p.text[p.pos:], word) {
+			p.pos += len(word)
+			v, _ := Parse([]byte(word))
+			return &literal{v: v}, nil
+		}
+	}
+	return nil, p.errorf("expected an operand")
+}
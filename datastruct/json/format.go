@@ -0,0 +1,133 @@
+package json
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Format is the layout used to serialize values, the zero value is compact
+type Format struct {
+	// Indent is written once per level of nesting at the beginning of lines
+	Indent string
+	// Newline is written after each element of arrays and objects
+	Newline string
+	// Space is written after the colon of object members
+	Space string
+}
+
+// Marshal returns the compact text of v
+func Marshal(v any) []byte {
+	return Format{}.Marshal(v)
+}
+
+// Marshal returns the text of v in the layout of f
+func (f Format) Marshal(v any) []byte {
+	return f.appendValue(nil, v, 0)
+}
+
+func (f Format) appendLine(buf []byte, depth int) []byte {
+	buf = append(buf, f.Newline...)
+	for i := 0; i < depth; i++ {
+		buf = append(buf, f.Indent...)
+	}
+	return buf
+}
+
+func (f Format) appendValue(buf []byte, v any, depth int) []byte {
+	switch val := v.(type) {
+	case nil:
+		return append(buf, "null"...)
+	case bool:
+		return strconv.AppendBool(buf, val)
+	case int64:
+		return strconv.AppendInt(buf, val, 10)
+	case float64:
+		return append(buf, FormatFloat(val)...)
+	case string:
+		return appendString(buf, val)
+	case *Array:
+		if val.Len() == 0 {
+			return append(buf, "[]"...)
+		}
+		buf = append(buf, '[')
+		for i, element := range val.elements {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = f.appendLine(buf, depth+1)
+			buf = f.appendValue(buf, element, depth+1)
+		}
+		buf = f.appendLine(buf, depth)
+		return append(buf, ']')
+	case *Object:
+		if val.Len() == 0 {
+			return append(buf, "{}"...)
+		}
+		buf = append(buf, '{')
+		for i, key := range val.keys {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = f.appendLine(buf, depth+1)
+			buf = appendString(buf, key)
+			buf = append(buf, ':')
+			buf = append(buf, f.Space...)
+			buf = f.appendValue(buf, val.values[key], depth+1)
+		}
+		buf = f.appendLine(buf, depth)
+		return append(buf, '}')
+	}
+	return buf
+}
+
+// FormatFloat formats a float in the shortest form which is read back as the same float,
+// a float always has a fraction or an exponent to tell it from an integer, e.g. 1.0, 1.5e-7, 1e20
+func FormatFloat(f float64) string {
+	abs := math.Abs(f)
+	if abs != 0 && (abs < 1e-5 || abs >= 1e16) {
+		s := strconv.FormatFloat(f, 'e', -1, 64)
+		// 1e+20 is written as 1e20 and 1e-07 as 1e-7
+		mantissa, exp, _ := strings.Cut(s, "e")
+		sign := ""
+		if exp[0] == '-' {
+			sign = "-"
+		}
+		return mantissa + "e" + sign + strings.TrimLeft(exp[1:], "0")
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsRune(s, '.') {
+		s += ".0"
+	}
+	return s
+}
+
+const hexDigits = "0123456789abcdef"
+
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"', '\\':
+			buf = append(buf, '\\', c)
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		case '\b':
+			buf = append(buf, '\\', 'b')
+		case '\f':
+			buf = append(buf, '\\', 'f')
+		default:
+			if c < 0x20 {
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			} else {
+				buf = append(buf, c)
+			}
+		}
+	}
+	return append(buf, '"')
+}
@@ -0,0 +1,358 @@
+package json
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxDepth is the max nesting of arrays and objects
+const maxDepth = 128
+
+// SyntaxError describes why a text is not JSON, the line and the column start from 1
+type SyntaxError struct {
+	Msg    string
+	Line   int
+	Column int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at line %d column %d", e.Msg, e.Line, e.Column)
+}
+
+type parser struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+// Parse parses a JSON text, numbers without fraction or exponent which fit in int64 are integers
+func Parse(data []byte) (any, error) {
+	p := &parser{data: data}
+	p.skipSpaces()
+	v, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.data) {
+		return nil, p.errorAt("trailing characters", p.pos)
+	}
+	return v, nil
+}
+
+// errorAt returns an error at the offset pos
+func (p *parser) errorAt(msg string, pos int) error {
+	line, column := 1, 0
+	for i := 0; i < pos && i < len(p.data); i++ {
+		if p.data[i] == '\n' {
+			line++
+			column = 0
+		} else {
+			column++
+		}
+	}
+	if pos < len(p.data) {
+		column++
+	}
+	return &SyntaxError{Msg: msg, Line: line, Column: column}
+}
+
+func (p *parser) eofError(msg string) error {
+	return p.errorAt("EOF while parsing "+msg, len(p.data))
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) parseValue() (any, error) {
+	if p.pos >= len(p.data) {
+		return nil, p.eofError("a value")
+	}
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		return p.parseObject()
+	case c == '[':
+		return p.parseArray()
+	case c == '"':
+		return p.parseString()
+	case c == '-' || c >= '0' && c <= '9':
+		return p.parseNumber()
+	case c == 't':
+		return true, p.parseLiteral("true")
+	case c == 'f':
+		return false, p.parseLiteral("false")
+	case c == 'n':
+		return nil, p.parseLiteral("null")
+	}
+	return nil, p.errorAt("expected value", p.pos)
+}
+
+func (p *parser) parseLiteral(literal string) error {
+	for i := 0; i < len(literal); i++ {
+		if p.pos >= len(p.data) {
+			return p.eofError("a value")
+		}
+		if p.data[p.pos] != literal[i] {
+			return p.errorAt("expected ident", p.pos)
+		}
+		p.pos++
+	}
+	return nil
+}
+
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return p.errorAt("recursion limit exceeded", p.pos)
+	}
+	p.pos++
+	p.skipSpaces()
+	return nil
+}
+
+func (p *parser) parseArray() (any, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	a := NewArray()
+	if p.pos < len(p.data) && p.data[p.pos] == ']' {
+		p.pos++
+		p.depth--
+		return a, nil
+	}
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		a.Append(v)
+		p.skipSpaces()
+		if p.pos >= len(p.data) {
+			return nil, p.eofError("a list")
+		}
+		switch p.data[p.pos] {
+		case ',':
+			p.pos++
+			p.skipSpaces()
+		case ']':
+			p.pos++
+			p.depth--
+			return a, nil
+		default:
+			return nil, p.errorAt("expected `,` or `]`", p.pos)
+		}
+	}
+}
+
+func (p *parser) parseObject() (any, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	o := NewObject()
+	if p.pos < len(p.data) && p.data[p.pos] == '}' {
+		p.pos++
+		p.depth--
+		return o, nil
+	}
+	for {
+		if p.pos >= len(p.data) {
+			return nil, p.eofError("an object")
+		}
+		if p.data[p.pos] != '"' {
+			return nil, p.errorAt("key must be a string", p.pos)
+		}
+		key, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if p.pos >= len(p.data) {
+			return nil, p.eofError("an object")
+		}
+		if p.data[p.pos] != ':' {
+			return nil, p.errorAt("expected `:`", p.pos)
+		}
+		p.pos++
+		p.skipSpaces()
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		o.Set(key, v)
+		p.skipSpaces()
+		if p.pos >= len(p.data) {
+			return nil, p.eofError("an object")
+		}
+		switch p.data[p.pos] {
+		case ',':
+			p.pos++
+			p.skipSpaces()
+		case '}':
+			p.pos++
+			p.depth--
+			return o, nil
+		default:
+			return nil, p.errorAt("expected `,` or `}`", p.pos)
+		}
+	}
+}
+
+func (p *parser) parseNumber() (any, error) {
+	start := p.pos
+	isFloat := false
+	digits := func() int {
+		n := 0
+		for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+			p.pos++
+			n++
+		}
+		return n
+	}
+	if p.data[p.pos] == '-' {
+		p.pos++
+	}
+	if p.pos >= len(p.data) {
+		return nil, p.eofError("a value")
+	}
+	if p.data[p.pos] == '0' {
+		p.pos++
+		// leading zeros are invalid
+		if p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+			return nil, p.errorAt("invalid number", p.pos)
+		}
+	} else if digits() == 0 {
+		return nil, p.errorAt("invalid number", p.pos)
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '.' {
+		isFloat = true
+		p.pos++
+		if digits() == 0 {
+			return nil, p.errorAt("invalid number", p.pos)
+		}
+	}
+	if p.pos < len(p.data) && (p.data[p.pos] == 'e' || p.data[p.pos] == 'E') {
+		isFloat = true
+		p.pos++
+		if p.pos < len(p.data) && (p.data[p.pos] == '+' || p.data[p.pos] == '-') {
+			p.pos++
+		}
+		if digits() == 0 {
+			return nil, p.errorAt("invalid number", p.pos)
+		}
+	}
+	text := string(p.data[start:p.pos])
+	if !isFloat {
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, p.errorAt("number out of range", start)
+	}
+	return f, nil
+}
+
+func hexValue(c byte) (rune, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return rune(c - '0'), true
+	case c >= 'a' && c <= 'f':
+		return rune(c - 'a' + 10), true
+	case c >= 'A' && c <= 'F':
+		return rune(c - 'A' + 10), true
+	}
+	return 0, false
+}
+
+// parseHex4 parses the 4 hex digits of a \u escape
+func (p *parser) parseHex4() (rune, error) {
+	if p.pos+4 > len(p.data) {
+		return 0, p.eofError("a string")
+	}
+	r := rune(0)
+	for i := 0; i < 4; i++ {
+		v, ok := hexValue(p.data[p.pos])
+		if !ok {
+			return 0, p.errorAt("invalid escape", p.pos)
+		}
+		r = r<<4 | v
+		p.pos++
+	}
+	return r, nil
+}
+
+func (p *parser) parseString() (string, error) {
+	p.pos++
+	var buf []byte
+	for {
+		if p.pos >= len(p.data) {
+			return "", p.eofError("a string")
+		}
+		c := p.data[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return string(buf), nil
+		case c < 0x20:
+			return "", p.errorAt("control character (\\u0000-\\u001F) found while parsing a string", p.pos)
+		case c != '\\':
+			buf = append(buf, c)
+			p.pos++
+			continue
+		}
+		p.pos++
+		if p.pos >= len(p.data) {
+			return "", p.eofError("a string")
+		}
+		escape := p.data[p.pos]
+		p.pos++
+		switch escape {
+		case '"', '\\', '/':
+			buf = append(buf, escape)
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'u':
+			r, err := p.parseHex4()
+			if err != nil {
+				return "", err
+			}
+			if utf16.IsSurrogate(r) {
+				// a high surrogate must be followed by a low one
+				if p.pos+2 > len(p.data) || p.data[p.pos] != '\\' || p.data[p.pos+1] != 'u' {
+					return "", p.errorAt("lone leading surrogate in hex escape", p.pos)
+				}
+				p.pos += 2
+				low, err := p.parseHex4()
+				if err != nil {
+					return "", err
+				}
+				r = utf16.DecodeRune(r, low)
+				if r == utf8.RuneError {
+					return "", p.errorAt("lone leading surrogate in hex escape", p.pos)
+				}
+			}
+			buf = utf8.AppendRune(buf, r)
+		default:
+			return "", p.errorAt("invalid escape", p.pos-1)
+		}
+	}
+}
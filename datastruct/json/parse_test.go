@@ -0,0 +1,87 @@
+package json
+
+import (
+	"testing"
+)
+
+func TestParseAndMarshal(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{`null`, `null`},
+		{` true `, `true`},
+		{`-12`, `-12`},
+		{`1.50`, `1.5`},
+		{`2e3`, `2000.0`},
+		{`1e20`, `1e20`},
+		{`1.5e-7`, `1.5e-7`},
+		{`9223372036854775808`, `9.223372036854776e18`},
+		{`"a\"b\\c\/\né😀\u0001"`, `"a\"b\\c/\n` + "é😀" + `\u0001"`},
+		{`[ 1 , [] , {} ]`, `[1,[],{}]`},
+		{`{"b": 1, "a": {"c": [true, null]}, "b": 2}`, `{"b":2,"a":{"c":[true,null]}}`},
+	}
+	for _, tt := range tests {
+		v, err := Parse([]byte(tt.text))
+		if err != nil {
+			t.Errorf("Parse(%s) fails: %v", tt.text, err)
+			continue
+		}
+		if got := string(Marshal(v)); got != tt.want {
+			t.Errorf("Marshal(Parse(%s)) = %s, want %s", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		text, err string
+	}{
+		{``, "EOF while parsing a value at line 1 column 0"},
+		{`x`, "expected value at line 1 column 1"},
+		{`[1,]`, "expected value at line 1 column 4"},
+		{`[1 2]`, "expected `,` or `]` at line 1 column 4"},
+		{"{\n\"a\" 1}", "expected `:` at line 2 column 5"},
+		{`{1:2}`, "key must be a string at line 1 column 2"},
+		{`{"a":1`, "EOF while parsing an object at line 1 column 6"},
+		{`"abc`, "EOF while parsing a string at line 1 column 4"},
+		{`01`, "invalid number at line 1 column 2"},
+		{`1.`, "invalid number at line 1 column 2"},
+		{`nul`, "EOF while parsing a value at line 1 column 3"},
+		{`1 2`, "trailing characters at line 1 column 3"},
+		{`"\x"`, "invalid escape at line 1 column 3"},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.text))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("Parse(%s) returns error %v, want %s", tt.text, err, tt.err)
+		}
+	}
+	deep := make([]byte, 0, 2*maxDepth+2)
+	for i := 0; i <= maxDepth; i++ {
+		deep = append(deep, '[')
+	}
+	if _, err := Parse(deep); err == nil {
+		t.Errorf("recursion is not limited")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	v, _ := Parse([]byte(`{"a":[1,{"b":"c"}],"d":{},"e":[]}`))
+	f := Format{Indent: "  ", Newline: "\n", Space: " "}
+	want := "{\n  \"a\": [\n    1,\n    {\n      \"b\": \"c\"\n    }\n  ],\n  \"d\": {},\n  \"e\": []\n}"
+	if got := string(f.Marshal(v)); got != want {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	target, _ := Parse([]byte(`{"a":"b","c":{"d":"e","f":"g"}}`))
+	patch, _ := Parse([]byte(`{"a":"z","c":{"f":null},"h":[1]}`))
+	if got := string(Marshal(MergePatch(target, patch))); got != `{"a":"z","c":{"d":"e"},"h":[1]}` {
+		t.Errorf("got %s", got)
+	}
+	patch, _ = Parse([]byte(`{"a":{"b":null,"c":1}}`))
+	if got := string(Marshal(MergePatch(nil, patch))); got != `{"a":{"c":1}}` {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,553 @@
+package json
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Path selects values of a document. A JSONPath starts with $, e.g. $.a[*].b, $..b, $.a[?(@.b > 1)],
+// any other path is a legacy path, e.g. ., .a.b, a[0], which is converted to the JSONPath $.a.b and $.a[0].
+type Path struct {
+	text     string
+	legacy   bool
+	segments []*segment
+}
+
+// segment applies its selectors to the children of the selected values, or to all the descendants after ..
+type segment struct {
+	descendant bool
+	selectors  []selector
+}
+
+// selector appends the children of n it selects to result, root is used by filters
+type selector interface {
+	apply(n *Node, root any, result []*Node) []*Node
+}
+
+// PathError reports an invalid path, Offset is the index in the path of the error
+type PathError struct {
+	Path   string
+	Msg    string
+	Offset int
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("invalid JSON path '%s': %s at offset %d", e.Path, e.Msg, e.Offset)
+}
+
+// ParsePath compiles a JSONPath or a legacy path
+func ParsePath(text string) (*Path, error) {
+	p := &pathParser{text: text}
+	path := &Path{text: text}
+	switch {
+	case strings.HasPrefix(text, "$"):
+		p.pos = 1
+	case text == ".":
+		path.legacy = true
+		return path, nil
+	case strings.HasPrefix(text, ".") || strings.HasPrefix(text, "["):
+		path.legacy = true
+	default:
+		// a legacy path may omit the leading dot
+		path.legacy = true
+		p.text = "." + text
+	}
+	segments, err := p.parseSegments(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.text) {
+		return nil, p.errorf("unexpected character %q", p.text[p.pos])
+	}
+	path.segments = segments
+	return path, nil
+}
+
+// IsLegacy reports whether the path does not start with $, a legacy path returns a single value rather than an array
+func (p *Path) IsLegacy() bool {
+	return p.legacy
+}
+
+// IsRoot reports whether the path selects the root only
+func (p *Path) IsRoot() bool {
+	return len(p.segments) == 0
+}
+
+func (p *Path) String() string {
+	return p.text
+}
+
+// Node is a value selected by a path, it knows where the value is to replace it
+type Node struct {
+	Value  any
+	doc    *Document
+	parent any
+	key    string
+	index  int
+}
+
+// Replace replaces the value of n in the document
+func (n *Node) Replace(v any) {
+	n.Value = v
+	switch parent := n.parent.(type) {
+	case nil:
+		n.doc.Root = v
+	case *Object:
+		parent.Set(n.key, v)
+	case *Array:
+		parent.Set(n.index, v)
+	}
+}
+
+func (n *Node) child(v any, key string, index int) *Node {
+	return &Node{Value: v, doc: n.doc, parent: n.Value, key: key, index: index}
+}
+
+// Select returns the values selected by p in order
+func (d *Document) Select(p *Path) []*Node {
+	return selectSegments(&Node{Value: d.Root, doc: d}, d.Root, p.segments)
+}
+
+func selectSegments(start *Node, root any, segments []*segment) []*Node {
+	nodes := []*Node{start}
+	for _, seg := range segments {
+		var result []*Node
+		for _, n := range nodes {
+			if seg.descendant {
+				result = seg.applyDescendants(n, root, result)
+				continue
+			}
+			for _, sel := range seg.selectors {
+				result = sel.apply(n, root, result)
+			}
+		}
+		nodes = result
+	}
+	return nodes
+}
+
+// applyDescendants applies the selectors to n and to its descendants in document order
+func (seg *segment) applyDescendants(n *Node, root any, result []*Node) []*Node {
+	for _, sel := range seg.selectors {
+		result = sel.apply(n, root, result)
+	}
+	for _, c := range children(n) {
+		result = seg.applyDescendants(c, root, result)
+	}
+	return result
+}
+
+func children(n *Node) []*Node {
+	var result []*Node
+	switch val := n.Value.(type) {
+	case *Array:
+		for i, element := range val.elements {
+			result = append(result, n.child(element, "", i))
+		}
+	case *Object:
+		for _, key := range val.keys {
+			result = append(result, n.child(val.values[key], key, 0))
+		}
+	}
+	return result
+}
+
+// Set sets v to the values selected by p and returns the number of values set.
+// If nothing is selected and the last segment of p is a single key, the key is added to the parent objects.
+func (d *Document) Set(p *Path, v any) int {
+	nodes := d.Select(p)
+	for i, n := range nodes {
+		if i > 0 {
+			v = Copy(v)
+		}
+		n.Replace(v)
+	}
+	if len(nodes) > 0 {
+		return len(nodes)
+	}
+	return d.addKey(p, func() any { return Copy(v) })
+}
+
+// addKey adds the last key of p to the objects selected by the parent path, whose values are made by newValue
+func (d *Document) addKey(p *Path, newValue func() any) int {
+	if len(p.segments) == 0 {
+		return 0
+	}
+	last := p.segments[len(p.segments)-1]
+	if last.descendant || len(last.selectors) != 1 {
+		return 0
+	}
+	name, ok := last.selectors[0].(*nameSelector)
+	if !ok {
+		return 0
+	}
+	count := 0
+	parents := selectSegments(&Node{Value: d.Root, doc: d}, d.Root, p.segments[:len(p.segments)-1])
+	for _, parent := range parents {
+		if o, ok := parent.Value.(*Object); ok {
+			o.Set(name.name, newValue())
+			count++
+		}
+	}
+	return count
+}
+
+// Merge applies the merge patch to the values selected by p, the keys missing are added as Set does.
+// A null patch deletes the selected values.
+func (d *Document) Merge(p *Path, patch any) int {
+	if patch == nil {
+		return d.Delete(p)
+	}
+	nodes := d.Select(p)
+	for _, n := range nodes {
+		n.Replace(MergePatch(n.Value, Copy(patch)))
+	}
+	if len(nodes) > 0 {
+		return len(nodes)
+	}
+	return d.addKey(p, func() any { return MergePatch(nil, Copy(patch)) })
+}
+
+// Delete removes the values selected by p except the root, and returns the number of values removed
+func (d *Document) Delete(p *Path) int {
+	count := 0
+	indexes := make(map[*Array][]int)
+	for _, n := range d.Select(p) {
+		switch parent := n.parent.(type) {
+		case *Object:
+			if parent.Delete(n.key) {
+				count++
+			}
+		case *Array:
+			indexes[parent] = append(indexes[parent], n.index)
+		}
+	}
+	// the elements are removed from the last one, so that the indexes are not shifted
+	for a, list := range indexes {
+		sort.Sort(sort.Reverse(sort.IntSlice(list)))
+		for i, index := range list {
+			if i > 0 && index == list[i-1] {
+				continue
+			}
+			a.Remove(index)
+			count++
+		}
+	}
+	return count
+}
+
+type nameSelector struct {
+	name string
+}
+
+func (s *nameSelector) apply(n *Node, root any, result []*Node) []*Node {
+	if o, ok := n.Value.(*Object); ok {
+		if v, ok := o.Get(s.name); ok {
+			result = append(result, n.child(v, s.name, 0))
+		}
+	}
+	return result
+}
+
+type wildcardSelector struct{}
+
+func (s *wildcardSelector) apply(n *Node, root any, result []*Node) []*Node {
+	return append(result, children(n)...)
+}
+
+type indexSelector struct {
+	index int
+}
+
+func (s *indexSelector) apply(n *Node, root any, result []*Node) []*Node {
+	a, ok := n.Value.(*Array)
+	if !ok {
+		return result
+	}
+	i := s.index
+	if i < 0 {
+		i += a.Len()
+	}
+	if i < 0 || i >= a.Len() {
+		return result
+	}
+	return append(result, n.child(a.Get(i), "", i))
+}
+
+// sliceSelector is [start:end:step] as python
+type sliceSelector struct {
+	start, end, step int
+	hasStart, hasEnd bool
+}
+
+func (s *sliceSelector) apply(n *Node, root any, result []*Node) []*Node {
+	a, ok := n.Value.(*Array)
+	if !ok || s.step == 0 {
+		return result
+	}
+	length := a.Len()
+	normalize := func(i, lower, upper int) int {
+		if i < 0 {
+			i += length
+		}
+		return min(max(i, lower), upper)
+	}
+	if s.step > 0 {
+		start, end := 0, length
+		if s.hasStart {
+			start = normalize(s.start, 0, length)
+		}
+		if s.hasEnd {
+			end = normalize(s.end, 0, length)
+		}
+		for i := start; i < end; i += s.step {
+			result = append(result, n.child(a.Get(i), "", i))
+		}
+		return result
+	}
+	start, end := length-1, -1
+	if s.hasStart {
+		start = normalize(s.start, -1, length-1)
+	}
+	if s.hasEnd {
+		end = normalize(s.end, -1, length-1)
+	}
+	for i := start; i > end; i += s.step {
+		result = append(result, n.child(a.Get(i), "", i))
+	}
+	return result
+}
+
+type filterSelector struct {
+	expr expr
+}
+
+func (s *filterSelector) apply(n *Node, root any, result []*Node) []*Node {
+	for _, c := range children(n) {
+		if s.expr.eval(c.Value, root) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+type pathParser struct {
+	text string
+	pos  int
+}
+
+func (p *pathParser) errorf(format string, args ...any) error {
+	return &PathError{Path: p.text, Msg: fmt.Sprintf(format, args...), Offset: p.pos}
+}
+
+func (p *pathParser) peek() byte {
+	if p.pos < len(p.text) {
+		return p.text[p.pos]
+	}
+	return 0
+}
+
+func (p *pathParser) skipSpaces() {
+	for p.pos < len(p.text) && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// parseSegments parses segments until the end of the path, or until a character which is not a segment in a filter
+func (p *pathParser) parseSegments(inFilter bool) ([]*segment, error) {
+	var segments []*segment
+	for p.pos < len(p.text) {
+		seg := &segment{}
+		switch p.peek() {
+		case '.':
+			p.pos++
+			if p.peek() == '.' {
+				p.pos++
+				seg.descendant = true
+				if p.peek() == '[' {
+					selectors, err := p.parseBracket()
+					if err != nil {
+						return nil, err
+					}
+					seg.selectors = selectors
+					break
+				}
+			}
+			sel, err := p.parseDotSelector()
+			if err != nil {
+				return nil, err
+			}
+			seg.selectors = []selector{sel}
+		case '[':
+			selectors, err := p.parseBracket()
+			if err != nil {
+				return nil, err
+			}
+			seg.selectors = selectors
+		default:
+			if inFilter {
+				return segments, nil
+			}
+			return nil, p.errorf("unexpected character %q", p.peek())
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+// isNameChar reports whether c may be in a key following a dot
+func isNameChar(c byte) bool {
+	return !strings.ContainsRune(".[]()<>=!&|,'\"?@ \t\r\n", rune(c))
+}
+
+func (p *pathParser) parseDotSelector() (selector, error) {
+	if p.peek() == '*' {
+		p.pos++
+		return &wildcardSelector{}, nil
+	}
+	start := p.pos
+	for p.pos < len(p.text) && isNameChar(p.text[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, p.errorf("expected a key")
+	}
+	return &nameSelector{name: p.text[start:p.pos]}, nil
+}
+
+func (p *pathParser) parseBracket() ([]selector, error) {
+	p.pos++
+	var selectors []selector
+	for {
+		p.skipSpaces()
+		sel, err := p.parseBracketSelector()
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, sel)
+		p.skipSpaces()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+			p.pos++
+			return selectors, nil
+		default:
+			return nil, p.errorf("expected ',' or ']'")
+		}
+	}
+}
+
+func (p *pathParser) parseBracketSelector() (selector, error) {
+	switch c := p.peek(); {
+	case c == '\'' || c == '"':
+		name, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return &nameSelector{name: name}, nil
+	case c == '*':
+		p.pos++
+		return &wildcardSelector{}, nil
+	case c == '?':
+		p.pos++
+		p.skipSpaces()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return &filterSelector{expr: e}, nil
+	case c == '-' || c == ':' || c >= '0' && c <= '9':
+		return p.parseIndexOrSlice()
+	}
+	return nil, p.errorf("unexpected character %q", p.peek())
+}
+
+// parseInt parses an optional integer, ok is false if there is none
+func (p *pathParser) parseInt() (int, bool, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.text) && p.text[p.pos] >= '0' && p.text[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos == start {
+		return 0, false, nil
+	}
+	n, err := strconv.Atoi(p.text[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return 0, false, p.errorf("invalid integer")
+	}
+	return n, true, nil
+}
+
+func (p *pathParser) parseIndexOrSlice() (selector, error) {
+	start, hasStart, err := p.parseInt()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.peek() != ':' {
+		if !hasStart {
+			return nil, p.errorf("expected an index")
+		}
+		return &indexSelector{index: start}, nil
+	}
+	s := &sliceSelector{start: start, hasStart: hasStart, step: 1}
+	p.pos++
+	p.skipSpaces()
+	if s.end, s.hasEnd, err = p.parseInt(); err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.peek() == ':' {
+		p.pos++
+		p.skipSpaces()
+		step, ok, err := p.parseInt()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.step = step
+		}
+	}
+	return s, nil
+}
+
+// parseQuoted parses a string quoted by ' or ", the quote and the backslash are escaped by a backslash
+func (p *pathParser) parseQuoted() (string, error) {
+	quote := p.text[p.pos]
+	p.pos++
+	var buf strings.Builder
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		p.pos++
+		switch c {
+		case quote:
+			return buf.String(), nil
+		case '\\':
+			if p.pos >= len(p.text) {
+				return "", p.errorf("unterminated string")
+			}
+			escape := p.text[p.pos]
+			p.pos++
+			switch escape {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'r':
+				buf.WriteByte('\r')
+			default:
+				buf.WriteByte(escape)
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
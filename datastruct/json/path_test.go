@@ -0,0 +1,134 @@
+package json
+
+import (
+	"testing"
+)
+
+const storeText = `{"store":{
+	"book":[
+		{"category":"reference","author":"Nigel Rees","title":"Sayings of the Century","price":8.95},
+		{"category":"fiction","author":"Evelyn Waugh","title":"Sword of Honour","price":12.99},
+		{"category":"fiction","author":"Herman Melville","title":"Moby Dick","isbn":"0-553-21311-3","price":8.99},
+		{"category":"fiction","author":"J. R. R. Tolkien","title":"The Lord of the Rings","isbn":"0-395-19395-8","price":22}
+	],
+	"bicycle":{"color":"red","price":19.95}
+},"limit":10}`
+
+func newDocument(t *testing.T, text string) *Document {
+	t.Helper()
+	v, err := Parse([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Document{Root: v}
+}
+
+// selectText returns the selected values as a JSON array
+func selectText(t *testing.T, d *Document, path string) string {
+	t.Helper()
+	p, err := ParsePath(path)
+	if err != nil {
+		t.Fatalf("ParsePath(%s) fails: %v", path, err)
+	}
+	a := NewArray()
+	for _, n := range d.Select(p) {
+		a.Append(n.Value)
+	}
+	return string(Marshal(a))
+}
+
+func TestSelect(t *testing.T) {
+	d := newDocument(t, storeText)
+	tests := []struct {
+		path, want string
+	}{
+		{`$`, `[` + string(Marshal(d.Root)) + `]`},
+		{`$.store.book[*].author`, `["Nigel Rees","Evelyn Waugh","Herman Melville","J. R. R. Tolkien"]`},
+		{`$..author`, `["Nigel Rees","Evelyn Waugh","Herman Melville","J. R. R. Tolkien"]`},
+		{`$.store.*.color`, `["red"]`},
+		{`$.store.*[1].price`, `[12.99]`},
+		{`$.store..price`, `[8.95,12.99,8.99,22,19.95]`},
+		{`$..book[2].title`, `["Moby Dick"]`},
+		{`$..book[-1].title`, `["The Lord of the Rings"]`},
+		{`$..book[0,1].title`, `["Sayings of the Century","Sword of Honour"]`},
+		{`$..book[:2].price`, `[8.95,12.99]`},
+		{`$..book[1:3].price`, `[12.99,8.99]`},
+		{`$..book[::-2].price`, `[22,12.99]`},
+		{`$..book[?(@.isbn)].title`, `["Moby Dick","The Lord of the Rings"]`},
+		{`$..book[?(!@.isbn)].price`, `[8.95,12.99]`},
+		{`$..book[?(@.price<10)].title`, `["Sayings of the Century","Moby Dick"]`},
+		{`$..book[?(@.price <= $.limit && @.category == 'fiction')].title`, `["Moby Dick"]`},
+		{`$..book[?(@.price > 20 || @.author =~ "^Nigel")].price`, `[8.95,22]`},
+		{`$..book[?@.price == 22].author`, `["J. R. R. Tolkien"]`},
+		{`$.store['bicycle']["color"]`, `["red"]`},
+		{`$.store.bicycle[*]`, `["red",19.95]`},
+		{`$.none`, `[]`},
+		{`$.store.book.author`, `[]`},
+		{`.store.bicycle.color`, `["red"]`},
+		{`store.bicycle`, `[{"color":"red","price":19.95}]`},
+		{`.`, `[` + string(Marshal(d.Root)) + `]`},
+		{`.store.book[1].price`, `[12.99]`},
+	}
+	for _, tt := range tests {
+		if got := selectText(t, d, tt.path); got != tt.want {
+			t.Errorf("%s selects %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	legacy := map[string]bool{"$": false, "$.a": false, ".": true, ".a": true, "a.b": true, "[0]": true}
+	for text, want := range legacy {
+		p, err := ParsePath(text)
+		if err != nil || p.IsLegacy() != want {
+			t.Errorf("ParsePath(%s) = %v, %v", text, p, err)
+		}
+	}
+	for _, text := range []string{"$.", "$a", "$[", "$[1", "$['a]", "$[?(@.a == )]", "$[?(@.a == 1]", "a..", "$[x]"} {
+		if _, err := ParsePath(text); err == nil {
+			t.Errorf("ParsePath(%s) succeeds", text)
+		}
+	}
+}
+
+func mustPath(t *testing.T, text string) *Path {
+	t.Helper()
+	p, err := ParsePath(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestModify(t *testing.T) {
+	d := newDocument(t, `{"a":[1,2,3,4],"b":{"c":1},"d":[{"c":2},{"e":3}]}`)
+	if n := d.Set(mustPath(t, `$..c`), int64(0)); n != 2 {
+		t.Errorf("set %d values", n)
+	}
+	if n := d.Set(mustPath(t, `$.d[*].f`), "x"); n != 2 {
+		t.Errorf("added %d keys", n)
+	}
+	if n := d.Set(mustPath(t, `$.x.y`), "x"); n != 0 {
+		t.Errorf("added %d keys to a missing object", n)
+	}
+	if got := string(Marshal(d.Root)); got != `{"a":[1,2,3,4],"b":{"c":0},"d":[{"c":0,"f":"x"},{"e":3,"f":"x"}]}` {
+		t.Errorf("got %s", got)
+	}
+	if n := d.Delete(mustPath(t, `$.a[0,2,-1,0]`)); n != 3 {
+		t.Errorf("deleted %d values", n)
+	}
+	if n := d.Delete(mustPath(t, `$..f`)); n != 2 {
+		t.Errorf("deleted %d values", n)
+	}
+	patch, _ := Parse([]byte(`{"c":null,"g":{"h":null}}`))
+	if n := d.Merge(mustPath(t, `$.b`), patch); n != 1 {
+		t.Errorf("merged %d values", n)
+	}
+	if got := string(Marshal(d.Root)); got != `{"a":[2],"b":{"g":{}},"d":[{"c":0},{"e":3}]}` {
+		t.Errorf("got %s", got)
+	}
+	d.Set(mustPath(t, `$`), "root")
+	if d.Root != "root" {
+		t.Errorf("root is %v", d.Root)
+	}
+}
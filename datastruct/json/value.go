@@ -0,0 +1,222 @@
+package json
+
+// A JSON value is one of nil for null, bool, int64, float64, string, *Array and *Object.
+// Integers are kept apart from floats, so that they are formatted as they are written.
+
+// Document holds the root value of a JSON key, the root may be replaced by any value
+type Document struct {
+	Root any
+}
+
+// Copy returns a deep copy of d
+func (d *Document) Copy() *Document {
+	return &Document{Root: Copy(d.Root)}
+}
+
+// Array is a JSON array
+type Array struct {
+	elements []any
+}
+
+func NewArray(elements ...any) *Array {
+	return &Array{elements: elements}
+}
+
+func (a *Array) Len() int {
+	return len(a.elements)
+}
+
+func (a *Array) Get(i int) any {
+	return a.elements[i]
+}
+
+func (a *Array) Set(i int, v any) {
+	a.elements[i] = v
+}
+
+func (a *Array) Append(values ...any) {
+	a.elements = append(a.elements, values...)
+}
+
+// Insert inserts values before the i-th element, 0 <= i <= Len()
+func (a *Array) Insert(i int, values ...any) {
+	a.elements = append(a.elements[:i], append(values, a.elements[i:]...)...)
+}
+
+// Remove removes the i-th element and returns it
+func (a *Array) Remove(i int) any {
+	v := a.elements[i]
+	a.elements = append(a.elements[:i], a.elements[i+1:]...)
+	return v
+}
+
+// Object is a JSON object, the keys are kept in insertion order
+type Object struct {
+	keys   []string
+	values map[string]any
+}
+
+func NewObject() *Object {
+	return &Object{values: make(map[string]any)}
+}
+
+func (o *Object) Len() int {
+	return len(o.keys)
+}
+
+func (o *Object) Get(key string) (any, bool) {
+	v, ok := o.values[key]
+	return v, ok
+}
+
+// Set replaces the value of key, a new key is appended
+func (o *Object) Set(key string, v any) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+func (o *Object) Delete(key string) bool {
+	if _, ok := o.values[key]; !ok {
+		return false
+	}
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Keys returns the keys in order, the slice must not be modified
+func (o *Object) Keys() []string {
+	return o.keys
+}
+
+// TypeName returns the type of v replied by JSON.TYPE
+func TypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Array:
+		return "array"
+	case *Object:
+		return "object"
+	default:
+		return "unknown"
+	}
+}
+
+// Copy returns a deep copy of v
+func Copy(v any) any {
+	switch val := v.(type) {
+	case *Array:
+		elements := make([]any, len(val.elements))
+		for i, element := range val.elements {
+			elements[i] = Copy(element)
+		}
+		return &Array{elements: elements}
+	case *Object:
+		o := &Object{keys: make([]string, len(val.keys)), values: make(map[string]any, len(val.keys))}
+		copy(o.keys, val.keys)
+		for key, value := range val.values {
+			o.values[key] = Copy(value)
+		}
+		return o
+	default:
+		return v
+	}
+}
+
+// toFloat returns v as a float if it is a number
+func toFloat(v any) (float64, bool) {
+	switch val := v.(type) {
+	case int64:
+		return float64(val), true
+	case float64:
+		return val, true
+	}
+	return 0, false
+}
+
+// Equal reports whether a and b are the same JSON value, an integer equals a float of the same value
+func Equal(a, b any) bool {
+	if x, ok := toFloat(a); ok {
+		// large integers are not exact as floats
+		if i, ok := a.(int64); ok {
+			if j, ok := b.(int64); ok {
+				return i == j
+			}
+		}
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	switch x := a.(type) {
+	case nil:
+		return b == nil
+	case bool:
+		y, ok := b.(bool)
+		return ok && x == y
+	case string:
+		y, ok := b.(string)
+		return ok && x == y
+	case *Array:
+		y, ok := b.(*Array)
+		if !ok || x.Len() != y.Len() {
+			return false
+		}
+		for i := range x.elements {
+			if !Equal(x.elements[i], y.elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *Object:
+		y, ok := b.(*Object)
+		if !ok || x.Len() != y.Len() {
+			return false
+		}
+		for key, value := range x.values {
+			other, ok := y.values[key]
+			if !ok || !Equal(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// MergePatch applies patch to target as RFC 7396 and returns the result, target may be modified.
+// A null in patch removes the key, patch must not be shared with other values.
+func MergePatch(target, patch any) any {
+	p, ok := patch.(*Object)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(*Object)
+	if !ok {
+		t = NewObject()
+	}
+	for _, key := range p.keys {
+		value := p.values[key]
+		if value == nil {
+			t.Delete(key)
+			continue
+		}
+		old, _ := t.Get(key)
+		t.Set(key, MergePatch(old, value))
+	}
+	return t
+}
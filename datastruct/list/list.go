@@ -5,6 +5,26 @@ import "errors"
 type Consumer func(i int, val interface{}) bool
 type Expected func(actual interface{}) bool
 
+// List is the ordered collection used by the list commands
+type List interface {
+	Add(val interface{})
+	Insert(idx int, val interface{}) error
+	Get(idx int) (any, error)
+	Modify(idx int, val any) error
+	Del(idx int) (any, error)
+	DelLastNode() (any, error)
+	DelAllByVal(expected Expected) int
+	DelByVal(expected Expected, count int) int
+	ReverseDelByVal(expected Expected, count int) int
+	Len() int
+	ForEach(consumer Consumer)
+	ReverseForEach(consumer Consumer)
+	Contains(expect Expected) bool
+	Range(start, stop int) []any
+}
+
+var errOutOfRange = errors.New("out of range")
+
 type LinkedList struct {
 	head *node
 	last *node
@@ -34,6 +54,29 @@ func (l *LinkedList) Add(val interface{}) {
 	l.size++
 }
 
+// Insert puts val at idx, the element at idx and after it are moved backward, idx == Len() appends val
+func (l *LinkedList) Insert(idx int, val interface{}) error {
+	if idx < 0 || idx > l.Len() {
+		return errOutOfRange
+	}
+	if idx == l.Len() {
+		l.Add(val)
+		return nil
+	}
+	pivot := l.find(idx)
+	n := newNode(val)
+	n.prev = pivot.prev
+	n.next = pivot
+	if pivot.prev == nil {
+		l.head = n
+	} else {
+		pivot.prev.next = n
+	}
+	pivot.prev = n
+	l.size++
+	return nil
+}
+
 func (l *LinkedList) find(idx int) *node {
 	if idx < l.Len()/2 {
 		n := l.head
@@ -55,14 +98,14 @@ func (l *LinkedList) Len() int {
 
 func (l *LinkedList) Get(idx int) (any, error) {
 	if idx < 0 || idx >= l.Len() {
-		return nil, errors.New("out of range")
+		return nil, errOutOfRange
 	}
 	return l.find(idx).val, nil
 }
 
 func (l *LinkedList) Modify(idx int, val any) error {
 	if idx < 0 || idx >= l.Len() {
-		return errors.New("out of range")
+		return errOutOfRange
 	}
 	l.find(idx).val = val
 	return nil
@@ -81,16 +124,16 @@ func (l *LinkedList) delNode(n *node) {
 	if next != nil {
 		next.prev = prev
 	} else {
-		l.last = next
+		l.last = prev
 	}
 	n.prev = nil
 	n.next = nil
-	return
+	l.size--
 }
 
 func (l *LinkedList) Del(idx int) (any, error) {
 	if idx < 0 || idx >= l.Len() {
-		return nil, errors.New("out of range")
+		return nil, errOutOfRange
 	}
 	n := l.find(idx)
 	l.delNode(n)
@@ -110,6 +153,18 @@ func (l *LinkedList) ForEach(consumer Consumer) {
 		if !consumer(i, n.val) {
 			break
 		}
+		i++
+	}
+}
+
+// ReverseForEach visits elements from the tail, i is still the index counted from the head
+func (l *LinkedList) ReverseForEach(consumer Consumer) {
+	i := l.Len() - 1
+	for n := l.last; n != nil; n = n.prev {
+		if !consumer(i, n.val) {
+			break
+		}
+		i--
 	}
 }
 
@@ -138,6 +193,51 @@ func (l *LinkedList) DelAllByVal(expected Expected) int {
 	return removed
 }
 
+// DelByVal removes at most count matched elements from the head
+func (l *LinkedList) DelByVal(expected Expected, count int) int {
+	removed := 0
+	for n := l.head; n != nil && removed < count; {
+		next := n.next
+		if expected(n.val) {
+			l.delNode(n)
+			removed++
+		}
+		n = next
+	}
+	return removed
+}
+
+// ReverseDelByVal removes at most count matched elements from the tail
+func (l *LinkedList) ReverseDelByVal(expected Expected, count int) int {
+	removed := 0
+	for n := l.last; n != nil && removed < count; {
+		prev := n.prev
+		if expected(n.val) {
+			l.delNode(n)
+			removed++
+		}
+		n = prev
+	}
+	return removed
+}
+
+// Range returns the elements in [start, stop)
+func (l *LinkedList) Range(start, stop int) []any {
+	if start < 0 || stop > l.Len() || start > stop {
+		return nil
+	}
+	result := make([]any, 0, stop-start)
+	if start == stop {
+		return result
+	}
+	n := l.find(start)
+	for i := start; i < stop; i++ {
+		result = append(result, n.val)
+		n = n.next
+	}
+	return result
+}
+
 func NewLinkedList() *LinkedList {
 	return &LinkedList{}
 }
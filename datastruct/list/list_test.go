@@ -0,0 +1,107 @@
+package list
+
+import (
+	"reflect"
+	"testing"
+)
+
+func toSlice(l List) []any {
+	result := make([]any, 0, l.Len())
+	l.ForEach(func(i int, val interface{}) bool {
+		result = append(result, val)
+		return true
+	})
+	return result
+}
+
+func TestLinkedListForEach(t *testing.T) {
+	l := NewLinkedList()
+	for i := 0; i < 5; i++ {
+		l.Add(i)
+	}
+	l.ForEach(func(i int, val interface{}) bool {
+		if i != val.(int) {
+			t.Errorf("expect index %d, got %d", val, i)
+		}
+		return true
+	})
+	l.ReverseForEach(func(i int, val interface{}) bool {
+		if i != val.(int) {
+			t.Errorf("expect index %d, got %d", val, i)
+		}
+		return true
+	})
+}
+
+func TestLinkedListDel(t *testing.T) {
+	l := NewLinkedList()
+	for i := 0; i < 5; i++ {
+		l.Add(i)
+	}
+	if val, _ := l.Del(4); val != 4 || l.Len() != 4 {
+		t.Fatalf("del last got %v, len %d", val, l.Len())
+	}
+	// the tail must move to the previous node
+	l.Add(5)
+	if got := toSlice(l); !reflect.DeepEqual(got, []any{0, 1, 2, 3, 5}) {
+		t.Fatalf("got %v", got)
+	}
+	if val, _ := l.DelLastNode(); val != 5 {
+		t.Fatalf("got %v", val)
+	}
+	if val, _ := l.Del(0); val != 0 || l.Len() != 3 {
+		t.Fatalf("del first got %v, len %d", val, l.Len())
+	}
+	if _, err := l.Del(3); err == nil {
+		t.Error("expect out of range")
+	}
+}
+
+func TestLinkedListDelByVal(t *testing.T) {
+	l := NewLinkedList()
+	for _, v := range []int{1, 2, 1, 3, 1} {
+		l.Add(v)
+	}
+	isOne := func(actual interface{}) bool {
+		return actual.(int) == 1
+	}
+	if n := l.ReverseDelByVal(isOne, 1); n != 1 || l.Len() != 4 {
+		t.Fatalf("removed %d, len %d", n, l.Len())
+	}
+	if got := toSlice(l); !reflect.DeepEqual(got, []any{1, 2, 1, 3}) {
+		t.Fatalf("got %v", got)
+	}
+	if n := l.DelByVal(isOne, 1); n != 1 {
+		t.Fatalf("removed %d", n)
+	}
+	if got := toSlice(l); !reflect.DeepEqual(got, []any{2, 1, 3}) {
+		t.Fatalf("got %v", got)
+	}
+	if n := l.DelAllByVal(isOne); n != 1 || l.Len() != 2 {
+		t.Fatalf("removed %d, len %d", n, l.Len())
+	}
+	l.Add(4)
+	if got := toSlice(l); !reflect.DeepEqual(got, []any{2, 3, 4}) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestLinkedListInsertRange(t *testing.T) {
+	l := NewLinkedList()
+	_ = l.Insert(0, 2)
+	_ = l.Insert(0, 0)
+	_ = l.Insert(1, 1)
+	_ = l.Insert(3, 3)
+	if err := l.Insert(5, 5); err == nil {
+		t.Error("expect out of range")
+	}
+	if got := l.Range(0, 4); !reflect.DeepEqual(got, []any{0, 1, 2, 3}) {
+		t.Fatalf("got %v", got)
+	}
+	if got := l.Range(1, 3); !reflect.DeepEqual(got, []any{1, 2}) {
+		t.Fatalf("got %v", got)
+	}
+	if got := l.Range(2, 2); len(got) != 0 {
+		t.Fatalf("got %v", got)
+	}
+}
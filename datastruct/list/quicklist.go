@@ -0,0 +1,618 @@
+package list
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+const (
+	// DefaultFill limits every node to 8KB, the same as the default list-max-listpack-size of redis
+	DefaultFill = -2
+	// minCompressBytes is the smallest node worth compressing
+	minCompressBytes = 48
+	// minCompressSaving is the least saved bytes to keep a node compressed
+	minCompressSaving = 8
+)
+
+// fillSizeLimits are the node size limits in bytes of fill -1 to -5
+var fillSizeLimits = [...]int{4096, 8192, 16384, 32768, 65536}
+
+// QuickList is a doubly linked list of packed nodes. Every node holds many elements in one byte slice,
+// so an element costs a few bytes of header instead of a node with two pointers.
+// Elements must be []byte, and the values returned are copies.
+type QuickList struct {
+	head *qlNode
+	tail *qlNode
+	size int
+	// fill limits the entries of a node if positive, otherwise it selects the byte limit of a node from fillSizeLimits
+	fill int
+	// compressDepth is the number of nodes at each end kept uncompressed, 0 disables compression
+	compressDepth int
+}
+
+// qlNode is a chunk of packed entries. An entry is encoded as
+// <uvarint value length> <value> <backlen>, and backlen is the size of the first two parts
+// which can be decoded from its last byte, so that entries can be walked in both directions.
+type qlNode struct {
+	prev       *qlNode
+	next       *qlNode
+	count      int
+	data       []byte
+	compressed bool
+	// incompressible is set if compressing failed to save space, it is reset once the node is decompressed for changes
+	incompressible bool
+}
+
+var (
+	flateWriters = sync.Pool{New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	}}
+	flateReaders = sync.Pool{New: func() any {
+		return flate.NewReader(nil)
+	}}
+)
+
+// NewQuickList creates a QuickList, see QuickList.fill and QuickList.compressDepth for the arguments
+func NewQuickList(fill, compressDepth int) *QuickList {
+	if fill == 0 || fill < -len(fillSizeLimits) {
+		fill = DefaultFill
+	}
+	if compressDepth < 0 {
+		compressDepth = 0
+	}
+	return &QuickList{fill: fill, compressDepth: compressDepth}
+}
+
+/* ---- packed entries ---- */
+
+func backlenSize(l int) int {
+	size := 1
+	for l >>= 7; l > 0; l >>= 7 {
+		size++
+	}
+	return size
+}
+
+func uvarintSize(x uint64) int {
+	size := 1
+	for ; x >= 0x80; x >>= 7 {
+		size++
+	}
+	return size
+}
+
+func entrySize(val []byte) int {
+	l := uvarintSize(uint64(len(val))) + len(val)
+	return l + backlenSize(l)
+}
+
+// putEntry encodes val into dst which must have entrySize(val) bytes
+func putEntry(dst []byte, val []byte) {
+	p := binary.PutUvarint(dst, uint64(len(val)))
+	p += copy(dst[p:], val)
+	// the highest 7 bits first, every byte except the first one is flagged to have more bytes on its left
+	l := p
+	n := backlenSize(l)
+	for i := n - 1; i >= 0; i-- {
+		b := byte(l>>(7*i)) & 0x7f
+		if i < n-1 {
+			b |= 0x80
+		}
+		dst[p] = b
+		p++
+	}
+}
+
+// growBytes extends buf by n bytes and returns the extended slice
+func growBytes(buf []byte, n int) []byte {
+	if len(buf)+n <= cap(buf) {
+		return buf[:len(buf)+n]
+	}
+	grown := make([]byte, len(buf)+n, 2*cap(buf)+n)
+	copy(grown, buf)
+	return grown
+}
+
+func appendEntry(buf []byte, val []byte) []byte {
+	start := len(buf)
+	buf = growBytes(buf, entrySize(val))
+	putEntry(buf[start:], val)
+	return buf
+}
+
+// readEntry returns the value at offset p and the offset of the next entry
+func readEntry(data []byte, p int) (val []byte, next int) {
+	l, hl := binary.Uvarint(data[p:])
+	start := p + hl
+	end := start + int(l)
+	return data[start:end], end + backlenSize(end-p)
+}
+
+// readEntryBackward returns the value ending at offset end and the offset of this entry
+func readEntryBackward(data []byte, end int) (val []byte, start int) {
+	l, shift, p := 0, 0, end
+	for {
+		p--
+		b := data[p]
+		l |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	start = p - l
+	val, _ = readEntry(data, start)
+	return val, start
+}
+
+func copyBytes(val []byte) []byte {
+	result := make([]byte, len(val))
+	copy(result, val)
+	return result
+}
+
+/* ---- node ---- */
+
+// rawData returns the uncompressed entries of n without changing n
+func (n *qlNode) rawData() []byte {
+	if !n.compressed {
+		return n.data
+	}
+	r := flateReaders.Get().(io.ReadCloser)
+	defer flateReaders.Put(r)
+	_ = r.(flate.Resetter).Reset(bytes.NewReader(n.data), nil)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		panic("quicklist: corrupted node: " + err.Error())
+	}
+	return data
+}
+
+// decompress prepares n for changes
+func (n *qlNode) decompress() {
+	n.incompressible = false
+	if n.compressed {
+		n.data = n.rawData()
+		n.compressed = false
+	}
+}
+
+func (n *qlNode) compress() {
+	if n.compressed || n.incompressible || len(n.data) < minCompressBytes {
+		return
+	}
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	w.Reset(&buf)
+	_, _ = w.Write(n.data)
+	_ = w.Close()
+	flateWriters.Put(w)
+	if buf.Len()+minCompressSaving > len(n.data) {
+		n.incompressible = true
+		return
+	}
+	n.data = buf.Bytes()
+	n.compressed = true
+}
+
+// offset returns the offset of the idx-th entry, n must be uncompressed
+func (n *qlNode) offset(idx int) int {
+	p := 0
+	for i := 0; i < idx; i++ {
+		_, p = readEntry(n.data, p)
+	}
+	return p
+}
+
+func (n *qlNode) insertAt(p int, val []byte) {
+	size := entrySize(val)
+	n.data = growBytes(n.data, size)
+	copy(n.data[p+size:], n.data[p:len(n.data)-size])
+	putEntry(n.data[p:], val)
+	n.count++
+}
+
+// removeAt removes the entry at offset p and returns its value
+func (n *qlNode) removeAt(p int) []byte {
+	val, next := readEntry(n.data, p)
+	val = copyBytes(val)
+	n.data = append(n.data[:p], n.data[next:]...)
+	n.count--
+	return val
+}
+
+/* ---- nodes of QuickList ---- */
+
+// allowInsert returns true if val can be added to n without exceeding the limit
+func (q *QuickList) allowInsert(n *qlNode, val []byte) bool {
+	if n == nil {
+		return false
+	}
+	if q.fill > 0 {
+		return n.count < q.fill
+	}
+	// an oversize element gets a node of its own
+	return n.count == 0 || n.rawSize()+entrySize(val) <= fillSizeLimits[-q.fill-1]
+}
+
+func (n *qlNode) rawSize() int {
+	if !n.compressed {
+		return len(n.data)
+	}
+	return len(n.rawData())
+}
+
+func (q *QuickList) linkAfter(prev, n *qlNode) {
+	n.prev = prev
+	if prev == nil {
+		n.next = q.head
+		q.head = n
+	} else {
+		n.next = prev.next
+		prev.next = n
+	}
+	if n.next == nil {
+		q.tail = n
+	} else {
+		n.next.prev = n
+	}
+}
+
+func (q *QuickList) unlink(n *qlNode) {
+	if n.prev == nil {
+		q.head = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		q.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+	n.prev, n.next = nil, nil
+}
+
+// isInterior returns true if n is farther than compressDepth from both ends
+func (q *QuickList) isInterior(n *qlNode) bool {
+	if q.compressDepth == 0 {
+		return false
+	}
+	h, t := q.head, q.tail
+	for i := 0; i < q.compressDepth; i++ {
+		if h == nil || t == nil {
+			return false
+		}
+		if h == n || t == n {
+			return false
+		}
+		h, t = h.next, t.prev
+	}
+	return true
+}
+
+// release recompresses n after it is modified if n is an interior node
+func (q *QuickList) release(n *qlNode) {
+	if n.count > 0 && q.isInterior(n) {
+		n.compress()
+	}
+}
+
+// compressEnds keeps the nodes within compressDepth of both ends uncompressed, and compresses
+// the nodes just beyond them. The nodes deeper inside have been compressed when they passed by.
+func (q *QuickList) compressEnds() {
+	if q.compressDepth == 0 {
+		return
+	}
+	h, t := q.head, q.tail
+	for i := 0; i < q.compressDepth && h != nil && t != nil; i++ {
+		h.decompress()
+		t.decompress()
+		if h == t || h.next == t {
+			return
+		}
+		h, t = h.next, t.prev
+	}
+	if h != nil && q.isInterior(h) {
+		h.compress()
+	}
+	if t != nil && q.isInterior(t) {
+		t.compress()
+	}
+}
+
+// find returns the node holding the idx-th element and the index of the element inside the node
+func (q *QuickList) find(idx int) (*qlNode, int) {
+	if idx < q.size/2 {
+		n := q.head
+		for idx >= n.count {
+			idx -= n.count
+			n = n.next
+		}
+		return n, idx
+	}
+	n := q.tail
+	rest := q.size - 1 - idx // elements after idx
+	for rest >= n.count {
+		rest -= n.count
+		n = n.prev
+	}
+	return n, n.count - 1 - rest
+}
+
+/* ---- List ---- */
+
+func (q *QuickList) Len() int {
+	return q.size
+}
+
+func (q *QuickList) Add(val interface{}) {
+	bs := val.([]byte)
+	n := q.tail
+	if !q.allowInsert(n, bs) {
+		n = &qlNode{}
+		q.linkAfter(q.tail, n)
+	}
+	n.decompress()
+	n.data = appendEntry(n.data, bs)
+	n.count++
+	q.size++
+	q.compressEnds()
+}
+
+func (q *QuickList) Insert(idx int, val interface{}) error {
+	if idx < 0 || idx > q.size {
+		return errOutOfRange
+	}
+	if idx == q.size {
+		q.Add(val)
+		return nil
+	}
+	bs := val.([]byte)
+	n, i := q.find(idx)
+	switch {
+	case q.allowInsert(n, bs):
+		n.decompress()
+		n.insertAt(n.offset(i), bs)
+		q.release(n)
+	case i == 0 && q.allowInsert(n.prev, bs):
+		// append to the previous node instead
+		prev := n.prev
+		prev.decompress()
+		prev.data = appendEntry(prev.data, bs)
+		prev.count++
+		q.release(prev)
+	case i == 0:
+		node := &qlNode{}
+		node.data = appendEntry(nil, bs)
+		node.count = 1
+		q.linkAfter(n.prev, node)
+		q.release(node)
+	default:
+		// split n at i, val goes to the tail of the left half
+		n.decompress()
+		p := n.offset(i)
+		right := &qlNode{count: n.count - i, data: copyBytes(n.data[p:])}
+		n.data, n.count = n.data[:p:p], i
+		q.linkAfter(n, right)
+		left := n
+		if !q.allowInsert(left, bs) {
+			left = &qlNode{}
+			q.linkAfter(n, left)
+		}
+		left.data = appendEntry(left.data, bs)
+		left.count++
+		q.release(n)
+		q.release(left)
+		q.release(right)
+	}
+	q.size++
+	q.compressEnds()
+	return nil
+}
+
+func (q *QuickList) Get(idx int) (any, error) {
+	if idx < 0 || idx >= q.size {
+		return nil, errOutOfRange
+	}
+	n, i := q.find(idx)
+	data := n.rawData()
+	p := 0
+	for j := 0; j < i; j++ {
+		_, p = readEntry(data, p)
+	}
+	val, _ := readEntry(data, p)
+	return copyBytes(val), nil
+}
+
+func (q *QuickList) Modify(idx int, val any) error {
+	if idx < 0 || idx >= q.size {
+		return errOutOfRange
+	}
+	n, i := q.find(idx)
+	n.decompress()
+	p := n.offset(i)
+	n.removeAt(p)
+	n.insertAt(p, val.([]byte))
+	q.release(n)
+	return nil
+}
+
+// delEntry removes the entry at offset p of n, and unlinks n once it is empty
+func (q *QuickList) delEntry(n *qlNode, p int) []byte {
+	n.decompress()
+	val := n.removeAt(p)
+	q.size--
+	if n.count == 0 {
+		q.unlink(n)
+	} else {
+		q.release(n)
+	}
+	q.compressEnds()
+	return val
+}
+
+func (q *QuickList) Del(idx int) (any, error) {
+	if idx < 0 || idx >= q.size {
+		return nil, errOutOfRange
+	}
+	n, i := q.find(idx)
+	n.decompress()
+	return q.delEntry(n, n.offset(i)), nil
+}
+
+func (q *QuickList) DelLastNode() (any, error) {
+	if q.size == 0 {
+		return nil, nil
+	}
+	n := q.tail
+	n.decompress()
+	_, p := readEntryBackward(n.data, len(n.data))
+	return q.delEntry(n, p), nil
+}
+
+// delByVal removes at most count matched elements, from the tail if reverse is true
+func (q *QuickList) delByVal(expected Expected, count int, reverse bool) int {
+	removed := 0
+	n := q.head
+	if reverse {
+		n = q.tail
+	}
+	for n != nil && removed < count {
+		next := n.next
+		if reverse {
+			next = n.prev
+		}
+		data := n.rawData()
+		kept := make([]byte, 0, len(data))
+		keptCount := 0
+		changed := false
+		if reverse {
+			// collect the kept entries backward, then restore their order
+			var entries [][]byte
+			for end := len(data); end > 0; {
+				val, start := readEntryBackward(data, end)
+				if removed < count && expected(val) {
+					removed++
+					changed = true
+				} else {
+					entries = append(entries, data[start:end])
+				}
+				end = start
+			}
+			for i := len(entries) - 1; i >= 0; i-- {
+				kept = append(kept, entries[i]...)
+			}
+			keptCount = len(entries)
+		} else {
+			for p := 0; p < len(data); {
+				val, next := readEntry(data, p)
+				if removed < count && expected(val) {
+					removed++
+					changed = true
+				} else {
+					kept = append(kept, data[p:next]...)
+					keptCount++
+				}
+				p = next
+			}
+		}
+		if changed {
+			q.size -= n.count - keptCount
+			n.data, n.count, n.compressed, n.incompressible = kept, keptCount, false, false
+			if n.count == 0 {
+				q.unlink(n)
+			} else {
+				q.release(n)
+			}
+		}
+		n = next
+	}
+	q.compressEnds()
+	return removed
+}
+
+func (q *QuickList) DelAllByVal(expected Expected) int {
+	return q.delByVal(expected, q.size, false)
+}
+
+func (q *QuickList) DelByVal(expected Expected, count int) int {
+	return q.delByVal(expected, count, false)
+}
+
+func (q *QuickList) ReverseDelByVal(expected Expected, count int) int {
+	return q.delByVal(expected, count, true)
+}
+
+func (q *QuickList) ForEach(consumer Consumer) {
+	i := 0
+	for n := q.head; n != nil; n = n.next {
+		data := n.rawData()
+		for p := 0; p < len(data); i++ {
+			var val []byte
+			val, p = readEntry(data, p)
+			if !consumer(i, copyBytes(val)) {
+				return
+			}
+		}
+	}
+}
+
+func (q *QuickList) ReverseForEach(consumer Consumer) {
+	i := q.size - 1
+	for n := q.tail; n != nil; n = n.prev {
+		data := n.rawData()
+		for end := len(data); end > 0; i-- {
+			var val []byte
+			val, end = readEntryBackward(data, end)
+			if !consumer(i, copyBytes(val)) {
+				return
+			}
+		}
+	}
+}
+
+func (q *QuickList) Contains(expect Expected) bool {
+	result := false
+	q.ForEach(func(i int, val interface{}) bool {
+		if expect(val) {
+			result = true
+			return false
+		}
+		return true
+	})
+	return result
+}
+
+// Range returns the elements in [start, stop)
+func (q *QuickList) Range(start, stop int) []any {
+	if start < 0 || stop > q.size || start > stop {
+		return nil
+	}
+	result := make([]any, 0, stop-start)
+	if start == stop {
+		return result
+	}
+	// values are copied into a shared buffer, a slice still points to the old buffer after it grows
+	var buf []byte
+	n, i := q.find(start)
+	for ; n != nil && len(result) < stop-start; n = n.next {
+		data := n.rawData()
+		p := 0
+		for j := 0; j < i; j++ {
+			_, p = readEntry(data, p)
+		}
+		for p < len(data) && len(result) < stop-start {
+			var val []byte
+			val, p = readEntry(data, p)
+			begin := len(buf)
+			buf = append(buf, val...)
+			result = append(result, buf[begin:len(buf):len(buf)])
+		}
+		i = 0
+	}
+	return result
+}
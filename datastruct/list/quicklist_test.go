@@ -0,0 +1,313 @@
+package list
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func bytesOf(i int) []byte {
+	return []byte("element-" + strconv.Itoa(i))
+}
+
+func isBytes(expected []byte) Expected {
+	return func(actual interface{}) bool {
+		return bytes.Equal(actual.([]byte), expected)
+	}
+}
+
+// checkNodes verifies the links, counts and compression of every node
+func checkNodes(t *testing.T, q *QuickList) {
+	t.Helper()
+	size, idx := 0, 0
+	var prev *qlNode
+	for n := q.head; n != nil; n = n.next {
+		if n.prev != prev {
+			t.Fatalf("node %d has a broken prev link", idx)
+		}
+		if n.count == 0 {
+			t.Fatalf("node %d is empty", idx)
+		}
+		if n.compressed && !q.isInterior(n) {
+			t.Fatalf("node %d within compress depth is compressed", idx)
+		}
+		size += n.count
+		prev = n
+		idx++
+	}
+	if prev != q.tail {
+		t.Fatal("tail is not the last node")
+	}
+	if size != q.size {
+		t.Fatalf("size %d, nodes hold %d", q.size, size)
+	}
+}
+
+func TestQuickListEntry(t *testing.T) {
+	var buf []byte
+	vals := [][]byte{{}, []byte("a"), bytes.Repeat([]byte("b"), 126), bytes.Repeat([]byte("c"), 200), bytes.Repeat([]byte("d"), 20000)}
+	for _, val := range vals {
+		buf = appendEntry(buf, val)
+	}
+	size := 0
+	for _, val := range vals {
+		size += entrySize(val)
+	}
+	if size != len(buf) {
+		t.Fatalf("entry size %d, encoded %d", size, len(buf))
+	}
+	p := 0
+	for _, val := range vals {
+		var got []byte
+		got, p = readEntry(buf, p)
+		if !bytes.Equal(got, val) {
+			t.Fatalf("read %d bytes, expect %d bytes", len(got), len(val))
+		}
+	}
+	end := len(buf)
+	for i := len(vals) - 1; i >= 0; i-- {
+		var got []byte
+		got, end = readEntryBackward(buf, end)
+		if !bytes.Equal(got, vals[i]) {
+			t.Fatalf("read %d bytes backward, expect %d bytes", len(got), len(vals[i]))
+		}
+	}
+}
+
+func TestQuickListOps(t *testing.T) {
+	q := NewQuickList(4, 1)
+	for i := 0; i < 10; i++ {
+		q.Add(bytesOf(i))
+	}
+	checkNodes(t, q)
+	if val, _ := q.Get(7); !bytes.Equal(val.([]byte), bytesOf(7)) {
+		t.Fatalf("got %s", val)
+	}
+	// insert into a full node
+	_ = q.Insert(2, []byte("x"))
+	_ = q.Insert(0, []byte("y"))
+	checkNodes(t, q)
+	if got := q.Range(0, 4); !reflect.DeepEqual(got, []any{[]byte("y"), bytesOf(0), bytesOf(1), []byte("x")}) {
+		t.Fatalf("got %q", got)
+	}
+	_ = q.Modify(1, []byte("z"))
+	if val, _ := q.Del(1); !bytes.Equal(val.([]byte), []byte("z")) {
+		t.Fatalf("got %s", val)
+	}
+	if val, _ := q.DelLastNode(); !bytes.Equal(val.([]byte), bytesOf(9)) {
+		t.Fatalf("got %s", val)
+	}
+	checkNodes(t, q)
+	if q.Len() != 10 {
+		t.Fatalf("len %d", q.Len())
+	}
+	q.ReverseForEach(func(i int, val interface{}) bool {
+		if expect, _ := q.Get(i); !bytes.Equal(expect.([]byte), val.([]byte)) {
+			t.Fatalf("index %d got %s, expect %s", i, val, expect)
+		}
+		return true
+	})
+	if _, err := q.Get(10); err == nil {
+		t.Error("expect out of range")
+	}
+	if err := q.Insert(11, []byte("x")); err == nil {
+		t.Error("expect out of range")
+	}
+}
+
+// TestQuickListRandom applies the same random operations to a QuickList and a LinkedList
+func TestQuickListRandom(t *testing.T) {
+	for _, setting := range [][2]int{{3, 0}, {5, 1}, {-1, 2}, {16, 1}} {
+		q := NewQuickList(setting[0], setting[1])
+		l := NewLinkedList()
+		r := rand.New(rand.NewSource(int64(setting[0])))
+		for op := 0; op < 5000; op++ {
+			// values repeat so that removing by value matches several elements
+			val := bytes.Repeat(bytesOf(r.Intn(20)), 1+r.Intn(30))
+			switch r.Intn(8) {
+			case 0, 1:
+				q.Add(val)
+				l.Add(val)
+			case 2, 3:
+				idx := r.Intn(l.Len() + 1)
+				_ = q.Insert(idx, val)
+				_ = l.Insert(idx, val)
+			case 4:
+				if l.Len() > 0 {
+					idx := r.Intn(l.Len())
+					got, _ := q.Del(idx)
+					expect, _ := l.Del(idx)
+					if !bytes.Equal(got.([]byte), expect.([]byte)) {
+						t.Fatalf("del %d got %q, expect %q", idx, got, expect)
+					}
+				}
+			case 5:
+				if l.Len() > 0 {
+					idx := r.Intn(l.Len())
+					_ = q.Modify(idx, val)
+					_ = l.Modify(idx, val)
+				}
+			case 6:
+				count := r.Intn(3)
+				if r.Intn(2) == 0 {
+					if n, m := q.DelByVal(isBytes(val), count), l.DelByVal(isBytes(val), count); n != m {
+						t.Fatalf("del by val removed %d, expect %d", n, m)
+					}
+				} else if n, m := q.ReverseDelByVal(isBytes(val), count), l.ReverseDelByVal(isBytes(val), count); n != m {
+					t.Fatalf("reverse del by val removed %d, expect %d", n, m)
+				}
+			case 7:
+				got, _ := q.DelLastNode()
+				expect, _ := l.DelLastNode()
+				if !reflect.DeepEqual(got, expect) {
+					t.Fatalf("del last got %q, expect %q", got, expect)
+				}
+			}
+			if q.Len() != l.Len() {
+				t.Fatalf("len %d, expect %d", q.Len(), l.Len())
+			}
+		}
+		checkNodes(t, q)
+		if got, expect := toSlice(q), toSlice(l); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("fill %d depth %d: elements differ", setting[0], setting[1])
+		}
+		start := l.Len() / 3
+		if got, expect := q.Range(start, l.Len()), l.Range(start, l.Len()); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("fill %d depth %d: range differs", setting[0], setting[1])
+		}
+	}
+}
+
+func TestQuickListCompress(t *testing.T) {
+	q := NewQuickList(8, 1)
+	for i := 0; i < 100; i++ {
+		q.Add(bytes.Repeat([]byte("a"), 20))
+	}
+	checkNodes(t, q)
+	compressed := 0
+	for n := q.head; n != nil; n = n.next {
+		if n.compressed {
+			compressed++
+		}
+	}
+	// 13 nodes, the head and the tail are kept uncompressed
+	if compressed != 11 {
+		t.Fatalf("%d nodes compressed", compressed)
+	}
+	for q.Len() > 0 {
+		if _, err := q.Del(0); err != nil {
+			t.Fatal(err)
+		}
+		checkNodes(t, q)
+	}
+	if q.head != nil || q.tail != nil {
+		t.Fatal("expect no node")
+	}
+}
+
+func TestQuickListDelAllByVal(t *testing.T) {
+	q := NewQuickList(2, 0)
+	for _, v := range []string{"a", "b", "a", "a", "c", "a"} {
+		q.Add([]byte(v))
+	}
+	if n := q.DelAllByVal(isBytes([]byte("a"))); n != 4 {
+		t.Fatalf("removed %d", n)
+	}
+	checkNodes(t, q)
+	if got := toSlice(q); !reflect.DeepEqual(got, []any{[]byte("b"), []byte("c")}) {
+		t.Fatalf("got %q", got)
+	}
+	if !q.Contains(isBytes([]byte("c"))) || q.Contains(isBytes([]byte("a"))) {
+		t.Fatal("contains mismatch")
+	}
+}
+
+const benchSize = 10000
+
+var benchValue = []byte("a value of list")
+
+func benchLists() map[string]func() List {
+	return map[string]func() List{
+		"LinkedList": func() List { return NewLinkedList() },
+		"QuickList":  func() List { return NewQuickList(DefaultFill, 0) },
+	}
+}
+
+func filledList(newList func() List) List {
+	l := newList()
+	for i := 0; i < benchSize; i++ {
+		l.Add(benchValue)
+	}
+	return l
+}
+
+func BenchmarkPush(b *testing.B) {
+	for name, newList := range benchLists() {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			l := newList()
+			for i := 0; i < b.N; i++ {
+				l.Add(benchValue)
+			}
+		})
+	}
+}
+
+func BenchmarkPushHead(b *testing.B) {
+	for name, newList := range benchLists() {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			l := newList()
+			for i := 0; i < b.N; i++ {
+				_ = l.Insert(0, benchValue)
+			}
+		})
+	}
+}
+
+func BenchmarkPop(b *testing.B) {
+	for name, newList := range benchLists() {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			l := filledList(newList)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if l.Len() == 0 {
+					b.StopTimer()
+					l = filledList(newList)
+					b.StartTimer()
+				}
+				_, _ = l.DelLastNode()
+			}
+		})
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	for name, newList := range benchLists() {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			l := filledList(newList)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = l.Get(i % benchSize)
+			}
+		})
+	}
+}
+
+func BenchmarkRange(b *testing.B) {
+	for name, newList := range benchLists() {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			l := filledList(newList)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := i % (benchSize - 100)
+				_ = l.Range(start, start+100)
+			}
+		})
+	}
+}
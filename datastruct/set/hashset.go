@@ -0,0 +1,52 @@
+package set
+
+import (
+	"github.com/issimo1/redis-issimo/datastruct/dict"
+)
+
+// HashSet is a Set backed by a hash table, it holds members of any kind
+type HashSet struct {
+	m *dict.SimpleDict
+}
+
+func NewHashSet() *HashSet {
+	return &HashSet{m: dict.NewSimple()}
+}
+
+func (s *HashSet) Add(member string) int {
+	return s.m.PutIfAbsent(member, nil)
+}
+
+func (s *HashSet) Remove(member string) int {
+	_, result := s.m.Remove(member)
+	return result
+}
+
+func (s *HashSet) Contains(member string) bool {
+	_, ok := s.m.Get(member)
+	return ok
+}
+
+func (s *HashSet) Len() int {
+	return s.m.Len()
+}
+
+func (s *HashSet) ForEach(consumer Consumer) {
+	s.m.ForEach(func(key string, val interface{}) bool {
+		return consumer(key)
+	})
+}
+
+func (s *HashSet) RandomMembers(count int) []string {
+	return s.m.RandomKeys(count)
+}
+
+func (s *HashSet) RandomDistinctMembers(count int) []string {
+	return s.m.RandomDistinctKeys(count)
+}
+
+func (s *HashSet) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	return s.m.Scan(cursor, count, func(key string, val interface{}) bool {
+		return consumer(key)
+	})
+}
@@ -0,0 +1,185 @@
+package set
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// the width in bytes of the integers of an IntSet
+const (
+	intSetEnc16 = 2
+	intSetEnc32 = 4
+	intSetEnc64 = 8
+)
+
+// IntSet keeps integers sorted in a byte slice as redis does. All of them have the width of the largest one,
+// which is 2, 4 or 8 bytes, and the width is upgraded when a larger integer is added.
+type IntSet struct {
+	encoding int
+	contents []byte
+}
+
+func NewIntSet() *IntSet {
+	return &IntSet{encoding: intSetEnc16}
+}
+
+func valueEncoding(v int64) int {
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return intSetEnc64
+	}
+	if v < math.MinInt16 || v > math.MaxInt16 {
+		return intSetEnc32
+	}
+	return intSetEnc16
+}
+
+func (s *IntSet) get(i int) int64 {
+	p := s.contents[i*s.encoding:]
+	switch s.encoding {
+	case intSetEnc16:
+		return int64(int16(binary.LittleEndian.Uint16(p)))
+	case intSetEnc32:
+		return int64(int32(binary.LittleEndian.Uint32(p)))
+	default:
+		return int64(binary.LittleEndian.Uint64(p))
+	}
+}
+
+func (s *IntSet) set(i int, v int64) {
+	p := s.contents[i*s.encoding:]
+	switch s.encoding {
+	case intSetEnc16:
+		binary.LittleEndian.PutUint16(p, uint16(v))
+	case intSetEnc32:
+		binary.LittleEndian.PutUint32(p, uint32(v))
+	default:
+		binary.LittleEndian.PutUint64(p, uint64(v))
+	}
+}
+
+// search returns the position of v, or the position to insert v if it is not found
+func (s *IntSet) search(v int64) (int, bool) {
+	n := s.Len()
+	pos := sort.Search(n, func(i int) bool {
+		return s.get(i) >= v
+	})
+	return pos, pos < n && s.get(pos) == v
+}
+
+// upgrade rewrites the integers in the width of encoding
+func (s *IntSet) upgrade(encoding int) {
+	old := &IntSet{encoding: s.encoding, contents: s.contents}
+	n := old.Len()
+	s.encoding = encoding
+	s.contents = make([]byte, n*encoding, (n+1)*encoding)
+	for i := 0; i < n; i++ {
+		s.set(i, old.get(i))
+	}
+}
+
+// AddInt inserts v, returns true if v is new
+func (s *IntSet) AddInt(v int64) bool {
+	if valueEncoding(v) > s.encoding {
+		s.upgrade(valueEncoding(v))
+	}
+	pos, found := s.search(v)
+	if found {
+		return false
+	}
+	n := s.Len()
+	s.contents = append(s.contents, make([]byte, s.encoding)...)
+	copy(s.contents[(pos+1)*s.encoding:], s.contents[pos*s.encoding:n*s.encoding])
+	s.set(pos, v)
+	return true
+}
+
+// RemoveInt deletes v, returns true if v exists. The width is never downgraded.
+func (s *IntSet) RemoveInt(v int64) bool {
+	if valueEncoding(v) > s.encoding {
+		return false
+	}
+	pos, found := s.search(v)
+	if !found {
+		return false
+	}
+	copy(s.contents[pos*s.encoding:], s.contents[(pos+1)*s.encoding:])
+	s.contents = s.contents[:len(s.contents)-s.encoding]
+	return true
+}
+
+func (s *IntSet) ContainsInt(v int64) bool {
+	if valueEncoding(v) > s.encoding {
+		return false
+	}
+	_, found := s.search(v)
+	return found
+}
+
+// Add inserts member, which must be an integer accepted by ParseInt
+func (s *IntSet) Add(member string) int {
+	v, ok := ParseInt(member)
+	if !ok {
+		panic("IntSet: " + strconv.Quote(member) + " is not an integer")
+	}
+	if s.AddInt(v) {
+		return 1
+	}
+	return 0
+}
+
+func (s *IntSet) Remove(member string) int {
+	v, ok := ParseInt(member)
+	if ok && s.RemoveInt(v) {
+		return 1
+	}
+	return 0
+}
+
+func (s *IntSet) Contains(member string) bool {
+	v, ok := ParseInt(member)
+	return ok && s.ContainsInt(v)
+}
+
+func (s *IntSet) Len() int {
+	return len(s.contents) / s.encoding
+}
+
+// ForEach visits the members in ascending order
+func (s *IntSet) ForEach(consumer Consumer) {
+	for i, n := 0, s.Len(); i < n; i++ {
+		if !consumer(strconv.FormatInt(s.get(i), 10)) {
+			return
+		}
+	}
+}
+
+func (s *IntSet) RandomMembers(count int) []string {
+	n := s.Len()
+	if n == 0 {
+		return nil
+	}
+	members := make([]string, count)
+	for i := range members {
+		members[i] = strconv.FormatInt(s.get(rand.Intn(n)), 10)
+	}
+	return members
+}
+
+func (s *IntSet) RandomDistinctMembers(count int) []string {
+	n := s.Len()
+	count = min(count, n)
+	members := make([]string, count)
+	for i, idx := range rand.Perm(n)[:count] {
+		members[i] = strconv.FormatInt(s.get(idx), 10)
+	}
+	return members
+}
+
+// Scan visits every member at once as redis does for an intset
+func (s *IntSet) Scan(cursor uint64, count int, consumer Consumer) uint64 {
+	s.ForEach(consumer)
+	return 0
+}
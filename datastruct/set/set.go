@@ -0,0 +1,49 @@
+package set
+
+import (
+	"strconv"
+)
+
+type Consumer func(member string) bool
+
+// Set is the member collection used by the set commands
+type Set interface {
+	// Add inserts member, returns 1 if member is new
+	Add(member string) int
+	// Remove deletes member, returns 1 if member exists
+	Remove(member string) int
+	Contains(member string) bool
+	Len() int
+	ForEach(consumer Consumer)
+	// RandomMembers returns count random members which may repeat
+	RandomMembers(count int) []string
+	// RandomDistinctMembers returns at most count distinct random members
+	RandomDistinctMembers(count int) []string
+	// Scan visits about count members from cursor, and returns the cursor of the next call, 0 means the end
+	Scan(cursor uint64, count int, consumer Consumer) uint64
+}
+
+// maxIntLen is the length of the longest int64, math.MinInt64
+const maxIntLen = 20
+
+// ParseInt returns the integer of member if member is an integer in the canonical form, which an IntSet can hold
+func ParseInt(member string) (int64, bool) {
+	if len(member) == 0 || len(member) > maxIntLen {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != member {
+		return 0, false
+	}
+	return n, true
+}
+
+// Members returns every member of s
+func Members(s Set) []string {
+	members := make([]string, 0, s.Len())
+	s.ForEach(func(member string) bool {
+		members = append(members, member)
+		return true
+	})
+	return members
+}
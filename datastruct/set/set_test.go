@@ -0,0 +1,104 @@
+package set
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	for name, s := range map[string]Set{"intset": NewIntSet(), "hashset": NewHashSet()} {
+		expected := make(map[string]bool)
+		for i := 0; i < 3000; i++ {
+			// members of every width are mixed to exercise the upgrades of IntSet
+			v := rand.Int63n(200)
+			switch rand.Intn(3) {
+			case 0:
+				v = -v * 1000
+			case 1:
+				v *= math.MaxInt64 / 200
+			}
+			member := strconv.FormatInt(v, 10)
+			if rand.Intn(3) == 0 {
+				if got := s.Remove(member); got != 1 && expected[member] || got != 0 && !expected[member] {
+					t.Fatalf("%s: remove %s returns %d", name, member, got)
+				}
+				delete(expected, member)
+			} else {
+				if got := s.Add(member); got != 1 && !expected[member] || got != 0 && expected[member] {
+					t.Fatalf("%s: add %s returns %d", name, member, got)
+				}
+				expected[member] = true
+			}
+		}
+		if s.Len() != len(expected) {
+			t.Fatalf("%s: len %d, want %d", name, s.Len(), len(expected))
+		}
+		for member := range expected {
+			if !s.Contains(member) {
+				t.Fatalf("%s: %s is missed", name, member)
+			}
+		}
+		if s.Contains("x") || s.Contains("1.0") {
+			t.Fatalf("%s: contains a missing member", name)
+		}
+		if members := Members(s); len(members) != len(expected) {
+			t.Fatalf("%s: %d members, want %d", name, len(members), len(expected))
+		}
+		distinct := s.RandomDistinctMembers(10)
+		seen := make(map[string]bool)
+		for _, member := range distinct {
+			if !expected[member] || seen[member] {
+				t.Fatalf("%s: random member %s", name, member)
+			}
+			seen[member] = true
+		}
+		if len(distinct) != min(10, len(expected)) || len(s.RandomMembers(300)) != 300 {
+			t.Fatalf("%s: wrong number of random members", name)
+		}
+	}
+}
+
+func TestIntSetOrder(t *testing.T) {
+	s := NewIntSet()
+	for _, v := range []int64{5, -3, 40000, math.MinInt64, 0, math.MaxInt32} {
+		s.AddInt(v)
+	}
+	if s.encoding != intSetEnc64 {
+		t.Fatalf("encoding %d", s.encoding)
+	}
+	var prev int64 = math.MinInt64
+	first := true
+	s.ForEach(func(member string) bool {
+		v, _ := strconv.ParseInt(member, 10, 64)
+		if !first && v <= prev {
+			t.Fatalf("%d follows %d", v, prev)
+		}
+		prev, first = v, false
+		return true
+	})
+	// removing the widest integer keeps the width
+	s.RemoveInt(math.MinInt64)
+	if s.encoding != intSetEnc64 || s.Len() != 5 || s.ContainsInt(math.MinInt64) {
+		t.Fatalf("encoding %d, len %d", s.encoding, s.Len())
+	}
+	small := NewIntSet()
+	small.AddInt(1)
+	if small.ContainsInt(math.MaxInt64) || small.RemoveInt(math.MaxInt64) {
+		t.Fatal("a wider integer is found")
+	}
+}
+
+func TestParseInt(t *testing.T) {
+	for _, member := range []string{"0", "-1", "9223372036854775807", "-9223372036854775808"} {
+		if _, ok := ParseInt(member); !ok {
+			t.Fatalf("%s is an integer", member)
+		}
+	}
+	for _, member := range []string{"", "01", "+1", "-0", " 1", "1.0", "9223372036854775808", "a"} {
+		if _, ok := ParseInt(member); ok {
+			t.Fatalf("%s is not an integer", member)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package sortedset
+
+import (
+	"math"
+	"strconv"
+)
+
+// Border is a bound of a range of a SortedSet, it is inclusive unless it is exclusive
+type Border interface {
+	// lowerThan reports whether e satisfies the border used as the min
+	lowerThan(e *Element) bool
+	// greaterThan reports whether e satisfies the border used as the max
+	greaterThan(e *Element) bool
+}
+
+// ScoreBorder bounds the scores, e.g. 1, (1, -inf and +inf
+type ScoreBorder struct {
+	Value   float64
+	Exclude bool
+}
+
+func (b *ScoreBorder) lowerThan(e *Element) bool {
+	if b.Exclude {
+		return b.Value < e.Score
+	}
+	return b.Value <= e.Score
+}
+
+func (b *ScoreBorder) greaterThan(e *Element) bool {
+	if b.Exclude {
+		return b.Value > e.Score
+	}
+	return b.Value >= e.Score
+}
+
+// ParseScoreBorder parses a score bound of ZRANGEBYSCORE, a leading ( makes it exclusive
+func ParseScoreBorder(s string) (*ScoreBorder, bool) {
+	b := &ScoreBorder{}
+	if len(s) > 0 && s[0] == '(' {
+		b.Exclude = true
+		s = s[1:]
+	}
+	if len(s) == 0 || s[0] == ' ' {
+		return nil, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) {
+		return nil, false
+	}
+	b.Value = v
+	return b, true
+}
+
+const (
+	negativeInf = -1
+	positiveInf = 1
+)
+
+// LexBorder bounds the members of elements having the same score, e.g. [a, (a, - and +
+type LexBorder struct {
+	Value   string
+	Exclude bool
+	// Inf is negativeInf for -, positiveInf for +, and 0 otherwise
+	Inf int
+}
+
+func (b *LexBorder) lowerThan(e *Element) bool {
+	switch {
+	case b.Inf == negativeInf:
+		return true
+	case b.Inf == positiveInf:
+		return false
+	case b.Exclude:
+		return b.Value < e.Member
+	default:
+		return b.Value <= e.Member
+	}
+}
+
+func (b *LexBorder) greaterThan(e *Element) bool {
+	switch {
+	case b.Inf == positiveInf:
+		return true
+	case b.Inf == negativeInf:
+		return false
+	case b.Exclude:
+		return b.Value > e.Member
+	default:
+		return b.Value >= e.Member
+	}
+}
+
+// ParseLexBorder parses a member bound of ZRANGEBYLEX, which is -, + or a member prefixed with [ or (
+func ParseLexBorder(s string) (*LexBorder, bool) {
+	switch {
+	case s == "-":
+		return &LexBorder{Inf: negativeInf}, true
+	case s == "+":
+		return &LexBorder{Inf: positiveInf}, true
+	case len(s) > 0 && s[0] == '[':
+		return &LexBorder{Value: s[1:]}, true
+	case len(s) > 0 && s[0] == '(':
+		return &LexBorder{Value: s[1:], Exclude: true}, true
+	default:
+		return nil, false
+	}
+}
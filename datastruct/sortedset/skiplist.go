@@ -0,0 +1,256 @@
+package sortedset
+
+import "math/rand"
+
+const (
+	maxLevel = 32
+	// levelP is the probability that a node has one more level
+	levelP = 0.25
+)
+
+// Element is a member and its score
+type Element struct {
+	Member string
+	Score  float64
+}
+
+// less reports whether e is ordered before the element of score and member,
+// elements are ordered by score, then by member if the scores are equal
+func (e *Element) less(score float64, member string) bool {
+	return e.Score < score || (e.Score == score && e.Member < member)
+}
+
+type level struct {
+	forward *node
+	// span is the number of nodes between the node and forward, forward included
+	span int
+}
+
+type node struct {
+	Element
+	backward *node
+	levels   []level
+}
+
+// skiplist keeps elements ordered with the spans of the links to look up ranks, as the zskiplist of redis
+type skiplist struct {
+	header *node
+	tail   *node
+	length int
+	level  int
+}
+
+func newNode(lvl int, score float64, member string) *node {
+	return &node{
+		Element: Element{Member: member, Score: score},
+		levels:  make([]level, lvl),
+	}
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: newNode(maxLevel, 0, ""),
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	lvl := 1
+	for lvl < maxLevel && rand.Float64() < levelP {
+		lvl++
+	}
+	return lvl
+}
+
+// insert adds an element, the caller makes sure member does not exist
+func (sl *skiplist) insert(member string, score float64) *node {
+	var update [maxLevel]*node
+	var rank [maxLevel]int
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].forward != nil && x.levels[i].forward.less(score, member) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+	lvl := randomLevel()
+	if lvl > sl.level {
+		for i := sl.level; i < lvl; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].levels[i].span = sl.length
+		}
+		sl.level = lvl
+	}
+	x = newNode(lvl, score, member)
+	for i := 0; i < lvl; i++ {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	for i := lvl; i < sl.level; i++ {
+		update[i].levels[i].span++
+	}
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+	return x
+}
+
+// removeNode unlinks x, update holds the last node before x on each level
+func (sl *skiplist) removeNode(x *node, update []*node) {
+	for i := 0; i < sl.level; i++ {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.header.levels[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+// remove deletes the element of member and score, returns true if it exists
+func (sl *skiplist) remove(member string, score float64) bool {
+	var update [maxLevel]*node
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && x.levels[i].forward.less(score, member) {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+	x = x.levels[0].forward
+	if x == nil || x.Score != score || x.Member != member {
+		return false
+	}
+	sl.removeNode(x, update[:])
+	return true
+}
+
+// getRank returns the 1-based rank of the element of member and score, 0 if it does not exist
+func (sl *skiplist) getRank(member string, score float64) int {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil &&
+			(x.levels[i].forward.less(score, member) || x.levels[i].forward.Score == score && x.levels[i].forward.Member == member) {
+			rank += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		if x != sl.header && x.Member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// getByRank returns the node of the 1-based rank, nil if rank is out of range
+func (sl *skiplist) getByRank(rank int) *node {
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		if traversed == rank && x != sl.header {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstInRange returns the first node within [min, max], nil if there is none
+func (sl *skiplist) firstInRange(min, max Border) *node {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && !min.lowerThan(&x.levels[i].forward.Element) {
+			x = x.levels[i].forward
+		}
+	}
+	x = x.levels[0].forward
+	if x == nil || !max.greaterThan(&x.Element) {
+		return nil
+	}
+	return x
+}
+
+// lastInRange returns the last node within [min, max], nil if there is none
+func (sl *skiplist) lastInRange(min, max Border) *node {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && max.greaterThan(&x.levels[i].forward.Element) {
+			x = x.levels[i].forward
+		}
+	}
+	if x == sl.header || !min.lowerThan(&x.Element) {
+		return nil
+	}
+	return x
+}
+
+// removeRange deletes the nodes within [min, max] and returns their elements
+func (sl *skiplist) removeRange(min, max Border) []*Element {
+	var update [maxLevel]*node
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && !min.lowerThan(&x.levels[i].forward.Element) {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+	var removed []*Element
+	x = x.levels[0].forward
+	for x != nil && max.greaterThan(&x.Element) {
+		next := x.levels[0].forward
+		removed = append(removed, &x.Element)
+		sl.removeNode(x, update[:])
+		x = next
+	}
+	return removed
+}
+
+// removeRangeByRank deletes the nodes between the 1-based ranks start and stop, both inclusive
+func (sl *skiplist) removeRangeByRank(start, stop int) []*Element {
+	var update [maxLevel]*node
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span < start {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+	var removed []*Element
+	traversed++
+	x = x.levels[0].forward
+	for x != nil && traversed <= stop {
+		next := x.levels[0].forward
+		removed = append(removed, &x.Element)
+		sl.removeNode(x, update[:])
+		traversed++
+		x = next
+	}
+	return removed
+}
@@ -0,0 +1,200 @@
+package sortedset
+
+import (
+	"github.com/issimo1/redis-issimo/datastruct/dict"
+)
+
+type Consumer func(element *Element) bool
+
+// SortedSet is the collection used by the zset commands, the skiplist orders the elements
+// and the dict looks up the score of a member
+type SortedSet struct {
+	dict     *dict.SimpleDict
+	skiplist *skiplist
+}
+
+func NewSortedSet() *SortedSet {
+	return &SortedSet{
+		dict:     dict.NewSimple(),
+		skiplist: newSkiplist(),
+	}
+}
+
+// Add puts member with score, returns true if member is new
+func (s *SortedSet) Add(member string, score float64) bool {
+	val, exists := s.dict.Get(member)
+	if exists {
+		element := val.(*Element)
+		if element.Score == score {
+			return false
+		}
+		s.skiplist.remove(member, element.Score)
+	}
+	x := s.skiplist.insert(member, score)
+	s.dict.Put(member, &x.Element)
+	return !exists
+}
+
+func (s *SortedSet) Len() int {
+	return s.skiplist.length
+}
+
+func (s *SortedSet) Get(member string) (*Element, bool) {
+	val, ok := s.dict.Get(member)
+	if !ok {
+		return nil, false
+	}
+	return val.(*Element), true
+}
+
+// Remove deletes member, returns true if member exists
+func (s *SortedSet) Remove(member string) bool {
+	val, ok := s.dict.Remove(member)
+	if ok == 0 {
+		return false
+	}
+	s.skiplist.remove(member, val.(*Element).Score)
+	return true
+}
+
+// GetRank returns the 0-based rank of member, in descending order if desc
+func (s *SortedSet) GetRank(member string, desc bool) (int, bool) {
+	element, ok := s.Get(member)
+	if !ok {
+		return 0, false
+	}
+	rank := s.skiplist.getRank(member, element.Score) - 1
+	if desc {
+		rank = s.Len() - 1 - rank
+	}
+	return rank, true
+}
+
+// ForEachByRank visits the elements of the 0-based ranks in [start, stop), in descending order if desc
+func (s *SortedSet) ForEachByRank(start, stop int, desc bool, consumer Consumer) {
+	if start < 0 || start >= stop || start >= s.Len() {
+		return
+	}
+	stop = min(stop, s.Len())
+	var x *node
+	if desc {
+		x = s.skiplist.getByRank(s.Len() - start)
+	} else {
+		x = s.skiplist.getByRank(start + 1)
+	}
+	for i := start; i < stop && x != nil; i++ {
+		if !consumer(&x.Element) {
+			return
+		}
+		if desc {
+			x = x.backward
+		} else {
+			x = x.levels[0].forward
+		}
+	}
+}
+
+// RangeByRank returns the elements of the 0-based ranks in [start, stop), in descending order if desc
+func (s *SortedSet) RangeByRank(start, stop int, desc bool) []*Element {
+	elements := make([]*Element, 0)
+	s.ForEachByRank(start, stop, desc, func(element *Element) bool {
+		elements = append(elements, element)
+		return true
+	})
+	return elements
+}
+
+// RangeCount returns the number of elements within [min, max]
+func (s *SortedSet) RangeCount(min, max Border) int {
+	first := s.skiplist.firstInRange(min, max)
+	if first == nil {
+		return 0
+	}
+	last := s.skiplist.lastInRange(min, max)
+	return s.skiplist.getRank(last.Member, last.Score) - s.skiplist.getRank(first.Member, first.Score) + 1
+}
+
+// ForEach visits the elements within [min, max] in descending order if desc, it skips offset elements
+// and stops after limit elements, a negative limit means no limit
+func (s *SortedSet) ForEach(min, max Border, offset, limit int, desc bool, consumer Consumer) {
+	var x *node
+	if desc {
+		x = s.skiplist.lastInRange(min, max)
+	} else {
+		x = s.skiplist.firstInRange(min, max)
+	}
+	for ; x != nil && offset > 0; offset-- {
+		x = s.next(x, desc)
+	}
+	for ; x != nil && limit != 0; limit-- {
+		if desc && !min.lowerThan(&x.Element) || !desc && !max.greaterThan(&x.Element) {
+			return
+		}
+		if !consumer(&x.Element) {
+			return
+		}
+		x = s.next(x, desc)
+	}
+}
+
+func (s *SortedSet) next(x *node, desc bool) *node {
+	if desc {
+		return x.backward
+	}
+	return x.levels[0].forward
+}
+
+// Range returns the elements within [min, max], see ForEach for offset, limit and desc
+func (s *SortedSet) Range(min, max Border, offset, limit int, desc bool) []*Element {
+	elements := make([]*Element, 0)
+	s.ForEach(min, max, offset, limit, desc, func(element *Element) bool {
+		elements = append(elements, element)
+		return true
+	})
+	return elements
+}
+
+// RemoveRange deletes the elements within [min, max] and returns them
+func (s *SortedSet) RemoveRange(min, max Border) []*Element {
+	removed := s.skiplist.removeRange(min, max)
+	for _, element := range removed {
+		s.dict.Remove(element.Member)
+	}
+	return removed
+}
+
+// RemoveByRank deletes the elements of the 0-based ranks in [start, stop) and returns them
+func (s *SortedSet) RemoveByRank(start, stop int) []*Element {
+	if start < 0 || start >= stop {
+		return nil
+	}
+	removed := s.skiplist.removeRangeByRank(start+1, stop)
+	for _, element := range removed {
+		s.dict.Remove(element.Member)
+	}
+	return removed
+}
+
+// PopMin removes and returns at most count elements of the lowest scores in ascending order
+func (s *SortedSet) PopMin(count int) []*Element {
+	return s.RemoveByRank(0, count)
+}
+
+// PopMax removes and returns at most count elements of the highest scores in descending order
+func (s *SortedSet) PopMax(count int) []*Element {
+	removed := s.RemoveByRank(max(s.Len()-count, 0), s.Len())
+	for i, j := 0, len(removed)-1; i < j; i, j = i+1, j-1 {
+		removed[i], removed[j] = removed[j], removed[i]
+	}
+	return removed
+}
+
+// RandomMembers returns count random members which may repeat
+func (s *SortedSet) RandomMembers(count int) []string {
+	return s.dict.RandomKeys(count)
+}
+
+// RandomDistinctMembers returns at most count distinct random members
+func (s *SortedSet) RandomDistinctMembers(count int) []string {
+	return s.dict.RandomDistinctKeys(count)
+}
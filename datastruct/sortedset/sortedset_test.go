@@ -0,0 +1,178 @@
+package sortedset
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// sortedElements returns the elements of scores ordered as a SortedSet orders them
+func sortedElements(scores map[string]float64) []Element {
+	elements := make([]Element, 0, len(scores))
+	for member, score := range scores {
+		elements = append(elements, Element{Member: member, Score: score})
+	}
+	sort.Slice(elements, func(i, j int) bool {
+		return elements[i].less(elements[j].Score, elements[j].Member)
+	})
+	return elements
+}
+
+func assertElements(t *testing.T, got []*Element, expected []Element) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("got %d elements, want %d", len(got), len(expected))
+	}
+	for i := range got {
+		if *got[i] != expected[i] {
+			t.Fatalf("element %d is %v, want %v", i, *got[i], expected[i])
+		}
+	}
+}
+
+func TestSortedSet(t *testing.T) {
+	s := NewSortedSet()
+	scores := make(map[string]float64)
+	for i := 0; i < 5000; i++ {
+		member := strconv.Itoa(rand.Intn(500))
+		if rand.Intn(4) == 0 {
+			_, exists := scores[member]
+			if s.Remove(member) != exists {
+				t.Fatalf("remove %s", member)
+			}
+			delete(scores, member)
+			continue
+		}
+		score := float64(rand.Intn(100))
+		_, exists := scores[member]
+		if s.Add(member, score) == exists {
+			t.Fatalf("add %s", member)
+		}
+		scores[member] = score
+	}
+	if s.Len() != len(scores) {
+		t.Fatalf("len %d, want %d", s.Len(), len(scores))
+	}
+	expected := sortedElements(scores)
+	assertElements(t, s.RangeByRank(0, s.Len(), false), expected)
+	for rank, element := range expected {
+		if got, ok := s.GetRank(element.Member, false); !ok || got != rank {
+			t.Fatalf("rank of %s is %d, want %d", element.Member, got, rank)
+		}
+		if got, _ := s.GetRank(element.Member, true); got != len(expected)-1-rank {
+			t.Fatalf("reverse rank of %s is %d", element.Member, got)
+		}
+	}
+	if _, ok := s.GetRank("missing", false); ok {
+		t.Fatal("rank of a missing member")
+	}
+
+	reversed := s.RangeByRank(3, 10, true)
+	for i, element := range reversed {
+		if *element != expected[len(expected)-4-i] {
+			t.Fatalf("reverse range %d is %v", i, *element)
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		lo := &ScoreBorder{Value: float64(rand.Intn(110) - 5), Exclude: rand.Intn(2) == 0}
+		hi := &ScoreBorder{Value: float64(rand.Intn(110) - 5), Exclude: rand.Intn(2) == 0}
+		var inRange []Element
+		for _, element := range expected {
+			if lo.lowerThan(&element) && hi.greaterThan(&element) {
+				inRange = append(inRange, element)
+			}
+		}
+		if got := s.RangeCount(lo, hi); got != len(inRange) {
+			t.Fatalf("count of %v %v is %d, want %d", *lo, *hi, got, len(inRange))
+		}
+		offset, limit := rand.Intn(5), rand.Intn(10)-1
+		window := inRange[min(offset, len(inRange)):]
+		if limit >= 0 {
+			window = window[:min(limit, len(window))]
+		}
+		assertElements(t, s.Range(lo, hi, offset, limit, false), window)
+		var reversedRange []Element
+		for j := len(inRange) - 1; j >= 0; j-- {
+			reversedRange = append(reversedRange, inRange[j])
+		}
+		assertElements(t, s.Range(lo, hi, 0, -1, true), reversedRange)
+	}
+}
+
+func TestRemoveRange(t *testing.T) {
+	s := NewSortedSet()
+	for i := 0; i < 100; i++ {
+		s.Add(strconv.Itoa(i), float64(i/10))
+	}
+	removed := s.RemoveRange(&ScoreBorder{Value: 2, Exclude: true}, &ScoreBorder{Value: 4})
+	if len(removed) != 20 || removed[0].Member != "30" || s.Len() != 80 {
+		t.Fatalf("removed %d elements", len(removed))
+	}
+	if _, ok := s.Get("35"); ok {
+		t.Fatal("35 is removed")
+	}
+	removed = s.RemoveByRank(10, 20)
+	if len(removed) != 10 || removed[0].Member != "10" || s.Len() != 70 {
+		t.Fatalf("removed %d elements", len(removed))
+	}
+	if popped := s.PopMin(2); len(popped) != 2 || popped[0].Member != "0" || popped[1].Member != "1" {
+		t.Fatalf("popped %v", popped)
+	}
+	if popped := s.PopMax(2); len(popped) != 2 || popped[0].Member != "99" || popped[1].Member != "98" {
+		t.Fatalf("popped %v", popped)
+	}
+	if popped := s.PopMax(100); len(popped) != 66 || s.Len() != 0 {
+		t.Fatalf("popped %d", len(popped))
+	}
+	if len(s.RangeByRank(0, 10, false)) != 0 {
+		t.Fatal("set is empty")
+	}
+}
+
+func TestLexRange(t *testing.T) {
+	s := NewSortedSet()
+	for _, member := range []string{"a", "b", "c", "d", "e"} {
+		s.Add(member, 0)
+	}
+	parse := func(str string) Border {
+		b, ok := ParseLexBorder(str)
+		if !ok {
+			t.Fatalf("parse %s", str)
+		}
+		return b
+	}
+	cases := []struct {
+		min, max string
+		count    int
+	}{
+		{"-", "+", 5}, {"[b", "[d", 3}, {"(b", "(d", 1}, {"(a", "+", 4}, {"-", "(a", 0}, {"+", "-", 0}, {"[c", "[b", 0},
+	}
+	for _, c := range cases {
+		if got := s.RangeCount(parse(c.min), parse(c.max)); got != c.count {
+			t.Fatalf("count of %s %s is %d, want %d", c.min, c.max, got, c.count)
+		}
+	}
+	if _, ok := ParseLexBorder("a"); ok {
+		t.Fatal("a is not a lex border")
+	}
+}
+
+func TestParseScoreBorder(t *testing.T) {
+	cases := map[string]ScoreBorder{
+		"1": {Value: 1}, "(1.5": {Value: 1.5, Exclude: true}, "-inf": {Value: math.Inf(-1)},
+		"+inf": {Value: math.Inf(1)}, "(inf": {Value: math.Inf(1), Exclude: true},
+	}
+	for s, expected := range cases {
+		if b, ok := ParseScoreBorder(s); !ok || *b != expected {
+			t.Fatalf("parse %s", s)
+		}
+	}
+	for _, s := range []string{"", "(", "x", "nan", " 1", "[1"} {
+		if _, ok := ParseScoreBorder(s); ok {
+			t.Fatalf("%q is not a score border", s)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package stream
+
+import (
+	"sort"
+)
+
+// PendingEntry is an entry delivered to a consumer but not acknowledged yet
+type PendingEntry struct {
+	ID       ID
+	Consumer *Consumer
+	// DeliveryTime is the unix time in milliseconds of the last delivery
+	DeliveryTime  int64
+	DeliveryCount uint64
+}
+
+// pendingList keeps pending entries ordered by ID, entries are mostly appended as IDs grow
+type pendingList struct {
+	entries []*PendingEntry
+}
+
+func (l *pendingList) search(id ID) int {
+	return sort.Search(len(l.entries), func(i int) bool {
+		return !l.entries[i].ID.Less(id)
+	})
+}
+
+func (l *pendingList) get(id ID) (*PendingEntry, bool) {
+	i := l.search(id)
+	if i < len(l.entries) && l.entries[i].ID == id {
+		return l.entries[i], true
+	}
+	return nil, false
+}
+
+// put inserts pe, or replaces the entry of the same ID
+func (l *pendingList) put(pe *PendingEntry) {
+	i := l.search(pe.ID)
+	if i < len(l.entries) && l.entries[i].ID == pe.ID {
+		l.entries[i] = pe
+		return
+	}
+	l.entries = append(l.entries, nil)
+	copy(l.entries[i+1:], l.entries[i:])
+	l.entries[i] = pe
+}
+
+func (l *pendingList) remove(id ID) bool {
+	i := l.search(id)
+	if i < len(l.entries) && l.entries[i].ID == id {
+		l.entries = append(l.entries[:i], l.entries[i+1:]...)
+		return true
+	}
+	return false
+}
+
+// rangeOf returns at most count entries between start and end inclusive, count not greater than 0 means no limit
+func (l *pendingList) rangeOf(start, end ID, count int) []*PendingEntry {
+	result := make([]*PendingEntry, 0)
+	for i := l.search(start); i < len(l.entries) && !end.Less(l.entries[i].ID); i++ {
+		if count > 0 && len(result) >= count {
+			break
+		}
+		result = append(result, l.entries[i])
+	}
+	return result
+}
+
+// Consumer is a member of a consumer group
+type Consumer struct {
+	Name string
+	// SeenTime is the unix time in milliseconds of the last interaction, ActiveTime is of the last successful one
+	SeenTime   int64
+	ActiveTime int64
+	pel        pendingList
+}
+
+// PendingLen returns the number of entries pending for the consumer
+func (c *Consumer) PendingLen() int {
+	return len(c.pel.entries)
+}
+
+// Group is a consumer group of a stream
+type Group struct {
+	Name string
+	// LastID is the ID of the last entry delivered to the group
+	LastID ID
+	// EntriesRead is the logical count of entries delivered to the group, or InvalidEntriesRead
+	EntriesRead int64
+	pel         pendingList
+	consumers   map[string]*Consumer
+}
+
+func newGroup(name string, lastID ID, entriesRead int64) *Group {
+	return &Group{
+		Name:        name,
+		LastID:      lastID,
+		EntriesRead: entriesRead,
+		consumers:   make(map[string]*Consumer),
+	}
+}
+
+// PendingLen returns the number of entries pending for the group
+func (g *Group) PendingLen() int {
+	return len(g.pel.entries)
+}
+
+func (g *Group) Consumer(name string) (*Consumer, bool) {
+	c, ok := g.consumers[name]
+	return c, ok
+}
+
+// CreateConsumer adds a consumer seen at now, it returns false if the name exists
+func (g *Group) CreateConsumer(name string, now int64) (*Consumer, bool) {
+	if c, ok := g.consumers[name]; ok {
+		return c, false
+	}
+	c := &Consumer{Name: name, SeenTime: now, ActiveTime: -1}
+	g.consumers[name] = c
+	return c, true
+}
+
+// DeleteConsumer removes a consumer and its pending entries, it returns the number of pending entries
+// or -1 if the consumer doesn't exist
+func (g *Group) DeleteConsumer(name string) int {
+	c, ok := g.consumers[name]
+	if !ok {
+		return -1
+	}
+	for _, pe := range c.pel.entries {
+		g.pel.remove(pe.ID)
+	}
+	delete(g.consumers, name)
+	return len(c.pel.entries)
+}
+
+// Consumers returns the consumers ordered by name
+func (g *Group) Consumers() []*Consumer {
+	consumers := make([]*Consumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		consumers = append(consumers, c)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].Name < consumers[j].Name
+	})
+	return consumers
+}
+
+func (g *Group) Pending(id ID) (*PendingEntry, bool) {
+	return g.pel.get(id)
+}
+
+// Assign makes id pending for c, an entry pending for another consumer is moved to c
+func (g *Group) Assign(id ID, c *Consumer, deliveryTime int64, deliveryCount uint64) *PendingEntry {
+	if pe, ok := g.pel.get(id); ok {
+		if pe.Consumer != c {
+			pe.Consumer.pel.remove(id)
+			pe.Consumer = c
+			c.pel.put(pe)
+		}
+		pe.DeliveryTime = deliveryTime
+		pe.DeliveryCount = deliveryCount
+		return pe
+	}
+	pe := &PendingEntry{
+		ID:            id,
+		Consumer:      c,
+		DeliveryTime:  deliveryTime,
+		DeliveryCount: deliveryCount,
+	}
+	g.pel.put(pe)
+	c.pel.put(pe)
+	return pe
+}
+
+// Ack removes id from the pending entries, it returns false if id is not pending
+func (g *Group) Ack(id ID) bool {
+	pe, ok := g.pel.get(id)
+	if !ok {
+		return false
+	}
+	g.pel.remove(id)
+	pe.Consumer.pel.remove(id)
+	return true
+}
+
+// PendingRange returns at most count pending entries between start and end inclusive, of c if it is not nil.
+// A count not greater than 0 means no limit.
+func (g *Group) PendingRange(start, end ID, count int, c *Consumer) []*PendingEntry {
+	if c != nil {
+		return c.pel.rangeOf(start, end, count)
+	}
+	return g.pel.rangeOf(start, end, count)
+}
+
+func (g *Group) copy() *Group {
+	c := newGroup(g.Name, g.LastID, g.EntriesRead)
+	for name, consumer := range g.consumers {
+		c.consumers[name] = &Consumer{
+			Name:       consumer.Name,
+			SeenTime:   consumer.SeenTime,
+			ActiveTime: consumer.ActiveTime,
+		}
+	}
+	for _, pe := range g.pel.entries {
+		owner := c.consumers[pe.Consumer.Name]
+		cp := &PendingEntry{
+			ID:            pe.ID,
+			Consumer:      owner,
+			DeliveryTime:  pe.DeliveryTime,
+			DeliveryCount: pe.DeliveryCount,
+		}
+		c.pel.entries = append(c.pel.entries, cp)
+		owner.pel.entries = append(owner.pel.entries, cp)
+	}
+	return c
+}
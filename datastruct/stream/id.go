@@ -0,0 +1,81 @@
+package stream
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ID identifies an entry by the milliseconds it is added at and a sequence within the millisecond
+type ID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	MinID = ID{}
+	MaxID = ID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+func (id ID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare returns -1, 0 or 1 if id is less than, equal to or greater than other
+func (id ID) Compare(other ID) int {
+	switch {
+	case id.Ms < other.Ms:
+		return -1
+	case id.Ms > other.Ms:
+		return 1
+	case id.Seq < other.Seq:
+		return -1
+	case id.Seq > other.Seq:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (id ID) Less(other ID) bool {
+	return id.Compare(other) < 0
+}
+
+// Next returns the smallest ID greater than id, ok is false if id is MaxID
+func (id ID) Next() (next ID, ok bool) {
+	if id.Seq < math.MaxUint64 {
+		return ID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return ID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// Prev returns the greatest ID less than id, ok is false if id is MinID
+func (id ID) Prev() (prev ID, ok bool) {
+	if id.Seq > 0 {
+		return ID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return ID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// ParseID parses ms-seq, or ms whose sequence is missingSeq
+func ParseID(s string, missingSeq uint64) (ID, bool) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return ID{}, false
+	}
+	if !hasSeq {
+		return ID{Ms: ms, Seq: missingSeq}, true
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return ID{}, false
+	}
+	return ID{Ms: ms, Seq: seq}, true
+}
@@ -0,0 +1,111 @@
+package stream
+
+import (
+	"encoding/binary"
+)
+
+// Entry is an entry of a stream, Fields holds its fields and values one after another
+type Entry struct {
+	ID     ID
+	Fields [][]byte
+}
+
+const (
+	flagLive    = 0
+	flagDeleted = 1
+)
+
+// node keeps consecutive entries in a byte slice as a listpack of redis. Every entry is a flag byte,
+// the differences of its ms and seq from the master ID, the number of fields and values,
+// and each of them prefixed by its length. A deleted entry is flagged in place.
+type node struct {
+	// master is the ID of the first entry ever added to the node, nodes are ordered by it
+	master ID
+	last   ID
+	data   []byte
+	// live is the number of entries not deleted, total includes the deleted ones
+	live  int
+	total int
+}
+
+func newNode(master ID) *node {
+	return &node{master: master}
+}
+
+func (n *node) append(id ID, fields [][]byte) {
+	n.data = append(n.data, flagLive)
+	n.data = binary.AppendUvarint(n.data, id.Ms-n.master.Ms)
+	// the seq of a later ms may be less than the seq of master, the wrapped difference is small as an int64
+	n.data = binary.AppendVarint(n.data, int64(id.Seq-n.master.Seq))
+	n.data = binary.AppendUvarint(n.data, uint64(len(fields)))
+	for _, field := range fields {
+		n.data = binary.AppendUvarint(n.data, uint64(len(field)))
+		n.data = append(n.data, field...)
+	}
+	n.last = id
+	n.live++
+	n.total++
+}
+
+// decode returns the entry at offset, whether it is deleted and the offset of the next entry.
+// The fields share the memory of the node, which is never modified except the flags.
+func (n *node) decode(offset int) (*Entry, bool, int) {
+	deleted := n.data[offset] == flagDeleted
+	p := offset + 1
+	msDiff, size := binary.Uvarint(n.data[p:])
+	p += size
+	seqDiff, size := binary.Varint(n.data[p:])
+	p += size
+	count, size := binary.Uvarint(n.data[p:])
+	p += size
+	entry := &Entry{
+		ID:     ID{Ms: n.master.Ms + msDiff, Seq: n.master.Seq + uint64(seqDiff)},
+		Fields: make([][]byte, count),
+	}
+	for i := range entry.Fields {
+		length, size := binary.Uvarint(n.data[p:])
+		p += size
+		end := p + int(length)
+		entry.Fields[i] = n.data[p:end:end]
+		p = end
+	}
+	return entry, deleted, p
+}
+
+// forEach visits the live entries in ascending order with their offsets
+func (n *node) forEach(consumer func(offset int, entry *Entry) bool) {
+	for offset := 0; offset < len(n.data); {
+		entry, deleted, next := n.decode(offset)
+		if !deleted && !consumer(offset, entry) {
+			return
+		}
+		offset = next
+	}
+}
+
+// reverseForEach visits the live entries in descending order
+func (n *node) reverseForEach(consumer func(offset int, entry *Entry) bool) {
+	offsets := make([]int, 0, n.live)
+	n.forEach(func(offset int, entry *Entry) bool {
+		offsets = append(offsets, offset)
+		return true
+	})
+	for i := len(offsets) - 1; i >= 0; i-- {
+		entry, _, _ := n.decode(offsets[i])
+		if !consumer(offsets[i], entry) {
+			return
+		}
+	}
+}
+
+func (n *node) markDeleted(offset int) {
+	n.data[offset] = flagDeleted
+	n.live--
+}
+
+func (n *node) copy() *node {
+	c := *n
+	c.data = make([]byte, len(n.data))
+	copy(c.data, n.data)
+	return &c
+}
@@ -0,0 +1,356 @@
+package stream
+
+import (
+	"sort"
+)
+
+// InvalidEntriesRead means the number of entries read by a group is unknown
+const InvalidEntriesRead = -1
+
+// Stream is an append only log of entries ordered by ID. The entries are kept in nodes of at most
+// nodeMaxEntries entries and nodeMaxBytes bytes, which are looked up by their master IDs as the radix tree of redis.
+type Stream struct {
+	nodes  []*node
+	length int
+	lastID ID
+	// maxDeletedID is the greatest ID deleted by XDEL
+	maxDeletedID ID
+	entriesAdded uint64
+	groups       map[string]*Group
+
+	nodeMaxEntries int
+	nodeMaxBytes   int
+}
+
+// NewStream returns an empty stream, 0 means no limit for nodeMaxEntries and nodeMaxBytes
+func NewStream(nodeMaxEntries, nodeMaxBytes int) *Stream {
+	return &Stream{
+		groups:         make(map[string]*Group),
+		nodeMaxEntries: nodeMaxEntries,
+		nodeMaxBytes:   nodeMaxBytes,
+	}
+}
+
+func (s *Stream) Len() int {
+	return s.length
+}
+
+// NodeCount returns the number of nodes, which is the number of keys of the radix tree of redis
+func (s *Stream) NodeCount() int {
+	return len(s.nodes)
+}
+
+// LastID returns the greatest ID ever added
+func (s *Stream) LastID() ID {
+	return s.lastID
+}
+
+func (s *Stream) MaxDeletedID() ID {
+	return s.maxDeletedID
+}
+
+func (s *Stream) EntriesAdded() uint64 {
+	return s.entriesAdded
+}
+
+// SetLastID overwrites the metadata restored by XSETID, the caller validates them
+func (s *Stream) SetLastID(lastID ID, entriesAdded uint64, maxDeletedID ID) {
+	s.lastID = lastID
+	s.entriesAdded = entriesAdded
+	s.maxDeletedID = maxDeletedID
+}
+
+// FirstID returns the ID of the first entry, ok is false if the stream is empty
+func (s *Stream) FirstID() (id ID, ok bool) {
+	if s.length == 0 {
+		return ID{}, false
+	}
+	s.nodes[0].forEach(func(offset int, entry *Entry) bool {
+		id = entry.ID
+		return false
+	})
+	return id, true
+}
+
+func (s *Stream) isFull(n *node) bool {
+	return s.nodeMaxEntries > 0 && n.total >= s.nodeMaxEntries ||
+		s.nodeMaxBytes > 0 && len(n.data) >= s.nodeMaxBytes
+}
+
+// Add appends an entry, it returns false if id is not greater than LastID, which is 0-0 for a new stream
+func (s *Stream) Add(id ID, fields [][]byte) bool {
+	if !s.lastID.Less(id) {
+		return false
+	}
+	if len(s.nodes) == 0 || s.isFull(s.nodes[len(s.nodes)-1]) {
+		s.nodes = append(s.nodes, newNode(id))
+	}
+	s.nodes[len(s.nodes)-1].append(id, fields)
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+	return true
+}
+
+// seek returns the index of the node which may hold id, that is the last node whose master is not greater than id
+func (s *Stream) seek(id ID) int {
+	i := sort.Search(len(s.nodes), func(i int) bool {
+		return id.Less(s.nodes[i].master)
+	})
+	return max(i-1, 0)
+}
+
+// find returns the node holding the live entry of id and its offset
+func (s *Stream) find(id ID) (int, int, *Entry) {
+	if len(s.nodes) == 0 {
+		return -1, 0, nil
+	}
+	i := s.seek(id)
+	pos := -1
+	var found *Entry
+	s.nodes[i].forEach(func(offset int, entry *Entry) bool {
+		if entry.ID == id {
+			pos, found = offset, entry
+		}
+		return entry.ID.Less(id)
+	})
+	if found == nil {
+		return -1, 0, nil
+	}
+	return i, pos, found
+}
+
+func (s *Stream) Get(id ID) (*Entry, bool) {
+	_, _, entry := s.find(id)
+	return entry, entry != nil
+}
+
+func (s *Stream) removeNode(i int) {
+	s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+}
+
+// Delete removes the entry of id, returns true if it exists
+func (s *Stream) Delete(id ID) bool {
+	i, offset, entry := s.find(id)
+	if entry == nil {
+		return false
+	}
+	n := s.nodes[i]
+	n.markDeleted(offset)
+	if n.live == 0 {
+		s.removeNode(i)
+	}
+	s.length--
+	if s.maxDeletedID.Less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+// Range returns at most count entries between start and end inclusive, in descending order if rev.
+// A count not greater than 0 means no limit.
+func (s *Stream) Range(start, end ID, count int, rev bool) []*Entry {
+	entries := make([]*Entry, 0)
+	if len(s.nodes) == 0 || end.Less(start) {
+		return entries
+	}
+	full := func() bool {
+		return count > 0 && len(entries) >= count
+	}
+	if !rev {
+		for i := s.seek(start); i < len(s.nodes) && !full(); i++ {
+			inRange := true
+			s.nodes[i].forEach(func(offset int, entry *Entry) bool {
+				if end.Less(entry.ID) {
+					inRange = false
+					return false
+				}
+				if !entry.ID.Less(start) {
+					entries = append(entries, entry)
+				}
+				return !full()
+			})
+			if !inRange {
+				break
+			}
+		}
+		return entries
+	}
+	for i := s.seek(end); i >= 0 && !full(); i-- {
+		inRange := true
+		s.nodes[i].reverseForEach(func(offset int, entry *Entry) bool {
+			if entry.ID.Less(start) {
+				inRange = false
+				return false
+			}
+			if !end.Less(entry.ID) {
+				entries = append(entries, entry)
+			}
+			return !full()
+		})
+		if !inRange {
+			break
+		}
+	}
+	return entries
+}
+
+// trim removes the oldest entries while shouldRemove, which is given the ID of the entry and the length.
+// If approx, only whole nodes are removed. limit caps the number of removed entries, 0 means no limit.
+func (s *Stream) trim(shouldRemove func(id ID, length int) bool, approx bool, limit int) int {
+	removed := 0
+	for len(s.nodes) > 0 {
+		n := s.nodes[0]
+		if shouldRemove(n.last, s.length-n.live+1) {
+			if limit > 0 && removed+n.live > limit {
+				break
+			}
+			removed += n.live
+			s.length -= n.live
+			s.removeNode(0)
+			continue
+		}
+		if approx {
+			break
+		}
+		n.forEach(func(offset int, entry *Entry) bool {
+			if !shouldRemove(entry.ID, s.length) {
+				return false
+			}
+			n.markDeleted(offset)
+			s.length--
+			removed++
+			return true
+		})
+		if n.live == 0 {
+			s.removeNode(0)
+		}
+		break
+	}
+	return removed
+}
+
+// TrimByLen removes the oldest entries until at most maxLen entries are left, see trim for approx and limit
+func (s *Stream) TrimByLen(maxLen int, approx bool, limit int) int {
+	return s.trim(func(id ID, length int) bool {
+		return length > maxLen
+	}, approx, limit)
+}
+
+// TrimByMinID removes the entries whose IDs are less than minID, see trim for approx and limit
+func (s *Stream) TrimByMinID(minID ID, approx bool, limit int) int {
+	return s.trim(func(id ID, length int) bool {
+		return id.Less(minID)
+	}, approx, limit)
+}
+
+// hasTombstonesAfter reports whether an entry after id may have been deleted
+func (s *Stream) hasTombstonesAfter(id ID) bool {
+	if s.length == 0 || s.maxDeletedID == MinID {
+		return false
+	}
+	if first, _ := s.FirstID(); s.maxDeletedID.Less(first) {
+		return false
+	}
+	return !s.maxDeletedID.Less(id)
+}
+
+// EstimateEntriesRead returns the number of entries added up to id, or InvalidEntriesRead if it is unknown
+func (s *Stream) EstimateEntriesRead(id ID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+	if s.length == 0 && !s.lastID.Less(id) {
+		return int64(s.entriesAdded)
+	}
+	switch id.Compare(s.lastID) {
+	case 0:
+		return int64(s.entriesAdded)
+	case 1:
+		return InvalidEntriesRead
+	}
+	first, _ := s.FirstID()
+	if s.maxDeletedID == MinID || s.maxDeletedID.Less(first) {
+		// no tombstone is in the stream, so the entries before first are all trimmed
+		switch id.Compare(first) {
+		case -1:
+			return int64(s.entriesAdded) - int64(s.length)
+		case 0:
+			return int64(s.entriesAdded) - int64(s.length) + 1
+		}
+	}
+	return InvalidEntriesRead
+}
+
+// Lag returns the number of entries not yet delivered to g, ok is false if it is unknown
+func (s *Stream) Lag(g *Group) (int64, bool) {
+	if s.entriesAdded == 0 {
+		return 0, true
+	}
+	if g.EntriesRead != InvalidEntriesRead && !s.hasTombstonesAfter(g.LastID) {
+		return int64(s.entriesAdded) - g.EntriesRead, true
+	}
+	if read := s.EstimateEntriesRead(g.LastID); read != InvalidEntriesRead {
+		return int64(s.entriesAdded) - read, true
+	}
+	return 0, false
+}
+
+// Deliver moves the last delivered ID of g to id, and counts the entry as read by g
+func (s *Stream) Deliver(g *Group, id ID) {
+	if g.EntriesRead != InvalidEntriesRead && !s.hasTombstonesAfter(id) {
+		g.EntriesRead++
+	} else if s.entriesAdded > 0 {
+		g.EntriesRead = s.EstimateEntriesRead(id)
+	}
+	g.LastID = id
+}
+
+// CreateGroup adds a consumer group, it returns false if the name exists
+func (s *Stream) CreateGroup(name string, lastID ID, entriesRead int64) (*Group, bool) {
+	if _, ok := s.groups[name]; ok {
+		return nil, false
+	}
+	g := newGroup(name, lastID, entriesRead)
+	s.groups[name] = g
+	return g, true
+}
+
+func (s *Stream) Group(name string) (*Group, bool) {
+	g, ok := s.groups[name]
+	return g, ok
+}
+
+func (s *Stream) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// Groups returns the consumer groups ordered by name
+func (s *Stream) Groups() []*Group {
+	groups := make([]*Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// Copy returns a deep copy of s including its consumer groups
+func (s *Stream) Copy() *Stream {
+	c := *s
+	c.nodes = make([]*node, len(s.nodes))
+	for i, n := range s.nodes {
+		c.nodes[i] = n.copy()
+	}
+	c.groups = make(map[string]*Group, len(s.groups))
+	for name, g := range s.groups {
+		c.groups[name] = g.copy()
+	}
+	return &c
+}
@@ -0,0 +1,181 @@
+package stream
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func assertIDs(t *testing.T, entries []*Entry, expected []ID) {
+	t.Helper()
+	if len(entries) != len(expected) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(expected))
+	}
+	for i, entry := range entries {
+		if entry.ID != expected[i] {
+			t.Fatalf("entry %d is %s, want %s", i, entry.ID, expected[i])
+		}
+	}
+}
+
+func TestParseID(t *testing.T) {
+	cases := []struct {
+		s   string
+		id  ID
+		ok  bool
+		seq uint64
+	}{
+		{"1-2", ID{1, 2}, true, 0},
+		{"5", ID{5, 0}, true, 0},
+		{"5", ID{5, 9}, true, 9},
+		{"18446744073709551615-18446744073709551615", MaxID, true, 0},
+		{"1-", ID{}, false, 0},
+		{"-1", ID{}, false, 0},
+		{"a-1", ID{}, false, 0},
+		{"18446744073709551616", ID{}, false, 0},
+	}
+	for _, c := range cases {
+		id, ok := ParseID(c.s, c.seq)
+		if ok != c.ok || ok && id != c.id {
+			t.Errorf("ParseID(%q) = %v %v", c.s, id, ok)
+		}
+	}
+	if next, _ := (ID{1, 2}).Next(); next != (ID{1, 3}) {
+		t.Errorf("next %s", next)
+	}
+	if prev, _ := (ID{2, 0}).Prev(); prev != (ID{1, MaxID.Seq}) {
+		t.Errorf("prev %s", prev)
+	}
+	if _, ok := MinID.Prev(); ok {
+		t.Error("prev of MinID")
+	}
+}
+
+func TestStream(t *testing.T) {
+	s := NewStream(7, 0)
+	var live []ID
+	for i := 1; i <= 200; i++ {
+		id := ID{Ms: uint64(i / 3), Seq: uint64(i % 3)}
+		fields := [][]byte{[]byte("k"), []byte(strconv.Itoa(i))}
+		if !s.Add(id, fields) {
+			t.Fatalf("add %s", id)
+		}
+		live = append(live, id)
+	}
+	if s.Add(ID{Ms: 1}, nil) {
+		t.Fatal("add a smaller ID")
+	}
+	for i := 0; i < 60; i++ {
+		j := rand.Intn(len(live))
+		if !s.Delete(live[j]) {
+			t.Fatalf("delete %s", live[j])
+		}
+		if s.Delete(live[j]) {
+			t.Fatalf("delete %s twice", live[j])
+		}
+		live = append(live[:j], live[j+1:]...)
+	}
+	if s.Len() != len(live) {
+		t.Fatalf("len %d, want %d", s.Len(), len(live))
+	}
+	assertIDs(t, s.Range(MinID, MaxID, 0, false), live)
+	entry, ok := s.Get(live[10])
+	if !ok || entry.ID != live[10] || string(entry.Fields[0]) != "k" {
+		t.Fatalf("get %s", live[10])
+	}
+
+	reversed := make([]ID, 0, 5)
+	for i := len(live) - 21; i > len(live)-26; i-- {
+		reversed = append(reversed, live[i])
+	}
+	assertIDs(t, s.Range(MinID, live[len(live)-21], 5, true), reversed)
+	assertIDs(t, s.Range(live[3], live[8], 0, false), live[3:9])
+	assertIDs(t, s.Range(live[8], live[3], 0, false), nil)
+
+	c := s.Copy()
+	removed := s.TrimByLen(50, false, 0)
+	if removed != len(live)-50 || s.Len() != 50 {
+		t.Fatalf("trim removed %d, len %d", removed, s.Len())
+	}
+	assertIDs(t, s.Range(MinID, MaxID, 0, false), live[len(live)-50:])
+	assertIDs(t, c.Range(MinID, MaxID, 0, false), live)
+
+	before := c.Len()
+	removed = c.TrimByMinID(live[100], true, 0)
+	if c.Len() != before-removed || c.Len() < len(live)-100 {
+		t.Fatalf("approximate trim removed %d, len %d", removed, c.Len())
+	}
+	first, _ := c.FirstID()
+	if live[100].Less(first) {
+		t.Fatalf("approximate trim removed %s", first)
+	}
+}
+
+func TestEntriesRead(t *testing.T) {
+	s := NewStream(0, 0)
+	for i := 1; i <= 5; i++ {
+		s.Add(ID{Ms: uint64(i)}, nil)
+	}
+	g, _ := s.CreateGroup("g", MinID, InvalidEntriesRead)
+	if lag, ok := s.Lag(g); !ok || lag != 5 {
+		t.Fatalf("lag %d %v", lag, ok)
+	}
+	s.Deliver(g, ID{Ms: 1})
+	s.Deliver(g, ID{Ms: 2})
+	if g.EntriesRead != 2 {
+		t.Fatalf("entries read %d", g.EntriesRead)
+	}
+	s.Delete(ID{Ms: 4})
+	s.Deliver(g, ID{Ms: 3})
+	if _, ok := s.Lag(g); ok {
+		t.Fatal("lag is known with a tombstone")
+	}
+	s.Deliver(g, ID{Ms: 5})
+	if lag, ok := s.Lag(g); !ok || lag != 0 || g.EntriesRead != 5 {
+		t.Fatalf("lag %d %v, entries read %d", lag, ok, g.EntriesRead)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	s := NewStream(0, 0)
+	g, ok := s.CreateGroup("g", MinID, 0)
+	if !ok {
+		t.Fatal("create group")
+	}
+	if _, ok := s.CreateGroup("g", MinID, 0); ok {
+		t.Fatal("create group twice")
+	}
+	alice, _ := g.CreateConsumer("alice", 1)
+	bob, _ := g.CreateConsumer("bob", 1)
+	for i := 5; i > 0; i-- {
+		g.Assign(ID{Ms: uint64(i)}, alice, 10, 1)
+	}
+	g.Assign(ID{Ms: 3}, bob, 20, 2)
+	if g.PendingLen() != 5 || alice.PendingLen() != 4 || bob.PendingLen() != 1 {
+		t.Fatalf("pending %d %d %d", g.PendingLen(), alice.PendingLen(), bob.PendingLen())
+	}
+	pending := g.PendingRange(MinID, MaxID, 3, nil)
+	if len(pending) != 3 || pending[0].ID != (ID{Ms: 1}) || pending[2].Consumer != bob {
+		t.Fatalf("pending range %v", pending)
+	}
+
+	c := s.Copy()
+	if !g.Ack(ID{Ms: 3}) || g.Ack(ID{Ms: 3}) || bob.PendingLen() != 0 {
+		t.Fatal("ack")
+	}
+	if n := g.DeleteConsumer("alice"); n != 4 || g.PendingLen() != 0 {
+		t.Fatalf("delete consumer %d", n)
+	}
+	if g.DeleteConsumer("alice") != -1 {
+		t.Fatal("delete consumer twice")
+	}
+
+	cg, _ := c.Group("g")
+	cbob, _ := cg.Consumer("bob")
+	if cg.PendingLen() != 5 || cbob.PendingLen() != 1 {
+		t.Fatal("copy shares pending entries")
+	}
+	if pe, _ := cg.Pending(ID{Ms: 3}); pe.Consumer != cbob || pe.DeliveryCount != 2 {
+		t.Fatalf("copied pending entry %v", pe)
+	}
+}
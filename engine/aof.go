@@ -0,0 +1,359 @@
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/parser"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/config"
+	"github.com/issimo1/redis-issimo/utils/logger"
+)
+
+const (
+	// fsyncAlways syncs the file after every command
+	fsyncAlways = "always"
+	// fsyncEverySec syncs the file once a second, it is used for an unknown appendFsync
+	fsyncEverySec = "everysec"
+	// fsyncNo leaves syncing to the operating system
+	fsyncNo = "no"
+)
+
+// aofQueueSize is the number of propagated commands waiting to be written
+const aofQueueSize = 1 << 16
+
+var errAofRewriting = errors.New("ERR Background append only file rewriting already in progress")
+
+type aofPayload struct {
+	dbIdx   int
+	cmdLine [][]byte
+}
+
+// persister appends the commands propagated by writes to the AOF file.
+// Commands are written in the order they are propagated, a SELECT is inserted whenever the db changes.
+type persister struct {
+	filename string
+	fsync    string
+	aofChan  chan *aofPayload
+	// closing stops accepting commands, finished is closed once the queued ones are written
+	closing   chan struct{}
+	closeOnce sync.Once
+	finished  chan struct{}
+	// mu guards file and currentDB, a rewrite holds it while the files are swapped
+	mu   sync.Mutex
+	file *os.File
+	// currentDB is the db selected at the end of file, -1 makes the next command select its db
+	currentDB int
+	rewriting atomic.Bool
+}
+
+func aofFilename() string {
+	return filepath.Join(config.GlobalConfig.Dir, config.GlobalConfig.AppendFileName)
+}
+
+func newPersister(filename, fsync string) (*persister, error) {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if fsync != fsyncAlways && fsync != fsyncNo {
+		fsync = fsyncEverySec
+	}
+	p := &persister{
+		filename:  filename,
+		fsync:     fsync,
+		aofChan:   make(chan *aofPayload, aofQueueSize),
+		closing:   make(chan struct{}),
+		finished:  make(chan struct{}),
+		file:      file,
+		currentDB: -1,
+	}
+	go p.listen()
+	if fsync == fsyncEverySec {
+		go p.fsyncEverySecond()
+	}
+	return p, nil
+}
+
+// append writes cmdLine executed in db dbIdx, commands appended after close are dropped
+func (p *persister) append(dbIdx int, cmdLine [][]byte) {
+	if p.fsync == fsyncAlways {
+		p.write(&aofPayload{dbIdx: dbIdx, cmdLine: cmdLine})
+		return
+	}
+	select {
+	case p.aofChan <- &aofPayload{dbIdx: dbIdx, cmdLine: cmdLine}:
+	case <-p.closing:
+	}
+}
+
+func (p *persister) listen() {
+	defer close(p.finished)
+	for {
+		select {
+		case aof := <-p.aofChan:
+			p.write(aof)
+		case <-p.closing:
+			for {
+				select {
+				case aof := <-p.aofChan:
+					p.write(aof)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *persister) write(aof *aofPayload) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return
+	}
+	if aof.dbIdx != p.currentDB {
+		selectCmd := toCmdLine("SELECT", strconv.Itoa(aof.dbIdx))
+		if _, err := p.file.Write(protocol.MakeMultiBulkReply(selectCmd).ToBytes()); err != nil {
+			logger.Warn("write aof: " + err.Error())
+			return
+		}
+		p.currentDB = aof.dbIdx
+	}
+	if _, err := p.file.Write(protocol.MakeMultiBulkReply(aof.cmdLine).ToBytes()); err != nil {
+		logger.Warn("write aof: " + err.Error())
+		return
+	}
+	if p.fsync == fsyncAlways {
+		if err := p.file.Sync(); err != nil {
+			logger.Warn("fsync aof: " + err.Error())
+		}
+	}
+}
+
+func (p *persister) fsyncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			if p.file != nil {
+				if err := p.file.Sync(); err != nil {
+					logger.Warn("fsync aof: " + err.Error())
+				}
+			}
+			p.mu.Unlock()
+		case <-p.closing:
+			return
+		}
+	}
+}
+
+// close writes the queued commands and closes the file
+func (p *persister) close() {
+	p.closeOnce.Do(func() {
+		close(p.closing)
+	})
+	<-p.finished
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return
+	}
+	if err := p.file.Sync(); err != nil {
+		logger.Warn("fsync aof: " + err.Error())
+	}
+	_ = p.file.Close()
+	p.file = nil
+}
+
+// bgRewrite starts a rewrite in the background, BGREWRITEAOF
+func (p *persister) bgRewrite() protocol.Reply {
+	if !p.rewriting.CompareAndSwap(false, true) {
+		return protocol.MakeErrReply(errAofRewriting.Error())
+	}
+	go func() {
+		defer p.rewriting.Store(false)
+		if err := p.doRewrite(); err != nil {
+			logger.Error("rewrite aof: " + err.Error())
+		}
+	}()
+	return protocol.MakeStatusReply("Background append only file rewriting started")
+}
+
+// rewrite replaces the AOF file by the commands creating the current keys
+func (p *persister) rewrite() error {
+	if !p.rewriting.CompareAndSwap(false, true) {
+		return errAofRewriting
+	}
+	defer p.rewriting.Store(false)
+	return p.doRewrite()
+}
+
+// doRewrite loads the AOF file up to its current size into an auxiliary engine and dumps the keys to a
+// temporary file, writes go on meanwhile. The commands appended since then are copied to the temporary
+// file before it replaces the AOF file.
+func (p *persister) doRewrite() error {
+	p.mu.Lock()
+	if p.file == nil {
+		p.mu.Unlock()
+		return errors.New("aof is closed")
+	}
+	if err := p.file.Sync(); err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	info, err := p.file.Stat()
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	size, startDB := info.Size(), p.currentDB
+	p.mu.Unlock()
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(p.filename), filepath.Base(p.filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+	}()
+	aux := newAuxiliaryEngine()
+	aux.loadAof(p.filename, size)
+	err = aux.dumpAof(tmpFile)
+	aux.Close()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return errors.New("aof is closed")
+	}
+	src, err := os.Open(p.filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err := src.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	if startDB >= 0 {
+		// the appended commands run in the db selected at the end of the loaded part
+		selectCmd := toCmdLine("SELECT", strconv.Itoa(startDB))
+		if _, err := tmpFile.Write(protocol.MakeMultiBulkReply(selectCmd).ToBytes()); err != nil {
+			return err
+		}
+	}
+	if _, err := io.Copy(tmpFile, src); err != nil {
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile.Name(), p.filename); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(p.filename, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_ = p.file.Close()
+	p.file = file
+	return nil
+}
+
+// aofBindEveryDB makes every db append its propagated commands to the AOF file
+func (e *Engine) aofBindEveryDB() {
+	for _, dbset := range e.dbSet {
+		db := dbset.Load().(*DB)
+		db.writeAof = func(redisCommand [][]byte) {
+			e.persister.append(db.index, redisCommand)
+		}
+	}
+}
+
+// loadAof replays the first maxBytes of the AOF file, or the whole file if maxBytes is negative.
+// It is called before the dbs are bound to the AOF, so the replayed commands are not appended again.
+func (e *Engine) loadAof(filename string, maxBytes int64) {
+	file, err := os.Open(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("open aof: " + err.Error())
+		}
+		return
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if maxBytes >= 0 {
+		reader = io.LimitReader(file, maxBytes)
+	}
+	dbIdx := 0
+	for p := range parser.ParseStream(reader) {
+		if p.Err != nil {
+			if p.Err != io.EOF {
+				// a command cut off by a crash is dropped with the rest of file
+				logger.Error("read aof: " + p.Err.Error())
+			}
+			break
+		}
+		r, ok := p.Data.(*protocol.MultiBulkReply)
+		if !ok || len(r.Args) == 0 {
+			logger.Error("read aof: require multi bulk protocol")
+			continue
+		}
+		if strings.ToLower(string(r.Args[0])) == "select" && len(r.Args) == 2 {
+			if idx, err := strconv.Atoi(string(r.Args[1])); err == nil {
+				dbIdx = idx
+			}
+			continue
+		}
+		db, errReply := e.selectDB(dbIdx)
+		if errReply != nil {
+			logger.Error("replay aof: " + errReply.Error())
+			continue
+		}
+		if reply := db.generalCommand(nil, r.Args); protocol.IsErrorReply(reply) {
+			logger.Error("replay aof: " + string(reply.ToBytes()))
+		}
+	}
+}
+
+// dumpAof writes the commands creating every key of e to w
+func (e *Engine) dumpAof(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	var err error
+	writeCmd := func(cmdLine [][]byte) {
+		if err == nil {
+			_, err = buf.Write(protocol.MakeMultiBulkReply(cmdLine).ToBytes())
+		}
+	}
+	for idx := range e.dbSet {
+		writeCmd(toCmdLine("SELECT", strconv.Itoa(idx)))
+		e.ForEach(idx, func(key string, entity *payload.DataEntity, expiration *time.Time) bool {
+			for _, cmdLine := range entityToCmds(key, entity) {
+				writeCmd(cmdLine)
+			}
+			if expiration != nil {
+				writeCmd(makeExpireCmd(key, *expiration))
+			}
+			return err == nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return buf.Flush()
+}
@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/utils/config"
+)
+
+// useAof makes the engines created by the test append to an AOF file in a temporary dir
+func useAof(t *testing.T) {
+	t.Helper()
+	appendOnly, dir, fsync := config.GlobalConfig.AppendOnly, config.GlobalConfig.Dir, config.GlobalConfig.AppendFsync
+	config.GlobalConfig.AppendOnly, config.GlobalConfig.Dir = true, t.TempDir()
+	t.Cleanup(func() {
+		config.GlobalConfig.AppendOnly, config.GlobalConfig.Dir, config.GlobalConfig.AppendFsync = appendOnly, dir, fsync
+	})
+}
+
+// newAofEngine starts an engine from the AOF file, it is closed at the end of the test
+func newAofEngine(t *testing.T) *Engine {
+	e := NewEngine()
+	t.Cleanup(e.Close)
+	return e
+}
+
+// reloadAof closes e, which writes the queued commands, and starts an engine from the AOF file
+func reloadAof(t *testing.T, e *Engine) *Engine {
+	e.Close()
+	return newAofEngine(t)
+}
+
+func TestAof(t *testing.T) {
+	useAof(t)
+	e := newAofEngine(t)
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "a", "1")
+	execCmd(e, c, "incrbyfloat", "f", "1.5")
+	execCmd(e, c, "rpush", "l", "x", "y", "z")
+	execCmd(e, c, "lpop", "l")
+	execCmd(e, c, "set", "ttl", "v", "ex", "100")
+	execCmd(e, c, "set", "gone", "v", "px", "1")
+	execCmd(e, c, "select", "3")
+	execCmd(e, c, "sadd", "s", "m1", "m2")
+	execCmd(e, c, "zadd", "z", "1", "a", "2", "b")
+	execCmd(e, c, "copy", "s", "s", "db", "5")
+	execCmd(e, c, "select", "5")
+	execCmd(e, c, "multi")
+	execCmd(e, c, "srem", "s", "m1")
+	execCmd(e, c, "hset", "h", "k", "v")
+	execCmd(e, c, "exec")
+	execCmd(e, c, "select", "7")
+	execCmd(e, c, "set", "flushed", "v")
+	execCmd(e, c, "flushdb")
+	time.Sleep(10 * time.Millisecond)
+
+	dump := func(e *Engine) string {
+		c := connection.NewFakeConn()
+		var b strings.Builder
+		for _, cmd := range [][]string{
+			{"mget", "a", "f", "gone"}, {"lrange", "l", "0", "-1"}, {"pexpiretime", "ttl"},
+			{"select", "3"}, {"smembers", "s"}, {"zrange", "z", "0", "-1", "withscores"},
+			{"select", "5"}, {"smembers", "s"}, {"hgetall", "h"},
+			{"select", "7"}, {"dbsize"},
+		} {
+			b.Write(execCmd(e, c, cmd...).ToBytes())
+		}
+		return b.String()
+	}
+	want := dump(e)
+	if !strings.Contains(want, "$3\r\n1.5\r\n") || !strings.Contains(want, "$2\r\nm2\r\n") {
+		t.Fatalf("unexpected dump %q", want)
+	}
+
+	e = reloadAof(t, e)
+	if got := dump(e); got != want {
+		t.Errorf("AOF load differs:\n%q\n%q", got, want)
+	}
+
+	// the commands appended after the load go on in the db selected at the end of the file
+	execCmd(e, connection.NewFakeConn(), "set", "b", "2")
+	execCmd(e, connection.NewFakeConn(), "del", "b")
+	if err := e.persister.rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(aofFilename()); strings.Contains(string(data), "lpop") {
+		t.Errorf("file is not rewritten: %q", data)
+	}
+	execCmd(e, connection.NewFakeConn(), "set", "a", "3")
+	e = reloadAof(t, e)
+	want = strings.Replace(want, "$1\r\n1\r\n", "$1\r\n3\r\n", 1)
+	if got := dump(e); got != want {
+		t.Errorf("AOF rewrite differs:\n%q\n%q", got, want)
+	}
+}
+
+func TestAofRewriteWhileWriting(t *testing.T) {
+	useAof(t)
+	e := newAofEngine(t)
+	const clients, rounds = 4, 300
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := connection.NewFakeConn()
+			execCmd(e, c, "select", strconv.Itoa(i))
+			for j := 0; j < rounds; j++ {
+				execCmd(e, c, "incr", "counter")
+				execCmd(e, c, "rpush", "log", strconv.Itoa(j))
+			}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		if err := e.persister.rewrite(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	e = reloadAof(t, e)
+	c := connection.NewFakeConn()
+	for i := 0; i < clients; i++ {
+		execCmd(e, c, "select", strconv.Itoa(i))
+		assertReply(t, execCmd(e, c, "get", "counter"), "$3\r\n300\r\n")
+		assertReply(t, execCmd(e, c, "llen", "log"), ":300\r\n")
+		assertReply(t, execCmd(e, c, "lindex", "log", "-1"), "$3\r\n299\r\n")
+	}
+}
+
+func TestAofFsyncAlways(t *testing.T) {
+	useAof(t)
+	config.GlobalConfig.AppendFsync = "always"
+	e := newAofEngine(t)
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "a", "1")
+	// the command is written before the reply
+	data, _ := os.ReadFile(aofFilename())
+	if want := "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+
+	// a command cut off by a crash is dropped
+	e.Close()
+	file, _ := os.OpenFile(aofFilename(), os.O_APPEND|os.O_WRONLY, 0600)
+	_, _ = file.WriteString("*3\r\n$3\r\nset\r\n$1\r\nb\r\n$1")
+	_ = file.Close()
+	e = newAofEngine(t)
+	assertReply(t, execCmd(e, c, "get", "a"), "$1\r\n1\r\n")
+	assertReply(t, execCmd(e, c, "exists", "b"), ":0\r\n")
+}
+
+func TestBgRewriteAof(t *testing.T) {
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(NewEngine(), c, "bgrewriteaof"), "-ERR AppendOnly is false, you can't rewrite aof file\r\n")
+
+	useAof(t)
+	e := newAofEngine(t)
+	for i := 0; i < 10; i++ {
+		execCmd(e, c, "incr", "n")
+	}
+	// the increments are written to the file before the rewrite loads it
+	e = reloadAof(t, e)
+	assertReply(t, execCmd(e, c, "bgrewriteaof"), "+Background append only file rewriting started\r\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for e.persister.rewriting.Load() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	e.persister.rewriting.Store(true)
+	assertReply(t, execCmd(e, c, "bgrewriteaof"), "-ERR Background append only file rewriting already in progress\r\n")
+	e.persister.rewriting.Store(false)
+
+	e = reloadAof(t, e)
+	if data, _ := os.ReadFile(aofFilename()); strings.Contains(string(data), "incr") {
+		t.Errorf("file is not rewritten: %q", data)
+	}
+	assertReply(t, execCmd(e, c, "get", "n"), "$2\r\n10\r\n")
+}
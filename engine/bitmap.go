@@ -0,0 +1,610 @@
+package engine
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+// Bits are numbered from the most significant bit of the first byte, as redis does.
+
+func bitOffsetErr() protocol.ErrorReply {
+	return protocol.MakeErrReply("ERR bit offset is not an integer or out of range")
+}
+
+func notIntegerErr() protocol.ErrorReply {
+	return protocol.MakeErrReply("ERR value is not an integer or out of range")
+}
+
+// parseBitOffset parses the offset of a field of width bits, "#n" means the n-th field of this width
+// if withIndex. The field must lie within the max length of a string.
+func parseBitOffset(arg []byte, withIndex bool, width int) (int64, protocol.ErrorReply) {
+	s := string(arg)
+	byIndex := withIndex && strings.HasPrefix(s, "#")
+	if byIndex {
+		s = s[1:]
+	}
+	offset, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, bitOffsetErr()
+	}
+	if byIndex {
+		if offset > math.MaxInt64/int64(width) {
+			return 0, bitOffsetErr()
+		}
+		offset *= int64(width)
+	}
+	if (offset+int64(width)-1)>>3 >= maxStringSize {
+		return 0, bitOffsetErr()
+	}
+	return offset, nil
+}
+
+// growString returns a copy of old padded with zeros to at least size bytes,
+// the old slice is never changed as it may be held by replies not yet sent
+func growString(old []byte, size int64) []byte {
+	value := make([]byte, max(int64(len(old)), size))
+	copy(value, old)
+	return value
+}
+
+func getBit(value []byte, offset int64) byte {
+	if offset>>3 >= int64(len(value)) {
+		return 0
+	}
+	return value[offset>>3] >> (7 - offset&7) & 1
+}
+
+func setBit(value []byte, offset int64, bit byte) {
+	mask := byte(1) << (7 - offset&7)
+	if bit == 1 {
+		value[offset>>3] |= mask
+	} else {
+		value[offset>>3] &^= mask
+	}
+}
+
+// execSetBit sets or clears the bit at offset and returns the old bit, SETBIT key offset value
+func execSetBit(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	offset, errReply := parseBitOffset(args[1], false, 1)
+	if errReply != nil {
+		return errReply
+	}
+	bitArg := string(args[2])
+	if bitArg != "0" && bitArg != "1" {
+		return protocol.MakeErrReply("ERR bit is not an integer or out of range")
+	}
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	value := growString(old, offset>>3+1)
+	oldBit := getBit(value, offset)
+	setBit(value, offset, bitArg[0]-'0')
+	db.PutEntity(key, &payload.DataEntity{RedisObject: value})
+	db.writeAof(toCmdLine3("setbit", args))
+	return protocol.MakeIntReply(int64(oldBit))
+}
+
+// execGetBit returns the bit at offset, GETBIT key offset
+func execGetBit(db *DB, args [][]byte) protocol.Reply {
+	offset, errReply := parseBitOffset(args[1], false, 1)
+	if errReply != nil {
+		return errReply
+	}
+	value, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	return protocol.MakeIntReply(int64(getBit(value, offset)))
+}
+
+// bitRange is a range of bits given by start and end both inclusive, either in bytes or in bits
+type bitRange struct {
+	start, end int64
+	isBit      bool
+	endGiven   bool
+}
+
+// parseBitRange parses [start [end [BYTE|BIT]]], end is required if requireEnd
+func parseBitRange(args [][]byte, requireEnd bool) (*bitRange, protocol.ErrorReply) {
+	r := &bitRange{start: 0, end: -1}
+	if len(args) > 3 || requireEnd && len(args) == 1 {
+		return nil, protocol.MakeSyntaxErrReply()
+	}
+	var err error
+	if len(args) > 0 {
+		if r.start, err = strconv.ParseInt(string(args[0]), 10, 64); err != nil {
+			return nil, notIntegerErr()
+		}
+	}
+	if len(args) > 1 {
+		if r.end, err = strconv.ParseInt(string(args[1]), 10, 64); err != nil {
+			return nil, notIntegerErr()
+		}
+		r.endGiven = true
+	}
+	if len(args) > 2 {
+		switch strings.ToLower(string(args[2])) {
+		case "byte":
+		case "bit":
+			r.isBit = true
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+	}
+	return r, nil
+}
+
+// bits returns the range in bits within a value of size bytes, ok is false if the range is empty
+func (r *bitRange) bits(size int) (first, last int64, ok bool) {
+	total := int64(size)
+	if r.isBit {
+		total *= 8
+	}
+	start, end := r.start, r.end
+	if start < 0 && end < 0 && start > end {
+		return 0, 0, false
+	}
+	if start < 0 {
+		start = max(total+start, 0)
+	}
+	if end < 0 {
+		end = max(total+end, 0)
+	}
+	end = min(end, total-1)
+	if start > end {
+		return 0, 0, false
+	}
+	if r.isBit {
+		return start, end, true
+	}
+	return start * 8, end*8 + 7, true
+}
+
+// countBits returns the number of set bits between first and last both inclusive
+func countBits(value []byte, first, last int64) int64 {
+	firstByte, lastByte := first>>3, last>>3
+	if firstByte == lastByte {
+		mask := byte(0xff>>(first&7)) & byte(0xff<<(7-last&7))
+		return int64(bits.OnesCount8(value[firstByte] & mask))
+	}
+	count := bits.OnesCount8(value[firstByte] & byte(0xff>>(first&7)))
+	count += bits.OnesCount8(value[lastByte] & byte(0xff<<(7-last&7)))
+	for _, b := range value[firstByte+1 : lastByte] {
+		count += bits.OnesCount8(b)
+	}
+	return int64(count)
+}
+
+// execBitCount returns the number of set bits, BITCOUNT key [start end [BYTE|BIT]]
+func execBitCount(db *DB, args [][]byte) protocol.Reply {
+	r, errReply := parseBitRange(args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+	value, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	first, last, ok := r.bits(len(value))
+	if !ok {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(countBits(value, first, last))
+}
+
+// findBit returns the position of the first bit between first and last both inclusive, or -1
+func findBit(value []byte, bit byte, first, last int64) int64 {
+	// the bytes without the bit are skipped
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for pos := first; pos <= last; {
+		if pos&7 == 0 && pos+7 <= last && value[pos>>3] == skip {
+			pos += 8
+			continue
+		}
+		if getBit(value, pos) == bit {
+			return pos
+		}
+		pos++
+	}
+	return -1
+}
+
+// execBitPos returns the position of the first bit set to 1 or 0, BITPOS key bit [start [end [BYTE|BIT]]]
+func execBitPos(db *DB, args [][]byte) protocol.Reply {
+	bitArg := string(args[1])
+	if bitArg != "0" && bitArg != "1" {
+		return protocol.MakeErrReply("ERR The bit argument must be 1 or 0.")
+	}
+	bit := bitArg[0] - '0'
+	r, errReply := parseBitRange(args[2:], false)
+	if errReply != nil {
+		return errReply
+	}
+	value, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if value == nil {
+		if bit == 1 {
+			return protocol.MakeIntReply(-1)
+		}
+		return protocol.MakeIntReply(0)
+	}
+	first, last, ok := r.bits(len(value))
+	if !ok {
+		return protocol.MakeIntReply(-1)
+	}
+	pos := findBit(value, bit, first, last)
+	if pos == -1 && bit == 0 && !r.endGiven {
+		// without an explicit end, the string is considered padded with zeros on the right
+		pos = last + 1
+	}
+	return protocol.MakeIntReply(pos)
+}
+
+// prepareBitOp locks destkey for write and the source keys for read, BITOP operation destkey key [key ...]
+func prepareBitOp(args [][]byte) ([]string, []string) {
+	return toStrings(args[2:]), []string{string(args[1])}
+}
+
+func undoBitOp(db *DB, args [][]byte) [][][]byte {
+	return rollbackGivenKeys(db, string(args[1]))
+}
+
+// execBitOp stores the bitwise operation of the source strings to destkey and returns its length,
+// BITOP AND|OR|XOR|NOT|DIFF destkey key [key ...]
+func execBitOp(db *DB, args [][]byte) protocol.Reply {
+	op := strings.ToLower(string(args[0]))
+	dest := string(args[1])
+	keys := args[2:]
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(keys) != 1 {
+			return protocol.MakeErrReply("ERR BITOP NOT must be called with a single source key.")
+		}
+	case "diff":
+		if len(keys) < 2 {
+			return protocol.MakeErrReply("ERR BITOP DIFF must be called with at least two source keys.")
+		}
+	default:
+		return protocol.MakeSyntaxErrReply()
+	}
+	values := make([][]byte, len(keys))
+	size := 0
+	for i, key := range keys {
+		value, errReply := db.getAsString(string(key))
+		if errReply != nil {
+			return errReply
+		}
+		values[i] = value
+		size = max(size, len(value))
+	}
+	// the shorter strings are padded with zeros
+	byteAt := func(value []byte, i int) byte {
+		if i < len(value) {
+			return value[i]
+		}
+		return 0
+	}
+	result := make([]byte, size)
+	for i := range result {
+		b := byteAt(values[0], i)
+		switch op {
+		case "not":
+			b = ^b
+		case "diff":
+			// the bits of the first key not set in any of the others
+			others := byte(0)
+			for _, value := range values[1:] {
+				others |= byteAt(value, i)
+			}
+			b &^= others
+		default:
+			for _, value := range values[1:] {
+				switch op {
+				case "and":
+					b &= byteAt(value, i)
+				case "or":
+					b |= byteAt(value, i)
+				case "xor":
+					b ^= byteAt(value, i)
+				}
+			}
+		}
+		result[i] = b
+	}
+	if size == 0 {
+		db.Remove(dest)
+	} else {
+		db.PutEntity(dest, &payload.DataEntity{RedisObject: result})
+		db.Persist(dest)
+	}
+	db.writeAof(toCmdLine3("bitop", args))
+	return protocol.MakeIntReply(int64(size))
+}
+
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+const (
+	bitFieldGet = iota
+	bitFieldSet
+	bitFieldIncrBy
+)
+
+// bitFieldOp is a GET, SET or INCRBY of BITFIELD
+type bitFieldOp struct {
+	kind     int
+	signed   bool
+	width    int
+	offset   int64
+	value    int64
+	overflow int
+}
+
+// parseBitFieldType parses i1 to i64 and u1 to u63
+func parseBitFieldType(arg []byte) (signed bool, width int, errReply protocol.ErrorReply) {
+	typeErr := protocol.MakeErrReply(
+		"ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	s := strings.ToLower(string(arg))
+	if len(s) < 2 || s[0] != 'i' && s[0] != 'u' || s[1] < '1' || s[1] > '9' {
+		return false, 0, typeErr
+	}
+	n, err := strconv.Atoi(s[1:])
+	if err != nil || s[0] == 'i' && n > 64 || s[0] == 'u' && n > 63 {
+		return false, 0, typeErr
+	}
+	return s[0] == 'i', n, nil
+}
+
+// parseBitField parses the sub-commands of BITFIELD, writing tells whether any of them is SET or INCRBY
+func parseBitField(args [][]byte, readOnly bool) (ops []*bitFieldOp, writing bool, errReply protocol.ErrorReply) {
+	overflow := overflowWrap
+	for i := 0; i < len(args); {
+		sub := strings.ToLower(string(args[i]))
+		if sub == "overflow" {
+			if i+1 >= len(args) {
+				return nil, false, protocol.MakeSyntaxErrReply()
+			}
+			switch strings.ToLower(string(args[i+1])) {
+			case "wrap":
+				overflow = overflowWrap
+			case "sat":
+				overflow = overflowSat
+			case "fail":
+				overflow = overflowFail
+			default:
+				return nil, false, protocol.MakeErrReply("ERR Invalid OVERFLOW type specified")
+			}
+			i += 2
+			continue
+		}
+		op := &bitFieldOp{overflow: overflow}
+		n := 3
+		switch sub {
+		case "get":
+			op.kind = bitFieldGet
+		case "set":
+			op.kind, n = bitFieldSet, 4
+		case "incrby":
+			op.kind, n = bitFieldIncrBy, 4
+		default:
+			return nil, false, protocol.MakeSyntaxErrReply()
+		}
+		if i+n > len(args) {
+			return nil, false, protocol.MakeSyntaxErrReply()
+		}
+		if readOnly && op.kind != bitFieldGet {
+			return nil, false, protocol.MakeErrReply("ERR BITFIELD_RO only supports the GET subcommand")
+		}
+		if op.signed, op.width, errReply = parseBitFieldType(args[i+1]); errReply != nil {
+			return nil, false, errReply
+		}
+		if op.offset, errReply = parseBitOffset(args[i+2], true, op.width); errReply != nil {
+			return nil, false, errReply
+		}
+		if n == 4 {
+			var err error
+			if op.value, err = strconv.ParseInt(string(args[i+3]), 10, 64); err != nil {
+				return nil, false, notIntegerErr()
+			}
+			writing = true
+		}
+		ops = append(ops, op)
+		i += n
+	}
+	return ops, writing, nil
+}
+
+func getUnsignedField(value []byte, offset int64, width int) uint64 {
+	var v uint64
+	for i := int64(0); i < int64(width); i++ {
+		v = v<<1 | uint64(getBit(value, offset+i))
+	}
+	return v
+}
+
+func getSignedField(value []byte, offset int64, width int) int64 {
+	v := getUnsignedField(value, offset, width)
+	// sign extension
+	if width < 64 && v&(1<<(width-1)) != 0 {
+		v |= math.MaxUint64 << width
+	}
+	return int64(v)
+}
+
+func setField(value []byte, offset int64, width int, v uint64) {
+	for i := 0; i < width; i++ {
+		setBit(value, offset+int64(i), byte(v>>(width-1-i)&1))
+	}
+}
+
+// checkUnsignedOverflow returns whether value+incr overflows the unsigned field of width bits,
+// and the value to store for WRAP and SAT
+func checkUnsignedOverflow(value uint64, incr int64, width int, overflow int) (bool, uint64) {
+	maxValue := uint64(1)<<width - 1
+	maxIncr := int64(maxValue - value)
+	minIncr := -int64(value)
+	switch {
+	case value > maxValue || incr > 0 && incr > maxIncr:
+		if overflow == overflowSat {
+			return true, maxValue
+		}
+	case incr < 0 && incr < minIncr:
+		if overflow == overflowSat {
+			return true, 0
+		}
+	default:
+		return false, 0
+	}
+	return true, (value + uint64(incr)) & maxValue
+}
+
+// checkSignedOverflow returns whether value+incr overflows the signed field of width bits,
+// and the value to store for WRAP and SAT
+func checkSignedOverflow(value, incr int64, width int, overflow int) (bool, int64) {
+	maxValue := int64(math.MaxInt64)
+	if width < 64 {
+		maxValue = int64(1)<<(width-1) - 1
+	}
+	minValue := -maxValue - 1
+	maxIncr := maxValue - value
+	minIncr := minValue - value
+	switch {
+	case value > maxValue || width != 64 && incr > maxIncr || value >= 0 && incr > 0 && incr > maxIncr:
+		if overflow == overflowSat {
+			return true, maxValue
+		}
+	case value < minValue || width != 64 && incr < minIncr || value < 0 && incr < 0 && incr < minIncr:
+		if overflow == overflowSat {
+			return true, minValue
+		}
+	default:
+		return false, 0
+	}
+	c := uint64(value) + uint64(incr)
+	if width < 64 {
+		mask := uint64(math.MaxUint64) << width
+		if c&(1<<(width-1)) != 0 {
+			c |= mask
+		} else {
+			c &^= mask
+		}
+	}
+	return true, int64(c)
+}
+
+// execBitFieldOp runs op on value, which is already grown to hold the field
+func execBitFieldOp(value []byte, op *bitFieldOp) protocol.Reply {
+	if op.kind == bitFieldGet {
+		if op.signed {
+			return protocol.MakeIntReply(getSignedField(value, op.offset, op.width))
+		}
+		return protocol.MakeIntReply(int64(getUnsignedField(value, op.offset, op.width)))
+	}
+	var reply, store int64
+	var overflowed bool
+	if op.signed {
+		old := getSignedField(value, op.offset, op.width)
+		if op.kind == bitFieldIncrBy {
+			store = old + op.value
+			if ok, wrapped := checkSignedOverflow(old, op.value, op.width, op.overflow); ok {
+				overflowed, store = true, wrapped
+			}
+			reply = store
+		} else {
+			store = op.value
+			if ok, wrapped := checkSignedOverflow(op.value, 0, op.width, op.overflow); ok {
+				overflowed, store = true, wrapped
+			}
+			reply = old
+		}
+	} else {
+		old := getUnsignedField(value, op.offset, op.width)
+		if op.kind == bitFieldIncrBy {
+			store = int64(old + uint64(op.value))
+			if ok, wrapped := checkUnsignedOverflow(old, op.value, op.width, op.overflow); ok {
+				overflowed, store = true, int64(wrapped)
+			}
+			reply = store
+		} else {
+			store = op.value
+			if ok, wrapped := checkUnsignedOverflow(uint64(op.value), 0, op.width, op.overflow); ok {
+				overflowed, store = true, int64(wrapped)
+			}
+			reply = int64(old)
+		}
+	}
+	if overflowed && op.overflow == overflowFail {
+		return protocol.MakeNullBulkReply()
+	}
+	setField(value, op.offset, op.width, uint64(store))
+	return protocol.MakeIntReply(reply)
+}
+
+func bitFieldGeneric(db *DB, cmd string, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	ops, writing, errReply := parseBitField(args[1:], cmd == "bitfield_ro")
+	if errReply != nil {
+		return errReply
+	}
+	value, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if writing {
+		// the string grows to hold the fields written, even if an overflow fails the writing
+		size := int64(0)
+		for _, op := range ops {
+			if op.kind != bitFieldGet {
+				size = max(size, (op.offset+int64(op.width)-1)>>3+1)
+			}
+		}
+		value = growString(value, size)
+	}
+	replies := make([]protocol.Reply, len(ops))
+	for i, op := range ops {
+		replies[i] = execBitFieldOp(value, op)
+	}
+	if writing {
+		db.PutEntity(key, &payload.DataEntity{RedisObject: value})
+		db.writeAof(toCmdLine3(cmd, args))
+	}
+	return protocol.MakeArrayReply(replies)
+}
+
+// execBitField gets, sets and increments integer fields of arbitrary width and offset,
+// BITFIELD key [GET encoding offset | [OVERFLOW WRAP|SAT|FAIL] SET encoding offset value |
+// INCRBY encoding offset increment ...]
+func execBitField(db *DB, args [][]byte) protocol.Reply {
+	return bitFieldGeneric(db, "bitfield", args)
+}
+
+// execBitFieldRO is the read only BITFIELD, BITFIELD_RO key [GET encoding offset ...]
+func execBitFieldRO(db *DB, args [][]byte) protocol.Reply {
+	return bitFieldGeneric(db, "bitfield_ro", args)
+}
+
+func init() {
+	registerCommand("SetBit", 4, execSetBit, writeFirstKey, rollbackFirstKey)
+	registerCommand("GetBit", 3, execGetBit, readFirstKey, nil)
+	registerCommand("BitCount", -2, execBitCount, readFirstKey, nil)
+	registerCommand("BitPos", -3, execBitPos, readFirstKey, nil)
+	registerCommand("BitOp", -4, execBitOp, prepareBitOp, undoBitOp)
+	registerCommand("BitField", -2, execBitField, writeFirstKey, rollbackFirstKey)
+	registerCommand("BitField_RO", -2, execBitFieldRO, readFirstKey, nil)
+}
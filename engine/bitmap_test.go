@@ -0,0 +1,245 @@
+package engine
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/issimo1/redis-issimo/redis/connection"
+)
+
+func TestSetBit(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "setbit", "k", "7", "1"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "get", "k"), "$1\r\n\x01\r\n")
+	assertReply(t, execCmd(e, c, "setbit", "k", "7", "0"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "setbit", "k", "17", "1"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "get", "k"), "$3\r\n\x00\x00\x40\r\n")
+	assertReply(t, execCmd(e, c, "getbit", "k", "17"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "getbit", "k", "16"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "getbit", "k", "1000"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "getbit", "none", "0"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "setbit", "k", "0", "2"), "-ERR bit is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "setbit", "k", "-1", "1"), "-ERR bit offset is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "setbit", "k", "4294967296", "1"), "-ERR bit offset is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "getbit", "k", "x"), "-ERR bit offset is not an integer or out of range\r\n")
+
+	// the value of an integer string is its text
+	execCmd(e, c, "set", "n", "1")
+	assertReply(t, execCmd(e, c, "getbit", "n", "2"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "setbit", "n", "6", "1"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "get", "n"), "$1\r\n3\r\n")
+	assertReply(t, execCmd(e, c, "incr", "n"), ":4\r\n")
+
+	// the ttl is kept
+	execCmd(e, c, "set", "ttl", "a", "ex", "100")
+	execCmd(e, c, "setbit", "ttl", "0", "1")
+	assertReply(t, execCmd(e, c, "ttl", "ttl"), ":100\r\n")
+	execCmd(e, c, "lpush", "list", "a")
+	assertReply(t, execCmd(e, c, "setbit", "list", "0", "1"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+}
+
+func TestBitCount(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "k", "foobar")
+	assertReply(t, execCmd(e, c, "bitcount", "k"), ":26\r\n")
+	assertReply(t, execCmd(e, c, "bitcount", "k", "0", "0"), ":4\r\n")
+	assertReply(t, execCmd(e, c, "bitcount", "k", "1", "1"), ":6\r\n")
+	assertReply(t, execCmd(e, c, "bitcount", "k", "1", "1", "byte"), ":6\r\n")
+	assertReply(t, execCmd(e, c, "bitcount", "k", "5", "30", "bit"), ":17\r\n")
+	assertReply(t, execCmd(e, c, "bitcount", "k", "-2", "-1"), ":7\r\n")
+	assertReply(t, execCmd(e, c, "bitcount", "k", "-1", "-2"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "bitcount", "k", "3", "100"), ":10\r\n")
+	assertReply(t, execCmd(e, c, "bitcount", "k", "0"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "bitcount", "k", "0", "1", "bits"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "bitcount", "k", "a", "1"), "-ERR value is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "bitcount", "none"), ":0\r\n")
+
+	value := make([]byte, 100)
+	rand.Read(value)
+	execCmd(e, c, "set", "r", string(value))
+	for i := 0; i < 100; i++ {
+		start, end := rand.Intn(800), rand.Intn(800)
+		count := 0
+		for pos := start; pos <= end; pos++ {
+			count += int(value[pos/8] >> (7 - pos%8) & 1)
+		}
+		assertReply(t, execCmd(e, c, "bitcount", "r", strconv.Itoa(start), strconv.Itoa(end), "bit"),
+			":"+strconv.Itoa(count)+"\r\n")
+	}
+}
+
+func TestBitPos(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "k", "\xff\xf0\x00")
+	assertReply(t, execCmd(e, c, "bitpos", "k", "0"), ":12\r\n")
+	execCmd(e, c, "set", "k", "\x00\xff\xf0")
+	assertReply(t, execCmd(e, c, "bitpos", "k", "1", "0"), ":8\r\n")
+	assertReply(t, execCmd(e, c, "bitpos", "k", "1", "2"), ":16\r\n")
+	assertReply(t, execCmd(e, c, "bitpos", "k", "1", "2", "-1", "byte"), ":16\r\n")
+	assertReply(t, execCmd(e, c, "bitpos", "k", "1", "7", "15", "bit"), ":8\r\n")
+	assertReply(t, execCmd(e, c, "bitpos", "k", "0", "9", "-1", "bit"), ":20\r\n")
+	execCmd(e, c, "set", "k", "\x00\x00\x00")
+	assertReply(t, execCmd(e, c, "bitpos", "k", "1"), ":-1\r\n")
+	assertReply(t, execCmd(e, c, "bitpos", "k", "1", "7", "-3", "bit"), ":-1\r\n")
+
+	// the string is padded with zeros unless end is given
+	execCmd(e, c, "set", "k", "\xff\xff\xff")
+	assertReply(t, execCmd(e, c, "bitpos", "k", "0"), ":24\r\n")
+	assertReply(t, execCmd(e, c, "bitpos", "k", "0", "1"), ":24\r\n")
+	assertReply(t, execCmd(e, c, "bitpos", "k", "0", "0", "-1"), ":-1\r\n")
+	assertReply(t, execCmd(e, c, "bitpos", "none", "0"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "bitpos", "none", "1"), ":-1\r\n")
+	assertReply(t, execCmd(e, c, "bitpos", "k", "2"), "-ERR The bit argument must be 1 or 0.\r\n")
+	assertReply(t, execCmd(e, c, "bitpos", "k", "1", "0", "1", "bit", "x"), "-ERR syntax error\r\n")
+}
+
+func TestBitOp(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "a", "foobar")
+	execCmd(e, c, "set", "b", "abcdef")
+	assertReply(t, execCmd(e, c, "bitop", "and", "dest", "a", "b"), ":6\r\n")
+	assertReply(t, execCmd(e, c, "get", "dest"), "$6\r\n`bc`ab\r\n")
+	assertReply(t, execCmd(e, c, "bitop", "or", "dest", "a", "b"), ":6\r\n")
+	assertReply(t, execCmd(e, c, "get", "dest"), "$6\r\ngoofev\r\n")
+
+	execCmd(e, c, "set", "x", "\xf0\x0f")
+	execCmd(e, c, "set", "y", "\x30")
+	execCmd(e, c, "set", "z", "\x80\xff\x01")
+	assertReply(t, execCmd(e, c, "bitop", "xor", "dest", "x", "y", "none"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "get", "dest"), "$2\r\n\xc0\x0f\r\n")
+	assertReply(t, execCmd(e, c, "bitop", "and", "dest", "x", "z"), ":3\r\n")
+	assertReply(t, execCmd(e, c, "get", "dest"), "$3\r\n\x80\x0f\x00\r\n")
+	assertReply(t, execCmd(e, c, "bitop", "not", "dest", "x"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "get", "dest"), "$2\r\n\x0f\xf0\r\n")
+	assertReply(t, execCmd(e, c, "bitop", "diff", "dest", "x", "y", "z"), ":3\r\n")
+	assertReply(t, execCmd(e, c, "get", "dest"), "$3\r\n\x40\x00\x00\r\n")
+
+	assertReply(t, execCmd(e, c, "bitop", "not", "dest", "x", "y"), "-ERR BITOP NOT must be called with a single source key.\r\n")
+	assertReply(t, execCmd(e, c, "bitop", "diff", "dest", "x"), "-ERR BITOP DIFF must be called with at least two source keys.\r\n")
+	assertReply(t, execCmd(e, c, "bitop", "nand", "dest", "x"), "-ERR syntax error\r\n")
+	execCmd(e, c, "lpush", "list", "a")
+	assertReply(t, execCmd(e, c, "bitop", "or", "dest", "x", "list"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	assertReply(t, execCmd(e, c, "bitop", "or", "dest", "none"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "exists", "dest"), ":0\r\n")
+}
+
+func TestBitField(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "set", "i8", "0", "-100"), "*1\r\n:0\r\n")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "set", "i8", "0", "101"), "*1\r\n:-100\r\n")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "get", "i8", "0"), "*1\r\n:101\r\n")
+	execCmd(e, c, "del", "bits")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "set", "u8", "0", "255"), "*1\r\n:0\r\n")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "set", "u8", "0", "100"), "*1\r\n:255\r\n")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "get", "u8", "0"), "*1\r\n:100\r\n")
+
+	execCmd(e, c, "del", "bits")
+	execCmd(e, c, "bitfield", "bits", "set", "u8", "#0", "65", "set", "u8", "#1", "66", "set", "u8", "#2", "67")
+	assertReply(t, execCmd(e, c, "get", "bits"), "$3\r\nABC\r\n")
+	execCmd(e, c, "del", "bits")
+	execCmd(e, c, "bitfield", "bits", "set", "u8", "#0", "10")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "incrby", "u8", "#0", "100", "incrby", "u8", "#0", "100"),
+		"*2\r\n:110\r\n:210\r\n")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "incrby", "i5", "100", "1", "get", "u4", "0"), "*2\r\n:1\r\n:13\r\n")
+	assertReply(t, execCmd(e, c, "strlen", "bits"), ":14\r\n")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "get", "i64", "0", "get", "u63", "1", "get", "i1", "0"),
+		"*3\r\n:-3314649325744685056\r\n:5908722711110090752\r\n:-1\r\n")
+
+	execCmd(e, c, "del", "bits")
+	for _, expected := range []string{"*2\r\n:1\r\n:1\r\n", "*2\r\n:2\r\n:2\r\n", "*2\r\n:3\r\n:3\r\n", "*2\r\n:0\r\n:3\r\n"} {
+		assertReply(t, execCmd(e, c, "bitfield", "bits", "incrby", "u2", "100", "1", "overflow", "sat", "incrby", "u2", "102", "1"), expected)
+	}
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "overflow", "fail", "incrby", "u2", "102", "1"), "*1\r\n$-1\r\n")
+
+	overflows := []struct {
+		cmd      []string
+		expected string
+	}{
+		{[]string{"set", "u8", "0", "100", "overflow", "wrap", "incrby", "u8", "0", "257", "incrby", "u8", "0", "255"}, "*3\r\n:0\r\n:101\r\n:100\r\n"},
+		{[]string{"set", "u8", "0", "100", "overflow", "sat", "incrby", "u8", "0", "257", "incrby", "u8", "0", "-256"}, "*3\r\n:0\r\n:255\r\n:0\r\n"},
+		{[]string{"set", "i8", "0", "100", "overflow", "wrap", "incrby", "i8", "0", "257", "incrby", "i8", "0", "255"}, "*3\r\n:0\r\n:101\r\n:100\r\n"},
+		{[]string{"set", "i8", "0", "100", "overflow", "sat", "incrby", "i8", "0", "257", "incrby", "i8", "0", "-255"}, "*3\r\n:0\r\n:127\r\n:-128\r\n"},
+		{[]string{"overflow", "fail", "set", "i8", "0", "128", "set", "u8", "0", "256", "set", "i8", "0", "-128", "incrby", "i8", "0", "-1"}, "*4\r\n$-1\r\n$-1\r\n:0\r\n$-1\r\n"},
+		{[]string{"overflow", "sat", "set", "u8", "0", "-1", "get", "u8", "0", "overflow", "wrap", "set", "i8", "0", "200", "get", "i8", "0"}, "*4\r\n:0\r\n:255\r\n:-1\r\n:-56\r\n"},
+		{[]string{"set", "i64", "0", "9223372036854775807", "overflow", "wrap", "incrby", "i64", "0", "1", "overflow", "sat", "incrby", "i64", "0", "-1", "incrby", "i64", "0", "-9223372036854775808"},
+			"*4\r\n:0\r\n:-9223372036854775808\r\n:-9223372036854775808\r\n:-9223372036854775808\r\n"},
+		{[]string{"set", "u63", "0", "9223372036854775807", "incrby", "u63", "0", "1", "overflow", "sat", "incrby", "u63", "0", "-1"}, "*3\r\n:0\r\n:0\r\n:0\r\n"},
+	}
+	for _, o := range overflows {
+		execCmd(e, c, "del", "bits")
+		assertReply(t, execCmd(e, c, append([]string{"bitfield", "bits"}, o.cmd...)...), o.expected)
+	}
+
+	execCmd(e, c, "del", "bits")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "set", "i8", "0", "10", "set", "i8", "64", "10", "incrby", "i8", "10", "99900"),
+		"*3\r\n:0\r\n:0\r\n:60\r\n")
+	// a failed writing still grows the string
+	execCmd(e, c, "del", "bits")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "overflow", "fail", "set", "u8", "16", "256"), "*1\r\n$-1\r\n")
+	assertReply(t, execCmd(e, c, "get", "bits"), "$3\r\n\x00\x00\x00\r\n")
+	// a reading never creates the key
+	assertReply(t, execCmd(e, c, "bitfield", "none", "get", "u8", "100"), "*1\r\n:0\r\n")
+	assertReply(t, execCmd(e, c, "exists", "none"), ":0\r\n")
+	execCmd(e, c, "set", "n", "1")
+	assertReply(t, execCmd(e, c, "bitfield", "n", "get", "u1", "0"), "*1\r\n:0\r\n")
+	assertReply(t, execCmd(e, c, "bitfield", "n"), "*0\r\n")
+
+	typeErr := "-ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.\r\n"
+	for _, typ := range []string{"u64", "i65", "i0", "x8", "i", "u+8", "i08"} {
+		assertReply(t, execCmd(e, c, "bitfield", "bits", "get", typ, "0"), typeErr)
+	}
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "get", "u8", "#-1"), "-ERR bit offset is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "get", "u8", "4294967289"), "-ERR bit offset is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "set", "u8", "0", "x"), "-ERR value is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "overflow", "none"), "-ERR Invalid OVERFLOW type specified\r\n")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "get", "u8"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "bitfield", "bits", "del", "u8", "0"), "-ERR syntax error\r\n")
+	// nothing is written if any sub-command is invalid
+	assertReply(t, execCmd(e, c, "bitfield", "fresh", "set", "u8", "0", "1", "get", "u64", "0"), typeErr)
+	assertReply(t, execCmd(e, c, "exists", "fresh"), ":0\r\n")
+
+	assertReply(t, execCmd(e, c, "bitfield_ro", "bits", "get", "u8", "16"), "*1\r\n:0\r\n")
+	assertReply(t, execCmd(e, c, "bitfield_ro", "bits"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "bitfield_ro", "bits", "set", "u8", "0", "100", "get", "u8", "0"),
+		"-ERR BITFIELD_RO only supports the GET subcommand\r\n")
+}
+
+func TestBitmapPropagation(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	db, _ := e.selectDB(0)
+	var aof [][][]byte
+	db.writeAof = func(cmdLine [][]byte) { aof = append(aof, cmdLine) }
+	execCmd(e, c, "setbit", "a", "100", "1")
+	execCmd(e, c, "bitfield", "b", "overflow", "fail", "set", "u8", "16", "256")
+	execCmd(e, c, "bitfield", "b", "incrby", "i5", "3", "20")
+	execCmd(e, c, "bitfield", "b", "get", "i5", "3")
+	execCmd(e, c, "bitop", "or", "c", "a", "b")
+	execCmd(e, c, "getbit", "a", "100")
+	if len(aof) != 4 {
+		t.Fatalf("got %d commands in aof", len(aof))
+	}
+	replayed := NewEngine()
+	for _, cmdLine := range aof {
+		replayed.Exec(c, cmdLine)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		assertReply(t, execCmd(replayed, c, "get", key), string(execCmd(e, c, "get", key).ToBytes()))
+	}
+}
+
+func TestBitmapRollback(t *testing.T) {
+	testRollback(t, rollbackCase{
+		name:  "bitmap",
+		setup: [][]string{{"setbit", "a", "100", "1"}, {"bitfield", "b", "set", "i8", "0", "-3"}},
+		cmds: [][]string{{"setbit", "a", "1000", "1"}, {"bitfield", "b", "set", "u16", "#10", "1"},
+			{"bitop", "not", "c", "a"}},
+		reads: [][]string{{"get", "a"}, {"get", "b"}, {"get", "c"}},
+	})
+}
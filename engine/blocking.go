@@ -0,0 +1,290 @@
+package engine
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/issimo1/redis-issimo/abs"
+	"github.com/issimo1/redis-issimo/datastruct/list"
+	"github.com/issimo1/redis-issimo/datastruct/sortedset"
+	"github.com/issimo1/redis-issimo/datastruct/stream"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/timewheel"
+)
+
+// waiter is a client blocked on keys
+type waiter struct {
+	keys []string
+	// ready receives a channel when one of keys may serve the client, the client closes it after trying again
+	ready chan chan struct{}
+	// registered is guarded by blockingQueues.mu
+	registered bool
+}
+
+// blockingQueues keeps the clients blocked on every key of a db, the client blocked first is served first
+type blockingQueues struct {
+	mu      sync.Mutex
+	queues  map[string][]*waiter
+	waiting atomic.Int32
+}
+
+func newBlockingQueues() *blockingQueues {
+	return &blockingQueues{
+		queues: make(map[string][]*waiter),
+	}
+}
+
+// add appends w to the queues of its keys, a registered waiter keeps its position
+func (b *blockingQueues) add(w *waiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if w.registered {
+		return
+	}
+	for _, key := range w.keys {
+		queue := b.queues[key]
+		// a key may be given twice
+		if len(queue) == 0 || queue[len(queue)-1] != w {
+			b.queues[key] = append(queue, w)
+		}
+	}
+	w.registered = true
+	b.waiting.Add(1)
+}
+
+// remove takes w out of the queues of its keys
+func (b *blockingQueues) remove(w *waiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !w.registered {
+		return
+	}
+	for _, key := range w.keys {
+		queue := b.queues[key]
+		for i, other := range queue {
+			if other == w {
+				queue = append(queue[:i:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(b.queues, key)
+		} else {
+			b.queues[key] = queue
+		}
+	}
+	w.registered = false
+	b.waiting.Add(-1)
+}
+
+// wake signals the first client blocked on key which is not in woken, adds it to woken, and returns
+// the channel closed once the client has tried again. A client that can't be served keeps its position,
+// woken lets the clients behind it try in the same round.
+// It returns nil if no such client is blocked on key, or the client is being woken by someone else.
+func (b *blockingQueues) wake(key string, woken map[*waiter]struct{}) chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, w := range b.queues[key] {
+		if _, ok := woken[w]; ok {
+			continue
+		}
+		woken[w] = struct{}{}
+		done := make(chan struct{})
+		select {
+		case w.ready <- done:
+			return done
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// isBlockingType returns true if a client may be blocked for the type of entity
+func isBlockingType(entity any) bool {
+	switch entity.(type) {
+	case list.List, *sortedset.SortedSet, *stream.Stream:
+		return true
+	default:
+		return false
+	}
+}
+
+// isReady returns true if key holds a value which may serve the blocked clients
+func (db *DB) isReady(key string) bool {
+	keys := []string{key}
+	db.RWLock(keys, nil)
+	defer db.RWUnLock(keys, nil)
+	entity, ok := db.GetEntity(key)
+	return ok && isBlockingType(entity.RedisObject)
+}
+
+// serveBlocked hands the values of keys to the clients blocked on them one by one, in the order they blocked.
+// It returns once no more client can be served, so that the next command sees what is left as redis does.
+// Keys must not be locked by caller.
+func (db *DB) serveBlocked(keys ...string) {
+	if db.blocking.waiting.Load() == 0 {
+		return
+	}
+	woken := make(map[*waiter]struct{})
+	for _, key := range keys {
+		for db.isReady(key) {
+			done := db.blocking.wake(key, woken)
+			if done == nil {
+				break
+			}
+			<-done
+		}
+	}
+}
+
+// blockingCommand is a command which blocks the client until one of its keys can serve it
+type blockingCommand struct {
+	// parse validates args and returns the timeout and the keys to wait for, 0 timeout waits forever
+	// and a negative timeout never blocks
+	parse func(args [][]byte) (time.Duration, []string, protocol.ErrorReply)
+	// prepare is optional, it rewrites args with the keys locked before the first try,
+	// so that the arguments relative to the current values are fixed while the client waits
+	prepare func(db *DB, args [][]byte) [][]byte
+	// try runs the command once, it returns nil if the client has to wait
+	try ExecFunc
+	// timeoutReply is returned if the client is not served in time
+	timeoutReply func() protocol.Reply
+}
+
+var blockingCommands = make(map[string]*blockingCommand)
+
+// registerBlockingCommand registers a blocking command, which never blocks when it is executed in MULTI
+func registerBlockingCommand(name string, arity int, bc *blockingCommand, keysFunc KeysFunc, undoFunc UndoFunc) *command {
+	blockingCommands[strings.ToLower(name)] = bc
+	return registerCommand(name, arity, func(db *DB, args [][]byte) protocol.Reply {
+		if _, _, errReply := bc.parse(args); errReply != nil {
+			return errReply
+		}
+		if reply := bc.try(db, args); reply != nil {
+			return reply
+		}
+		return bc.timeoutReply()
+	}, keysFunc, undoFunc)
+}
+
+// parseTimeout parses the timeout in seconds of blocking commands
+func parseTimeout(arg []byte) (time.Duration, protocol.ErrorReply) {
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, protocol.MakeErrReply("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, protocol.MakeErrReply("ERR timeout is negative")
+	}
+	if seconds > float64(math.MaxInt64/int64(time.Second)) {
+		return 0, protocol.MakeErrReply("ERR timeout is out of range")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// newBlockDelay creates the time wheel timing out the blocking commands,
+// the 1s ticks of the expiring wheel would delay a timeout like BLPOP q 0.1 for about a second
+func newBlockDelay() *timewheel.Delay {
+	return timewheel.NewDelayWithInterval(10*time.Millisecond, 6000)
+}
+
+func genBlockKey(c abs.Connection) string {
+	return "block:" + strconv.FormatInt(c.GetID(), 10)
+}
+
+// flusher is implemented by the connections buffering the replies of pipelined commands
+type flusher interface {
+	Flush() error
+}
+
+// execBlocking runs a blocking command, the client waits in the queues of the keys until it is served,
+// the timeout fires or the client is disconnected
+func (db *DB) execBlocking(c abs.Connection, bc *blockingCommand, cmdLine [][]byte) protocol.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd := commandCenter[cmdName]
+	if !validateArity(cmd.argsNum, cmdLine) {
+		return protocol.MakeArgNumErrReply(cmdName)
+	}
+	args := cmdLine[1:]
+	timeout, keys, errReply := bc.parse(args)
+	if errReply != nil {
+		return errReply
+	}
+	if timeout < 0 {
+		return db.generalCommand(c, cmdLine)
+	}
+	readKeys, writeKeys := cmd.keyFunc(args)
+	if bc.prepare != nil {
+		db.RWLock(readKeys, writeKeys)
+		args = bc.prepare(db, args)
+		db.RWUnLock(readKeys, writeKeys)
+	}
+	w := &waiter{keys: keys, ready: make(chan chan struct{}, 1)}
+	var timedOut chan struct{}
+	if timeout > 0 {
+		timedOut = make(chan struct{})
+		taskKey := genBlockKey(c)
+		db.blockDelay.Add(timeout, taskKey, func() {
+			close(timedOut)
+		})
+		defer db.blockDelay.Cancel(taskKey)
+	}
+	var done chan struct{}
+	for {
+		reply := db.tryBlocking(bc, w, args, readKeys, writeKeys)
+		if done != nil {
+			close(done)
+		}
+		if reply != nil {
+			// a moved value may serve the clients blocked on dest
+			db.serveBlocked(writeKeys...)
+			return reply
+		}
+		if done == nil {
+			// the replies of the commands pipelined before must not wait for the client to be served
+			if f, ok := c.(flusher); ok {
+				_ = f.Flush()
+			}
+		}
+		select {
+		case done = <-w.ready:
+		case <-timedOut:
+			db.stopWaiting(w)
+			return bc.timeoutReply()
+		case <-c.Done():
+			db.stopWaiting(w)
+			return bc.timeoutReply()
+		}
+	}
+}
+
+// tryBlocking runs the blocking command once, the client is queued while the keys are still locked if it has to wait
+func (db *DB) tryBlocking(bc *blockingCommand, w *waiter, args [][]byte, readKeys, writeKeys []string) protocol.Reply {
+	db.RWLock(readKeys, writeKeys)
+	defer db.RWUnLock(readKeys, writeKeys)
+	reply := bc.try(db, args)
+	if reply == nil {
+		db.blocking.add(w)
+		return nil
+	}
+	db.stopWaiting(w)
+	if !protocol.IsErrorReply(reply) {
+		db.addVersion(writeKeys...)
+	}
+	return reply
+}
+
+// stopWaiting removes w from the queues, a waker is released if it woke w meanwhile
+func (db *DB) stopWaiting(w *waiter) {
+	db.blocking.remove(w)
+	select {
+	case done := <-w.ready:
+		close(done)
+	default:
+	}
+}
@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+// execAsync runs the command in another goroutine, the reply is sent to the returned channel
+func execAsync(e *Engine, c *connection.FakeConn, cmd ...string) <-chan protocol.Reply {
+	result := make(chan protocol.Reply, 1)
+	go func() {
+		result <- execCmd(e, c, cmd...)
+	}()
+	return result
+}
+
+// waitBlocked waits until n clients are blocked in db 0
+func waitBlocked(t *testing.T, e *Engine, n int32) {
+	t.Helper()
+	db, _ := e.selectDB(0)
+	deadline := time.Now().Add(time.Second)
+	for db.blocking.waiting.Load() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients blocked, want %d", db.blocking.waiting.Load(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, ch <-chan protocol.Reply, timeout time.Duration) protocol.Reply {
+	t.Helper()
+	select {
+	case reply := <-ch:
+		return reply
+	case <-time.After(timeout):
+		t.Fatal("the blocked command is not served")
+		return nil
+	}
+}
+
+func assertBlocked(t *testing.T, ch <-chan protocol.Reply) {
+	t.Helper()
+	select {
+	case reply := <-ch:
+		t.Fatalf("expect blocked, got %q", reply.ToBytes())
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestBlockingPopReady(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "rpush", "b", "1", "2")
+	assertReply(t, execCmd(e, c, "blpop", "a", "b", "0"), "*2\r\n$1\r\nb\r\n$1\r\n1\r\n")
+	assertReply(t, execCmd(e, c, "brpop", "a", "b", "0"), "*2\r\n$1\r\nb\r\n$1\r\n2\r\n")
+	assertReply(t, execCmd(e, c, "exists", "b"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "blpop", "a", "-1"), "-ERR timeout is negative\r\n")
+	assertReply(t, execCmd(e, c, "blpop", "a", "x"), "-ERR timeout is not a float or out of range\r\n")
+	assertReply(t, execCmd(e, c, "blmove", "a", "b", "up", "left", "0"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "blmpop", "0", "0", "a", "left"), "-ERR numkeys should be greater than 0\r\n")
+	execCmd(e, c, "set", "s", "1")
+	assertReply(t, execCmd(e, c, "blpop", "s", "0"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	// never blocks inside MULTI
+	execCmd(e, c, "multi")
+	execCmd(e, c, "blpop", "a", "0")
+	execCmd(e, c, "rpush", "a", "x")
+	execCmd(e, c, "brpoplpush", "a", "c", "0")
+	execCmd(e, c, "blmpop", "0", "1", "a", "left")
+	assertReply(t, execCmd(e, c, "exec"), "*4\r\n*-1\r\n:1\r\n$1\r\nx\r\n*-1\r\n")
+	assertReply(t, execCmd(e, c, "lrange", "c", "0", "-1"), "*1\r\n$1\r\nx\r\n")
+}
+
+func TestBlockingPopWake(t *testing.T) {
+	e := NewEngine()
+	c1 := connection.NewFakeConn()
+	c2 := connection.NewFakeConn()
+	c := connection.NewFakeConn()
+
+	// clients are served in the order they blocked
+	r1 := execAsync(e, c1, "blpop", "a", "b", "0")
+	waitBlocked(t, e, 1)
+	r2 := execAsync(e, c2, "brpop", "b", "0")
+	waitBlocked(t, e, 2)
+	assertReply(t, execCmd(e, c, "rpush", "b", "x"), ":1\r\n")
+	assertReply(t, receive(t, r1, time.Second), "*2\r\n$1\r\nb\r\n$1\r\nx\r\n")
+	assertBlocked(t, r2)
+	waitBlocked(t, e, 1)
+	// the popped element is not left in the list
+	assertReply(t, execCmd(e, c, "exists", "b"), ":0\r\n")
+
+	// a push of several elements serves several clients
+	r1 = execAsync(e, c1, "blmpop", "0", "1", "b", "left", "count", "2")
+	waitBlocked(t, e, 2)
+	execCmd(e, c, "rpush", "b", "1", "2", "3")
+	assertReply(t, receive(t, r2, time.Second), "*2\r\n$1\r\nb\r\n$1\r\n3\r\n")
+	assertReply(t, receive(t, r1, time.Second), "*2\r\n$1\r\nb\r\n*2\r\n$1\r\n1\r\n$1\r\n2\r\n")
+	waitBlocked(t, e, 0)
+}
+
+func TestBlockingMove(t *testing.T) {
+	e := NewEngine()
+	c1 := connection.NewFakeConn()
+	c2 := connection.NewFakeConn()
+	c := connection.NewFakeConn()
+
+	r1 := execAsync(e, c1, "blpop", "dest", "0")
+	waitBlocked(t, e, 1)
+	r2 := execAsync(e, c2, "blmove", "src", "dest", "left", "right", "0")
+	waitBlocked(t, e, 2)
+	// the element moved to dest serves the client blocked on dest
+	execCmd(e, c, "lpush", "src", "x")
+	assertReply(t, receive(t, r2, time.Second), "$1\r\nx\r\n")
+	assertReply(t, receive(t, r1, time.Second), "*2\r\n$4\r\ndest\r\n$1\r\nx\r\n")
+	assertReply(t, execCmd(e, c, "exists", "src", "dest"), ":0\r\n")
+
+	// a transaction wakes the clients once it is executed
+	r1 = execAsync(e, c1, "brpoplpush", "src", "dest", "0")
+	waitBlocked(t, e, 1)
+	execCmd(e, c, "multi")
+	execCmd(e, c, "rpush", "src", "y")
+	execCmd(e, c, "set", "s", "1")
+	execCmd(e, c, "exec")
+	assertReply(t, receive(t, r1, time.Second), "$1\r\ny\r\n")
+	assertReply(t, execCmd(e, c, "lrange", "dest", "0", "-1"), "*1\r\n$1\r\ny\r\n")
+}
+
+func TestBlockingTimeoutAndDisconnect(t *testing.T) {
+	e := NewEngine()
+	c1 := connection.NewFakeConn()
+	c2 := connection.NewFakeConn()
+	c := connection.NewFakeConn()
+
+	start := time.Now()
+	r1 := execAsync(e, c1, "blpop", "a", "0.1")
+	r2 := execAsync(e, c2, "blpop", "a", "0")
+	waitBlocked(t, e, 2)
+	assertReply(t, receive(t, r1, time.Second), "*-1\r\n")
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 900*time.Millisecond {
+		t.Fatalf("timeout after %s", elapsed)
+	}
+	waitBlocked(t, e, 1)
+
+	_ = c2.Close()
+	receive(t, r2, time.Second)
+	waitBlocked(t, e, 0)
+	// nothing is popped for a gone client
+	execCmd(e, c, "rpush", "a", "x")
+	assertReply(t, execCmd(e, c, "llen", "a"), ":1\r\n")
+}
+
+func TestBlockingConcurrent(t *testing.T) {
+	e := NewEngine()
+	const clients, perClient = 4, 50
+	results := make(chan protocol.Reply, clients*perClient)
+	for i := 0; i < clients; i++ {
+		c := connection.NewFakeConn()
+		go func() {
+			for j := 0; j < perClient; j++ {
+				results <- execCmd(e, c, "blpop", "a", "b", "0")
+			}
+		}()
+	}
+	pushers := []*connection.FakeConn{connection.NewFakeConn(), connection.NewFakeConn()}
+	for i, c := range pushers {
+		key := []string{"a", "b"}[i]
+		go func() {
+			for j := 0; j < clients*perClient/2; j++ {
+				execCmd(e, c, "rpush", key, strconv.Itoa(j))
+			}
+		}()
+	}
+	for i := 0; i < clients*perClient; i++ {
+		reply := receive(t, results, 5*time.Second)
+		if protocol.IsErrorReply(reply) {
+			t.Fatalf("got %q", reply.ToBytes())
+		}
+	}
+	waitBlocked(t, e, 0)
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "exists", "a", "b"), ":0\r\n")
+}
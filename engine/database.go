@@ -3,14 +3,20 @@ package engine
 import (
 	"github.com/issimo1/redis-issimo/abs"
 	"github.com/issimo1/redis-issimo/datastruct/dict"
+	"github.com/issimo1/redis-issimo/datastruct/hash"
+	"github.com/issimo1/redis-issimo/engine/payload"
 	"github.com/issimo1/redis-issimo/redis/protocol"
 	"github.com/issimo1/redis-issimo/utils/timewheel"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	dataDictSize = 1 << 16
 	ttlDictSize  = 1 << 10
+	// auxDictSize is the shard count of the dbs of an auxiliary engine, which is used by a single goroutine
+	auxDictSize = 1 << 4
 )
 
 type DB struct {
@@ -18,26 +24,33 @@ type DB struct {
 	dataDict   *dict.ConcurrentDict
 	ttlDict    *dict.ConcurrentDict
 	versionMap *dict.ConcurrentDict
+	// writeAof receives the commands propagated by writes, they are appended to the AOF file if appendOnly is set
 	writeAof   func(redisCommand [][]byte)
 	delay      *timewheel.Delay
+	blockDelay *timewheel.Delay
+	blocking   *blockingQueues
 }
 
-func newDB(delay *timewheel.Delay) *DB {
+func newDB(delay, blockDelay *timewheel.Delay) *DB {
 	return &DB{
 		dataDict:   dict.NewConcurrent(dataDictSize),
 		ttlDict:    dict.NewConcurrent(ttlDictSize),
 		versionMap: dict.NewConcurrent(dataDictSize),
 		writeAof:   func(redisCommand [][]byte) {},
 		delay:      delay,
+		blockDelay: blockDelay,
+		blocking:   newBlockingQueues(),
 	}
 }
 
-func newBasicDB(delay *timewheel.Delay) *DB {
+func newBasicDB(delay, blockDelay *timewheel.Delay) *DB {
 	return &DB{
-		dataDict: dict.NewConcurrent(dataDictSize),
-		ttlDict:  dict.NewConcurrent(ttlDictSize),
-		writeAof: func(redisCommand [][]byte) {},
-		delay:    delay,
+		dataDict:   dict.NewConcurrent(auxDictSize),
+		ttlDict:    dict.NewConcurrent(auxDictSize),
+		writeAof:   func(redisCommand [][]byte) {},
+		delay:      delay,
+		blockDelay: blockDelay,
+		blocking:   newBlockingQueues(),
 	}
 }
 
@@ -48,27 +61,27 @@ func (db *DB) setIndex(idx int) {
 func (db *DB) Exec(c abs.Connection, redisCommand [][]byte) protocol.Reply {
 	command := strings.ToLower(string(redisCommand[0]))
 	if command == "multi" {
-		if len(redisCommand) != 1 {
-			// todo protocol reply
+		if len(redisCommand) > 2 {
+			return protocol.MakeArgNumErrReply(command)
 		}
-		return nil
+		return StartMulti(c, redisCommand[1:])
 	} else if command == "discard" {
 		if len(redisCommand) != 1 {
-			// todo
+			return protocol.MakeArgNumErrReply(command)
 		}
-		return nil
+		return DiscardMulti(c)
 	} else if command == "watch" {
-
-	} else if command == "unwatch" {
-		if len(redisCommand) != 1 {
-
+		if len(redisCommand) < 2 {
+			return protocol.MakeArgNumErrReply(command)
 		}
-	} else if command == "exec" {
-
+		return Watch(db, c, redisCommand[1:])
 	}
 
 	if c != nil && c.IsTransaction() {
-		return nil
+		return EnqueueCmd(c, redisCommand)
+	}
+	if bc, ok := blockingCommands[command]; ok && c != nil {
+		return db.execBlocking(c, bc, redisCommand)
 	}
 	return db.generalCommand(c, redisCommand)
 }
@@ -78,39 +91,71 @@ func validateArity(arity int, cmd [][]byte) bool {
 	if arity > 0 {
 		return arity == argNum
 	}
-	return -arity == argNum
+	return argNum >= -arity
 }
 
 func (db *DB) generalCommand(c abs.Connection, redisCommand [][]byte) protocol.Reply {
 	cmd := strings.ToLower(string(redisCommand[0]))
 	cmdFunc, ok := commandCenter[cmd]
 	if !ok {
-		// unknown command
+		return protocol.MakeUnknownCommandErrReply(cmd, redisCommand[1:])
 	}
 	if !validateArity(cmdFunc.argsNum, redisCommand) {
-		// params doesnt match
+		return protocol.MakeArgNumErrReply(cmd)
 	}
 	keyFunc := cmdFunc.keyFunc
 	readKeys, writeKeys := keyFunc(redisCommand[1:])
+	// the clients blocked on the written keys are served after the keys are unlocked
+	defer db.serveBlocked(writeKeys...)
+	if cmdFunc.lockMode == lockKeys {
+		db.RWLock(readKeys, writeKeys)
+		defer db.RWUnLock(readKeys, writeKeys)
+	} else {
+		write := cmdFunc.lockMode == lockAllWrite
+		db.dataDict.LockAll(write)
+		defer db.dataDict.UnLockAll(write)
+	}
 	db.addVersion(writeKeys...)
 	fun := cmdFunc.execFunc
 	return fun(db, redisCommand[1:])
 }
 
 func (db *DB) addVersion(keys ...string) {
+	if db.versionMap == nil {
+		// the dbs of an auxiliary engine are never watched
+		return
+	}
 	for _, key := range keys {
 		db.versionMap.AddVersion(key, 1)
 	}
 }
 
+// GetUndoLogs returns the commands which restore the keys written by cmdLine
+func (db *DB) GetUndoLogs(cmdLine [][]byte) [][][]byte {
+	cmd, ok := commandCenter[strings.ToLower(string(cmdLine[0]))]
+	if !ok || cmd.undoFunc == nil {
+		return nil
+	}
+	return cmd.undoFunc(db, cmdLine[1:])
+}
+
+// GetVersion returns the version of key, it changes on every write
+func (db *DB) GetVersion(key string) int64 {
+	raw, ok := db.versionMap.Get(key)
+	if !ok {
+		return 0
+	}
+	return raw.(int64)
+}
+
 func (db *DB) execWithLock(cmd [][]byte) protocol.Reply {
 	cmds := strings.ToLower(string(cmd[0]))
 	cmdFunc, ok := commandCenter[cmds]
 	if !ok {
-		// unknown command
+		return protocol.MakeUnknownCommandErrReply(cmds, cmd[1:])
 	}
 	if !validateArity(cmdFunc.argsNum, cmd) {
-		//
+		return protocol.MakeArgNumErrReply(cmds)
 	}
 	fun := cmdFunc.execFunc
 	return fun(db, cmd[1:])
@@ -124,6 +169,137 @@ func (db *DB) RWUnLock(readKeys, writeKeys []string) {
 	db.dataDict.RWUnLock(readKeys, writeKeys)
 }
 
-func genExpireKey(key string) string {
-	return "expire:" + key
+/* ---- data access, keys must be locked by caller ---- */
+
+// GetEntity returns the entity bound to key, an expired key is treated as absent.
+// Readers only hold a read lock, so the expired key is left to the time wheel or the next writer to remove.
+func (db *DB) GetEntity(key string) (*payload.DataEntity, bool) {
+	raw, ok := db.dataDict.GetWithoutLock(key)
+	if !ok {
+		return nil, false
+	}
+	entity, _ := raw.(*payload.DataEntity)
+	if db.isTTLExpired(key) || allFieldsExpired(entity) {
+		return nil, false
+	}
+	return entity, true
+}
+
+// PutEntity binds entity to key, returns 1 if key is new
+func (db *DB) PutEntity(key string, entity *payload.DataEntity) int {
+	db.expireIfNeeded(key)
+	result := db.dataDict.PutWithoutLock(key, entity)
+	if h, ok := entity.RedisObject.(hash.Hash); ok {
+		// a hash moved from another key keeps the ttl of its fields
+		db.scheduleFieldExpire(key, h)
+	}
+	return result
+}
+
+// PutIfExists updates the entity of an existing key
+func (db *DB) PutIfExists(key string, entity *payload.DataEntity) int {
+	db.expireIfNeeded(key)
+	return db.dataDict.PutIfPresentWithoutLock(key, entity)
+}
+
+// PutIfAbsent binds entity to key only if key does not exist
+func (db *DB) PutIfAbsent(key string, entity *payload.DataEntity) int {
+	db.expireIfNeeded(key)
+	return db.dataDict.PutIfAbsentWithoutLock(key, entity)
+}
+
+// Remove deletes key with its ttl
+func (db *DB) Remove(key string) {
+	db.dataDict.DeleteWithoutLock(key)
+	db.Persist(key)
+}
+
+// Removes deletes keys and returns the number of deleted keys
+func (db *DB) Removes(keys ...string) int {
+	deleted := 0
+	for _, key := range keys {
+		if db.expireIfNeeded(key) {
+			continue
+		}
+		if _, exists := db.dataDict.GetWithoutLock(key); exists {
+			db.Remove(key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// Flush removes every key, all shards must be locked by caller
+func (db *DB) Flush() {
+	db.dataDict.ForEachWithoutLock(func(key string, val interface{}) bool {
+		db.addVersion(key)
+		return true
+	})
+	db.dataDict.ClearWithoutLock()
+	for _, key := range db.ttlDict.Keys() {
+		db.Persist(key)
+	}
+}
+
+/* ---- ttl, keys must be locked by caller ---- */
+
+// Expire sets the expire time of key and schedules its active deletion
+func (db *DB) Expire(key string, expireTime time.Time) {
+	db.ttlDict.Put(key, expireTime)
+	db.delay.AddAt(expireTime, genExpireKey(db.index, key), func() {
+		keys := []string{key}
+		db.RWLock(nil, keys)
+		defer db.RWUnLock(nil, keys)
+		// the ttl may be changed while waiting for the lock, so check it again
+		db.expireIfNeeded(key)
+	})
+}
+
+// Persist removes the ttl of key and cancels its active deletion, returns false if key has no ttl
+func (db *DB) Persist(key string) bool {
+	if _, deleted := db.ttlDict.Delete(key); deleted == 0 {
+		return false
+	}
+	db.delay.Cancel(genExpireKey(db.index, key))
+	return true
+}
+
+// GetExpireTime returns the expire time of key, ok is false if key has no ttl
+func (db *DB) GetExpireTime(key string) (expireTime time.Time, ok bool) {
+	raw, ok := db.ttlDict.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	return raw.(time.Time), true
+}
+
+// isTTLExpired returns true if key has passed its expire time
+func (db *DB) isTTLExpired(key string) bool {
+	expireTime, ok := db.GetExpireTime(key)
+	return ok && !time.Now().Before(expireTime)
+}
+
+// IsExpired returns true if key has passed its expire time, or key holds a hash whose fields have all expired
+func (db *DB) IsExpired(key string) bool {
+	if db.isTTLExpired(key) {
+		return true
+	}
+	raw, ok := db.dataDict.GetWithoutLock(key)
+	return ok && allFieldsExpired(raw.(*payload.DataEntity))
+}
+
+// expireIfNeeded removes key if it is expired and propagates the deletion as DEL,
+// key must be write locked by caller.
+func (db *DB) expireIfNeeded(key string) bool {
+	if !db.IsExpired(key) {
+		return false
+	}
+	db.Remove(key)
+	db.addVersion(key)
+	db.writeAof(toCmdLine("del", key))
+	return true
+}
+
+func genExpireKey(dbIdx int, key string) string {
+	return "expire:" + strconv.Itoa(dbIdx) + ":" + key
 }
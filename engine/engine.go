@@ -1,12 +1,15 @@
 package engine
 
 import (
+	"fmt"
 	"github.com/issimo1/redis-issimo/abs"
 	"github.com/issimo1/redis-issimo/engine/payload"
 	"github.com/issimo1/redis-issimo/redis/protocol"
 	"github.com/issimo1/redis-issimo/utils/config"
 	"github.com/issimo1/redis-issimo/utils/logger"
 	"github.com/issimo1/redis-issimo/utils/timewheel"
+	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -15,14 +18,22 @@ import (
 type Engine struct {
 	dbSet []*atomic.Value
 	delay *timewheel.Delay
+	// blockDelay times the blocking commands out, it ticks finer than delay
+	blockDelay *timewheel.Delay
+	hub        *Hub
+	// persister is nil unless appendOnly is set
+	persister *persister
 }
 
 func NewEngine() *Engine {
 	engine := &Engine{}
+	engine.hub = newHub()
+	engine.delay = timewheel.NewDelay()
+	engine.blockDelay = newBlockDelay()
 
 	engine.dbSet = make([]*atomic.Value, config.GlobalConfig.DBCount)
 	for idx := 0; idx < config.GlobalConfig.DBCount; idx++ {
-		db := newDB(engine.delay)
+		db := newDB(engine.delay, engine.blockDelay)
 		db.setIndex(idx)
 		dbSet := &atomic.Value{}
 		dbSet.Store(db)
@@ -30,70 +41,227 @@ func NewEngine() *Engine {
 	}
 
 	if config.GlobalConfig.AppendOnly {
-
-	}
-	return engine
-}
-
-func (e *Engine) aofBindEveryDB() {
-	for _, dbset := range e.dbSet {
-		db := dbset.Load().(*DB)
-		db.writeAof = func(redisCommand [][]byte) {
-			if config.GlobalConfig.AppendOnly {
-
-			}
+		engine.loadAof(aofFilename(), -1)
+		persister, err := newPersister(aofFilename(), config.GlobalConfig.AppendFsync)
+		if err != nil {
+			panic(err)
 		}
+		engine.persister = persister
+		engine.aofBindEveryDB()
 	}
+	return engine
 }
 
-func (e *Engine) selectDB(idx int) (*DB, protocol.Reply) {
+func (e *Engine) selectDB(idx int) (*DB, protocol.ErrorReply) {
 	if idx < 0 || idx >= len(e.dbSet) {
-		return nil, nil
+		return nil, protocol.MakeErrReply("ERR DB index is out of range")
 	}
 	return e.dbSet[idx].Load().(*DB), nil
 }
 
-func (e *Engine) Exec(c abs.Connection, redisCommand [][]byte) protocol.Reply {
+func (e *Engine) Exec(c abs.Connection, redisCommand [][]byte) (result protocol.Reply) {
 	defer func() {
 		if err := recover(); err != nil {
-
+			logger.Error(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
+			result = &protocol.UnknownErrReply{}
 		}
 	}()
 	cmd := strings.ToLower(string(redisCommand[0]))
-	if cmd == "ping" {
-		return Ping(redisCommand[1:])
-	}
-	if cmd == "auth" {
-		return Auth(c, redisCommand[1:])
+	inTx := c != nil && c.IsTransaction()
+	if _, ok := noAuthCommands[cmd]; ok && !inTx {
+		return e.execEngineCommand(c, redisCommand)
 	}
 	if !checkPassword(c) {
-		// err
+		return protocol.MakeNoAuthErrReply()
+	}
+	if errReply := checkSubscribeContext(c, cmd); errReply != nil {
+		return errReply
+	}
+	if inTx {
+		switch cmd {
+		case "multi", "exec", "discard", "watch":
+		case "select":
+			return protocol.MakeErrReply("ERR cannot select database within multi")
+		default:
+			return EnqueueCmd(c, redisCommand)
+		}
 	}
 
+	if _, ok := engineCommands[cmd]; ok {
+		return e.execEngineCommand(c, redisCommand)
+	}
 	switch cmd {
 	case "select":
-		if c != nil && c.IsTransaction() {
-			// can not select db
+		return e.execSelect(c, redisCommand[1:])
+	case "copy":
+		return e.execCopy(c, redisCommand)
+	case "exec":
+		if len(redisCommand) != 1 {
+			return protocol.MakeArgNumErrReply(cmd)
 		}
-		return nil
-	case "bgrewriteaof":
-		if !config.GlobalConfig.AppendOnly {
-			// appendonly false
-		}
-	case "subscribe":
-	case "unsubscribe":
-	case "publish":
+		return e.execMulti(c)
 	}
 	dbIdx := c.GetDBIdx()
 	logger.Debugf("db index:%d", dbIdx)
-	db, err := e.selectDB(dbIdx)
-	if err != nil {
+	db, errReply := e.selectDB(dbIdx)
+	if errReply != nil {
+		return errReply
 	}
 	return db.Exec(c, redisCommand)
 }
 
+// engineCommand is served by Engine rather than a db, as it needs the connection or every db.
+// It is queued in MULTI like the commands of dbs and executed by EXEC.
+type engineCommand struct {
+	arity int
+	exec  func(e *Engine, c abs.Connection, args [][]byte) protocol.Reply
+}
+
+var engineCommands map[string]*engineCommand
+
+// noAuthCommands are served before the password is checked
+var noAuthCommands = map[string]struct{}{
+	"ping":  {},
+	"auth":  {},
+	"hello": {},
+}
+
+func init() {
+	engineCommands = map[string]*engineCommand{
+		"ping": {-1, func(e *Engine, c abs.Connection, args [][]byte) protocol.Reply {
+			return Ping(args)
+		}},
+		"auth": {2, func(e *Engine, c abs.Connection, args [][]byte) protocol.Reply {
+			return Auth(c, args)
+		}},
+		"hello": {-1, func(e *Engine, c abs.Connection, args [][]byte) protocol.Reply {
+			return Hello(c, args)
+		}},
+		"unwatch": {1, func(e *Engine, c abs.Connection, args [][]byte) protocol.Reply {
+			return UnWatch(c)
+		}},
+		"bgrewriteaof": {1, func(e *Engine, c abs.Connection, args [][]byte) protocol.Reply {
+			if e.persister == nil {
+				return protocol.MakeErrReply("ERR AppendOnly is false, you can't rewrite aof file")
+			}
+			return e.persister.bgRewrite()
+		}},
+		"flushall": {-1, func(e *Engine, c abs.Connection, args [][]byte) protocol.Reply {
+			return e.flushAll(args, false)
+		}},
+		"subscribe": {-2, func(e *Engine, c abs.Connection, args [][]byte) protocol.Reply {
+			return e.hub.Subscribe(c, args)
+		}},
+		"unsubscribe": {-1, func(e *Engine, c abs.Connection, args [][]byte) protocol.Reply {
+			return e.hub.UnSubscribe(c, args)
+		}},
+		"publish": {3, func(e *Engine, c abs.Connection, args [][]byte) protocol.Reply {
+			return e.hub.Publish(args)
+		}},
+	}
+}
+
+func (e *Engine) execEngineCommand(c abs.Connection, cmdLine [][]byte) protocol.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd := engineCommands[cmdName]
+	if !validateArity(cmd.arity, cmdLine) {
+		return protocol.MakeArgNumErrReply(cmdName)
+	}
+	return cmd.exec(e, c, cmdLine[1:])
+}
+
+func (e *Engine) execSelect(c abs.Connection, args [][]byte) protocol.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("select")
+	}
+	dbIdx, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return protocol.MakeErrReply("ERR invalid DB index")
+	}
+	if _, errReply := e.selectDB(dbIdx); errReply != nil {
+		return errReply
+	}
+	c.SetDBIdx(dbIdx)
+	return protocol.MakeOkReply()
+}
+
+// flushAll removes the keys of every db, FLUSHALL [ASYNC|SYNC].
+// If locked is set, every db is locked by caller, as EXEC does.
+func (e *Engine) flushAll(args [][]byte, locked bool) protocol.Reply {
+	cmdLine := toCmdLine3("flushdb", args)
+	for _, holder := range e.dbSet {
+		db := holder.Load().(*DB)
+		var reply protocol.Reply
+		if locked {
+			reply = db.execWithLock(cmdLine)
+		} else {
+			reply = db.generalCommand(nil, cmdLine)
+		}
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+	}
+	return protocol.MakeOkReply()
+}
+
+// execCopy copies a key to another db, COPY source destination [DB destination-db] [REPLACE]
+func (e *Engine) execCopy(c abs.Connection, cmdLine [][]byte) protocol.Reply {
+	if len(cmdLine) < 3 {
+		return protocol.MakeArgNumErrReply("copy")
+	}
+	srcDB, errReply := e.selectDB(c.GetDBIdx())
+	if errReply != nil {
+		return errReply
+	}
+	copyArgs, destDB, errReply := e.crossDBCopy(srcDB, cmdLine[1:])
+	if errReply != nil {
+		return errReply
+	}
+	if destDB == nil {
+		return srcDB.Exec(c, cmdLine)
+	}
+	defer destDB.serveBlocked(copyArgs.dest)
+	// lock dbs in the order of index to avoid dead lock with a reversed copy
+	if srcDB.index < destDB.index {
+		srcDB.RWLock([]string{copyArgs.src}, nil)
+		destDB.RWLock(nil, []string{copyArgs.dest})
+	} else {
+		destDB.RWLock(nil, []string{copyArgs.dest})
+		srcDB.RWLock([]string{copyArgs.src}, nil)
+	}
+	defer srcDB.RWUnLock([]string{copyArgs.src}, nil)
+	defer destDB.RWUnLock(nil, []string{copyArgs.dest})
+	destDB.addVersion(copyArgs.dest)
+	return copyKey(srcDB, destDB, copyArgs.src, copyArgs.dest, copyArgs.replace)
+}
+
+// crossDBCopy parses the args of COPY sent to db, destDB is nil if the key is copied inside db
+func (e *Engine) crossDBCopy(db *DB, args [][]byte) (*copyArgs, *DB, protocol.ErrorReply) {
+	copyArgs, errReply := parseCopyArgs(args)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if !copyArgs.hasDB || copyArgs.dbIdx == db.index {
+		return copyArgs, nil, nil
+	}
+	destDB, errReply := e.selectDB(copyArgs.dbIdx)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	return copyArgs, destDB, nil
+}
+
 func (e *Engine) Close() {
+	e.delay.Stop()
+	e.blockDelay.Stop()
+	if e.persister != nil {
+		e.persister.close()
+	}
+}
 
+// AfterClientClose releases the resources held by a closed client
+func (e *Engine) AfterClientClose(c abs.Connection) {
+	e.hub.UnSubscribeAll(c)
 }
 
 func (e *Engine) RWLocks(dbIdx int, readKeys, writeKeys []string) {
@@ -115,8 +283,14 @@ func (e *Engine) RWUnLocks(dbIdx int, readKeys, writeKeys []string) {
 	db.RWUnLock(readKeys, writeKeys)
 }
 
-func (e *Engine) GetUndoLogs(dbIdx int, redisCommand [][]byte) {
-
+// GetUndoLogs returns the commands which undo redisCommand, it must be called before redisCommand is executed
+func (e *Engine) GetUndoLogs(dbIdx int, redisCommand [][]byte) [][][]byte {
+	db, err := e.selectDB(dbIdx)
+	if err != nil {
+		logger.Error("GetUndoLogs err:", err)
+		return nil
+	}
+	return db.GetUndoLogs(redisCommand)
 }
 
 func (e *Engine) ExecWithLock(dbIdx int, redisCommand [][]byte) protocol.Reply {
@@ -149,11 +323,13 @@ func (e *Engine) ForEach(dbIdx int, callBack func(key string, data *payload.Data
 
 func newAuxiliaryEngine() *Engine {
 	engine := &Engine{}
+	engine.hub = newHub()
 	engine.delay = timewheel.NewDelay()
+	engine.blockDelay = newBlockDelay()
 	engine.dbSet = make([]*atomic.Value, config.GlobalConfig.DBCount)
 	for i := range engine.dbSet {
 
-		db := newBasicDB(engine.delay)
+		db := newBasicDB(engine.delay, engine.blockDelay)
 		db.setIndex(i)
 
 		holder := &atomic.Value{}
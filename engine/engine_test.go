@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/issimo1/redis-issimo/abs"
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/config"
+)
+
+func TestMain(m *testing.M) {
+	// the defaults are those of a server started without a config file
+	config.GlobalConfig = config.NewDefaultConfig()
+	os.Exit(m.Run())
+}
+
+func toArgs(cmd ...string) [][]byte {
+	args := make([][]byte, len(cmd))
+	for i, s := range cmd {
+		args[i] = []byte(s)
+	}
+	return args
+}
+
+func execCmd(e *Engine, c abs.Connection, cmd ...string) protocol.Reply {
+	return e.Exec(c, toArgs(cmd...))
+}
+
+func assertReply(t *testing.T, reply protocol.Reply, want string) {
+	t.Helper()
+	if got := string(protocol.Encode(reply, protocol.RESP2)); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func assertReply3(t *testing.T, reply protocol.Reply, want string) {
+	t.Helper()
+	if got := string(protocol.Encode(reply, protocol.RESP3)); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPingAndSelect(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "PING"), "+PONG\r\n")
+	assertReply(t, execCmd(e, c, "ping", "hi"), "$2\r\nhi\r\n")
+	assertReply(t, execCmd(e, c, "select", "3"), "+OK\r\n")
+	if c.GetDBIdx() != 3 {
+		t.Errorf("db index is %d, want 3", c.GetDBIdx())
+	}
+	assertReply(t, execCmd(e, c, "select", "16"), "-ERR DB index is out of range\r\n")
+	assertReply(t, execCmd(e, c, "select", "a"), "-ERR invalid DB index\r\n")
+	assertReply(t, execCmd(e, c, "nope", "a"), "-ERR unknown command 'nope', with args beginning with: 'a' \r\n")
+}
+
+func TestAuth(t *testing.T) {
+	config.GlobalConfig.RequiredPwd = "secret"
+	defer func() { config.GlobalConfig.RequiredPwd = "" }()
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "select", "1"), "-NOAUTH Authentication required.\r\n")
+	assertReply(t, execCmd(e, c, "auth", "wrong"), "-ERR invalid password\r\n")
+	assertReply(t, execCmd(e, c, "auth", "secret"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "select", "1"), "+OK\r\n")
+}
+
+func TestHello(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "hello", "4"), "-NOPROTO unsupported protocol version\r\n")
+	assertReply(t, execCmd(e, c, "hello", "x"), "-ERR Protocol version is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "hello", "3", "foo"), "-ERR Syntax error in HELLO option 'foo'\r\n")
+
+	reply := execCmd(e, c, "hello", "3", "setname", "worker")
+	if c.GetProtocol() != protocol.RESP3 || c.GetName() != "worker" {
+		t.Fatalf("protocol %d name %q", c.GetProtocol(), c.GetName())
+	}
+	m, ok := reply.(*protocol.MapReply)
+	if !ok || len(m.Keys) != 7 {
+		t.Fatalf("unexpected hello reply %q", protocol.Encode(reply, protocol.RESP3))
+	}
+	encoded := string(protocol.Encode(reply, protocol.RESP3))
+	if encoded[:3] != "%7\r" {
+		t.Errorf("hello reply should be a map in RESP3, got %q", encoded)
+	}
+	execCmd(e, c, "hello", "2")
+	if c.GetProtocol() != protocol.RESP2 {
+		t.Errorf("protocol should be switched back to 2")
+	}
+}
+
+func TestHelloAuth(t *testing.T) {
+	config.GlobalConfig.RequiredPwd = "secret"
+	defer func() { config.GlobalConfig.RequiredPwd = "" }()
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	reply := execCmd(e, c, "hello", "3")
+	if !protocol.IsErrorReply(reply) {
+		t.Fatal("hello without auth should fail")
+	}
+	assertReply(t, execCmd(e, c, "hello", "3", "auth", "default", "wrong"),
+		"-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+	reply = execCmd(e, c, "hello", "3", "auth", "default", "secret")
+	if protocol.IsErrorReply(reply) || c.GetProtocol() != protocol.RESP3 {
+		t.Fatalf("hello with auth failed: %q", reply.ToBytes())
+	}
+	assertReply(t, execCmd(e, c, "select", "1"), "+OK\r\n")
+}
+
+func TestPubSub(t *testing.T) {
+	e := NewEngine()
+	sub2 := connection.NewFakeConn()
+	sub3 := connection.NewFakeConn()
+	sub3.SetProtocol(protocol.RESP3)
+	pub := connection.NewFakeConn()
+
+	execCmd(e, sub2, "subscribe", "news", "sports")
+	if got := string(sub2.Bytes()); got != "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n"+
+		"*3\r\n$9\r\nsubscribe\r\n$6\r\nsports\r\n:2\r\n" {
+		t.Errorf("unexpected subscribe reply %q", got)
+	}
+	execCmd(e, sub3, "subscribe", "news")
+	if got := string(sub3.Bytes()); got != ">3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n" {
+		t.Errorf("unexpected subscribe reply %q", got)
+	}
+
+	assertReply(t, execCmd(e, sub2, "select", "1"),
+		"-ERR Can't execute 'select': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n")
+
+	assertReply(t, execCmd(e, pub, "publish", "news", "hi"), ":2\r\n")
+	if got := string(sub2.Bytes()); got != "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$2\r\nhi\r\n" {
+		t.Errorf("unexpected RESP2 message %q", got)
+	}
+	if got := string(sub3.Bytes()); got != ">3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$2\r\nhi\r\n" {
+		t.Errorf("unexpected RESP3 message %q", got)
+	}
+
+	execCmd(e, sub2, "unsubscribe")
+	if sub2.SubscribeCount() != 0 {
+		t.Errorf("sub2 should have no subscription")
+	}
+	sub2.Bytes()
+	e.AfterClientClose(sub3)
+	assertReply(t, execCmd(e, pub, "publish", "news", "hi"), ":0\r\n")
+	execCmd(e, sub2, "unsubscribe")
+	if got := string(sub2.Bytes()); got != "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n" {
+		t.Errorf("unexpected unsubscribe reply %q", got)
+	}
+}
+
+// slowConn blocks its writes until release is closed, writing receives a value once a write is blocked
+type slowConn struct {
+	*connection.FakeConn
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (c *slowConn) Write(b []byte) (int, error) {
+	c.writing <- struct{}{}
+	<-c.release
+	return c.FakeConn.Write(b)
+}
+
+func TestPublishToSlowSubscriber(t *testing.T) {
+	e := NewEngine()
+	slow := &slowConn{FakeConn: connection.NewFakeConn(), writing: make(chan struct{}, 1), release: make(chan struct{})}
+	close(slow.release)
+	execCmd(e, slow, "subscribe", "news")
+	<-slow.writing
+	slow.release = make(chan struct{})
+
+	published := make(chan protocol.Reply)
+	go func() {
+		published <- execCmd(e, connection.NewFakeConn(), "publish", "news", "hi")
+	}()
+	<-slow.writing
+	// the hub must not be locked while the message is written to the slow subscriber
+	subscribed := make(chan struct{})
+	go func() {
+		execCmd(e, connection.NewFakeConn(), "subscribe", "news")
+		close(subscribed)
+	}()
+	select {
+	case <-subscribed:
+	case <-time.After(time.Second):
+		t.Fatal("subscribe is blocked by a slow subscriber")
+	}
+	close(slow.release)
+	assertReply(t, <-published, ":1\r\n")
+}
@@ -0,0 +1,536 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/issimo1/redis-issimo/datastruct/geohash"
+	"github.com/issimo1/redis-issimo/datastruct/sortedset"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+// The geo commands store points in sorted sets, the score of a member is the 52 bits geohash of its point
+
+func parseLongLat(lonArg, latArg []byte) (float64, float64, protocol.ErrorReply) {
+	longitude, err1 := strconv.ParseFloat(string(lonArg), 64)
+	latitude, err2 := strconv.ParseFloat(string(latArg), 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, protocol.MakeErrReply("ERR value is not a valid float")
+	}
+	if longitude < geohash.LongitudeMin || longitude > geohash.LongitudeMax ||
+		latitude < geohash.LatitudeMin || latitude > geohash.LatitudeMax {
+		return 0, 0, protocol.MakeErrReply(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude))
+	}
+	return longitude, latitude, nil
+}
+
+// parseUnit returns the meters of a unit
+func parseUnit(arg []byte) (float64, protocol.ErrorReply) {
+	switch strings.ToLower(string(arg)) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, protocol.MakeErrReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+}
+
+// parseRadius parses radius unit, the radius is returned in the unit
+func parseRadius(args [][]byte) (float64, float64, protocol.ErrorReply) {
+	radius, err := strconv.ParseFloat(string(args[0]), 64)
+	if err != nil {
+		return 0, 0, protocol.MakeErrReply("ERR need numeric radius")
+	}
+	if radius < 0 {
+		return 0, 0, protocol.MakeErrReply("ERR radius cannot be negative")
+	}
+	unit, errReply := parseUnit(args[1])
+	if errReply != nil {
+		return 0, 0, errReply
+	}
+	return radius, unit, nil
+}
+
+// parseBox parses width height unit, the sizes are returned in the unit
+func parseBox(args [][]byte) (float64, float64, float64, protocol.ErrorReply) {
+	width, err := strconv.ParseFloat(string(args[0]), 64)
+	if err != nil {
+		return 0, 0, 0, protocol.MakeErrReply("ERR need numeric width")
+	}
+	height, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil {
+		return 0, 0, 0, protocol.MakeErrReply("ERR need numeric height")
+	}
+	if width < 0 || height < 0 {
+		return 0, 0, 0, protocol.MakeErrReply("ERR height or width cannot be negative")
+	}
+	unit, errReply := parseUnit(args[2])
+	if errReply != nil {
+		return 0, 0, 0, errReply
+	}
+	return width, height, unit, nil
+}
+
+// scoreToHash converts a score to a geohash, scores which are not geohashes are clamped into the valid range
+func scoreToHash(score float64) uint64 {
+	return uint64(min(max(score, 0), 1<<52-1))
+}
+
+// memberPosition returns the point of member, ok is false if member does not exist
+func memberPosition(zs *sortedset.SortedSet, member string) (float64, float64, bool) {
+	element, ok := zs.Get(member)
+	if !ok {
+		return 0, 0, false
+	}
+	longitude, latitude := geohash.Decode(scoreToHash(element.Score))
+	return longitude, latitude, true
+}
+
+func makeDistanceReply(distance float64) protocol.Reply {
+	return protocol.MakeBulkReply([]byte(strconv.FormatFloat(distance, 'f', 4, 64)))
+}
+
+func makePositionReply(longitude, latitude float64) protocol.Reply {
+	return protocol.MakeArrayReply([]protocol.Reply{
+		protocol.MakeHumanDoubleReply(longitude),
+		protocol.MakeHumanDoubleReply(latitude),
+	})
+}
+
+// execGeoAdd adds points as a ZADD, GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func execGeoAdd(db *DB, args [][]byte) protocol.Reply {
+	first := 1
+	nx, xx := false, false
+	for ; first < len(args); first++ {
+		switch strings.ToLower(string(args[first])) {
+		case "nx":
+			nx = true
+			continue
+		case "xx":
+			xx = true
+			continue
+		case "ch":
+			continue
+		}
+		break
+	}
+	if (len(args)-first)%3 != 0 || nx && xx {
+		return protocol.MakeSyntaxErrReply()
+	}
+	zaddArgs := make([][]byte, 0, first+(len(args)-first)/3*2)
+	zaddArgs = append(zaddArgs, args[:first]...)
+	for i := first; i < len(args); i += 3 {
+		longitude, latitude, errReply := parseLongLat(args[i], args[i+1])
+		if errReply != nil {
+			return errReply
+		}
+		bits, _ := geohash.Encode(longitude, latitude)
+		zaddArgs = append(zaddArgs, []byte(strconv.FormatUint(bits, 10)), args[i+2])
+	}
+	return execZAdd(db, zaddArgs)
+}
+
+// execGeoDist returns the distance between two members, GEODIST key member1 member2 [M|KM|FT|MI]
+func execGeoDist(db *DB, args [][]byte) protocol.Reply {
+	unit := 1.0
+	if len(args) == 4 {
+		var errReply protocol.ErrorReply
+		unit, errReply = parseUnit(args[3])
+		if errReply != nil {
+			return errReply
+		}
+	} else if len(args) > 4 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	zs, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	lon1, lat1, ok1 := memberPosition(zs, string(args[1]))
+	lon2, lat2, ok2 := memberPosition(zs, string(args[2]))
+	if !ok1 || !ok2 {
+		return protocol.MakeNullBulkReply()
+	}
+	return makeDistanceReply(geohash.Distance(lon1, lat1, lon2, lat2) / unit)
+}
+
+// execGeoHash returns the standard geohash strings of members, GEOHASH key [member ...]
+func execGeoHash(db *DB, args [][]byte) protocol.Reply {
+	zs, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	replies := make([]protocol.Reply, 0, len(args)-1)
+	for _, member := range args[1:] {
+		var element *sortedset.Element
+		ok := false
+		if zs != nil {
+			element, ok = zs.Get(string(member))
+		}
+		if !ok {
+			replies = append(replies, protocol.MakeNullBulkReply())
+			continue
+		}
+		replies = append(replies, protocol.MakeBulkReply([]byte(geohash.ToString(scoreToHash(element.Score)))))
+	}
+	return protocol.MakeArrayReply(replies)
+}
+
+// execGeoPos returns the longitude and the latitude of members, GEOPOS key [member ...]
+func execGeoPos(db *DB, args [][]byte) protocol.Reply {
+	zs, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	replies := make([]protocol.Reply, 0, len(args)-1)
+	for _, member := range args[1:] {
+		ok := false
+		var longitude, latitude float64
+		if zs != nil {
+			longitude, latitude, ok = memberPosition(zs, string(member))
+		}
+		if !ok {
+			replies = append(replies, protocol.MakeNullArrayReply())
+			continue
+		}
+		replies = append(replies, makePositionReply(longitude, latitude))
+	}
+	return protocol.MakeArrayReply(replies)
+}
+
+// flags of geoSearchGeneric, telling which command is executed
+const (
+	// searchRadius is GEORADIUS, the center is given by coordinates
+	searchRadius = 1 << iota
+	// searchRadiusByMember is GEORADIUSBYMEMBER, the center is given by a member
+	searchRadiusByMember
+	// searchNoStore forbids the STORE options of GEORADIUS, it is the read only variants
+	searchNoStore
+	// searchGeneric is GEOSEARCH, the shape is given by options
+	searchGeneric
+	// searchStore is GEOSEARCHSTORE
+	searchStore
+)
+
+// geoPoint is a member found by a search
+type geoPoint struct {
+	member    string
+	hash      uint64
+	distance  float64
+	longitude float64
+	latitude  float64
+}
+
+// geoSearchOptions are the parsed arguments of the search commands
+type geoSearchOptions struct {
+	shape     geohash.Shape
+	unit      float64
+	withDist  bool
+	withHash  bool
+	withCoord bool
+	// sort is 0 for unsorted, 1 for ASC and -1 for DESC
+	sort      int
+	any       bool
+	count     int64
+	storeKey  string
+	storeDist bool
+}
+
+// geoSearchStoreKey returns the destination of GEORADIUS and GEORADIUSBYMEMBER, first is the index of the options
+func geoSearchStoreKey(args [][]byte, first int) string {
+	storeKey := ""
+	for i := first; i+1 < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "count":
+			i++
+		case "store", "storedist":
+			storeKey = string(args[i+1])
+			i++
+		}
+	}
+	return storeKey
+}
+
+// parseGeoSearch parses the arguments of the search commands like redis does,
+// zs is used to find the center given by a member, it may be nil if the source does not exist
+func parseGeoSearch(cmd string, flags int, zs *sortedset.SortedSet, args [][]byte) (*geoSearchOptions, protocol.ErrorReply) {
+	opts := &geoSearchOptions{unit: 1}
+	var longitude, latitude, radius, width, height float64
+	var errReply protocol.ErrorReply
+	first := 0
+	memberNotFound := protocol.MakeErrReply("ERR could not decode requested zset member")
+	switch {
+	case flags&searchRadius != 0:
+		// key longitude latitude radius unit
+		first = 5
+		longitude, latitude, errReply = parseLongLat(args[1], args[2])
+		if errReply != nil {
+			return nil, errReply
+		}
+		if radius, opts.unit, errReply = parseRadius(args[3:5]); errReply != nil {
+			return nil, errReply
+		}
+	case flags&searchRadiusByMember != 0:
+		// key member radius unit
+		first = 4
+		if zs != nil {
+			var ok bool
+			longitude, latitude, ok = memberPosition(zs, string(args[1]))
+			if !ok {
+				return nil, memberNotFound
+			}
+			if radius, opts.unit, errReply = parseRadius(args[2:4]); errReply != nil {
+				return nil, errReply
+			}
+		}
+	case flags&searchStore != 0:
+		// destination source
+		first = 2
+		opts.storeKey = string(args[0])
+	default:
+		first = 1
+	}
+
+	fromMember, fromLonLat, byRadius, byBox := false, false, false, false
+	for i := first; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch arg := strings.ToLower(string(args[i])); {
+		case arg == "withdist":
+			opts.withDist = true
+		case arg == "withhash":
+			opts.withHash = true
+		case arg == "withcoord":
+			opts.withCoord = true
+		case arg == "any":
+			opts.any = true
+		case arg == "asc":
+			opts.sort = 1
+		case arg == "desc":
+			opts.sort = -1
+		case arg == "count" && remaining >= 1:
+			count, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, notIntegerErr()
+			}
+			if count <= 0 {
+				return nil, protocol.MakeErrReply("ERR COUNT must be > 0")
+			}
+			opts.count = count
+			i++
+		case (arg == "store" || arg == "storedist") && remaining >= 1 && flags&(searchNoStore|searchGeneric|searchStore) == 0:
+			opts.storeKey = string(args[i+1])
+			opts.storeDist = arg == "storedist"
+			i++
+		case arg == "storedist" && flags&searchStore != 0:
+			opts.storeDist = true
+		case arg == "frommember" && remaining >= 1 && flags&(searchGeneric|searchStore) != 0 && !fromLonLat:
+			// the error of a missing source is replied once the options are parsed
+			if zs != nil {
+				var ok bool
+				longitude, latitude, ok = memberPosition(zs, string(args[i+1]))
+				if !ok {
+					return nil, memberNotFound
+				}
+			}
+			fromMember = true
+			i++
+		case arg == "fromlonlat" && remaining >= 2 && flags&(searchGeneric|searchStore) != 0 && !fromMember:
+			if longitude, latitude, errReply = parseLongLat(args[i+1], args[i+2]); errReply != nil {
+				return nil, errReply
+			}
+			fromLonLat = true
+			i += 2
+		case arg == "byradius" && remaining >= 2 && flags&(searchGeneric|searchStore) != 0 && !byBox:
+			if radius, opts.unit, errReply = parseRadius(args[i+1 : i+3]); errReply != nil {
+				return nil, errReply
+			}
+			byRadius = true
+			i += 2
+		case arg == "bybox" && remaining >= 3 && flags&(searchGeneric|searchStore) != 0 && !byRadius:
+			if width, height, opts.unit, errReply = parseBox(args[i+1 : i+4]); errReply != nil {
+				return nil, errReply
+			}
+			byBox = true
+			i += 3
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+	}
+
+	if opts.storeKey != "" && (opts.withDist || opts.withHash || opts.withCoord) {
+		name := "STORE option in GEORADIUS"
+		if flags&searchStore != 0 {
+			name = "GEOSEARCHSTORE"
+		}
+		return nil, protocol.MakeErrReply("ERR " + name + " is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+	}
+	if flags&(searchGeneric|searchStore) != 0 {
+		if !fromMember && !fromLonLat {
+			return nil, protocol.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmd)
+		}
+		if !byRadius && !byBox {
+			return nil, protocol.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmd)
+		}
+	}
+	if opts.any && opts.count == 0 {
+		return nil, protocol.MakeErrReply("ERR the ANY argument requires COUNT argument")
+	}
+	// the closest points are returned by COUNT unless ANY is given
+	if opts.count > 0 && opts.sort == 0 && !opts.any {
+		opts.sort = 1
+	}
+	if byBox {
+		opts.shape = &geohash.Box{Longitude: longitude, Latitude: latitude, Width: width * opts.unit, Height: height * opts.unit}
+	} else {
+		opts.shape = &geohash.Circle{Longitude: longitude, Latitude: latitude, Radius: radius * opts.unit}
+	}
+	return opts, nil
+}
+
+// searchPoints returns the members within opts.shape, at most opts.count members are found if opts.any
+func searchPoints(zs *sortedset.SortedSet, opts *geoSearchOptions) []*geoPoint {
+	var points []*geoPoint
+	limit := int64(0)
+	if opts.any {
+		limit = opts.count
+	}
+	for _, cell := range geohash.SearchCells(opts.shape) {
+		lo, hi := cell.ScoreRange()
+		lower := &sortedset.ScoreBorder{Value: float64(lo)}
+		upper := &sortedset.ScoreBorder{Value: float64(hi), Exclude: true}
+		zs.ForEach(lower, upper, 0, -1, false, func(element *sortedset.Element) bool {
+			hash := scoreToHash(element.Score)
+			longitude, latitude := geohash.Decode(hash)
+			distance, ok := opts.shape.Contains(longitude, latitude)
+			if ok {
+				points = append(points, &geoPoint{
+					member:    element.Member,
+					hash:      hash,
+					distance:  distance / opts.unit,
+					longitude: longitude,
+					latitude:  latitude,
+				})
+			}
+			return limit == 0 || int64(len(points)) < limit
+		})
+		if limit > 0 && int64(len(points)) >= limit {
+			break
+		}
+	}
+	if opts.sort != 0 {
+		sort.SliceStable(points, func(i, j int) bool {
+			if opts.sort > 0 {
+				return points[i].distance < points[j].distance
+			}
+			return points[i].distance > points[j].distance
+		})
+	}
+	if opts.count > 0 && int64(len(points)) > opts.count {
+		points = points[:opts.count]
+	}
+	return points
+}
+
+func makeGeoPointsReply(points []*geoPoint, opts *geoSearchOptions) protocol.Reply {
+	replies := make([]protocol.Reply, len(points))
+	for i, point := range points {
+		name := protocol.MakeBulkReply([]byte(point.member))
+		if !opts.withDist && !opts.withHash && !opts.withCoord {
+			replies[i] = name
+			continue
+		}
+		item := []protocol.Reply{name}
+		if opts.withDist {
+			item = append(item, makeDistanceReply(point.distance))
+		}
+		if opts.withHash {
+			item = append(item, protocol.MakeIntReply(int64(point.hash)))
+		}
+		if opts.withCoord {
+			item = append(item, makePositionReply(point.longitude, point.latitude))
+		}
+		replies[i] = protocol.MakeArrayReply(item)
+	}
+	return protocol.MakeArrayReply(replies)
+}
+
+// geoSearchGeneric executes the search commands, the source key is the first argument except for GEOSEARCHSTORE
+func geoSearchGeneric(cmd string, flags int) ExecFunc {
+	return func(db *DB, args [][]byte) protocol.Reply {
+		src := string(args[0])
+		if flags&searchStore != 0 {
+			src = string(args[1])
+		}
+		zs, errReply := db.getAsSortedSet(src)
+		if errReply != nil {
+			return errReply
+		}
+		opts, errReply := parseGeoSearch(cmd, flags, zs, args)
+		if errReply != nil {
+			return errReply
+		}
+		var points []*geoPoint
+		if zs != nil {
+			points = searchPoints(zs, opts)
+		}
+		if opts.storeKey == "" {
+			return makeGeoPointsReply(points, opts)
+		}
+		elements := make([]*sortedset.Element, len(points))
+		for i, point := range points {
+			score := float64(point.hash)
+			if opts.storeDist {
+				score = point.distance
+			}
+			elements[i] = &sortedset.Element{Member: point.member, Score: score}
+		}
+		db.storeSortedSet(opts.storeKey, elements)
+		db.writeAof(toCmdLine3(cmd, args))
+		return protocol.MakeIntReply(int64(len(elements)))
+	}
+}
+
+// prepareGeoRadius locks the destination of the STORE options for write, first is the index of the options
+func prepareGeoRadius(first int) KeysFunc {
+	return func(args [][]byte) ([]string, []string) {
+		storeKey := geoSearchStoreKey(args, first)
+		if storeKey == "" {
+			return readFirstKey(args)
+		}
+		return []string{string(args[0])}, []string{storeKey}
+	}
+}
+
+func undoGeoRadius(first int) UndoFunc {
+	return func(db *DB, args [][]byte) [][][]byte {
+		storeKey := geoSearchStoreKey(args, first)
+		if storeKey == "" {
+			return nil
+		}
+		return rollbackGivenKeys(db, storeKey)
+	}
+}
+
+func init() {
+	registerCommand("GeoAdd", -5, execGeoAdd, writeFirstKey, rollbackFirstKey)
+	registerCommand("GeoDist", -4, execGeoDist, readFirstKey, nil)
+	registerCommand("GeoHash", -2, execGeoHash, readFirstKey, nil)
+	registerCommand("GeoPos", -2, execGeoPos, readFirstKey, nil)
+	registerCommand("GeoRadius", -6, geoSearchGeneric("georadius", searchRadius), prepareGeoRadius(5), undoGeoRadius(5))
+	registerCommand("GeoRadius_RO", -6, geoSearchGeneric("georadius_ro", searchRadius|searchNoStore), readFirstKey, nil)
+	registerCommand("GeoRadiusByMember", -5, geoSearchGeneric("georadiusbymember", searchRadiusByMember),
+		prepareGeoRadius(4), undoGeoRadius(4))
+	registerCommand("GeoRadiusByMember_RO", -5, geoSearchGeneric("georadiusbymember_ro", searchRadiusByMember|searchNoStore),
+		readFirstKey, nil)
+	registerCommand("GeoSearch", -7, geoSearchGeneric("geosearch", searchGeneric), readFirstKey, nil)
+	registerCommand("GeoSearchStore", -8, geoSearchGeneric("geosearchstore", searchStore), prepareZRangeStore, rollbackFirstKey)
+}
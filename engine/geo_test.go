@@ -0,0 +1,190 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/issimo1/redis-issimo/redis/connection"
+)
+
+const (
+	palermoPos = "*2\r\n$20\r\n13.36138933897018433\r\n$20\r\n38.11555639549629859\r\n"
+	cataniaPos = "*2\r\n$20\r\n15.08726745843887329\r\n$20\r\n37.50266842333162032\r\n"
+)
+
+func addSicily(e *Engine, c *connection.FakeConn) {
+	execCmd(e, c, "geoadd", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+}
+
+func TestGeoAdd(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "geoadd", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "zscore", "Sicily", "Palermo"), "$16\r\n3479099956230698\r\n")
+	assertReply(t, execCmd(e, c, "zscore", "Sicily", "Catania"), "$16\r\n3479447370796909\r\n")
+	assertReply(t, execCmd(e, c, "geoadd", "Sicily", "13.361389", "38.115556", "Palermo"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "geoadd", "Sicily", "ch", "13", "38", "Palermo", "13", "38", "Trapani"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "geoadd", "Sicily", "xx", "13.361389", "38.115556", "Palermo", "13", "38", "Agrigento"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "geoadd", "Sicily", "nx", "15", "37", "Catania"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "zcard", "Sicily"), ":3\r\n")
+	assertReply(t, execCmd(e, c, "geopos", "Sicily", "Palermo"), "*1\r\n"+palermoPos)
+
+	assertReply(t, execCmd(e, c, "geoadd", "Sicily", "nx", "xx", "15", "37", "Catania"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "geoadd", "Sicily", "15", "37", "Catania", "13"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "geoadd", "Sicily", "x", "37", "Catania"), "-ERR value is not a valid float\r\n")
+	assertReply(t, execCmd(e, c, "geoadd", "Sicily", "15", "86", "Catania"),
+		"-ERR invalid longitude,latitude pair 15.000000,86.000000\r\n")
+	execCmd(e, c, "set", "str", "1")
+	assertReply(t, execCmd(e, c, "geoadd", "str", "15", "37", "Catania"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+}
+
+func TestGeoDistHashPos(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	addSicily(e, c)
+	assertReply(t, execCmd(e, c, "geodist", "Sicily", "Palermo", "Catania"), "$11\r\n166274.1516\r\n")
+	assertReply(t, execCmd(e, c, "geodist", "Sicily", "Palermo", "Catania", "km"), "$8\r\n166.2742\r\n")
+	assertReply(t, execCmd(e, c, "geodist", "Sicily", "Palermo", "Catania", "MI"), "$8\r\n103.3182\r\n")
+	assertReply(t, execCmd(e, c, "geodist", "Sicily", "Palermo", "Catania", "ft"), "$11\r\n545518.8700\r\n")
+	assertReply(t, execCmd(e, c, "geodist", "Sicily", "Palermo", "Palermo"), "$6\r\n0.0000\r\n")
+	assertReply(t, execCmd(e, c, "geodist", "Sicily", "Foo", "Bar"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "geodist", "none", "Palermo", "Catania"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "geodist", "Sicily", "Palermo", "Catania", "yd"),
+		"-ERR unsupported unit provided. please use M, KM, FT, MI\r\n")
+	assertReply(t, execCmd(e, c, "geodist", "Sicily", "Palermo", "Catania", "km", "km"), "-ERR syntax error\r\n")
+
+	assertReply(t, execCmd(e, c, "geohash", "Sicily", "Palermo", "Catania", "Foo"),
+		"*3\r\n$11\r\nsqc8b49rny0\r\n$11\r\nsqdtr74hyu0\r\n$-1\r\n")
+	assertReply(t, execCmd(e, c, "geohash", "none", "Palermo"), "*1\r\n$-1\r\n")
+	assertReply(t, execCmd(e, c, "geohash", "Sicily"), "*0\r\n")
+
+	assertReply(t, execCmd(e, c, "geopos", "Sicily", "Palermo", "Catania", "NonExisting"), "*3\r\n"+palermoPos+cataniaPos+"*-1\r\n")
+	assertReply(t, execCmd(e, c, "geopos", "none", "Palermo"), "*1\r\n*-1\r\n")
+	assertReply3(t, execCmd(e, c, "geopos", "Sicily", "Palermo", "Foo"),
+		"*2\r\n*2\r\n,13.36138933897018433\r\n,38.11555639549629859\r\n_\r\n")
+	execCmd(e, c, "lpush", "list", "a")
+	assertReply(t, execCmd(e, c, "geopos", "list", "a"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+}
+
+func TestGeoRadius(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	addSicily(e, c)
+	assertReply(t, execCmd(e, c, "georadius", "Sicily", "15", "37", "200", "km", "withdist"),
+		"*2\r\n*2\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n*2\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n")
+	assertReply(t, execCmd(e, c, "georadius", "Sicily", "15", "37", "200", "km", "withcoord", "desc"),
+		"*2\r\n*2\r\n$7\r\nPalermo\r\n"+palermoPos+"*2\r\n$7\r\nCatania\r\n"+cataniaPos)
+	assertReply(t, execCmd(e, c, "georadius", "Sicily", "15", "37", "200", "km", "withdist", "withhash", "withcoord", "count", "1"),
+		"*1\r\n*4\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n:3479447370796909\r\n"+cataniaPos)
+	assertReply(t, execCmd(e, c, "georadius", "Sicily", "15", "37", "100", "km"), "*1\r\n$7\r\nCatania\r\n")
+	assertReply(t, execCmd(e, c, "georadius", "none", "15", "37", "100", "km"), "*0\r\n")
+
+	assertReply(t, execCmd(e, c, "georadiusbymember", "Sicily", "Palermo", "200", "km", "withdist", "asc"),
+		"*2\r\n*2\r\n$7\r\nPalermo\r\n$6\r\n0.0000\r\n*2\r\n$7\r\nCatania\r\n$8\r\n166.2742\r\n")
+	assertReply(t, execCmd(e, c, "georadiusbymember", "Sicily", "Foo", "200", "km"), "-ERR could not decode requested zset member\r\n")
+	assertReply(t, execCmd(e, c, "georadiusbymember", "none", "Foo", "200", "km"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "georadiusbymember_ro", "Sicily", "Catania", "10", "km"), "*1\r\n$7\r\nCatania\r\n")
+
+	assertReply(t, execCmd(e, c, "georadius", "Sicily", "15", "37", "200", "km", "store", "dst"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "dst", "0", "-1", "withscores"),
+		"*4\r\n$7\r\nPalermo\r\n$16\r\n3479099956230698\r\n$7\r\nCatania\r\n$16\r\n3479447370796909\r\n")
+	assertReply(t, execCmd(e, c, "georadius", "Sicily", "15", "37", "200", "km", "storedist", "dst"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "zscore", "dst", "Catania"), "$16\r\n56.4412578701582\r\n")
+	assertReply(t, execCmd(e, c, "georadius", "none", "15", "37", "200", "km", "store", "dst"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "exists", "dst"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "georadius_ro", "Sicily", "15", "37", "200", "km", "store", "dst"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "georadius", "Sicily", "15", "37", "200", "km", "withdist", "store", "dst"),
+		"-ERR STORE option in GEORADIUS is not compatible with WITHDIST, WITHHASH and WITHCOORD options\r\n")
+
+	assertReply(t, execCmd(e, c, "georadius", "Sicily", "15", "37", "x", "km"), "-ERR need numeric radius\r\n")
+	assertReply(t, execCmd(e, c, "georadius", "Sicily", "15", "37", "-1", "km"), "-ERR radius cannot be negative\r\n")
+	assertReply(t, execCmd(e, c, "georadius", "Sicily", "15", "37", "1", "yd"),
+		"-ERR unsupported unit provided. please use M, KM, FT, MI\r\n")
+	assertReply(t, execCmd(e, c, "georadius", "Sicily", "15", "37", "1", "km", "count", "0"), "-ERR COUNT must be > 0\r\n")
+	assertReply(t, execCmd(e, c, "georadius", "Sicily", "15", "37", "1", "km", "any"), "-ERR the ANY argument requires COUNT argument\r\n")
+	assertReply(t, execCmd(e, c, "georadius", "Sicily", "15", "37", "1", "km", "frommember", "Palermo"), "-ERR syntax error\r\n")
+}
+
+func TestGeoSearch(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	addSicily(e, c)
+	execCmd(e, c, "geoadd", "Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "asc"),
+		"*2\r\n$7\r\nCatania\r\n$7\r\nPalermo\r\n")
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "fromlonlat", "15", "37", "bybox", "400", "400", "km", "asc", "withdist", "withhash"),
+		"*4\r\n"+
+			"*3\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n:3479447370796909\r\n"+
+			"*3\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n:3479099956230698\r\n"+
+			"*3\r\n$5\r\nedge2\r\n$8\r\n279.7403\r\n:3481342659049484\r\n"+
+			"*3\r\n$5\r\nedge1\r\n$8\r\n279.7405\r\n:3479273021651468\r\n")
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "fromlonlat", "15", "37", "bybox", "400", "400", "km", "desc", "count", "2"),
+		"*2\r\n$5\r\nedge1\r\n$5\r\nedge2\r\n")
+	// ANY returns the first points found rather than the closest ones
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "frommember", "Catania", "byradius", "170", "km", "count", "1", "any"),
+		"*1\r\n$7\r\nPalermo\r\n")
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "frommember", "Catania", "byradius", "170", "km", "count", "1"),
+		"*1\r\n$7\r\nCatania\r\n")
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "frommember", "Palermo", "bybox", "10", "10", "km"), "*1\r\n$7\r\nPalermo\r\n")
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "frommember", "Foo", "bybox", "10", "10", "km"),
+		"-ERR could not decode requested zset member\r\n")
+	assertReply(t, execCmd(e, c, "geosearch", "none", "frommember", "Foo", "bybox", "10", "10", "km"), "*0\r\n")
+
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "byradius", "200", "km", "asc", "withdist"),
+		"-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch\r\n")
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "fromlonlat", "15", "37", "count", "1"),
+		"-ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch\r\n")
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "frommember", "Palermo", "fromlonlat", "15", "37", "byradius", "1", "km"),
+		"-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "fromlonlat", "15", "37", "byradius", "1", "km", "bybox", "1", "1", "km"),
+		"-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "fromlonlat", "15", "37", "bybox", "x", "1", "km"), "-ERR need numeric width\r\n")
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "fromlonlat", "15", "37", "bybox", "1", "x", "km"), "-ERR need numeric height\r\n")
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "fromlonlat", "15", "37", "bybox", "1", "-1", "km"),
+		"-ERR height or width cannot be negative\r\n")
+	assertReply(t, execCmd(e, c, "geosearch", "Sicily", "fromlonlat", "15", "37", "bybox", "1", "1", "km", "store", "dst"),
+		"-ERR syntax error\r\n")
+
+	assertReply(t, execCmd(e, c, "geosearchstore", "dst", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "count", "1"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "dst", "0", "-1", "withscores"), "*2\r\n$7\r\nCatania\r\n$16\r\n3479447370796909\r\n")
+	assertReply(t, execCmd(e, c, "geosearchstore", "dst", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "storedist"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "dst", "0", "-1", "withscores"),
+		"*4\r\n$7\r\nCatania\r\n$16\r\n56.4412578701582\r\n$7\r\nPalermo\r\n$17\r\n190.4424298477578\r\n")
+	assertReply(t, execCmd(e, c, "geosearchstore", "dst", "Sicily", "fromlonlat", "15", "37", "byradius", "200", "km", "withdist"),
+		"-ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options\r\n")
+	assertReply(t, execCmd(e, c, "geosearchstore", "dst", "none", "fromlonlat", "15", "37", "byradius", "200", "km"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "exists", "dst"), ":0\r\n")
+}
+
+func TestGeoPropagation(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	db, _ := e.selectDB(0)
+	var aof [][][]byte
+	db.writeAof = func(cmdLine [][]byte) { aof = append(aof, cmdLine) }
+	addSicily(e, c)
+	execCmd(e, c, "georadius", "Sicily", "15", "37", "200", "km", "storedist", "a")
+	execCmd(e, c, "geosearchstore", "b", "Sicily", "frommember", "Palermo", "byradius", "100", "km")
+	if len(aof) != 3 || string(aof[0][0]) != "zadd" {
+		t.Fatalf("got %d commands propagated", len(aof))
+	}
+
+	replayed := NewEngine()
+	for _, cmdLine := range aof {
+		replayed.Exec(c, cmdLine)
+	}
+	for _, key := range []string{"Sicily", "a", "b"} {
+		want := string(execCmd(e, c, "zrange", key, "0", "-1", "withscores").ToBytes())
+		assertReply(t, execCmd(replayed, c, "zrange", key, "0", "-1", "withscores"), want)
+	}
+}
+
+func TestGeoRollback(t *testing.T) {
+	testRollback(t, rollbackCase{
+		name:  "geo",
+		setup: [][]string{{"geoadd", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"}},
+		cmds: [][]string{{"geoadd", "Sicily", "15", "37", "Foo"},
+			{"georadiusbymember", "Sicily", "Palermo", "1", "km", "store", "a"},
+			{"geosearchstore", "b", "Sicily", "frommember", "Foo", "byradius", "1", "km"}},
+		reads: [][]string{{"zrange", "Sicily", "0", "-1", "withscores"}, {"zrange", "a", "0", "-1"}, {"zrange", "b", "0", "-1"}},
+	})
+}
@@ -0,0 +1,484 @@
+package engine
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/issimo1/redis-issimo/datastruct/hash"
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/config"
+	"github.com/issimo1/redis-issimo/utils/wildcard"
+)
+
+// getAsHash returns the hash of key, or nil if key does not exist
+func (db *DB) getAsHash(key string) (hash.Hash, protocol.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	h, ok := entity.RedisObject.(hash.Hash)
+	if !ok {
+		return nil, protocol.MakeWrongTypeErrReply()
+	}
+	return h, nil
+}
+
+// getOrInitHash returns the hash of key for write, an empty hash is bound to key if key does not exist
+func (db *DB) getOrInitHash(key string) (hash.Hash, protocol.ErrorReply) {
+	h, errReply := db.getHashForWrite(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if h == nil {
+		h = hash.NewListPack()
+		db.PutEntity(key, &payload.DataEntity{RedisObject: h})
+	}
+	return h, nil
+}
+
+// convertHashIfNeeded switches the ListPack of key to a Dict once it exceeds hash-max-listpack-entries,
+// or one of added, the fields and values just set, is longer than hash-max-listpack-value.
+// It returns the hash bound to key.
+func (db *DB) convertHashIfNeeded(key string, h hash.Hash, added ...[]byte) hash.Hash {
+	if _, ok := h.(*hash.ListPack); !ok {
+		return h
+	}
+	convert := h.Len() > config.GlobalConfig.HashMaxListpackEntries
+	for _, val := range added {
+		if len(val) > config.GlobalConfig.HashMaxListpackValue {
+			convert = true
+			break
+		}
+	}
+	if !convert {
+		return h
+	}
+	d := hash.NewDict()
+	hash.CopyTo(d, h)
+	db.PutEntity(key, &payload.DataEntity{RedisObject: d})
+	return d
+}
+
+// removeHashIfEmpty deletes key once its hash has no field
+func (db *DB) removeHashIfEmpty(key string, h hash.Hash) {
+	if h.Len() == 0 {
+		db.Remove(key)
+	}
+}
+
+// execHSet sets fields of a hash, HSET key field value [field value ...]
+func execHSet(db *DB, args [][]byte) protocol.Reply {
+	if len(args)%2 != 1 {
+		return protocol.MakeArgNumErrReply("hset")
+	}
+	key := string(args[0])
+	h, errReply := db.getOrInitHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	added := 0
+	for i := 1; i < len(args); i += 2 {
+		added += h.Set(string(args[i]), args[i+1])
+	}
+	db.convertHashIfNeeded(key, h, args[1:]...)
+	db.writeAof(toCmdLine3("hset", args))
+	return protocol.MakeIntReply(int64(added))
+}
+
+// execHSetNX sets a field only if it does not exist, HSETNX key field value
+func execHSetNX(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	h, errReply := db.getOrInitHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	if _, exists := h.Get(string(args[1])); exists {
+		return protocol.MakeIntReply(0)
+	}
+	h.Set(string(args[1]), args[2])
+	db.convertHashIfNeeded(key, h, args[1:]...)
+	db.writeAof(toCmdLine3("hsetnx", args))
+	return protocol.MakeIntReply(1)
+}
+
+// execHGet returns the value of a field, HGET key field
+func execHGet(db *DB, args [][]byte) protocol.Reply {
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	val, exists := h.Get(string(args[1]))
+	if !exists {
+		return protocol.MakeNullBulkReply()
+	}
+	return protocol.MakeBulkReply(val)
+}
+
+// execHMGet returns the values of fields, HMGET key field [field ...]
+func execHMGet(db *DB, args [][]byte) protocol.Reply {
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, len(args)-1)
+	if h != nil {
+		for i, field := range args[1:] {
+			result[i], _ = h.Get(string(field))
+		}
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// execHDel removes fields, HDEL key field [field ...]
+func execHDel(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	h, errReply := db.getHashForWrite(key)
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.MakeIntReply(0)
+	}
+	deleted := 0
+	for _, field := range args[1:] {
+		deleted += h.Delete(string(field))
+	}
+	if deleted > 0 {
+		db.removeHashIfEmpty(key, h)
+		db.writeAof(toCmdLine3("hdel", args))
+	}
+	return protocol.MakeIntReply(int64(deleted))
+}
+
+// execHLen returns the number of fields, HLEN key
+func execHLen(db *DB, args [][]byte) protocol.Reply {
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(int64(h.Len()))
+}
+
+// execHStrLen returns the length of the value of a field, HSTRLEN key field
+func execHStrLen(db *DB, args [][]byte) protocol.Reply {
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.MakeIntReply(0)
+	}
+	val, _ := h.Get(string(args[1]))
+	return protocol.MakeIntReply(int64(len(val)))
+}
+
+// execHExists checks whether a field exists, HEXISTS key field
+func execHExists(db *DB, args [][]byte) protocol.Reply {
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.MakeIntReply(0)
+	}
+	if _, exists := h.Get(string(args[1])); exists {
+		return protocol.MakeIntReply(1)
+	}
+	return protocol.MakeIntReply(0)
+}
+
+// hashItems returns the fields or the values of key
+func hashItems(db *DB, key string, fields bool) protocol.Reply {
+	h, errReply := db.getAsHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	result := make([][]byte, 0, h.Len())
+	h.ForEach(func(field string, val []byte) bool {
+		if fields {
+			result = append(result, []byte(field))
+		} else {
+			result = append(result, val)
+		}
+		return true
+	})
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// execHKeys returns every field, HKEYS key
+func execHKeys(db *DB, args [][]byte) protocol.Reply {
+	return hashItems(db, string(args[0]), true)
+}
+
+// execHVals returns every value, HVALS key
+func execHVals(db *DB, args [][]byte) protocol.Reply {
+	return hashItems(db, string(args[0]), false)
+}
+
+// execHGetAll returns every field and value, HGETALL key
+func execHGetAll(db *DB, args [][]byte) protocol.Reply {
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return protocol.MakeMapReply(nil, nil)
+	}
+	fields := make([]protocol.Reply, 0, h.Len())
+	values := make([]protocol.Reply, 0, h.Len())
+	h.ForEach(func(field string, val []byte) bool {
+		fields = append(fields, protocol.MakeBulkReply([]byte(field)))
+		values = append(values, protocol.MakeBulkReply(val))
+		return true
+	})
+	return protocol.MakeMapReply(fields, values)
+}
+
+// setKeepTTL updates the value of field without changing its ttl
+func setKeepTTL(h hash.Hash, field string, val []byte) {
+	expireTime, hasTTL := h.GetExpireTime(field)
+	h.Set(field, val)
+	if hasTTL {
+		h.Expire(field, expireTime)
+	}
+}
+
+// execHIncrBy increments the integer value of a field, HINCRBY key field increment
+func execHIncrBy(db *DB, args [][]byte) protocol.Reply {
+	delta, errReply := parseIncrement(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	h, errReply := db.getOrInitHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	field := string(args[1])
+	var n int64
+	if val, exists := h.Get(field); exists {
+		var ok bool
+		n, ok = parseStrictInt(val)
+		if !ok {
+			return protocol.MakeErrReply("ERR hash value is not an integer")
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		db.removeHashIfEmpty(key, h)
+		return protocol.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	n += delta
+	result := []byte(strconv.FormatInt(n, 10))
+	setKeepTTL(h, field, result)
+	db.convertHashIfNeeded(key, h, args[1], result)
+	db.writeAof(toCmdLine3("hincrby", args))
+	return protocol.MakeIntReply(n)
+}
+
+// execHIncrByFloat increments the float value of a field, HINCRBYFLOAT key field increment
+func execHIncrByFloat(db *DB, args [][]byte) protocol.Reply {
+	incr, ok := parseLongDouble(args[2])
+	if !ok {
+		return protocol.MakeErrReply("ERR value is not a valid float")
+	}
+	key := string(args[0])
+	h, errReply := db.getOrInitHash(key)
+	if errReply != nil {
+		return errReply
+	}
+	field := string(args[1])
+	value := new(big.Float).SetPrec(longDoublePrec)
+	if val, exists := h.Get(field); exists {
+		value, ok = parseLongDouble(val)
+		if !ok {
+			return protocol.MakeErrReply("ERR hash value is not a float")
+		}
+	}
+	if value.IsInf() || incr.IsInf() {
+		db.removeHashIfEmpty(key, h)
+		return protocol.MakeErrReply("ERR increment would produce NaN or Infinity")
+	}
+	value.Add(value, incr)
+	result := []byte(formatLongDouble(value))
+	setKeepTTL(h, field, result)
+	db.convertHashIfNeeded(key, h, args[1], result)
+	// propagate the result rather than the increment, so that replaying is not affected by float rounding
+	db.writeAof(toCmdLine2("hset", args[0], args[1], result))
+	if expireTime, ok := h.GetExpireTime(field); ok {
+		// HSET removes the ttl of field
+		db.writeAof(makeFieldExpireCmd(key, expireTime, args[1]))
+	}
+	return protocol.MakeBulkReply(result)
+}
+
+// execHRandField returns random fields, HRANDFIELD key [count [WITHVALUES]].
+// A positive count returns distinct fields, a negative count may return a field several times.
+func execHRandField(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 3 || (len(args) == 3 && strings.ToLower(string(args[2])) != "withvalues") {
+		return protocol.MakeSyntaxErrReply()
+	}
+	withCount := len(args) >= 2
+	withValues := len(args) == 3
+	var count int64
+	if withCount {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count == math.MinInt64 || (withValues && count < -math.MaxInt64/2) {
+			return protocol.MakeErrReply("ERR value is out of range")
+		}
+	}
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !withCount {
+		if h == nil {
+			return protocol.MakeNullBulkReply()
+		}
+		// the last fields may expire after the hash is got
+		fields := h.RandomFields(1)
+		if len(fields) == 0 {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeBulkReply([]byte(fields[0]))
+	}
+	if h == nil || count == 0 {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	var fields []string
+	if count > 0 {
+		fields = h.RandomDistinctFields(int(min(count, int64(h.Len()))))
+	} else {
+		fields = h.RandomFields(int(-count))
+	}
+	if !withValues {
+		result := make([][]byte, len(fields))
+		for i, field := range fields {
+			result[i] = []byte(field)
+		}
+		return protocol.MakeMultiBulkReply(result)
+	}
+	keys := make([]protocol.Reply, len(fields))
+	values := make([]protocol.Reply, len(fields))
+	for i, field := range fields {
+		val, _ := h.Get(field)
+		keys[i] = protocol.MakeBulkReply([]byte(field))
+		values[i] = protocol.MakeBulkReply(val)
+	}
+	return protocol.MakePairsReply(keys, values)
+}
+
+// scanOptions are the options of the SCAN family
+type scanOptions struct {
+	cursor   uint64
+	pattern  string
+	count    int
+	noValues bool
+}
+
+const defaultScanCount = 10
+
+// parseScanArgs parses cursor [MATCH pattern] [COUNT count] [NOVALUES], NOVALUES is accepted only if allowNoValues
+func parseScanArgs(args [][]byte, allowNoValues bool) (*scanOptions, protocol.ErrorReply) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, protocol.MakeErrReply("ERR invalid cursor")
+	}
+	options := &scanOptions{cursor: cursor, pattern: "*", count: defaultScanCount}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			if i+1 >= len(args) {
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			options.pattern = string(args[i+1])
+			i++
+		case "count":
+			if i+1 >= len(args) {
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			count, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			options.count = int(min(count, math.MaxInt32))
+			i++
+		case "novalues":
+			if !allowNoValues {
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			options.noValues = true
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+	}
+	return options, nil
+}
+
+// makeScanReply returns the reply of the SCAN family, the next cursor and the items found
+func makeScanReply(cursor uint64, items [][]byte) protocol.Reply {
+	return protocol.MakeArrayReply([]protocol.Reply{
+		protocol.MakeBulkReply([]byte(strconv.FormatUint(cursor, 10))),
+		protocol.MakeMultiBulkReply(items),
+	})
+}
+
+// execHScan iterates the fields, HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+func execHScan(db *DB, args [][]byte) protocol.Reply {
+	options, errReply := parseScanArgs(args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	items := make([][]byte, 0)
+	if h == nil {
+		return makeScanReply(0, items)
+	}
+	allFields := options.pattern == "*"
+	cursor := h.Scan(options.cursor, options.count, func(field string, val []byte) bool {
+		if allFields || wildcard.Match(options.pattern, field) {
+			items = append(items, []byte(field))
+			if !options.noValues {
+				items = append(items, val)
+			}
+		}
+		return true
+	})
+	return makeScanReply(cursor, items)
+}
+
+func init() {
+	registerCommand("HSet", -4, execHSet, writeFirstKey, rollbackFirstKey)
+	registerCommand("HSetNX", 4, execHSetNX, writeFirstKey, rollbackFirstKey)
+	registerCommand("HGet", 3, execHGet, readFirstKey, nil)
+	registerCommand("HMGet", -3, execHMGet, readFirstKey, nil)
+	registerCommand("HDel", -3, execHDel, writeFirstKey, rollbackFirstKey)
+	registerCommand("HLen", 2, execHLen, readFirstKey, nil)
+	registerCommand("HStrLen", 3, execHStrLen, readFirstKey, nil)
+	registerCommand("HExists", 3, execHExists, readFirstKey, nil)
+	registerCommand("HKeys", 2, execHKeys, readFirstKey, nil)
+	registerCommand("HVals", 2, execHVals, readFirstKey, nil)
+	registerCommand("HGetAll", 2, execHGetAll, readFirstKey, nil)
+	registerCommand("HIncrBy", 4, execHIncrBy, writeFirstKey, rollbackFirstKey)
+	registerCommand("HIncrByFloat", 4, execHIncrByFloat, writeFirstKey, rollbackFirstKey)
+	registerCommand("HRandField", -2, execHRandField, readFirstKey, nil)
+	registerCommand("HScan", -3, execHScan, readFirstKey, nil)
+}
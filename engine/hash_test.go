@@ -0,0 +1,207 @@
+package engine
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/config"
+)
+
+func TestHashSetGet(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "hset", "h", "a", "1", "b", "2"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "hset", "h", "a", "3", "c", "4"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "hset", "h", "a"), "-ERR wrong number of arguments for 'hset' command\r\n")
+	assertReply(t, execCmd(e, c, "hset", "h", "a", "1", "b"), "-ERR wrong number of arguments for 'hset' command\r\n")
+	assertReply(t, execCmd(e, c, "type", "h"), "+hash\r\n")
+	assertReply(t, execCmd(e, c, "hsetnx", "h", "a", "5"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "hsetnx", "h", "d", ""), ":1\r\n")
+	assertReply(t, execCmd(e, c, "hget", "h", "a"), "$1\r\n3\r\n")
+	assertReply(t, execCmd(e, c, "hget", "h", "d"), "$0\r\n\r\n")
+	assertReply(t, execCmd(e, c, "hget", "h", "x"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "hget", "none", "x"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "hmget", "h", "a", "x", "b"), "*3\r\n$1\r\n3\r\n$-1\r\n$1\r\n2\r\n")
+	assertReply(t, execCmd(e, c, "hmget", "none", "a"), "*1\r\n$-1\r\n")
+	assertReply(t, execCmd(e, c, "hlen", "h"), ":4\r\n")
+	assertReply(t, execCmd(e, c, "hlen", "none"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "hstrlen", "h", "a"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "hstrlen", "h", "x"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "hexists", "h", "a"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "hexists", "h", "x"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "hkeys", "h"), "*4\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n")
+	assertReply(t, execCmd(e, c, "hvals", "h"), "*4\r\n$1\r\n3\r\n$1\r\n2\r\n$1\r\n4\r\n$0\r\n\r\n")
+	assertReply(t, execCmd(e, c, "hkeys", "none"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "hgetall", "h"),
+		"*8\r\n$1\r\na\r\n$1\r\n3\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\nc\r\n$1\r\n4\r\n$1\r\nd\r\n$0\r\n\r\n")
+	assertReply3(t, execCmd(e, c, "hgetall", "h"),
+		"%4\r\n$1\r\na\r\n$1\r\n3\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\nc\r\n$1\r\n4\r\n$1\r\nd\r\n$0\r\n\r\n")
+	assertReply(t, execCmd(e, c, "hgetall", "none"), "*0\r\n")
+
+	assertReply(t, execCmd(e, c, "hdel", "h", "a", "x", "b"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "hdel", "none", "a"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "hdel", "h", "c", "d"), ":2\r\n")
+	// an empty hash is removed
+	assertReply(t, execCmd(e, c, "exists", "h"), ":0\r\n")
+
+	execCmd(e, c, "set", "s", "1")
+	assertReply(t, execCmd(e, c, "hset", "s", "a", "1"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	assertReply(t, execCmd(e, c, "hget", "s", "a"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	assertReply(t, execCmd(e, c, "hgetall", "s"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+}
+
+func TestHashIncr(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "hincrby", "h", "n", "5"), ":5\r\n")
+	assertReply(t, execCmd(e, c, "hincrby", "h", "n", "-7"), ":-2\r\n")
+	assertReply(t, execCmd(e, c, "hincrby", "h", "n", "x"), "-ERR value is not an integer or out of range\r\n")
+	execCmd(e, c, "hset", "h", "s", "abc", "big", "9223372036854775807", "sp", " 1")
+	assertReply(t, execCmd(e, c, "hincrby", "h", "s", "1"), "-ERR hash value is not an integer\r\n")
+	assertReply(t, execCmd(e, c, "hincrby", "h", "sp", "1"), "-ERR hash value is not an integer\r\n")
+	assertReply(t, execCmd(e, c, "hincrby", "h", "big", "1"), "-ERR increment or decrement would overflow\r\n")
+
+	assertReply(t, execCmd(e, c, "hincrbyfloat", "h", "f", "10.5"), "$4\r\n10.5\r\n")
+	assertReply(t, execCmd(e, c, "hincrbyfloat", "h", "f", "0.1"), "$4\r\n10.6\r\n")
+	assertReply(t, execCmd(e, c, "hincrbyfloat", "h", "n", "5.0e3"), "$4\r\n4998\r\n")
+	assertReply(t, execCmd(e, c, "hincrbyfloat", "h", "s", "1"), "-ERR hash value is not a float\r\n")
+	assertReply(t, execCmd(e, c, "hincrbyfloat", "h", "f", "x"), "-ERR value is not a valid float\r\n")
+	assertReply(t, execCmd(e, c, "hincrbyfloat", "h", "f", "inf"), "-ERR increment would produce NaN or Infinity\r\n")
+	assertReply(t, execCmd(e, c, "hincrbyfloat", "new", "f", "inf"), "-ERR increment would produce NaN or Infinity\r\n")
+	assertReply(t, execCmd(e, c, "exists", "new"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "hget", "h", "f"), "$4\r\n10.6\r\n")
+}
+
+func TestHashRandField(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "hrandfield", "none"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "hrandfield", "none", "3"), "*0\r\n")
+	execCmd(e, c, "hset", "h", "a", "1", "b", "2", "c", "3")
+	fields := map[string]string{"a": "1", "b": "2", "c": "3"}
+	reply := execCmd(e, c, "hrandfield", "h")
+	if _, ok := fields[string(reply.(*protocol.BulkReply).Arg)]; !ok {
+		t.Fatalf("got %q", reply.ToBytes())
+	}
+	assertReply(t, execCmd(e, c, "hrandfield", "h", "0"), "*0\r\n")
+
+	reply = execCmd(e, c, "hrandfield", "h", "5")
+	args := reply.(*protocol.MultiBulkReply).Args
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		seen[string(arg)] = true
+	}
+	if len(args) != 3 || len(seen) != 3 {
+		t.Fatalf("got %q", reply.ToBytes())
+	}
+	reply = execCmd(e, c, "hrandfield", "h", "-5")
+	if args := reply.(*protocol.MultiBulkReply).Args; len(args) != 5 {
+		t.Fatalf("got %q", reply.ToBytes())
+	}
+
+	reply = execCmd(e, c, "hrandfield", "h", "-4", "withvalues")
+	pairs := reply.(*protocol.PairsReply)
+	if len(pairs.Keys) != 4 {
+		t.Fatalf("got %q", reply.ToBytes())
+	}
+	for i, key := range pairs.Keys {
+		field := string(key.(*protocol.BulkReply).Arg)
+		if fields[field] != string(pairs.Values[i].(*protocol.BulkReply).Arg) {
+			t.Fatalf("got %q", reply.ToBytes())
+		}
+	}
+	assertReply(t, execCmd(e, c, "hrandfield", "h", "1", "values"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "hrandfield", "h", "x"), "-ERR value is out of range\r\n")
+	assertReply(t, execCmd(e, c, "hrandfield", "h", "-9223372036854775807", "withvalues"), "-ERR value is out of range\r\n")
+}
+
+func TestHashScan(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "hscan", "none", "0"), "*2\r\n$1\r\n0\r\n*0\r\n")
+	execCmd(e, c, "hset", "h", "a1", "1", "b1", "2", "a2", "3")
+	assertReply(t, execCmd(e, c, "hscan", "h", "0"),
+		"*2\r\n$1\r\n0\r\n*6\r\n$2\r\na1\r\n$1\r\n1\r\n$2\r\nb1\r\n$1\r\n2\r\n$2\r\na2\r\n$1\r\n3\r\n")
+	assertReply(t, execCmd(e, c, "hscan", "h", "0", "match", "a*", "novalues"),
+		"*2\r\n$1\r\n0\r\n*2\r\n$2\r\na1\r\n$2\r\na2\r\n")
+	assertReply(t, execCmd(e, c, "hscan", "h", "x"), "-ERR invalid cursor\r\n")
+	assertReply(t, execCmd(e, c, "hscan", "h", "0", "count", "0"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "hscan", "h", "0", "count"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "hscan", "h", "0", "count", "x"), "-ERR value is not an integer or out of range\r\n")
+
+	// a large hash is scanned in several calls
+	for i := 0; i < 500; i++ {
+		execCmd(e, c, "hset", "big", "f"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	seen := make(map[string]bool)
+	cursor := "0"
+	calls := 0
+	for {
+		reply := execCmd(e, c, "hscan", "big", cursor, "count", "20").(*protocol.ArrayReply)
+		cursor = string(reply.Replies[0].(*protocol.BulkReply).Arg)
+		items := reply.Replies[1].(*protocol.MultiBulkReply).Args
+		for i := 0; i < len(items); i += 2 {
+			if "f"+string(items[i+1]) != string(items[i]) {
+				t.Fatalf("%s is %s", items[i], items[i+1])
+			}
+			seen[string(items[i])] = true
+		}
+		calls++
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != 500 || calls < 10 {
+		t.Fatalf("%d fields in %d calls", len(seen), calls)
+	}
+}
+
+func TestHashEncoding(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "hset", "h", "a", "1")
+	assertReply(t, execCmd(e, c, "object", "encoding", "h"), "$8\r\nlistpack\r\n")
+	for i := 0; i < 128; i++ {
+		execCmd(e, c, "hset", "h", strconv.Itoa(i), "v")
+	}
+	assertReply(t, execCmd(e, c, "object", "encoding", "h"), "$9\r\nhashtable\r\n")
+	assertReply(t, execCmd(e, c, "hlen", "h"), ":129\r\n")
+	// a hashtable is never converted back
+	for i := 0; i < 128; i++ {
+		execCmd(e, c, "hdel", "h", strconv.Itoa(i))
+	}
+	assertReply(t, execCmd(e, c, "object", "encoding", "h"), "$9\r\nhashtable\r\n")
+	assertReply(t, execCmd(e, c, "hget", "h", "a"), "$1\r\n1\r\n")
+
+	execCmd(e, c, "hset", "long", "a", strings.Repeat("x", 65))
+	assertReply(t, execCmd(e, c, "object", "encoding", "long"), "$9\r\nhashtable\r\n")
+	execCmd(e, c, "hset", "incr", "a", "1")
+	execCmd(e, c, "hincrbyfloat", "incr", "a", "0.0000000000000001")
+	assertReply(t, execCmd(e, c, "object", "encoding", "incr"), "$8\r\nlistpack\r\n")
+
+	// the thresholds are read from the config
+	config.GlobalConfig.HashMaxListpackEntries = 0
+	defer func() { config.GlobalConfig.HashMaxListpackEntries = 128 }()
+	execCmd(e, c, "hset", "zero", "a", "1")
+	assertReply(t, execCmd(e, c, "object", "encoding", "zero"), "$9\r\nhashtable\r\n")
+
+	// copies keep the encoding and the fields
+	execCmd(e, c, "copy", "incr", "incr2")
+	assertReply(t, execCmd(e, c, "object", "encoding", "incr2"), "$8\r\nlistpack\r\n")
+	execCmd(e, c, "copy", "h", "h2")
+	assertReply(t, execCmd(e, c, "object", "encoding", "h2"), "$9\r\nhashtable\r\n")
+	execCmd(e, c, "hset", "h2", "a", "2")
+	assertReply(t, execCmd(e, c, "hget", "h", "a"), "$1\r\n1\r\n")
+}
+
+func TestHashRollback(t *testing.T) {
+	testRollback(t, rollbackCase{
+		name:  "hash",
+		setup: [][]string{{"hset", "h", "a", "1", "b", "2"}},
+		cmds:  [][]string{{"hdel", "h", "a", "b"}, {"hincrby", "n", "a", "1"}, {"hset", "h", "c", "3"}},
+		reads: [][]string{{"hgetall", "h"}, {"hgetall", "n"}},
+	})
+}
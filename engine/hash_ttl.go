@@ -0,0 +1,328 @@
+package engine
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/issimo1/redis-issimo/datastruct/hash"
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+// the results of HEXPIRE for each field
+const (
+	fieldNotFound  = -2
+	fieldNotMet    = 0
+	fieldExpireSet = 1
+	fieldDeleted   = 2
+)
+
+// the results of HTTL and HPERSIST for a field without ttl, and of HPERSIST for a field whose ttl is removed
+const (
+	fieldNoTTL     = -1
+	fieldPersisted = 1
+)
+
+// allFieldsExpired returns true if entity is a hash whose fields have all expired, such a key is treated as absent
+func allFieldsExpired(entity *payload.DataEntity) bool {
+	h, ok := entity.RedisObject.(hash.Hash)
+	return ok && h.Len() == 0
+}
+
+func genFieldExpireKey(dbIdx int, key string) string {
+	return "hexpire:" + strconv.Itoa(dbIdx) + ":" + key
+}
+
+// scheduleFieldExpire schedules the active deletion of the fields of h, h is bound to key
+func (db *DB) scheduleFieldExpire(key string, h hash.Hash) {
+	next, ok := h.NextExpireTime()
+	if !ok {
+		return
+	}
+	// a task replaces the pending one of key, so only the earliest expire time is scheduled
+	db.delay.AddAt(next, genFieldExpireKey(db.index, key), func() {
+		db.activeExpireFields(key)
+	})
+}
+
+// activeExpireFields is the task scheduled by scheduleFieldExpire, it removes the expired fields of key
+// and schedules the next expire time
+func (db *DB) activeExpireFields(key string) {
+	keys := []string{key}
+	db.RWLock(nil, keys)
+	defer db.RWUnLock(nil, keys)
+	if db.expireIfNeeded(key) {
+		return
+	}
+	// key may be bound to another value while waiting for the lock, so check it again
+	raw, ok := db.dataDict.GetWithoutLock(key)
+	if !ok {
+		return
+	}
+	if h, ok := raw.(*payload.DataEntity).RedisObject.(hash.Hash); ok {
+		if db.expireFields(key, h) {
+			db.scheduleFieldExpire(key, h)
+		}
+	}
+}
+
+// expireFields removes the expired fields of h and propagates them as HDEL, key is removed once h is empty.
+// It returns false if key is removed. Key must be write locked by caller.
+func (db *DB) expireFields(key string, h hash.Hash) bool {
+	removed := h.RemoveExpired(time.Now())
+	if len(removed) == 0 {
+		return true
+	}
+	cmdLine := make([][]byte, 0, len(removed)+2)
+	cmdLine = append(cmdLine, []byte("hdel"), []byte(key))
+	for _, field := range removed {
+		cmdLine = append(cmdLine, []byte(field))
+	}
+	db.writeAof(cmdLine)
+	db.addVersion(key)
+	if h.Len() == 0 {
+		db.Remove(key)
+		return false
+	}
+	return true
+}
+
+// getHashForWrite returns the hash of key like getAsHash, its expired fields are removed first
+func (db *DB) getHashForWrite(key string) (hash.Hash, protocol.ErrorReply) {
+	h, errReply := db.getAsHash(key)
+	if errReply != nil || h == nil {
+		return nil, errReply
+	}
+	if !db.expireFields(key, h) {
+		return nil, nil
+	}
+	return h, nil
+}
+
+// makeFieldExpireCmd returns a HPEXPIREAT command, relative ttl is propagated as absolute time to survive AOF replay
+func makeFieldExpireCmd(key string, expireTime time.Time, fields ...[]byte) [][]byte {
+	cmdLine := make([][]byte, 0, len(fields)+5)
+	cmdLine = append(cmdLine, []byte("hpexpireat"), []byte(key),
+		[]byte(strconv.FormatInt(expireTime.UnixMilli(), 10)),
+		[]byte("FIELDS"), []byte(strconv.Itoa(len(fields))))
+	return append(cmdLine, fields...)
+}
+
+// parseFields parses FIELDS numfields field [field ...] at the end of args
+func parseFields(args [][]byte) ([][]byte, protocol.ErrorReply) {
+	if len(args) < 2 || strings.ToLower(string(args[0])) != "fields" {
+		return nil, protocol.MakeErrReply("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	numFields, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || numFields <= 0 {
+		return nil, protocol.MakeErrReply("ERR Parameter `numFields` should be greater than 0")
+	}
+	fields := args[2:]
+	if numFields != int64(len(fields)) {
+		return nil, protocol.MakeErrReply("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return fields, nil
+}
+
+func makeIntsReply(results []int64) protocol.Reply {
+	replies := make([]protocol.Reply, len(results))
+	for i, result := range results {
+		replies[i] = protocol.MakeIntReply(result)
+	}
+	return protocol.MakeArrayReply(replies)
+}
+
+// hashExpireGeneric implements HEXPIRE, HPEXPIRE, HEXPIREAT and HPEXPIREAT,
+// the args are key time [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func hashExpireGeneric(db *DB, cmd string, args [][]byte, unit time.Duration, relative bool) protocol.Reply {
+	key := string(args[0])
+	when, errReply := parseExpireTime(cmd, args[1], unit, relative)
+	if errReply != nil {
+		return errReply
+	}
+	// unlike EXPIRE, a negative time is refused rather than deleting the fields
+	if raw, _ := strconv.ParseInt(string(args[1]), 10, 64); raw < 0 {
+		return protocol.MakeErrReply("ERR invalid expire time in '" + cmd + "' command")
+	}
+	rest := args[2:]
+	flags := 0
+	// at most one condition is given before FIELDS
+	switch strings.ToLower(string(rest[0])) {
+	case "nx", "xx", "gt", "lt":
+		flags, _ = parseExpireFlags(rest[:1])
+		rest = rest[1:]
+	}
+	fields, errReply := parseFields(rest)
+	if errReply != nil {
+		return errReply
+	}
+	h, errReply := db.getHashForWrite(key)
+	if errReply != nil {
+		return errReply
+	}
+	results := make([]int64, len(fields))
+	if h == nil {
+		for i := range results {
+			results[i] = fieldNotFound
+		}
+		return makeIntsReply(results)
+	}
+
+	expireTime := time.UnixMilli(when)
+	instant := !expireTime.After(time.Now())
+	var updated [][]byte
+	for i, field := range fields {
+		if _, exists := h.Get(string(field)); !exists {
+			results[i] = fieldNotFound
+			continue
+		}
+		current, hasTTL := h.GetExpireTime(string(field))
+		if !expireAllowed(flags, current, hasTTL, when) {
+			results[i] = fieldNotMet
+			continue
+		}
+		if instant {
+			h.Delete(string(field))
+			results[i] = fieldDeleted
+		} else {
+			h.Expire(string(field), expireTime)
+			results[i] = fieldExpireSet
+		}
+		updated = append(updated, field)
+	}
+	if len(updated) == 0 {
+		return makeIntsReply(results)
+	}
+	if instant {
+		db.removeHashIfEmpty(key, h)
+		db.writeAof(toCmdLine2("hdel", append([][]byte{args[0]}, updated...)...))
+	} else {
+		db.scheduleFieldExpire(key, h)
+		db.writeAof(makeFieldExpireCmd(key, expireTime, updated...))
+	}
+	return makeIntsReply(results)
+}
+
+// execHExpire sets the ttl of fields in seconds, HEXPIRE key seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func execHExpire(db *DB, args [][]byte) protocol.Reply {
+	return hashExpireGeneric(db, "hexpire", args, time.Second, true)
+}
+
+// execHPExpire sets the ttl of fields in milliseconds,
+// HPEXPIRE key milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func execHPExpire(db *DB, args [][]byte) protocol.Reply {
+	return hashExpireGeneric(db, "hpexpire", args, time.Millisecond, true)
+}
+
+// execHExpireAt sets the expire time of fields in unix seconds,
+// HEXPIREAT key unix-time-seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func execHExpireAt(db *DB, args [][]byte) protocol.Reply {
+	return hashExpireGeneric(db, "hexpireat", args, time.Second, false)
+}
+
+// execHPExpireAt sets the expire time of fields in unix milliseconds,
+// HPEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func execHPExpireAt(db *DB, args [][]byte) protocol.Reply {
+	return hashExpireGeneric(db, "hpexpireat", args, time.Millisecond, false)
+}
+
+// hashTTLGeneric returns for each field -2 if it does not exist, -1 if it has no ttl, otherwise the result of convert,
+// the args are key FIELDS numfields field [field ...]
+func hashTTLGeneric(db *DB, args [][]byte, convert func(expireTime time.Time) int64) protocol.Reply {
+	fields, errReply := parseFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	h, errReply := db.getAsHash(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	results := make([]int64, len(fields))
+	for i, field := range fields {
+		if h == nil {
+			results[i] = fieldNotFound
+			continue
+		}
+		if _, exists := h.Get(string(field)); !exists {
+			results[i] = fieldNotFound
+			continue
+		}
+		expireTime, ok := h.GetExpireTime(string(field))
+		if !ok {
+			results[i] = fieldNoTTL
+			continue
+		}
+		results[i] = convert(expireTime)
+	}
+	return makeIntsReply(results)
+}
+
+// execHTTL returns the remaining ttl of fields in seconds, HTTL key FIELDS numfields field [field ...]
+func execHTTL(db *DB, args [][]byte) protocol.Reply {
+	return hashTTLGeneric(db, args, remainingSeconds)
+}
+
+// execHPTTL returns the remaining ttl of fields in milliseconds, HPTTL key FIELDS numfields field [field ...]
+func execHPTTL(db *DB, args [][]byte) protocol.Reply {
+	return hashTTLGeneric(db, args, remainingMs)
+}
+
+// execHExpireTime returns the expire time of fields in unix seconds, HEXPIRETIME key FIELDS numfields field [field ...]
+func execHExpireTime(db *DB, args [][]byte) protocol.Reply {
+	return hashTTLGeneric(db, args, unixSeconds)
+}
+
+// execHPExpireTime returns the expire time of fields in unix milliseconds,
+// HPEXPIRETIME key FIELDS numfields field [field ...]
+func execHPExpireTime(db *DB, args [][]byte) protocol.Reply {
+	return hashTTLGeneric(db, args, unixMs)
+}
+
+// execHPersist removes the ttl of fields, HPERSIST key FIELDS numfields field [field ...]
+func execHPersist(db *DB, args [][]byte) protocol.Reply {
+	fields, errReply := parseFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	h, errReply := db.getHashForWrite(key)
+	if errReply != nil {
+		return errReply
+	}
+	results := make([]int64, len(fields))
+	var persisted [][]byte
+	for i, field := range fields {
+		if h == nil {
+			results[i] = fieldNotFound
+			continue
+		}
+		if _, exists := h.Get(string(field)); !exists {
+			results[i] = fieldNotFound
+			continue
+		}
+		if !h.Persist(string(field)) {
+			results[i] = fieldNoTTL
+			continue
+		}
+		results[i] = fieldPersisted
+		persisted = append(persisted, field)
+	}
+	if len(persisted) > 0 {
+		cmdLine := toCmdLine("hpersist", key, "FIELDS", strconv.Itoa(len(persisted)))
+		db.writeAof(append(cmdLine, persisted...))
+	}
+	return makeIntsReply(results)
+}
+
+func init() {
+	registerCommand("HExpire", -6, execHExpire, writeFirstKey, rollbackFirstKey)
+	registerCommand("HPExpire", -6, execHPExpire, writeFirstKey, rollbackFirstKey)
+	registerCommand("HExpireAt", -6, execHExpireAt, writeFirstKey, rollbackFirstKey)
+	registerCommand("HPExpireAt", -6, execHPExpireAt, writeFirstKey, rollbackFirstKey)
+	registerCommand("HTTL", -5, execHTTL, readFirstKey, nil)
+	registerCommand("HPTTL", -5, execHPTTL, readFirstKey, nil)
+	registerCommand("HExpireTime", -5, execHExpireTime, readFirstKey, nil)
+	registerCommand("HPExpireTime", -5, execHPExpireTime, readFirstKey, nil)
+	registerCommand("HPersist", -5, execHPersist, writeFirstKey, rollbackFirstKey)
+}
@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/issimo1/redis-issimo/datastruct/hash"
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/config"
+)
+
+func TestHashExpireAndTTL(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "hexpire", "h", "100", "fields", "2", "a", "b"), "*2\r\n:-2\r\n:-2\r\n")
+	assertReply(t, execCmd(e, c, "httl", "h", "fields", "1", "a"), "*1\r\n:-2\r\n")
+	execCmd(e, c, "hset", "h", "a", "1", "b", "2", "c", "3")
+	assertReply(t, execCmd(e, c, "hexpire", "h", "100", "fields", "2", "a", "x"), "*2\r\n:1\r\n:-2\r\n")
+	assertReply(t, execCmd(e, c, "httl", "h", "FIELDS", "3", "a", "b", "x"), "*3\r\n:100\r\n:-1\r\n:-2\r\n")
+	pttl := execCmd(e, c, "hpttl", "h", "fields", "1", "a").(*protocol.ArrayReply).Replies[0].(*protocol.IntReply).Code
+	if pttl <= 99000 || pttl > 100000 {
+		t.Errorf("unexpected pttl %d", pttl)
+	}
+	assertReply(t, execCmd(e, c, "object", "encoding", "h"), "$10\r\nlistpackex\r\n")
+
+	at := time.Now().Add(time.Hour).Unix()
+	assertReply(t, execCmd(e, c, "hexpireat", "h", strconv.FormatInt(at, 10), "fields", "1", "b"), "*1\r\n:1\r\n")
+	assertReply(t, execCmd(e, c, "hexpiretime", "h", "fields", "1", "b"), "*1\r\n:"+strconv.FormatInt(at, 10)+"\r\n")
+	assertReply(t, execCmd(e, c, "hpexpiretime", "h", "fields", "1", "b"), "*1\r\n:"+strconv.FormatInt(at*1000, 10)+"\r\n")
+
+	assertReply(t, execCmd(e, c, "hpersist", "h", "fields", "3", "a", "c", "x"), "*3\r\n:1\r\n:-1\r\n:-2\r\n")
+	assertReply(t, execCmd(e, c, "httl", "h", "fields", "1", "a"), "*1\r\n:-1\r\n")
+	assertReply(t, execCmd(e, c, "hpersist", "none", "fields", "1", "a"), "*1\r\n:-2\r\n")
+
+	// HSET replaces the field with its ttl, HINCRBY keeps the ttl
+	execCmd(e, c, "hexpire", "h", "100", "fields", "2", "a", "c")
+	execCmd(e, c, "hset", "h", "a", "5")
+	execCmd(e, c, "hincrby", "h", "c", "1")
+	assertReply(t, execCmd(e, c, "httl", "h", "fields", "2", "a", "c"), "*2\r\n:-1\r\n:100\r\n")
+
+	assertReply(t, execCmd(e, c, "hexpire", "h", "x", "fields", "1", "a"), "-ERR value is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "hexpire", "h", "-1", "fields", "1", "a"), "-ERR invalid expire time in 'hexpire' command\r\n")
+	assertReply(t, execCmd(e, c, "hexpire", "h", "10", "field", "1", "a"),
+		"-ERR Mandatory argument FIELDS is missing or not at the right position\r\n")
+	assertReply(t, execCmd(e, c, "hexpire", "h", "10", "nx", "xx", "fields", "1", "a"),
+		"-ERR Mandatory argument FIELDS is missing or not at the right position\r\n")
+	assertReply(t, execCmd(e, c, "hexpire", "h", "10", "fields", "0", "a"), "-ERR Parameter `numFields` should be greater than 0\r\n")
+	assertReply(t, execCmd(e, c, "hexpire", "h", "10", "fields", "2", "a"),
+		"-ERR The `numfields` parameter must match the number of arguments\r\n")
+	assertReply(t, execCmd(e, c, "httl", "h", "fields", "2", "a", "b", "c"),
+		"-ERR The `numfields` parameter must match the number of arguments\r\n")
+	execCmd(e, c, "set", "s", "1")
+	assertReply(t, execCmd(e, c, "hexpire", "s", "10", "fields", "1", "a"),
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	// an expire time in the past deletes the fields at once, and the key with its last field
+	assertReply(t, execCmd(e, c, "hpexpire", "h", "0", "fields", "2", "a", "x"), "*2\r\n:2\r\n:-2\r\n")
+	assertReply(t, execCmd(e, c, "hexists", "h", "a"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "hexpireat", "h", "1", "fields", "2", "b", "c"), "*2\r\n:2\r\n:2\r\n")
+	assertReply(t, execCmd(e, c, "exists", "h"), ":0\r\n")
+}
+
+func TestHashExpireFlags(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "hset", "h", "a", "1", "b", "2")
+	execCmd(e, c, "hexpire", "h", "100", "fields", "1", "a")
+	assertReply(t, execCmd(e, c, "hexpire", "h", "200", "nx", "fields", "2", "a", "b"), "*2\r\n:0\r\n:1\r\n")
+	execCmd(e, c, "hpersist", "h", "fields", "1", "b")
+	assertReply(t, execCmd(e, c, "hexpire", "h", "300", "xx", "fields", "2", "a", "b"), "*2\r\n:1\r\n:0\r\n")
+	// no ttl means infinite ttl, so GT never succeeds and LT always succeeds
+	assertReply(t, execCmd(e, c, "hexpire", "h", "400", "GT", "fields", "2", "a", "b"), "*2\r\n:1\r\n:0\r\n")
+	assertReply(t, execCmd(e, c, "hexpire", "h", "500", "lt", "fields", "2", "a", "b"), "*2\r\n:0\r\n:1\r\n")
+	assertReply(t, execCmd(e, c, "httl", "h", "fields", "2", "a", "b"), "*2\r\n:400\r\n:500\r\n")
+}
+
+func TestHashLazyExpire(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "hset", "h", "a", "1", "b", "2", "c", "3")
+	execCmd(e, c, "hpexpire", "h", "20", "fields", "2", "a", "b")
+	time.Sleep(30 * time.Millisecond)
+	// the time wheel has not run yet, reads must not see the expired fields
+	assertReply(t, execCmd(e, c, "hget", "h", "a"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "hlen", "h"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "hgetall", "h"), "*2\r\n$1\r\nc\r\n$1\r\n3\r\n")
+	assertReply(t, execCmd(e, c, "hrandfield", "h", "-3"), "*3\r\n$1\r\nc\r\n$1\r\nc\r\n$1\r\nc\r\n")
+	assertReply(t, execCmd(e, c, "hscan", "h", "0"), "*2\r\n$1\r\n0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n")
+	assertReply(t, execCmd(e, c, "httl", "h", "fields", "1", "a"), "*1\r\n:-2\r\n")
+	assertReply(t, execCmd(e, c, "hdel", "h", "a"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "hset", "h", "b", "4"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "hlen", "h"), ":2\r\n")
+
+	// a hash whose fields have all expired does not exist
+	execCmd(e, c, "hpexpire", "h", "20", "fields", "2", "b", "c")
+	time.Sleep(30 * time.Millisecond)
+	assertReply(t, execCmd(e, c, "exists", "h"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "type", "h"), "+none\r\n")
+	assertReply(t, execCmd(e, c, "keys", "*"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "hset", "h", "x", "1"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "hgetall", "h"), "*2\r\n$1\r\nx\r\n$1\r\n1\r\n")
+}
+
+func TestHRandFieldWhileExpiring(t *testing.T) {
+	config.GlobalConfig.HashMaxListpackEntries = 0
+	defer func() { config.GlobalConfig.HashMaxListpackEntries = 128 }()
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	for i := 0; i < 20; i++ {
+		key := "h" + strconv.Itoa(i)
+		execCmd(e, c, "hset", key, "a", "1", "b", "2", "c", "3", "d", "4", "e", "5")
+		execCmd(e, c, "hpexpire", key, "1", "fields", "4", "b", "c", "d", "e")
+		if i%2 == 0 {
+			execCmd(e, c, "hpexpire", key, "1", "fields", "1", "a")
+		}
+	}
+	// the fields expire while HRANDFIELD samples them, it must neither hang nor panic
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for deadline := time.Now().Add(20 * time.Millisecond); time.Now().Before(deadline); {
+			for i := 0; i < 20; i++ {
+				key := "h" + strconv.Itoa(i)
+				execCmd(e, c, "hrandfield", key)
+				execCmd(e, c, "hrandfield", key, "4")
+				execCmd(e, c, "hrandfield", key, "-3", "withvalues")
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("HRANDFIELD does not return once the fields expire")
+	}
+	assertReply(t, execCmd(e, c, "hrandfield", "h1", "-2"), "*2\r\n$1\r\na\r\n$1\r\na\r\n")
+}
+
+func TestHashActiveExpire(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	db, _ := e.selectDB(0)
+	var mu sync.Mutex
+	var aof [][][]byte
+	db.writeAof = func(cmdLine [][]byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		aof = append(aof, cmdLine)
+	}
+	execCmd(e, c, "hset", "h", "a", "1", "b", "2")
+	execCmd(e, c, "hset", "g", "a", "1", "b", "2")
+	expireAt := time.Now().Add(200 * time.Millisecond)
+	at := strconv.FormatInt(expireAt.UnixMilli(), 10)
+	assertReply(t, execCmd(e, c, "hpexpireat", "h", at, "fields", "1", "a"), "*1\r\n:1\r\n")
+	assertReply(t, execCmd(e, c, "hpexpireat", "g", at, "fields", "2", "a", "b"), "*2\r\n:1\r\n:1\r\n")
+	// the ttl of fields is kept by RENAME
+	assertReply(t, execCmd(e, c, "rename", "g", "r"), "+OK\r\n")
+
+	// the task of the time wheel is run at once instead of waiting for its tick
+	time.Sleep(time.Until(expireAt) + time.Millisecond)
+	db.activeExpireFields("h")
+	db.activeExpireFields("r")
+	if _, ok := db.dataDict.Get("r"); ok {
+		t.Fatal("the hash whose fields have all expired is not removed")
+	}
+	raw, _ := db.dataDict.Get("h")
+	if h := raw.(*payload.DataEntity).RedisObject.(hash.Hash); h.Len() != 1 || len(h.RemoveExpired(time.Now())) != 0 {
+		t.Fatal("the expired field is not removed")
+	}
+
+	// the time wheel runs the task by itself, the margin is generous over its 1s tick
+	execCmd(e, c, "hset", "w", "a", "1")
+	execCmd(e, c, "hpexpire", "w", "1", "fields", "1", "a")
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, ok := db.dataDict.Get("w"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the hash whose fields have all expired is not removed by the time wheel")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// expired fields are propagated as HDEL, and a key without live fields as DEL
+	deleted := make(map[string]string)
+	for _, cmdLine := range aof {
+		if cmd := string(cmdLine[0]); cmd == "hdel" || cmd == "del" {
+			deleted[string(cmdLine[1])] = string(bytes.Join(cmdLine, []byte(" ")))
+		}
+	}
+	if deleted["h"] != "hdel h a" || deleted["r"] != "del r" {
+		t.Errorf("unexpected propagation of expiration %q", aof)
+	}
+}
+
+func TestHashExpirePropagation(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	db, _ := e.selectDB(0)
+	var aof [][][]byte
+	db.writeAof = func(cmdLine [][]byte) {
+		aof = append(aof, cmdLine)
+	}
+	execCmd(e, c, "hset", "h", "a", "1", "b", "2")
+	execCmd(e, c, "hexpire", "h", "100", "fields", "2", "a", "x")
+	last := aof[len(aof)-1]
+	if len(last) != 6 || string(last[0]) != "hpexpireat" || string(last[4]) != "1" || string(last[5]) != "a" {
+		t.Errorf("expect HEXPIRE propagated as HPEXPIREAT, got %q", last)
+	}
+	execCmd(e, c, "hincrbyfloat", "h", "a", "1.5")
+	if len(aof) < 2 || string(aof[len(aof)-2][0]) != "hset" || string(aof[len(aof)-1][0]) != "hpexpireat" {
+		t.Errorf("expect HINCRBYFLOAT propagated with the ttl, got %q", aof)
+	}
+
+	// the ttl of fields is rebuilt by the undo logs
+	undo := e.GetUndoLogs(0, toArgs("hpersist", "h", "fields", "1", "a"))
+	last = undo[len(undo)-1]
+	if string(last[0]) != "hpexpireat" || string(last[1]) != "h" || string(last[5]) != "a" {
+		t.Errorf("expect undo log restoring the ttl of fields, got %q", undo)
+	}
+
+	// COPY keeps the ttl of fields, also after the conversion to hashtable
+	for i := 0; i < 200; i++ {
+		execCmd(e, c, "hset", "h", strconv.Itoa(i), "v")
+	}
+	assertReply(t, execCmd(e, c, "object", "encoding", "h"), "$9\r\nhashtable\r\n")
+	assertReply(t, execCmd(e, c, "copy", "h", "h2"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "httl", "h2", "fields", "2", "a", "b"), "*2\r\n:100\r\n:-1\r\n")
+}
+
+func TestHashExpireRollback(t *testing.T) {
+	testRollback(t, rollbackCase{
+		name:  "hash field ttl",
+		setup: [][]string{{"hset", "h", "a", "2.5", "b", "1"}, {"hexpire", "h", "100", "fields", "1", "a"}},
+		cmds:  [][]string{{"hpersist", "h", "fields", "1", "a"}, {"hexpire", "h", "50", "fields", "1", "b"}, {"hincrby", "h", "b", "1"}},
+		reads: [][]string{{"httl", "h", "fields", "2", "a", "b"}, {"hmget", "h", "a", "b"}},
+	})
+}
+
+func TestHashExpireAOF(t *testing.T) {
+	useAof(t)
+	e := newAofEngine(t)
+	c := connection.NewFakeConn()
+	execCmd(e, c, "hset", "h", "a", "1", "b", "2", "c", "3", "d", "4")
+	execCmd(e, c, "hexpire", "h", "100", "fields", "2", "a", "b")
+	execCmd(e, c, "hpexpireat", "h", strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10), "fields", "1", "c")
+	execCmd(e, c, "hpersist", "h", "fields", "1", "b")
+	execCmd(e, c, "hincrbyfloat", "h", "a", "0.5")
+	// HSET drops the ttl of d, a ttl in the past deletes the field
+	execCmd(e, c, "hexpire", "h", "50", "fields", "1", "d")
+	execCmd(e, c, "hset", "h", "d", "5")
+	execCmd(e, c, "hset", "h", "e", "6")
+	execCmd(e, c, "hexpireat", "h", "1", "fields", "1", "e")
+	execCmd(e, c, "hset", "g", "x", "1")
+	execCmd(e, c, "hexpire", "g", "200", "fields", "1", "x")
+	execCmd(e, c, "rename", "g", "r")
+
+	dump := func(e *Engine) string {
+		c := connection.NewFakeConn()
+		var b strings.Builder
+		for _, key := range []string{"h", "g", "r"} {
+			b.Write(execCmd(e, c, "hgetall", key).ToBytes())
+			b.Write(execCmd(e, c, "hpexpiretime", key, "fields", "5", "a", "b", "c", "d", "x").ToBytes())
+		}
+		return b.String()
+	}
+	expected := dump(e)
+
+	e = reloadAof(t, e)
+	if got := dump(e); got != expected {
+		t.Errorf("AOF load differs:\n%q\n%q", got, expected)
+	}
+
+	if err := e.persister.rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	e = reloadAof(t, e)
+	if got := dump(e); got != expected {
+		t.Errorf("AOF rewrite differs:\n%q\n%q", got, expected)
+	}
+}
@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/issimo1/redis-issimo/datastruct/hyperloglog"
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/config"
+)
+
+// getAsHLL returns the HyperLogLog of key, or nil if key does not exist.
+// The HyperLogLog holds a copy of the value, which may be held by replies not yet sent.
+func (db *DB) getAsHLL(key string) (*hyperloglog.HLL, protocol.ErrorReply) {
+	value, errReply := db.getAsString(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if value == nil {
+		return nil, nil
+	}
+	h, err := hyperloglog.Parse(bytes.Clone(value))
+	if errors.Is(err, hyperloglog.ErrCorrupted) {
+		return nil, protocol.MakeErrReply("INVALIDOBJ Corrupted HLL object detected")
+	} else if err != nil {
+		return nil, protocol.MakeErrReply("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	}
+	return h, nil
+}
+
+// putHLL binds h to key, the ttl of key is kept
+func (db *DB) putHLL(key string, h *hyperloglog.HLL) {
+	db.PutEntity(key, &payload.DataEntity{RedisObject: h.Bytes(config.GlobalConfig.HllSparseMaxBytes)})
+}
+
+// execPFAdd adds elements to the HyperLogLog, returns 1 if its cardinality may be changed, PFADD key [element ...]
+func execPFAdd(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	h, errReply := db.getAsHLL(key)
+	if errReply != nil {
+		return errReply
+	}
+	updated := false
+	if h == nil {
+		h = hyperloglog.New()
+		updated = true
+	}
+	for _, element := range args[1:] {
+		if h.Add(element) {
+			updated = true
+		}
+	}
+	if !updated {
+		return protocol.MakeIntReply(0)
+	}
+	db.putHLL(key, h)
+	db.writeAof(toCmdLine3("pfadd", args))
+	return protocol.MakeIntReply(1)
+}
+
+// preparePFCount write locks a single key to cache its cardinality, PFCOUNT key [key ...]
+func preparePFCount(args [][]byte) ([]string, []string) {
+	if len(args) == 1 {
+		return writeFirstKey(args)
+	}
+	return readAllKeys(args)
+}
+
+func undoPFCount(db *DB, args [][]byte) [][][]byte {
+	if len(args) == 1 {
+		return rollbackFirstKey(db, args)
+	}
+	return nil
+}
+
+// execPFCount returns the estimated cardinality of the union of HyperLogLogs, PFCOUNT key [key ...]
+func execPFCount(db *DB, args [][]byte) protocol.Reply {
+	if len(args) == 1 {
+		key := string(args[0])
+		h, errReply := db.getAsHLL(key)
+		if errReply != nil {
+			return errReply
+		}
+		if h == nil {
+			return protocol.MakeIntReply(0)
+		}
+		card, updated := h.Count()
+		if updated {
+			db.putHLL(key, h)
+			db.writeAof(toCmdLine3("pfcount", args))
+		}
+		return protocol.MakeIntReply(int64(card))
+	}
+	regs := make([]uint8, hyperloglog.Registers)
+	for _, arg := range args {
+		h, errReply := db.getAsHLL(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		if h != nil {
+			h.Union(regs)
+		}
+	}
+	return protocol.MakeIntReply(int64(hyperloglog.CountRegisters(regs)))
+}
+
+// preparePFMerge locks destkey for write and the source keys for read
+func preparePFMerge(args [][]byte) ([]string, []string) {
+	return toStrings(args[1:]), []string{string(args[0])}
+}
+
+// execPFMerge stores the union of destkey and the source HyperLogLogs to destkey, PFMERGE destkey [sourcekey ...]
+func execPFMerge(db *DB, args [][]byte) protocol.Reply {
+	regs := make([]uint8, hyperloglog.Registers)
+	// the result is dense if any of the inputs is dense
+	dense := false
+	var dest *hyperloglog.HLL
+	for i, arg := range args {
+		h, errReply := db.getAsHLL(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		if h == nil {
+			continue
+		}
+		if i == 0 {
+			dest = h
+		}
+		dense = dense || h.IsDense()
+		h.Union(regs)
+	}
+	if dest == nil {
+		dest = hyperloglog.New()
+	}
+	if dense {
+		dest.ToDense()
+	}
+	dest.Merge(regs)
+	db.putHLL(string(args[0]), dest)
+	db.writeAof(toCmdLine3("pfmerge", args))
+	return protocol.MakeOkReply()
+}
+
+func init() {
+	registerCommand("PFAdd", -2, execPFAdd, writeFirstKey, rollbackFirstKey)
+	registerCommand("PFCount", -2, execPFCount, preparePFCount, undoPFCount)
+	registerCommand("PFMerge", -2, execPFMerge, preparePFMerge, rollbackFirstKey)
+}
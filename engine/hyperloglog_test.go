@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+func pfAddRange(e *Engine, c *connection.FakeConn, key string, from, to int) {
+	args := []string{"pfadd", key}
+	for i := from; i < to; i++ {
+		args = append(args, strconv.Itoa(i))
+	}
+	execCmd(e, c, args...)
+}
+
+func assertCardinality(t *testing.T, reply protocol.Reply, n int) {
+	t.Helper()
+	r, ok := reply.(*protocol.IntReply)
+	if !ok {
+		t.Fatalf("got %q", reply.ToBytes())
+	}
+	if e := math.Abs(float64(r.Code)-float64(n)) / float64(n); e > 0.02 {
+		t.Errorf("got cardinality %d, want %d", r.Code, n)
+	}
+}
+
+func TestPFAdd(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "pfadd", "h"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "pfadd", "h"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "get", "h"), "$18\r\nHYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff\r\n")
+	assertReply(t, execCmd(e, c, "pfcount", "h"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "pfadd", "h", "a", "b", "c"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "pfadd", "h", "a", "b"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "pfcount", "h"), ":3\r\n")
+	assertReply(t, execCmd(e, c, "pfcount", "none"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "type", "h"), "+string\r\n")
+
+	// the value round-trips through GET and SET
+	value := execCmd(e, c, "get", "h").(*protocol.BulkReply).Arg
+	execCmd(e, c, "set", "copy", string(value))
+	assertReply(t, execCmd(e, c, "pfcount", "copy"), ":3\r\n")
+	assertReply(t, execCmd(e, c, "pfadd", "copy", "c"), ":0\r\n")
+
+	execCmd(e, c, "set", "str", "hello")
+	assertReply(t, execCmd(e, c, "pfadd", "str", "a"), "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n")
+	assertReply(t, execCmd(e, c, "pfcount", "str"), "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n")
+	execCmd(e, c, "lpush", "list", "a")
+	assertReply(t, execCmd(e, c, "pfadd", "list", "a"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	// a broken sparse representation is detected
+	execCmd(e, c, "append", "copy", "hello")
+	assertReply(t, execCmd(e, c, "pfcount", "copy"), "-INVALIDOBJ Corrupted HLL object detected\r\n")
+	execCmd(e, c, "setrange", "h", "4", "x")
+	assertReply(t, execCmd(e, c, "pfadd", "h", "a"), "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n")
+}
+
+func TestPFCount(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	pfAddRange(e, c, "small", 0, 1000)
+	assertCardinality(t, execCmd(e, c, "pfcount", "small"), 1000)
+	for i := 0; i < 1000000; i += 10000 {
+		pfAddRange(e, c, "big", i, i+10000)
+	}
+	assertCardinality(t, execCmd(e, c, "pfcount", "big"), 1000000)
+	if n := len(execCmd(e, c, "get", "big").(*protocol.BulkReply).Arg); n != 12304 {
+		t.Errorf("dense HyperLogLog takes %d bytes", n)
+	}
+	assertCardinality(t, execCmd(e, c, "pfcount", "small", "big", "none"), 1000000)
+	pfAddRange(e, c, "other", 500, 2500)
+	assertCardinality(t, execCmd(e, c, "pfcount", "small", "other"), 2500)
+
+	// the cardinality is cached in the header until the registers change
+	execCmd(e, c, "set", "h", "HYLL\x01\x00\x00\x00\x2a\x00\x00\x00\x00\x00\x00\x00\x7f\xff")
+	assertReply(t, execCmd(e, c, "pfcount", "h"), ":42\r\n")
+	execCmd(e, c, "pfadd", "h", "a")
+	assertReply(t, execCmd(e, c, "pfcount", "h"), ":1\r\n")
+	value := execCmd(e, c, "get", "h").(*protocol.BulkReply).Arg
+	if value[15]&0x80 != 0 || value[8] != 1 {
+		t.Errorf("cardinality is not cached: %q", value)
+	}
+}
+
+func TestPFMerge(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	pfAddRange(e, c, "a", 0, 1000)
+	pfAddRange(e, c, "b", 500, 2000)
+	assertReply(t, execCmd(e, c, "pfmerge", "dest", "a", "b", "none"), "+OK\r\n")
+	assertCardinality(t, execCmd(e, c, "pfcount", "dest"), 2000)
+	// the destination is merged too
+	pfAddRange(e, c, "c", 5000, 6000)
+	assertReply(t, execCmd(e, c, "pfmerge", "dest", "c"), "+OK\r\n")
+	assertCardinality(t, execCmd(e, c, "pfcount", "dest"), 3000)
+
+	assertReply(t, execCmd(e, c, "pfmerge", "empty", "none"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "pfcount", "empty"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "pfmerge", "a"), "+OK\r\n")
+	assertCardinality(t, execCmd(e, c, "pfcount", "a"), 1000)
+
+	// a dense input makes the destination dense
+	pfAddRange(e, c, "dense", 0, 5000)
+	assertReply(t, execCmd(e, c, "pfmerge", "d", "dense"), "+OK\r\n")
+	if n := len(execCmd(e, c, "get", "d").(*protocol.BulkReply).Arg); n != 12304 {
+		t.Errorf("merged HyperLogLog takes %d bytes", n)
+	}
+	execCmd(e, c, "set", "str", "hello")
+	assertReply(t, execCmd(e, c, "pfmerge", "dest", "str"), "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n")
+}
+
+func TestHLLAOF(t *testing.T) {
+	useAof(t)
+	e := newAofEngine(t)
+	c := connection.NewFakeConn()
+	pfAddRange(e, c, "a", 0, 100)
+	pfAddRange(e, c, "b", 0, 5000)
+	execCmd(e, c, "pfcount", "b")
+	execCmd(e, c, "pfmerge", "c", "a", "b")
+	execCmd(e, c, "pfadd", "a", "0")
+	want := make(map[string]string)
+	for _, key := range []string{"a", "b", "c"} {
+		want[key] = string(execCmd(e, c, "get", key).ToBytes())
+	}
+
+	e = reloadAof(t, e)
+	for _, key := range []string{"a", "b", "c"} {
+		assertReply(t, execCmd(e, c, "get", key), want[key])
+	}
+	if err := e.persister.rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	e = reloadAof(t, e)
+	for _, key := range []string{"a", "b", "c"} {
+		assertReply(t, execCmd(e, c, "get", key), want[key])
+	}
+}
+
+func TestHLLRollback(t *testing.T) {
+	testRollback(t, rollbackCase{
+		name:  "hyperloglog",
+		setup: [][]string{{"pfadd", "a", "1", "2"}, {"pfadd", "b", "3"}},
+		cmds:  [][]string{{"pfadd", "a", "x", "y"}, {"pfmerge", "a", "b"}, {"pfmerge", "c", "a"}},
+		reads: [][]string{{"get", "a"}, {"exists", "c"}},
+	})
+}
@@ -0,0 +1,747 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/issimo1/redis-issimo/datastruct/json"
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+// The JSON commands follow RedisJSON. A JSONPath, which starts with $, replies an array of the results of each
+// selected value, a legacy path replies the result of the first selected value and fails if nothing is selected.
+
+// getAsJSON returns the document of key, or nil if key does not exist
+func (db *DB) getAsJSON(key string) (*json.Document, protocol.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	doc, ok := entity.RedisObject.(*json.Document)
+	if !ok {
+		return nil, protocol.MakeWrongTypeErrReply()
+	}
+	return doc, nil
+}
+
+func parseJSONPath(arg []byte) (*json.Path, protocol.ErrorReply) {
+	path, err := json.ParsePath(string(arg))
+	if err != nil {
+		return nil, protocol.MakeErrReply("ERR " + err.Error())
+	}
+	return path, nil
+}
+
+// parseOptionalJSONPath parses the path at index i of args, the root is the default legacy path
+func parseOptionalJSONPath(args [][]byte, i int) (*json.Path, protocol.ErrorReply) {
+	if i >= len(args) {
+		return parseJSONPath([]byte("."))
+	}
+	return parseJSONPath(args[i])
+}
+
+func parseJSONValue(arg []byte) (any, protocol.ErrorReply) {
+	v, err := json.Parse(arg)
+	if err != nil {
+		return nil, protocol.MakeErrReply("ERR " + err.Error())
+	}
+	return v, nil
+}
+
+func pathNotExistErr(path *json.Path) protocol.ErrorReply {
+	return protocol.MakeErrReply(fmt.Sprintf("ERR Path '%s' does not exist", path))
+}
+
+// pathTypeErr is replied if a legacy path selects a value of a wrong type
+func pathTypeErr(expected string, v any) protocol.ErrorReply {
+	return protocol.MakeErrReply(fmt.Sprintf("WRONGTYPE wrong type of path value - expected %s but found %s", expected, json.TypeName(v)))
+}
+
+// valueTypeErr is replied if an argument is a value of a wrong type
+func valueTypeErr(expected string, v any) protocol.ErrorReply {
+	return protocol.MakeErrReply(fmt.Sprintf("ERR wrong type of value - expected %s but found %s", expected, json.TypeName(v)))
+}
+
+func noJSONKeyErr() protocol.ErrorReply {
+	return protocol.MakeErrReply("ERR could not perform this operation on a key that doesn't exist")
+}
+
+func rootOnlyErr() protocol.ErrorReply {
+	return protocol.MakeErrReply("ERR new objects must be created at the root")
+}
+
+// nodeValues returns the values of nodes as an array, the values are shared
+func nodeValues(nodes []*json.Node) *json.Array {
+	a := json.NewArray()
+	for _, n := range nodes {
+		a.Append(n.Value)
+	}
+	return a
+}
+
+// execJSONSet sets the value at path, JSON.SET key path value [NX|XX]
+func execJSONSet(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	path, errReply := parseJSONPath(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	value, errReply := parseJSONValue(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	nx, xx := false, false
+	for _, arg := range args[3:] {
+		switch strings.ToLower(string(arg)) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	if nx && xx {
+		return protocol.MakeSyntaxErrReply()
+	}
+	doc, errReply := db.getAsJSON(key)
+	if errReply != nil {
+		return errReply
+	}
+	if doc == nil {
+		if xx {
+			return protocol.MakeNullBulkReply()
+		}
+		if !path.IsRoot() {
+			return rootOnlyErr()
+		}
+		db.PutEntity(key, &payload.DataEntity{RedisObject: &json.Document{Root: value}})
+		db.writeAof(toCmdLine3("json.set", args))
+		return protocol.MakeOkReply()
+	}
+	exists := len(doc.Select(path)) > 0
+	if nx && exists || xx && !exists {
+		return protocol.MakeNullBulkReply()
+	}
+	if doc.Set(path, value) == 0 {
+		return protocol.MakeNullBulkReply()
+	}
+	db.writeAof(toCmdLine3("json.set", args))
+	return protocol.MakeOkReply()
+}
+
+// execJSONGet returns the values at paths, JSON.GET key [INDENT indent] [NEWLINE newline] [SPACE space] [path ...]
+func execJSONGet(db *DB, args [][]byte) protocol.Reply {
+	var format json.Format
+	var paths []*json.Path
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		if option == "noescape" {
+			continue
+		}
+		if i+1 < len(args) && (option == "indent" || option == "newline" || option == "space") {
+			switch option {
+			case "indent":
+				format.Indent = string(args[i+1])
+			case "newline":
+				format.Newline = string(args[i+1])
+			default:
+				format.Space = string(args[i+1])
+			}
+			i++
+			continue
+		}
+		path, errReply := parseJSONPath(args[i])
+		if errReply != nil {
+			return errReply
+		}
+		paths = append(paths, path)
+	}
+	doc, errReply := db.getAsJSON(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if doc == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	if len(paths) == 0 {
+		return protocol.MakeBulkReply(format.Marshal(doc.Root))
+	}
+	if len(paths) == 1 {
+		path := paths[0]
+		nodes := doc.Select(path)
+		if !path.IsLegacy() {
+			return protocol.MakeBulkReply(format.Marshal(nodeValues(nodes)))
+		}
+		if len(nodes) == 0 {
+			return pathNotExistErr(path)
+		}
+		return protocol.MakeBulkReply(format.Marshal(nodes[0].Value))
+	}
+	// the values of multiple paths are replied as an object keyed by the paths,
+	// they are all arrays if any of the paths is a JSONPath
+	legacy := true
+	for _, path := range paths {
+		legacy = legacy && path.IsLegacy()
+	}
+	result := json.NewObject()
+	for _, path := range paths {
+		nodes := doc.Select(path)
+		if !legacy {
+			result.Set(path.String(), nodeValues(nodes))
+			continue
+		}
+		if len(nodes) == 0 {
+			return pathNotExistErr(path)
+		}
+		result.Set(path.String(), nodes[0].Value)
+	}
+	return protocol.MakeBulkReply(format.Marshal(result))
+}
+
+// execJSONMGet returns the values at path of multiple keys, JSON.MGET key [key ...] path
+func execJSONMGet(db *DB, args [][]byte) protocol.Reply {
+	path, errReply := parseJSONPath(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	replies := make([]protocol.Reply, 0, len(args)-1)
+	for _, arg := range args[:len(args)-1] {
+		doc, errReply := db.getAsJSON(string(arg))
+		if errReply != nil || doc == nil {
+			replies = append(replies, protocol.MakeNullBulkReply())
+			continue
+		}
+		nodes := doc.Select(path)
+		switch {
+		case !path.IsLegacy():
+			replies = append(replies, protocol.MakeBulkReply(json.Marshal(nodeValues(nodes))))
+		case len(nodes) == 0:
+			replies = append(replies, protocol.MakeNullBulkReply())
+		default:
+			replies = append(replies, protocol.MakeBulkReply(json.Marshal(nodes[0].Value)))
+		}
+	}
+	return protocol.MakeArrayReply(replies)
+}
+
+func prepareJSONMGet(args [][]byte) ([]string, []string) {
+	return readAllKeys(args[:len(args)-1])
+}
+
+// execJSONDel deletes the values at path and returns the number of values deleted, JSON.DEL key [path]
+func execJSONDel(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 2 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	key := string(args[0])
+	path, errReply := parseOptionalJSONPath(args, 1)
+	if errReply != nil {
+		return errReply
+	}
+	doc, errReply := db.getAsJSON(key)
+	if errReply != nil {
+		return errReply
+	}
+	if doc == nil {
+		return protocol.MakeIntReply(0)
+	}
+	deleted := 1
+	if path.IsRoot() {
+		db.Remove(key)
+	} else {
+		deleted = doc.Delete(path)
+	}
+	if deleted > 0 {
+		db.writeAof(toCmdLine3("json.del", args))
+	}
+	return protocol.MakeIntReply(int64(deleted))
+}
+
+// execJSONType returns the types of the values at path, JSON.TYPE key [path]
+func execJSONType(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 2 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	path, errReply := parseOptionalJSONPath(args, 1)
+	if errReply != nil {
+		return errReply
+	}
+	doc, errReply := db.getAsJSON(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if doc == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	nodes := doc.Select(path)
+	if path.IsLegacy() {
+		if len(nodes) == 0 {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeStatusReply(json.TypeName(nodes[0].Value))
+	}
+	types := make([][]byte, len(nodes))
+	for i, n := range nodes {
+		types[i] = []byte(json.TypeName(n.Value))
+	}
+	return protocol.MakeMultiBulkReply(types)
+}
+
+// jsonModifier describes how a command modifies the values selected by a path
+type jsonModifier struct {
+	// expected is the type of the values accepted, it is reported by the error of a legacy path
+	expected string
+	// accepts reports whether v is modified, the result of the other values is nil
+	accepts func(v any) bool
+	// validate checks an accepted value before any value is modified, it may be nil
+	validate func(v any) protocol.ErrorReply
+	// modify modifies the value of n and returns the result
+	modify func(n *json.Node) any
+}
+
+// modifyJSON applies m to the values at path and propagates the command if any value is accepted.
+// It returns the result of each value selected, a legacy path must select a value and all of its values must be accepted.
+func (db *DB) modifyJSON(cmd string, args [][]byte, path *json.Path, m *jsonModifier) ([]any, protocol.ErrorReply) {
+	doc, errReply := db.getAsJSON(string(args[0]))
+	if errReply != nil {
+		return nil, errReply
+	}
+	if doc == nil {
+		return nil, noJSONKeyErr()
+	}
+	nodes := doc.Select(path)
+	if path.IsLegacy() && len(nodes) == 0 {
+		return nil, pathNotExistErr(path)
+	}
+	accepted := 0
+	for _, n := range nodes {
+		if !m.accepts(n.Value) {
+			if path.IsLegacy() {
+				return nil, pathTypeErr(m.expected, n.Value)
+			}
+			continue
+		}
+		if m.validate != nil {
+			if errReply := m.validate(n.Value); errReply != nil {
+				return nil, errReply
+			}
+		}
+		accepted++
+	}
+	results := make([]any, len(nodes))
+	for i, n := range nodes {
+		if m.accepts(n.Value) {
+			results[i] = m.modify(n)
+		}
+	}
+	if accepted > 0 {
+		db.writeAof(toCmdLine3(cmd, args))
+	}
+	return results, nil
+}
+
+// makeJSONIntsReply replies the lengths returned by modifyJSON or the read commands
+func makeJSONIntsReply(path *json.Path, results []any) protocol.Reply {
+	if path.IsLegacy() {
+		return protocol.MakeIntReply(results[0].(int64))
+	}
+	replies := make([]protocol.Reply, len(results))
+	for i, result := range results {
+		if result == nil {
+			replies[i] = protocol.MakeNullBulkReply()
+		} else {
+			replies[i] = protocol.MakeIntReply(result.(int64))
+		}
+	}
+	return protocol.MakeArrayReply(replies)
+}
+
+func isNumber(v any) bool {
+	switch v.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+func isString(v any) bool {
+	_, ok := v.(string)
+	return ok
+}
+
+func isArray(v any) bool {
+	_, ok := v.(*json.Array)
+	return ok
+}
+
+func isObject(v any) bool {
+	_, ok := v.(*json.Object)
+	return ok
+}
+
+// addNumbers returns the sum of two numbers, which is an integer unless a float is added or it overflows
+func addNumbers(a, b any) any {
+	x, ok1 := a.(int64)
+	y, ok2 := b.(int64)
+	if ok1 && ok2 {
+		sum := x + y
+		if (sum > x) == (y > 0) {
+			return sum
+		}
+	}
+	return toFloat64(a) + toFloat64(b)
+}
+
+func toFloat64(v any) float64 {
+	if n, ok := v.(int64); ok {
+		return float64(n)
+	}
+	return v.(float64)
+}
+
+// execJSONNumIncrBy increments the numbers at path, JSON.NUMINCRBY key path value
+func execJSONNumIncrBy(db *DB, args [][]byte) protocol.Reply {
+	path, errReply := parseJSONPath(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	incr, errReply := parseJSONValue(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	if !isNumber(incr) {
+		return valueTypeErr("number", incr)
+	}
+	results, errReply := db.modifyJSON("json.numincrby", args, path, &jsonModifier{
+		expected: "number",
+		accepts:  isNumber,
+		validate: func(v any) protocol.ErrorReply {
+			if f, ok := addNumbers(v, incr).(float64); ok && (math.IsInf(f, 0) || math.IsNaN(f)) {
+				return protocol.MakeErrReply("ERR result is not a finite number")
+			}
+			return nil
+		},
+		modify: func(n *json.Node) any {
+			n.Replace(addNumbers(n.Value, incr))
+			return n.Value
+		},
+	})
+	if errReply != nil {
+		return errReply
+	}
+	if path.IsLegacy() {
+		return protocol.MakeBulkReply(json.Marshal(results[0]))
+	}
+	return protocol.MakeBulkReply(json.Marshal(json.NewArray(results...)))
+}
+
+// execJSONStrAppend appends a string to the strings at path and returns their lengths, JSON.STRAPPEND key [path] value
+func execJSONStrAppend(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 3 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	path, errReply := parseOptionalJSONPath(args[:len(args)-1], 1)
+	if errReply != nil {
+		return errReply
+	}
+	value, errReply := parseJSONValue(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	suffix, ok := value.(string)
+	if !ok {
+		return valueTypeErr("string", value)
+	}
+	results, errReply := db.modifyJSON("json.strappend", args, path, &jsonModifier{
+		expected: "string",
+		accepts:  isString,
+		modify: func(n *json.Node) any {
+			s := n.Value.(string) + suffix
+			n.Replace(s)
+			return int64(len(s))
+		},
+	})
+	if errReply != nil {
+		return errReply
+	}
+	return makeJSONIntsReply(path, results)
+}
+
+// parseJSONValues parses the values to add to arrays
+func parseJSONValues(args [][]byte) ([]any, protocol.ErrorReply) {
+	result := make([]any, len(args))
+	for i, arg := range args {
+		v, errReply := parseJSONValue(arg)
+		if errReply != nil {
+			return nil, errReply
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+// copyValues returns deep copies of values, so that the arrays selected do not share them
+func copyValues(values []any) []any {
+	result := make([]any, len(values))
+	for i, v := range values {
+		result[i] = json.Copy(v)
+	}
+	return result
+}
+
+// execJSONArrAppend appends values to the arrays at path and returns their lengths,
+// JSON.ARRAPPEND key path value [value ...]
+func execJSONArrAppend(db *DB, args [][]byte) protocol.Reply {
+	path, errReply := parseJSONPath(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	elements, errReply := parseJSONValues(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	results, errReply := db.modifyJSON("json.arrappend", args, path, &jsonModifier{
+		expected: "array",
+		accepts:  isArray,
+		modify: func(n *json.Node) any {
+			a := n.Value.(*json.Array)
+			a.Append(copyValues(elements)...)
+			return int64(a.Len())
+		},
+	})
+	if errReply != nil {
+		return errReply
+	}
+	return makeJSONIntsReply(path, results)
+}
+
+// execJSONArrInsert inserts values before the index of the arrays at path and returns their lengths,
+// JSON.ARRINSERT key path index value [value ...]
+func execJSONArrInsert(db *DB, args [][]byte) protocol.Reply {
+	path, errReply := parseJSONPath(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	index, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return notIntegerErr()
+	}
+	elements, errReply := parseJSONValues(args[3:])
+	if errReply != nil {
+		return errReply
+	}
+	// normalize returns the index of an array of length n, a negative index counts from the end
+	normalize := func(n int) (int, bool) {
+		i := index
+		if i < 0 {
+			i += int64(n)
+		}
+		return int(i), i >= 0 && i <= int64(n)
+	}
+	results, errReply := db.modifyJSON("json.arrinsert", args, path, &jsonModifier{
+		expected: "array",
+		accepts:  isArray,
+		validate: func(v any) protocol.ErrorReply {
+			if _, ok := normalize(v.(*json.Array).Len()); !ok {
+				return protocol.MakeErrReply("ERR index out of bounds")
+			}
+			return nil
+		},
+		modify: func(n *json.Node) any {
+			a := n.Value.(*json.Array)
+			i, _ := normalize(a.Len())
+			a.Insert(i, copyValues(elements)...)
+			return int64(a.Len())
+		},
+	})
+	if errReply != nil {
+		return errReply
+	}
+	return makeJSONIntsReply(path, results)
+}
+
+// execJSONArrPop removes and returns the element at index of the arrays at path, JSON.ARRPOP key [path [index]]
+func execJSONArrPop(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 3 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	path, errReply := parseOptionalJSONPath(args, 1)
+	if errReply != nil {
+		return errReply
+	}
+	index := int64(-1)
+	if len(args) == 3 {
+		var err error
+		if index, err = strconv.ParseInt(string(args[2]), 10, 64); err != nil {
+			return notIntegerErr()
+		}
+	}
+	results, errReply := db.modifyJSON("json.arrpop", args, path, &jsonModifier{
+		expected: "array",
+		accepts:  isArray,
+		modify: func(n *json.Node) any {
+			a := n.Value.(*json.Array)
+			if a.Len() == 0 {
+				return nil
+			}
+			// the index is clamped into the array
+			i := index
+			if i < 0 {
+				i += int64(a.Len())
+			}
+			return json.Marshal(a.Remove(int(min(max(i, 0), int64(a.Len()-1)))))
+		},
+	})
+	if errReply != nil {
+		return errReply
+	}
+	replies := make([]protocol.Reply, len(results))
+	for i, result := range results {
+		// nothing is popped from an empty array or a value not an array
+		replies[i] = protocol.MakeNullBulkReply()
+		if result != nil {
+			replies[i] = protocol.MakeBulkReply(result.([]byte))
+		}
+	}
+	if path.IsLegacy() {
+		return replies[0]
+	}
+	return protocol.MakeArrayReply(replies)
+}
+
+// selectJSON returns the values at path, nil if key does not exist. A legacy path must select a value of the type.
+func (db *DB) selectJSON(args [][]byte, path *json.Path, expected string, accepts func(v any) bool) ([]*json.Node, protocol.ErrorReply) {
+	doc, errReply := db.getAsJSON(string(args[0]))
+	if errReply != nil || doc == nil {
+		return nil, errReply
+	}
+	nodes := doc.Select(path)
+	if !path.IsLegacy() {
+		return nodes, nil
+	}
+	if len(nodes) == 0 {
+		return nil, pathNotExistErr(path)
+	}
+	if !accepts(nodes[0].Value) {
+		return nil, pathTypeErr(expected, nodes[0].Value)
+	}
+	return nodes[:1], nil
+}
+
+// execJSONArrLen returns the lengths of the arrays at path, JSON.ARRLEN key [path]
+func execJSONArrLen(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 2 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	path, errReply := parseOptionalJSONPath(args, 1)
+	if errReply != nil {
+		return errReply
+	}
+	nodes, errReply := db.selectJSON(args, path, "array", isArray)
+	if errReply != nil {
+		return errReply
+	}
+	if nodes == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	results := make([]any, len(nodes))
+	for i, n := range nodes {
+		if a, ok := n.Value.(*json.Array); ok {
+			results[i] = int64(a.Len())
+		}
+	}
+	return makeJSONIntsReply(path, results)
+}
+
+// execJSONObjKeys returns the keys of the objects at path, JSON.OBJKEYS key [path]
+func execJSONObjKeys(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 2 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	path, errReply := parseOptionalJSONPath(args, 1)
+	if errReply != nil {
+		return errReply
+	}
+	nodes, errReply := db.selectJSON(args, path, "object", isObject)
+	if errReply != nil {
+		return errReply
+	}
+	if nodes == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	replies := make([]protocol.Reply, len(nodes))
+	for i, n := range nodes {
+		o, ok := n.Value.(*json.Object)
+		if !ok {
+			replies[i] = protocol.MakeNullArrayReply()
+			continue
+		}
+		keys := make([][]byte, o.Len())
+		for j, key := range o.Keys() {
+			keys[j] = []byte(key)
+		}
+		replies[i] = protocol.MakeMultiBulkReply(keys)
+	}
+	if path.IsLegacy() {
+		return replies[0]
+	}
+	return protocol.MakeArrayReply(replies)
+}
+
+// execJSONMerge merges a value into the values at path as RFC 7396, JSON.MERGE key path value
+func execJSONMerge(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	path, errReply := parseJSONPath(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	patch, errReply := parseJSONValue(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	doc, errReply := db.getAsJSON(key)
+	if errReply != nil {
+		return errReply
+	}
+	changed := 0
+	switch {
+	case doc == nil:
+		if !path.IsRoot() {
+			return rootOnlyErr()
+		}
+		if patch != nil {
+			db.PutEntity(key, &payload.DataEntity{RedisObject: &json.Document{Root: json.MergePatch(nil, patch)}})
+			changed = 1
+		}
+	case path.IsRoot() && patch == nil:
+		db.Remove(key)
+		changed = 1
+	default:
+		changed = doc.Merge(path, patch)
+	}
+	if changed > 0 {
+		db.writeAof(toCmdLine3("json.merge", args))
+	}
+	return protocol.MakeOkReply()
+}
+
+func init() {
+	registerCommand("JSON.Set", -4, execJSONSet, writeFirstKey, rollbackFirstKey)
+	registerCommand("JSON.Get", -2, execJSONGet, readFirstKey, nil)
+	registerCommand("JSON.MGet", -3, execJSONMGet, prepareJSONMGet, nil)
+	registerCommand("JSON.Del", -2, execJSONDel, writeFirstKey, rollbackFirstKey)
+	registerCommand("JSON.Forget", -2, execJSONDel, writeFirstKey, rollbackFirstKey)
+	registerCommand("JSON.Type", -2, execJSONType, readFirstKey, nil)
+	registerCommand("JSON.NumIncrBy", 4, execJSONNumIncrBy, writeFirstKey, rollbackFirstKey)
+	registerCommand("JSON.StrAppend", -3, execJSONStrAppend, writeFirstKey, rollbackFirstKey)
+	registerCommand("JSON.ArrAppend", -4, execJSONArrAppend, writeFirstKey, rollbackFirstKey)
+	registerCommand("JSON.ArrInsert", -5, execJSONArrInsert, writeFirstKey, rollbackFirstKey)
+	registerCommand("JSON.ArrPop", -2, execJSONArrPop, writeFirstKey, rollbackFirstKey)
+	registerCommand("JSON.ArrLen", -2, execJSONArrLen, readFirstKey, nil)
+	registerCommand("JSON.ObjKeys", -2, execJSONObjKeys, readFirstKey, nil)
+	registerCommand("JSON.Merge", 4, execJSONMerge, writeFirstKey, rollbackFirstKey)
+}
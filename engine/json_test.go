@@ -0,0 +1,234 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+// bulk returns the RESP2 bulk string of s
+func bulk(s string) string {
+	return string(protocol.MakeBulkReply([]byte(s)).ToBytes())
+}
+
+const docText = `{"a":1,"b":{"a":"x","c":[1,2,3]},"d":[{"a":2.5},{"e":null}]}`
+
+func TestJSONSetGet(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "json.set", "doc", "$", docText), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc"), bulk(docText))
+	assertReply(t, execCmd(e, c, "type", "doc"), "+ReJSON-RL\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc", "$..a"), bulk(`[1,"x",2.5]`))
+	assertReply(t, execCmd(e, c, "json.get", "doc", "$.b.c[?(@ > 1)]"), bulk(`[2,3]`))
+	assertReply(t, execCmd(e, c, "json.get", "doc", "$.none"), bulk(`[]`))
+	assertReply(t, execCmd(e, c, "json.get", "doc", ".b.c"), bulk(`[1,2,3]`))
+	assertReply(t, execCmd(e, c, "json.get", "doc", "b.c[-1]"), bulk(`3`))
+	assertReply(t, execCmd(e, c, "json.get", "doc", "..a"), bulk(`1`))
+	assertReply(t, execCmd(e, c, "json.get", "doc", ".none"), "-ERR Path '.none' does not exist\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc", ".a", "b.a"), bulk(`{".a":1,"b.a":"x"}`))
+	assertReply(t, execCmd(e, c, "json.get", "doc", ".a", "$.d[*].a"), bulk(`{".a":[1],"$.d[*].a":[2.5]}`))
+	assertReply(t, execCmd(e, c, "json.get", "doc", "indent", "\t", "newline", "\n", "space", " ", "$.d"),
+		bulk("[\n\t[\n\t\t{\n\t\t\t\"a\": 2.5\n\t\t},\n\t\t{\n\t\t\t\"e\": null\n\t\t}\n\t]\n]"))
+	assertReply(t, execCmd(e, c, "json.get", "none"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc", "$["), "-ERR invalid JSON path '$[': unexpected character '\\x00' at offset 2\r\n")
+
+	// a missing key is added to the parent objects
+	assertReply(t, execCmd(e, c, "json.set", "doc", "$.d[*].f", `"new"`), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc", "$.d"), bulk(`[[{"a":2.5,"f":"new"},{"e":null,"f":"new"}]]`))
+	assertReply(t, execCmd(e, c, "json.set", "doc", "$..a", `{"x":[]}`), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc", "$..a"), bulk(`[{"x":[]},{"x":[]},{"x":[]}]`))
+	assertReply(t, execCmd(e, c, "json.set", "doc", "$.x.y", "1"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "json.set", "doc", "$.a", "1", "nx"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "json.set", "doc", "$.z", "1", "xx"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "json.set", "doc", "$.z", "1", "nx"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "json.set", "doc", ".", `[1]`, "xx"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc"), bulk(`[1]`))
+
+	assertReply(t, execCmd(e, c, "json.set", "new", "$.a", "1"), "-ERR new objects must be created at the root\r\n")
+	assertReply(t, execCmd(e, c, "json.set", "new", "$", "1", "xx"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "json.set", "new", "$", `"str"`, "nx"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "new", "$"), bulk(`["str"]`))
+	assertReply(t, execCmd(e, c, "json.set", "new", "$", "{"), "-ERR EOF while parsing an object at line 1 column 1\r\n")
+	assertReply(t, execCmd(e, c, "json.set", "new", "$", "1", "nx", "xx"), "-ERR syntax error\r\n")
+	execCmd(e, c, "set", "str", "1")
+	assertReply(t, execCmd(e, c, "json.get", "str"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	assertReply(t, execCmd(e, c, "json.set", "str", "$", "1"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	// the ttl is kept
+	execCmd(e, c, "expire", "new", "100")
+	execCmd(e, c, "json.set", "new", "$", "2")
+	assertReply(t, execCmd(e, c, "ttl", "new"), ":100\r\n")
+}
+
+func TestJSONMGet(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "json.set", "a", "$", `{"x":1,"y":{"x":2}}`)
+	execCmd(e, c, "json.set", "b", "$", `{"x":3}`)
+	execCmd(e, c, "set", "str", "1")
+	assertReply(t, execCmd(e, c, "json.mget", "a", "b", "none", "str", "$..x"),
+		"*4\r\n"+bulk(`[1,2]`)+bulk(`[3]`)+"$-1\r\n$-1\r\n")
+	assertReply(t, execCmd(e, c, "json.mget", "a", "b", ".y.x"), "*2\r\n"+bulk(`2`)+"$-1\r\n")
+}
+
+func TestJSONDelType(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "json.set", "doc", "$", docText)
+	assertReply(t, execCmd(e, c, "json.type", "doc"), "+object\r\n")
+	assertReply(t, execCmd(e, c, "json.type", "doc", "$..a"), "*3\r\n$7\r\ninteger\r\n$6\r\nstring\r\n$6\r\nnumber\r\n")
+	assertReply(t, execCmd(e, c, "json.type", "doc", ".d[1].e"), "+null\r\n")
+	assertReply(t, execCmd(e, c, "json.type", "doc", ".none"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "json.type", "doc", "$.none"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "json.type", "none"), "$-1\r\n")
+
+	assertReply(t, execCmd(e, c, "json.del", "doc", "$..a"), ":3\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc"), bulk(`{"b":{"c":[1,2,3]},"d":[{},{"e":null}]}`))
+	assertReply(t, execCmd(e, c, "json.del", "doc", "$.b.c[0,-1]"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "json.forget", "doc", ".d[0]"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc"), bulk(`{"b":{"c":[2]},"d":[{"e":null}]}`))
+	assertReply(t, execCmd(e, c, "json.del", "doc", "$.none"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "json.del", "doc"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "exists", "doc"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "json.del", "doc"), ":0\r\n")
+}
+
+func TestJSONNumAndStr(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "json.set", "doc", "$", `{"a":1,"b":{"a":2.5,"s":"foo"},"c":{"a":"x"},"big":9223372036854775807}`)
+	assertReply(t, execCmd(e, c, "json.numincrby", "doc", "$..a", "2"), bulk(`[3,4.5,null]`))
+	assertReply(t, execCmd(e, c, "json.numincrby", "doc", ".a", "0.5"), bulk(`3.5`))
+	assertReply(t, execCmd(e, c, "json.numincrby", "doc", "$.big", "1"), bulk(`[9.223372036854776e18]`))
+	assertReply(t, execCmd(e, c, "json.numincrby", "doc", ".c.a", "1"),
+		"-WRONGTYPE wrong type of path value - expected number but found string\r\n")
+	assertReply(t, execCmd(e, c, "json.numincrby", "doc", ".none", "1"), "-ERR Path '.none' does not exist\r\n")
+	assertReply(t, execCmd(e, c, "json.numincrby", "doc", "$.a", `"1"`), "-ERR wrong type of value - expected number but found string\r\n")
+	assertReply(t, execCmd(e, c, "json.numincrby", "doc", "$.a", "1e308"), bulk(`[1e308]`))
+	assertReply(t, execCmd(e, c, "json.numincrby", "doc", "$.a", "1e308"), "-ERR result is not a finite number\r\n")
+	assertReply(t, execCmd(e, c, "json.numincrby", "none", "$.a", "1"),
+		"-ERR could not perform this operation on a key that doesn't exist\r\n")
+
+	assertReply(t, execCmd(e, c, "json.strappend", "doc", "$..s", `"bar"`), "*1\r\n:6\r\n")
+	assertReply(t, execCmd(e, c, "json.strappend", "doc", "$.b.*", `"!"`), "*2\r\n$-1\r\n:7\r\n")
+	assertReply(t, execCmd(e, c, "json.strappend", "doc", ".c.a", `"y"`), ":2\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc", "$.*['s','a']"), bulk(`["foobar!",4.5,"xy"]`))
+	assertReply(t, execCmd(e, c, "json.get", "doc", "$.b.s", "$.c.a"), bulk(`{"$.b.s":["foobar!"],"$.c.a":["xy"]}`))
+	assertReply(t, execCmd(e, c, "json.strappend", "doc", `"x"`), "-WRONGTYPE wrong type of path value - expected string but found object\r\n")
+	assertReply(t, execCmd(e, c, "json.strappend", "doc", ".c.a", "1"), "-ERR wrong type of value - expected string but found integer\r\n")
+}
+
+func TestJSONArrays(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "json.set", "doc", "$", `{"a":[1],"b":{"a":[]},"c":{"a":"x"}}`)
+	assertReply(t, execCmd(e, c, "json.arrappend", "doc", "$..a", "2", `{"k":[3]}`), "*3\r\n:3\r\n:2\r\n$-1\r\n")
+	assertReply(t, execCmd(e, c, "json.arrappend", "doc", ".a", "null"), ":4\r\n")
+	assertReply(t, execCmd(e, c, "json.arrappend", "doc", ".c.a", "1"),
+		"-WRONGTYPE wrong type of path value - expected array but found string\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc", "$.a"), bulk(`[[1,2,{"k":[3]},null]]`))
+	// the values appended to different arrays are not shared
+	execCmd(e, c, "json.arrappend", "doc", "$.b.a[1].k", "4")
+	assertReply(t, execCmd(e, c, "json.get", "doc", "$..k"), bulk(`[[3],[3,4]]`))
+
+	assertReply(t, execCmd(e, c, "json.arrinsert", "doc", "$.a", "0", `"first"`), "*1\r\n:5\r\n")
+	assertReply(t, execCmd(e, c, "json.arrinsert", "doc", ".a", "-1", `"x"`, `"y"`), ":7\r\n")
+	assertReply(t, execCmd(e, c, "json.arrinsert", "doc", ".a", "7", "8"), ":8\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc", ".a"), bulk(`["first",1,2,{"k":[3]},"x","y",null,8]`))
+	assertReply(t, execCmd(e, c, "json.arrinsert", "doc", "$..a", "3", "0"), "-ERR index out of bounds\r\n")
+	assertReply(t, execCmd(e, c, "json.arrinsert", "doc", ".a", "-9", "0"), "-ERR index out of bounds\r\n")
+	assertReply(t, execCmd(e, c, "json.arrinsert", "doc", ".a", "x", "0"), "-ERR value is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "json.arrlen", "doc", "$..a"), "*3\r\n:8\r\n:2\r\n$-1\r\n")
+	assertReply(t, execCmd(e, c, "json.arrlen", "doc", ".b.a"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "json.arrlen", "doc"), "-WRONGTYPE wrong type of path value - expected array but found object\r\n")
+	assertReply(t, execCmd(e, c, "json.arrlen", "none", "$.a"), "$-1\r\n")
+
+	assertReply(t, execCmd(e, c, "json.arrpop", "doc", ".a"), bulk(`8`))
+	assertReply(t, execCmd(e, c, "json.arrpop", "doc", ".a", "0"), bulk(`"first"`))
+	assertReply(t, execCmd(e, c, "json.arrpop", "doc", ".a", "100"), bulk(`null`))
+	assertReply(t, execCmd(e, c, "json.arrpop", "doc", "$..a", "-100"), "*3\r\n"+bulk(`1`)+bulk(`2`)+"$-1\r\n")
+	assertReply(t, execCmd(e, c, "json.arrpop", "doc", "$.b.a"), "*1\r\n"+bulk(`{"k":[3,4]}`))
+	assertReply(t, execCmd(e, c, "json.arrpop", "doc", ".b.a"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc", "$.a"), bulk(`[[2,{"k":[3]},"x","y"]]`))
+}
+
+func TestJSONObjKeysMerge(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "json.set", "doc", "$", `{"a":{"x":1,"y":2},"b":[],"c":{"a":{}}}`)
+	assertReply(t, execCmd(e, c, "json.objkeys", "doc"), "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n")
+	assertReply(t, execCmd(e, c, "json.objkeys", "doc", "$..a"), "*2\r\n*2\r\n$1\r\nx\r\n$1\r\ny\r\n*0\r\n")
+	assertReply(t, execCmd(e, c, "json.objkeys", "doc", "$.*"), "*3\r\n*2\r\n$1\r\nx\r\n$1\r\ny\r\n*-1\r\n*1\r\n$1\r\na\r\n")
+	assertReply(t, execCmd(e, c, "json.objkeys", "doc", ".b"), "-WRONGTYPE wrong type of path value - expected object but found array\r\n")
+	assertReply(t, execCmd(e, c, "json.objkeys", "none"), "$-1\r\n")
+
+	assertReply(t, execCmd(e, c, "json.merge", "doc", "$.a", `{"x":null,"z":{"w":null,"v":3}}`), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc", ".a"), bulk(`{"y":2,"z":{"v":3}}`))
+	assertReply(t, execCmd(e, c, "json.merge", "doc", "$..a", `{"n":1}`), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "json.merge", "doc", "$.d", `{"e":null,"f":[1]}`), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "json.merge", "doc", "$.b", "null"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc"), bulk(`{"a":{"y":2,"z":{"v":3},"n":1},"c":{"a":{"n":1}},"d":{"f":[1]}}`))
+	assertReply(t, execCmd(e, c, "json.merge", "doc", "$", `[1]`), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc"), bulk(`[1]`))
+	assertReply(t, execCmd(e, c, "json.merge", "doc", "$", "null"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "exists", "doc"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "json.merge", "doc", "$.a", "1"), "-ERR new objects must be created at the root\r\n")
+	assertReply(t, execCmd(e, c, "json.merge", "doc", "$", `{"a":null,"b":1}`), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "json.get", "doc"), bulk(`{"b":1}`))
+}
+
+func TestJSONAOF(t *testing.T) {
+	useAof(t)
+	e := newAofEngine(t)
+	c := connection.NewFakeConn()
+	db, _ := e.selectDB(0)
+	propagated, writeAof := 0, db.writeAof
+	db.writeAof = func(cmdLine [][]byte) {
+		propagated++
+		writeAof(cmdLine)
+	}
+	execCmd(e, c, "json.set", "doc", "$", docText)
+	execCmd(e, c, "json.set", "doc", "$.d[*].f", `"new"`)
+	execCmd(e, c, "json.numincrby", "doc", "$..a", "1")
+	execCmd(e, c, "json.strappend", "doc", ".b.a", `"yz"`)
+	execCmd(e, c, "json.arrappend", "doc", "$.b.c", "4")
+	execCmd(e, c, "json.arrinsert", "doc", "$.b.c", "0", "0")
+	execCmd(e, c, "json.arrpop", "doc", "$.b.c", "1")
+	execCmd(e, c, "json.del", "doc", "$.d[0]")
+	execCmd(e, c, "json.merge", "doc", "$.b", `{"m":true}`)
+	execCmd(e, c, "json.set", "other", "$", `[1,"a"]`)
+	execCmd(e, c, "json.set", "doc", "$.none.x", "1")
+	if propagated != 10 {
+		t.Errorf("got %d commands propagated", propagated)
+	}
+	want := `{"a":2,"b":{"a":"xyz","c":[0,2,3,4],"m":true},"d":[{"e":null,"f":"new"}]}`
+	assertReply(t, execCmd(e, c, "json.get", "doc"), bulk(want))
+
+	e = reloadAof(t, e)
+	assertReply(t, execCmd(e, c, "json.get", "doc"), bulk(want))
+	assertReply(t, execCmd(e, c, "json.get", "other"), bulk(`[1,"a"]`))
+
+	if err := e.persister.rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	e = reloadAof(t, e)
+	assertReply(t, execCmd(e, c, "json.get", "doc"), bulk(want))
+	assertReply(t, execCmd(e, c, "json.get", "other"), bulk(`[1,"a"]`))
+
+	// a copy is not shared
+	execCmd(e, c, "copy", "doc", "copy")
+	execCmd(e, c, "json.arrappend", "copy", "$.b.c", "5")
+	assertReply(t, execCmd(e, c, "json.get", "doc", "$.b.c"), bulk(`[[0,2,3,4]]`))
+}
+
+func TestJSONRollback(t *testing.T) {
+	testRollback(t, rollbackCase{
+		name:  "json",
+		setup: [][]string{{"json.set", "doc", "$", `{"a":1,"b":[1,2]}`}, {"json.set", "other", "$", `[1,"a"]`}},
+		cmds: [][]string{{"json.set", "doc", "$.a", "100"}, {"json.arrpop", "doc", "$.b"},
+			{"json.merge", "doc", "$", `{"c":{}}`}, {"json.del", "other"}},
+		reads: [][]string{{"json.get", "doc"}, {"json.get", "other"}},
+	})
+}
@@ -0,0 +1,404 @@
+package engine
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/issimo1/redis-issimo/datastruct/hash"
+	"github.com/issimo1/redis-issimo/datastruct/json"
+	"github.com/issimo1/redis-issimo/datastruct/list"
+	"github.com/issimo1/redis-issimo/datastruct/set"
+	"github.com/issimo1/redis-issimo/datastruct/sortedset"
+	"github.com/issimo1/redis-issimo/datastruct/stream"
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/wildcard"
+)
+
+// execDel removes keys, DEL key [key ...]
+func execDel(db *DB, args [][]byte) protocol.Reply {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	deleted := db.Removes(keys...)
+	if deleted > 0 {
+		db.writeAof(toCmdLine3("del", args))
+	}
+	return protocol.MakeIntReply(int64(deleted))
+}
+
+// execExists counts the existing keys, a key given twice is counted twice, EXISTS key [key ...]
+func execExists(db *DB, args [][]byte) protocol.Reply {
+	result := int64(0)
+	for _, arg := range args {
+		if _, exists := db.GetEntity(string(arg)); exists {
+			result++
+		}
+	}
+	return protocol.MakeIntReply(result)
+}
+
+// getType returns the type name of entity used by TYPE
+func getType(entity *payload.DataEntity) string {
+	switch entity.RedisObject.(type) {
+	case []byte, int64:
+		return "string"
+	case list.List:
+		return "list"
+	case hash.Hash:
+		return "hash"
+	case set.Set:
+		return "set"
+	case *sortedset.SortedSet:
+		return "zset"
+	case *stream.Stream:
+		return "stream"
+	case *json.Document:
+		return "ReJSON-RL"
+	default:
+		return "none"
+	}
+}
+
+// embstrSizeLimit is the max length of an embstr encoded string
+const embstrSizeLimit = 44
+
+// getEncoding returns the encoding name of entity used by OBJECT ENCODING
+func getEncoding(entity *payload.DataEntity) string {
+	switch val := entity.RedisObject.(type) {
+	case int64:
+		return "int"
+	case []byte:
+		if len(val) <= embstrSizeLimit {
+			return "embstr"
+		}
+		return "raw"
+	case *list.LinkedList:
+		return "linkedlist"
+	case *list.QuickList:
+		return "quicklist"
+	case *hash.ListPack:
+		if _, ok := val.NextExpireTime(); ok {
+			return "listpackex"
+		}
+		return "listpack"
+	case *hash.Dict:
+		return "hashtable"
+	case *set.IntSet:
+		return "intset"
+	case *set.HashSet:
+		return "hashtable"
+	case *sortedset.SortedSet:
+		return "skiplist"
+	case *stream.Stream:
+		return "stream"
+	default:
+		return "unknown"
+	}
+}
+
+func prepareObject(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return []string{string(args[1])}, nil
+}
+
+// execObject inspects the internal of a key, OBJECT ENCODING|REFCOUNT|HELP [key]
+func execObject(db *DB, args [][]byte) protocol.Reply {
+	sub := strings.ToLower(string(args[0]))
+	if sub == "help" && len(args) == 1 {
+		return protocol.MakeMultiBulkReply([][]byte{
+			[]byte("OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			[]byte("ENCODING <key>"),
+			[]byte("    Return the kind of internal representation used in order to store the value"),
+			[]byte("    associated with a <key>."),
+			[]byte("REFCOUNT <key>"),
+			[]byte("    Return the number of references of the value associated with the specified"),
+			[]byte("    <key>."),
+			[]byte("HELP"),
+			[]byte("    Print this help."),
+		})
+	}
+	if sub != "encoding" && sub != "refcount" && sub != "help" {
+		return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try OBJECT HELP.")
+	}
+	if len(args) != 2 || sub == "help" {
+		return protocol.MakeArgNumErrReply("object|" + sub)
+	}
+	entity, exists := db.GetEntity(string(args[1]))
+	if !exists {
+		return protocol.MakeNullBulkReply()
+	}
+	if sub == "refcount" {
+		return protocol.MakeIntReply(1)
+	}
+	return protocol.MakeBulkReply([]byte(getEncoding(entity)))
+}
+
+// execType returns the type of key, TYPE key
+func execType(db *DB, args [][]byte) protocol.Reply {
+	entity, exists := db.GetEntity(string(args[0]))
+	if !exists {
+		return protocol.MakeStatusReply("none")
+	}
+	return protocol.MakeStatusReply(getType(entity))
+}
+
+func prepareRename(args [][]byte) ([]string, []string) {
+	return nil, []string{string(args[0]), string(args[1])}
+}
+
+func undoRename(db *DB, args [][]byte) [][][]byte {
+	return rollbackGivenKeys(db, string(args[0]), string(args[1]))
+}
+
+// execRename renames key to newkey and overwrites newkey, RENAME key newkey
+func execRename(db *DB, args [][]byte) protocol.Reply {
+	src, dest := string(args[0]), string(args[1])
+	entity, ok := db.GetEntity(src)
+	if !ok {
+		return protocol.MakeErrReply("ERR no such key")
+	}
+	if src != dest {
+		expireTime, hasTTL := db.GetExpireTime(src)
+		db.Remove(dest)
+		db.Remove(src)
+		db.PutEntity(dest, entity)
+		if hasTTL {
+			db.Expire(dest, expireTime)
+		}
+	}
+	db.writeAof(toCmdLine3("rename", args))
+	return protocol.MakeOkReply()
+}
+
+// execRenameNx renames key to newkey only if newkey does not exist, RENAMENX key newkey
+func execRenameNx(db *DB, args [][]byte) protocol.Reply {
+	src, dest := string(args[0]), string(args[1])
+	entity, ok := db.GetEntity(src)
+	if !ok {
+		return protocol.MakeErrReply("ERR no such key")
+	}
+	if _, exists := db.GetEntity(dest); exists {
+		return protocol.MakeIntReply(0)
+	}
+	expireTime, hasTTL := db.GetExpireTime(src)
+	db.Remove(src)
+	db.PutEntity(dest, entity)
+	if hasTTL {
+		db.Expire(dest, expireTime)
+	}
+	db.writeAof(toCmdLine3("renamenx", args))
+	return protocol.MakeIntReply(1)
+}
+
+// copyEntity returns a deep copy of entity
+func copyEntity(entity *payload.DataEntity) *payload.DataEntity {
+	switch val := entity.RedisObject.(type) {
+	case []byte:
+		bytes := make([]byte, len(val))
+		copy(bytes, val)
+		return &payload.DataEntity{RedisObject: bytes}
+	case list.List:
+		// elements are never modified in place, so they can be shared
+		var l list.List = list.NewLinkedList()
+		if _, ok := val.(*list.QuickList); ok {
+			l = newQuickList()
+		}
+		val.ForEach(func(i int, element interface{}) bool {
+			l.Add(element)
+			return true
+		})
+		return &payload.DataEntity{RedisObject: l}
+	case hash.Hash:
+		// values are never modified in place either
+		var h hash.Hash = hash.NewListPack()
+		if _, ok := val.(*hash.Dict); ok {
+			h = hash.NewDict()
+		}
+		hash.CopyTo(h, val)
+		return &payload.DataEntity{RedisObject: h}
+	case set.Set:
+		var s set.Set = set.NewHashSet()
+		if _, ok := val.(*set.IntSet); ok {
+			s = set.NewIntSet()
+		}
+		val.ForEach(func(member string) bool {
+			s.Add(member)
+			return true
+		})
+		return &payload.DataEntity{RedisObject: s}
+	case *sortedset.SortedSet:
+		zs := sortedset.NewSortedSet()
+		val.ForEachByRank(0, val.Len(), false, func(element *sortedset.Element) bool {
+			zs.Add(element.Member, element.Score)
+			return true
+		})
+		return &payload.DataEntity{RedisObject: zs}
+	case *stream.Stream:
+		return &payload.DataEntity{RedisObject: val.Copy()}
+	case *json.Document:
+		return &payload.DataEntity{RedisObject: val.Copy()}
+	default:
+		return &payload.DataEntity{RedisObject: entity.RedisObject}
+	}
+}
+
+type copyArgs struct {
+	src, dest string
+	dbIdx     int
+	hasDB     bool
+	replace   bool
+}
+
+// parseCopyArgs parses COPY source destination [DB destination-db] [REPLACE]
+func parseCopyArgs(args [][]byte) (*copyArgs, protocol.ErrorReply) {
+	result := &copyArgs{src: string(args[0]), dest: string(args[1])}
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		if arg == "db" && i+1 < len(args) {
+			dbIdx, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			result.dbIdx, result.hasDB = dbIdx, true
+			i++
+		} else if arg == "replace" {
+			result.replace = true
+		} else {
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+	}
+	return result, nil
+}
+
+func prepareCopy(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, []string{string(args[1])}
+}
+
+func undoCopy(db *DB, args [][]byte) [][][]byte {
+	return rollbackGivenKeys(db, string(args[1]))
+}
+
+// copyKey copies src of srcDB to dest of destDB, both keys must be locked by caller
+func copyKey(srcDB, destDB *DB, src, dest string, replace bool) protocol.Reply {
+	if srcDB == destDB && src == dest {
+		return protocol.MakeErrReply("ERR source and destination objects are the same")
+	}
+	entity, ok := srcDB.GetEntity(src)
+	if !ok {
+		return protocol.MakeIntReply(0)
+	}
+	if _, exists := destDB.GetEntity(dest); exists {
+		if !replace {
+			return protocol.MakeIntReply(0)
+		}
+		destDB.Remove(dest)
+	}
+	destDB.PutEntity(dest, copyEntity(entity))
+	for _, cmdLine := range entityToCmds(dest, entity) {
+		destDB.writeAof(cmdLine)
+	}
+	if expireTime, hasTTL := srcDB.GetExpireTime(src); hasTTL {
+		destDB.Expire(dest, expireTime)
+		destDB.writeAof(makeExpireCmd(dest, expireTime))
+	}
+	return protocol.MakeIntReply(1)
+}
+
+// execCopy copies a key inside this db, COPY source destination [DB destination-db] [REPLACE]
+func execCopy(db *DB, args [][]byte) protocol.Reply {
+	copyArgs, errReply := parseCopyArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	if copyArgs.hasDB && copyArgs.dbIdx != db.index {
+		// copying to another db is done by Engine, which locks both dbs
+		return protocol.MakeErrReply("ERR COPY to another DB must be executed by the engine")
+	}
+	return copyKey(db, db, copyArgs.src, copyArgs.dest, copyArgs.replace)
+}
+
+// execTouch counts the existing keys, TOUCH key [key ...]
+func execTouch(db *DB, args [][]byte) protocol.Reply {
+	return execExists(db, args)
+}
+
+// randomKeyRetries limits the attempts to skip expired keys in RANDOMKEY
+const randomKeyRetries = 100
+
+// execRandomKey returns a random key, RANDOMKEY
+func execRandomKey(db *DB, args [][]byte) protocol.Reply {
+	for i := 0; i < randomKeyRetries; i++ {
+		key, ok := db.dataDict.RandomKeyWithoutLock()
+		if !ok {
+			return protocol.MakeNullBulkReply()
+		}
+		if !db.IsExpired(key) {
+			return protocol.MakeBulkReply([]byte(key))
+		}
+	}
+	return protocol.MakeNullBulkReply()
+}
+
+// execKeys returns the keys matching pattern, KEYS pattern
+func execKeys(db *DB, args [][]byte) protocol.Reply {
+	pattern := string(args[0])
+	allKeys := pattern == "*"
+	result := make([][]byte, 0)
+	db.dataDict.ForEachWithoutLock(func(key string, val interface{}) bool {
+		if (allKeys || wildcard.Match(pattern, key)) && !db.IsExpired(key) {
+			result = append(result, []byte(key))
+		}
+		return true
+	})
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// execDBSize returns the number of keys, DBSIZE
+func execDBSize(db *DB, args [][]byte) protocol.Reply {
+	return protocol.MakeIntReply(int64(db.dataDict.Count()))
+}
+
+// execFlushDB removes every key of the db, FLUSHDB [ASYNC|SYNC]
+func execFlushDB(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 1 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	if len(args) == 1 {
+		mode := strings.ToLower(string(args[0]))
+		if mode != "async" && mode != "sync" {
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	db.Flush()
+	db.writeAof(toCmdLine3("flushdb", args))
+	return protocol.MakeOkReply()
+}
+
+func undoFlushDB(db *DB, args [][]byte) [][][]byte {
+	keys := make([]string, 0, db.dataDict.Count())
+	db.dataDict.ForEachWithoutLock(func(key string, val interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return rollbackGivenKeys(db, keys...)
+}
+
+func init() {
+	registerCommand("Del", -2, execDel, writeAllKeys, rollbackAllKeys)
+	registerCommand("Unlink", -2, execDel, writeAllKeys, rollbackAllKeys)
+	registerCommand("Exists", -2, execExists, readAllKeys, nil)
+	registerCommand("Type", 2, execType, readFirstKey, nil)
+	registerCommand("Rename", 3, execRename, prepareRename, undoRename)
+	registerCommand("RenameNx", 3, execRenameNx, prepareRename, undoRename)
+	registerCommand("Copy", -3, execCopy, prepareCopy, undoCopy)
+	registerCommand("Touch", -2, execTouch, readAllKeys, nil)
+	registerCommand("RandomKey", 1, execRandomKey, noPrepare, nil).withLockMode(lockAllRead)
+	registerCommand("Keys", 2, execKeys, noPrepare, nil).withLockMode(lockAllRead)
+	registerCommand("Object", -2, execObject, prepareObject, nil)
+	registerCommand("DBSize", 1, execDBSize, noPrepare, nil)
+	registerCommand("FlushDB", -1, execFlushDB, noPrepare, undoFlushDB).withLockMode(lockAllWrite)
+}
@@ -0,0 +1,199 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+func TestDelExists(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "a", "1")
+	execCmd(e, c, "set", "b", "2")
+	assertReply(t, execCmd(e, c, "exists", "a", "b", "a", "c"), ":3\r\n")
+	assertReply(t, execCmd(e, c, "touch", "a", "c"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "del", "a", "c"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "unlink", "b"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "exists", "a", "b"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "del"), "-ERR wrong number of arguments for 'del' command\r\n")
+	assertReply(t, execCmd(e, c, "type", "a"), "+none\r\n")
+}
+
+func TestRename(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "rename", "a", "b"), "-ERR no such key\r\n")
+	execCmd(e, c, "set", "a", "1")
+	execCmd(e, c, "set", "b", "2")
+	assertReply(t, execCmd(e, c, "renamenx", "a", "b"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "rename", "a", "b"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "get", "b"), "$1\r\n1\r\n")
+	assertReply(t, execCmd(e, c, "exists", "a"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "renamenx", "b", "c"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "get", "c"), "$1\r\n1\r\n")
+	assertReply(t, execCmd(e, c, "rename", "c", "c"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "dbsize"), ":1\r\n")
+}
+
+func TestCopy(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "a", "1")
+	assertReply(t, execCmd(e, c, "copy", "a", "a"), "-ERR source and destination objects are the same\r\n")
+	assertReply(t, execCmd(e, c, "copy", "x", "y"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "copy", "a", "b"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "get", "b"), "$1\r\n1\r\n")
+	execCmd(e, c, "set", "a", "2")
+	assertReply(t, execCmd(e, c, "copy", "a", "b"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "copy", "a", "b", "replace"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "get", "b"), "$1\r\n2\r\n")
+	assertReply(t, execCmd(e, c, "copy", "a", "b", "foo"), "-ERR syntax error\r\n")
+
+	assertReply(t, execCmd(e, c, "copy", "a", "a", "db", "2"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "copy", "a", "a", "db", "16"), "-ERR DB index is out of range\r\n")
+	execCmd(e, c, "select", "2")
+	assertReply(t, execCmd(e, c, "get", "a"), "$1\r\n2\r\n")
+
+	execCmd(e, c, "multi")
+	assertReply(t, execCmd(e, c, "copy", "a", "d", "db", "0"), "+QUEUED\r\n")
+	assertReply(t, execCmd(e, c, "copy", "a", "c", "db", "16"), "+QUEUED\r\n")
+	assertReply(t, execCmd(e, c, "exec"), "*2\r\n:1\r\n-ERR DB index is out of range\r\n")
+	execCmd(e, c, "select", "0")
+	assertReply(t, execCmd(e, c, "get", "d"), "$1\r\n2\r\n")
+}
+
+func TestKeysAndRandomKey(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "randomkey"), "$-1\r\n")
+	for _, key := range []string{"user:1", "user:2", "user:10", "order:1", ""} {
+		execCmd(e, c, "set", key, "v")
+	}
+	keysOf := func(reply protocol.Reply) string {
+		var keys []string
+		for _, arg := range reply.(*protocol.MultiBulkReply).Args {
+			keys = append(keys, string(arg))
+		}
+		sort.Strings(keys)
+		return strings.Join(keys, ",")
+	}
+	if got := keysOf(execCmd(e, c, "keys", "user:?")); got != "user:1,user:2" {
+		t.Errorf("keys user:? got %s", got)
+	}
+	if got := keysOf(execCmd(e, c, "keys", "*:1*")); got != "order:1,user:1,user:10" {
+		t.Errorf("keys *:1* got %s", got)
+	}
+	if got := keysOf(execCmd(e, c, "keys", "[^u]*")); got != "order:1" {
+		t.Errorf("keys [^u]* got %s", got)
+	}
+	if got := keysOf(execCmd(e, c, "keys", "*")); got != ",order:1,user:1,user:10,user:2" {
+		t.Errorf("keys * got %s", got)
+	}
+	reply, ok := execCmd(e, c, "randomkey").(*protocol.BulkReply)
+	if !ok {
+		t.Fatal("randomkey should return a key")
+	}
+	assertReply(t, execCmd(e, c, "exists", string(reply.Arg)), ":1\r\n")
+
+	// KEYS inside MULTI locks the whole keyspace with the other queued keys
+	execCmd(e, c, "multi")
+	execCmd(e, c, "set", "user:3", "v")
+	execCmd(e, c, "keys", "user:3")
+	assertReply(t, execCmd(e, c, "exec"), "*2\r\n+OK\r\n*1\r\n$6\r\nuser:3\r\n")
+}
+
+func TestFlush(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "a", "1")
+	execCmd(e, c, "select", "1")
+	execCmd(e, c, "set", "a", "1")
+	execCmd(e, c, "set", "b", "1")
+	assertReply(t, execCmd(e, c, "dbsize"), ":2\r\n")
+
+	watcher := connection.NewFakeConn()
+	execCmd(e, watcher, "select", "1")
+	execCmd(e, watcher, "watch", "a")
+
+	assertReply(t, execCmd(e, c, "flushdb", "now"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "flushdb"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "dbsize"), ":0\r\n")
+	execCmd(e, c, "select", "0")
+	assertReply(t, execCmd(e, c, "dbsize"), ":1\r\n")
+
+	execCmd(e, watcher, "multi")
+	execCmd(e, watcher, "set", "a", "2")
+	assertReply(t, execCmd(e, watcher, "exec"), "*-1\r\n")
+
+	assertReply(t, execCmd(e, c, "flushall", "async"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "dbsize"), ":0\r\n")
+}
+
+func TestKeysRollback(t *testing.T) {
+	testRollback(t, rollbackCase{
+		name:  "keys",
+		setup: [][]string{{"set", "a", "1"}, {"set", "b", "2", "ex", "100"}, {"set", "c", "3"}},
+		cmds:  [][]string{{"rename", "a", "x"}, {"copy", "b", "y"}, {"renamenx", "c", "z"}, {"del", "b"}},
+		reads: [][]string{{"mget", "a", "b", "c", "x", "y", "z"}, {"ttl", "b"}, {"ttl", "y"}},
+	}, rollbackCase{
+		name:  "copy to another db",
+		setup: [][]string{{"set", "a", "1", "ex", "100"}, {"select", "1"}, {"set", "b", "2"}, {"select", "0"}},
+		cmds:  [][]string{{"copy", "a", "x", "db", "1"}, {"copy", "a", "b", "db", "1", "replace"}},
+		reads: [][]string{{"select", "1"}, {"mget", "b", "x"}, {"ttl", "b"}, {"select", "0"}},
+	})
+}
+
+// TestConcurrentCopyBetweenDBs copies keys between two dbs in both directions, inside and outside MULTI,
+// the dbs are always locked in the order of index so the copies never dead lock
+func TestConcurrentCopyBetweenDBs(t *testing.T) {
+	e := NewEngine()
+	const rounds = 200
+	var wg sync.WaitGroup
+	for _, dbIdx := range []string{"0", "1"} {
+		other := "1"
+		if dbIdx == "1" {
+			other = "0"
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			c := connection.NewFakeConn()
+			execCmd(e, c, "select", dbIdx)
+			execCmd(e, c, "set", "a", dbIdx)
+			for i := 0; i < rounds; i++ {
+				execCmd(e, c, "multi")
+				execCmd(e, c, "copy", "a", "b", "db", other, "replace")
+				execCmd(e, c, "set", "a", dbIdx)
+				execCmd(e, c, "exec")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			c := connection.NewFakeConn()
+			execCmd(e, c, "select", dbIdx)
+			for i := 0; i < rounds; i++ {
+				execCmd(e, c, "copy", "a", "c", "db", other, "replace")
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("copies between dbs dead lock")
+	}
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "get", "b"), "$1\r\n1\r\n")
+	execCmd(e, c, "select", "1")
+	assertReply(t, execCmd(e, c, "get", "b"), "$1\r\n0\r\n")
+}
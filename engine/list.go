@@ -0,0 +1,835 @@
+package engine
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/issimo1/redis-issimo/datastruct/list"
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/config"
+)
+
+// getAsList returns the list of key, or nil if key does not exist
+func (db *DB) getAsList(key string) (list.List, protocol.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	l, ok := entity.RedisObject.(list.List)
+	if !ok {
+		return nil, protocol.MakeWrongTypeErrReply()
+	}
+	return l, nil
+}
+
+// getOrInitList returns the list of key, an empty list is bound to key if key does not exist
+func (db *DB) getOrInitList(key string) (l list.List, isNew bool, errReply protocol.ErrorReply) {
+	l, errReply = db.getAsList(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	if l == nil {
+		l = list.NewLinkedList()
+		db.PutEntity(key, &payload.DataEntity{RedisObject: l})
+		isNew = true
+	}
+	return l, isNew, nil
+}
+
+func newQuickList() *list.QuickList {
+	return list.NewQuickList(config.GlobalConfig.ListMaxListpackSize, config.GlobalConfig.ListCompressDepth)
+}
+
+// convertListIfNeeded switches the LinkedList of key to a QuickList once it exceeds list-max-linked-entries,
+// or one of added, the elements just inserted, is longer than list-max-linked-value. It returns the list bound to key.
+func (db *DB) convertListIfNeeded(key string, l list.List, added ...[]byte) list.List {
+	if _, ok := l.(*list.LinkedList); !ok {
+		return l
+	}
+	convert := l.Len() > config.GlobalConfig.ListMaxLinkedEntries
+	for _, val := range added {
+		if len(val) > config.GlobalConfig.ListMaxLinkedValue {
+			convert = true
+			break
+		}
+	}
+	if !convert {
+		return l
+	}
+	ql := newQuickList()
+	l.ForEach(func(i int, val interface{}) bool {
+		ql.Add(val)
+		return true
+	})
+	db.PutEntity(key, &payload.DataEntity{RedisObject: ql})
+	return ql
+}
+
+// removeIfEmpty deletes key once its list has no element, as redis never keeps an empty list
+func (db *DB) removeIfEmpty(key string, l list.List) {
+	if l.Len() == 0 {
+		db.Remove(key)
+	}
+}
+
+func bytesEquals(expected []byte) list.Expected {
+	return func(actual interface{}) bool {
+		return bytes.Equal(actual.([]byte), expected)
+	}
+}
+
+// normalizeRange converts the inclusive start and stop which may be negative to [begin, end) of a sequence of size
+func normalizeRange(start, stop int64, size int) (int, int) {
+	n := int64(size)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop || start >= n {
+		return 0, 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	return int(start), int(stop) + 1
+}
+
+func toMultiBulk(vals []any) protocol.Reply {
+	result := make([][]byte, len(vals))
+	for i, val := range vals {
+		result[i] = val.([]byte)
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+func pushGeneric(db *DB, cmd string, args [][]byte, left, onlyExisting bool) protocol.Reply {
+	key := string(args[0])
+	var l list.List
+	var errReply protocol.ErrorReply
+	if onlyExisting {
+		l, errReply = db.getAsList(key)
+		if errReply == nil && l == nil {
+			return protocol.MakeIntReply(0)
+		}
+	} else {
+		l, _, errReply = db.getOrInitList(key)
+	}
+	if errReply != nil {
+		return errReply
+	}
+	for _, value := range args[1:] {
+		if left {
+			_ = l.Insert(0, value)
+		} else {
+			l.Add(value)
+		}
+	}
+	l = db.convertListIfNeeded(key, l, args[1:]...)
+	db.writeAof(toCmdLine3(cmd, args))
+	return protocol.MakeIntReply(int64(l.Len()))
+}
+
+// execLPush inserts elements at the head, LPUSH key element [element ...]
+func execLPush(db *DB, args [][]byte) protocol.Reply {
+	return pushGeneric(db, "lpush", args, true, false)
+}
+
+// execRPush appends elements at the tail, RPUSH key element [element ...]
+func execRPush(db *DB, args [][]byte) protocol.Reply {
+	return pushGeneric(db, "rpush", args, false, false)
+}
+
+// execLPushX inserts elements at the head only if the list exists, LPUSHX key element [element ...]
+func execLPushX(db *DB, args [][]byte) protocol.Reply {
+	return pushGeneric(db, "lpushx", args, true, true)
+}
+
+// execRPushX appends elements at the tail only if the list exists, RPUSHX key element [element ...]
+func execRPushX(db *DB, args [][]byte) protocol.Reply {
+	return pushGeneric(db, "rpushx", args, false, true)
+}
+
+// popFrom removes at most count elements from one end of l
+func popFrom(l list.List, left bool, count int) []any {
+	count = min(count, l.Len())
+	result := make([]any, 0, count)
+	for i := 0; i < count; i++ {
+		var val any
+		if left {
+			val, _ = l.Del(0)
+		} else {
+			val, _ = l.DelLastNode()
+		}
+		result = append(result, val)
+	}
+	return result
+}
+
+func popGeneric(db *DB, cmd string, args [][]byte, left bool) protocol.Reply {
+	if len(args) > 2 {
+		return protocol.MakeArgNumErrReply(cmd)
+	}
+	key := string(args[0])
+	withCount := len(args) == 2
+	count := int64(1)
+	if withCount {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count < 0 {
+			return protocol.MakeErrReply("ERR value is out of range, must be positive")
+		}
+	}
+	l, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		if withCount {
+			return protocol.MakeNullArrayReply()
+		}
+		return protocol.MakeNullBulkReply()
+	}
+	vals := popFrom(l, left, int(min(count, math.MaxInt32)))
+	db.removeIfEmpty(key, l)
+	if len(vals) > 0 {
+		db.writeAof(toCmdLine3(cmd, args))
+	}
+	if !withCount {
+		return protocol.MakeBulkReply(vals[0].([]byte))
+	}
+	return toMultiBulk(vals)
+}
+
+// execLPop removes elements from the head, LPOP key [count]
+func execLPop(db *DB, args [][]byte) protocol.Reply {
+	return popGeneric(db, "lpop", args, true)
+}
+
+// execRPop removes elements from the tail, RPOP key [count]
+func execRPop(db *DB, args [][]byte) protocol.Reply {
+	return popGeneric(db, "rpop", args, false)
+}
+
+// execLLen returns the length of the list, LLEN key
+func execLLen(db *DB, args [][]byte) protocol.Reply {
+	l, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(int64(l.Len()))
+}
+
+func parseInts(args ...[]byte) ([]int64, protocol.ErrorReply) {
+	result := make([]int64, len(args))
+	for i, arg := range args {
+		n, err := strconv.ParseInt(string(arg), 10, 64)
+		if err != nil {
+			return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		result[i] = n
+	}
+	return result, nil
+}
+
+// execLRange returns the elements between start and stop, LRANGE key start stop
+func execLRange(db *DB, args [][]byte) protocol.Reply {
+	ints, errReply := parseInts(args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	l, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	begin, end := normalizeRange(ints[0], ints[1], l.Len())
+	return toMultiBulk(l.Range(begin, end))
+}
+
+// normalizeIndex converts index which may be negative, ok is false if it is out of range
+func normalizeIndex(index int64, size int) (int, bool) {
+	if index < 0 {
+		index += int64(size)
+	}
+	if index < 0 || index >= int64(size) {
+		return 0, false
+	}
+	return int(index), true
+}
+
+// execLIndex returns the element at index, LINDEX key index
+func execLIndex(db *DB, args [][]byte) protocol.Reply {
+	ints, errReply := parseInts(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	l, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	index, ok := normalizeIndex(ints[0], l.Len())
+	if !ok {
+		return protocol.MakeNullBulkReply()
+	}
+	val, _ := l.Get(index)
+	return protocol.MakeBulkReply(val.([]byte))
+}
+
+// execLSet replaces the element at index, LSET key index element
+func execLSet(db *DB, args [][]byte) protocol.Reply {
+	ints, errReply := parseInts(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	l, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.MakeErrReply("ERR no such key")
+	}
+	index, ok := normalizeIndex(ints[0], l.Len())
+	if !ok {
+		return protocol.MakeErrReply("ERR index out of range")
+	}
+	_ = l.Modify(index, args[2])
+	db.convertListIfNeeded(string(args[0]), l, args[2])
+	db.writeAof(toCmdLine3("lset", args))
+	return protocol.MakeOkReply()
+}
+
+// execLInsert inserts element next to pivot, LINSERT key BEFORE|AFTER pivot element
+func execLInsert(db *DB, args [][]byte) protocol.Reply {
+	where := strings.ToLower(string(args[1]))
+	if where != "before" && where != "after" {
+		return protocol.MakeSyntaxErrReply()
+	}
+	l, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.MakeIntReply(0)
+	}
+	pivot := -1
+	equals := bytesEquals(args[2])
+	l.ForEach(func(i int, val interface{}) bool {
+		if equals(val) {
+			pivot = i
+			return false
+		}
+		return true
+	})
+	if pivot < 0 {
+		return protocol.MakeIntReply(-1)
+	}
+	if where == "after" {
+		pivot++
+	}
+	_ = l.Insert(pivot, args[3])
+	l = db.convertListIfNeeded(string(args[0]), l, args[3])
+	db.writeAof(toCmdLine3("linsert", args))
+	return protocol.MakeIntReply(int64(l.Len()))
+}
+
+// execLRem removes count elements equal to element, from the tail if count is negative, LREM key count element
+func execLRem(db *DB, args [][]byte) protocol.Reply {
+	ints, errReply := parseInts(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	l, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.MakeIntReply(0)
+	}
+	count := max(min(ints[0], math.MaxInt32), -math.MaxInt32)
+	equals := bytesEquals(args[2])
+	var removed int
+	switch {
+	case count == 0:
+		removed = l.DelAllByVal(equals)
+	case count > 0:
+		removed = l.DelByVal(equals, int(count))
+	default:
+		removed = l.ReverseDelByVal(equals, int(-count))
+	}
+	db.removeIfEmpty(key, l)
+	if removed > 0 {
+		db.writeAof(toCmdLine3("lrem", args))
+	}
+	return protocol.MakeIntReply(int64(removed))
+}
+
+// execLTrim keeps only the elements between start and stop, LTRIM key start stop
+func execLTrim(db *DB, args [][]byte) protocol.Reply {
+	ints, errReply := parseInts(args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	l, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return protocol.MakeOkReply()
+	}
+	begin, end := normalizeRange(ints[0], ints[1], l.Len())
+	tail := l.Len() - end
+	if begin == end {
+		begin, tail = l.Len(), 0
+	}
+	popFrom(l, true, begin)
+	popFrom(l, false, tail)
+	db.removeIfEmpty(key, l)
+	db.writeAof(toCmdLine3("ltrim", args))
+	return protocol.MakeOkReply()
+}
+
+// execLPos returns the indexes of matched elements, LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func execLPos(db *DB, args [][]byte) protocol.Reply {
+	rank, count, maxLen := int64(1), int64(-1), int64(0)
+	for i := 2; i < len(args); i += 2 {
+		opt := strings.ToLower(string(args[i]))
+		if i+1 >= len(args) || (opt != "rank" && opt != "count" && opt != "maxlen") {
+			return protocol.MakeSyntaxErrReply()
+		}
+		ints, errReply := parseInts(args[i+1])
+		if errReply != nil {
+			return errReply
+		}
+		switch opt {
+		case "rank":
+			rank = ints[0]
+			if rank == math.MinInt64 {
+				return protocol.MakeErrReply("ERR value is out of range, value must between -9223372036854775807 and 9223372036854775807")
+			}
+			if rank == 0 {
+				return protocol.MakeErrReply("ERR RANK can't be zero: use 1 to start from the first match, " +
+					"2 from the second ... or use negative to start from the end of the list")
+			}
+		case "count":
+			count = ints[0]
+			if count < 0 {
+				return protocol.MakeErrReply("ERR COUNT can't be negative")
+			}
+		case "maxlen":
+			maxLen = ints[0]
+			if maxLen < 0 {
+				return protocol.MakeErrReply("ERR MAXLEN can't be negative")
+			}
+		}
+	}
+	withCount := count >= 0
+	if !withCount {
+		count = 1
+	}
+	l, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+
+	indexes := make([]protocol.Reply, 0)
+	if l != nil {
+		skip := rank - 1
+		if rank < 0 {
+			skip = -rank - 1
+		}
+		compared := int64(0)
+		equals := bytesEquals(args[1])
+		consumer := func(i int, val interface{}) bool {
+			if maxLen > 0 && compared >= maxLen {
+				return false
+			}
+			compared++
+			if !equals(val) {
+				return true
+			}
+			if skip > 0 {
+				skip--
+				return true
+			}
+			indexes = append(indexes, protocol.MakeIntReply(int64(i)))
+			return count == 0 || int64(len(indexes)) < count
+		}
+		if rank > 0 {
+			l.ForEach(consumer)
+		} else {
+			l.ReverseForEach(consumer)
+		}
+	}
+	if withCount {
+		return protocol.MakeArrayReply(indexes)
+	}
+	if len(indexes) == 0 {
+		return protocol.MakeNullBulkReply()
+	}
+	return indexes[0]
+}
+
+// parseDirection parses LEFT|RIGHT
+func parseDirection(arg []byte) (left bool, errReply protocol.ErrorReply) {
+	switch strings.ToLower(string(arg)) {
+	case "left":
+		return true, nil
+	case "right":
+		return false, nil
+	default:
+		return false, protocol.MakeSyntaxErrReply()
+	}
+}
+
+// lmoveGeneric pops an element from src and pushes it to dest, both keys must be write locked
+func lmoveGeneric(db *DB, src, dest string, srcLeft, destLeft bool) protocol.Reply {
+	srcList, errReply := db.getAsList(src)
+	if errReply != nil {
+		return errReply
+	}
+	if srcList == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	// check dest before popping, so that nothing changes on WRONGTYPE
+	if _, errReply = db.getAsList(dest); errReply != nil {
+		return errReply
+	}
+	val := popFrom(srcList, srcLeft, 1)[0]
+	db.removeIfEmpty(src, srcList)
+	destList, _, _ := db.getOrInitList(dest)
+	if destLeft {
+		_ = destList.Insert(0, val)
+	} else {
+		destList.Add(val)
+	}
+	db.convertListIfNeeded(dest, destList, val.([]byte))
+	return protocol.MakeBulkReply(val.([]byte))
+}
+
+func prepareLMove(args [][]byte) ([]string, []string) {
+	return nil, []string{string(args[0]), string(args[1])}
+}
+
+func undoLMove(db *DB, args [][]byte) [][][]byte {
+	return rollbackGivenKeys(db, string(args[0]), string(args[1]))
+}
+
+// execLMove moves an element between lists, LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func execLMove(db *DB, args [][]byte) protocol.Reply {
+	srcLeft, errReply := parseDirection(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	destLeft, errReply := parseDirection(args[3])
+	if errReply != nil {
+		return errReply
+	}
+	reply := lmoveGeneric(db, string(args[0]), string(args[1]), srcLeft, destLeft)
+	if _, ok := reply.(*protocol.BulkReply); ok {
+		db.writeAof(toCmdLine3("lmove", args))
+	}
+	return reply
+}
+
+// execRPopLPush moves the tail of source to the head of destination, RPOPLPUSH source destination
+func execRPopLPush(db *DB, args [][]byte) protocol.Reply {
+	reply := lmoveGeneric(db, string(args[0]), string(args[1]), false, true)
+	if _, ok := reply.(*protocol.BulkReply); ok {
+		db.writeAof(toCmdLine3("rpoplpush", args))
+	}
+	return reply
+}
+
+// mpopArgs is the parsed arguments of LMPOP: numkeys key [key ...] LEFT|RIGHT [COUNT count]
+type mpopArgs struct {
+	keys  []string
+	left  bool
+	count int64
+}
+
+func parseNumKeys(args [][]byte) ([]string, protocol.ErrorReply) {
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return nil, protocol.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(args)-1) {
+		return nil, protocol.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(args[i+1])
+	}
+	return keys, nil
+}
+
+func parseMPopArgs(args [][]byte) (*mpopArgs, protocol.ErrorReply) {
+	keys, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return nil, errReply
+	}
+	rest := args[len(keys)+1:]
+	if len(rest) == 0 {
+		return nil, protocol.MakeSyntaxErrReply()
+	}
+	result := &mpopArgs{keys: keys, count: 1}
+	result.left, errReply = parseDirection(rest[0])
+	if errReply != nil {
+		return nil, errReply
+	}
+	rest = rest[1:]
+	if len(rest) == 0 {
+		return result, nil
+	}
+	if len(rest) != 2 || strings.ToLower(string(rest[0])) != "count" {
+		return nil, protocol.MakeSyntaxErrReply()
+	}
+	count, err := strconv.ParseInt(string(rest[1]), 10, 64)
+	if err != nil || count <= 0 {
+		return nil, protocol.MakeErrReply("ERR count should be greater than 0")
+	}
+	result.count = count
+	return result, nil
+}
+
+func prepareLMPop(args [][]byte) ([]string, []string) {
+	keys, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return nil, keys
+}
+
+func undoLMPop(db *DB, args [][]byte) [][][]byte {
+	_, keys := prepareLMPop(args)
+	return rollbackGivenKeys(db, keys...)
+}
+
+// mpopGeneric pops from the first non-empty list of keys, and returns nil if all of them are empty
+func mpopGeneric(db *DB, mpop *mpopArgs) protocol.Reply {
+	for _, key := range mpop.keys {
+		l, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply
+		}
+		if l == nil {
+			continue
+		}
+		vals := popFrom(l, mpop.left, int(min(mpop.count, math.MaxInt32)))
+		db.removeIfEmpty(key, l)
+		cmd := "rpop"
+		if mpop.left {
+			cmd = "lpop"
+		}
+		db.writeAof(toCmdLine(cmd, key, strconv.Itoa(len(vals))))
+		return protocol.MakeArrayReply([]protocol.Reply{
+			protocol.MakeBulkReply([]byte(key)),
+			toMultiBulk(vals),
+		})
+	}
+	return nil
+}
+
+// execLMPop pops elements from the first non-empty list, LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func execLMPop(db *DB, args [][]byte) protocol.Reply {
+	mpop, errReply := parseMPopArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	if reply := mpopGeneric(db, mpop); reply != nil {
+		return reply
+	}
+	return protocol.MakeNullArrayReply()
+}
+
+// parseBlockingPop parses BLPOP and BRPOP: key [key ...] timeout
+func parseBlockingPop(args [][]byte) (time.Duration, []string, protocol.ErrorReply) {
+	timeout, errReply := parseTimeout(args[len(args)-1])
+	if errReply != nil {
+		return 0, nil, errReply
+	}
+	_, keys := prepareBlockingPop(args)
+	return timeout, keys, nil
+}
+
+func prepareBlockingPop(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args)-1)
+	for i := range keys {
+		keys[i] = string(args[i])
+	}
+	return nil, keys
+}
+
+func undoBlockingPop(db *DB, args [][]byte) [][][]byte {
+	_, keys := prepareBlockingPop(args)
+	return rollbackGivenKeys(db, keys...)
+}
+
+// blockingPopGeneric pops an element from the first non-empty list, and returns nil if all of them are empty
+func blockingPopGeneric(db *DB, args [][]byte, left bool) protocol.Reply {
+	for _, arg := range args[:len(args)-1] {
+		key := string(arg)
+		l, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply
+		}
+		if l == nil {
+			continue
+		}
+		val := popFrom(l, left, 1)[0].([]byte)
+		db.removeIfEmpty(key, l)
+		cmd := "rpop"
+		if left {
+			cmd = "lpop"
+		}
+		db.writeAof(toCmdLine(cmd, key))
+		return protocol.MakeMultiBulkReply([][]byte{arg, val})
+	}
+	return nil
+}
+
+// BLPOP key [key ...] timeout
+var blPopCommand = &blockingCommand{
+	parse: parseBlockingPop,
+	try: func(db *DB, args [][]byte) protocol.Reply {
+		return blockingPopGeneric(db, args, true)
+	},
+	timeoutReply: func() protocol.Reply {
+		return protocol.MakeNullArrayReply()
+	},
+}
+
+// BRPOP key [key ...] timeout
+var brPopCommand = &blockingCommand{
+	parse: parseBlockingPop,
+	try: func(db *DB, args [][]byte) protocol.Reply {
+		return blockingPopGeneric(db, args, false)
+	},
+	timeoutReply: func() protocol.Reply {
+		return protocol.MakeNullArrayReply()
+	},
+}
+
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+var blMoveCommand = &blockingCommand{
+	parse: func(args [][]byte) (time.Duration, []string, protocol.ErrorReply) {
+		if _, errReply := parseDirection(args[2]); errReply != nil {
+			return 0, nil, errReply
+		}
+		if _, errReply := parseDirection(args[3]); errReply != nil {
+			return 0, nil, errReply
+		}
+		timeout, errReply := parseTimeout(args[4])
+		return timeout, []string{string(args[0])}, errReply
+	},
+	try: func(db *DB, args [][]byte) protocol.Reply {
+		srcLeft, _ := parseDirection(args[2])
+		destLeft, _ := parseDirection(args[3])
+		return blockingMoveGeneric(db, toCmdLine3("lmove", args[:4]), srcLeft, destLeft)
+	},
+	timeoutReply: func() protocol.Reply {
+		return protocol.MakeNullBulkReply()
+	},
+}
+
+// BRPOPLPUSH source destination timeout
+var brPopLPushCommand = &blockingCommand{
+	parse: func(args [][]byte) (time.Duration, []string, protocol.ErrorReply) {
+		timeout, errReply := parseTimeout(args[2])
+		return timeout, []string{string(args[0])}, errReply
+	},
+	try: func(db *DB, args [][]byte) protocol.Reply {
+		return blockingMoveGeneric(db, toCmdLine3("rpoplpush", args[:2]), false, true)
+	},
+	timeoutReply: func() protocol.Reply {
+		return protocol.MakeNullBulkReply()
+	},
+}
+
+// blockingMoveGeneric runs LMOVE or RPOPLPUSH given by cmdLine, and returns nil if source is empty
+func blockingMoveGeneric(db *DB, cmdLine [][]byte, srcLeft, destLeft bool) protocol.Reply {
+	reply := lmoveGeneric(db, string(cmdLine[1]), string(cmdLine[2]), srcLeft, destLeft)
+	switch reply.(type) {
+	case *protocol.NullBulkReply:
+		return nil
+	case *protocol.BulkReply:
+		db.writeAof(cmdLine)
+	}
+	return reply
+}
+
+// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+var blmPopCommand = &blockingCommand{
+	parse: func(args [][]byte) (time.Duration, []string, protocol.ErrorReply) {
+		timeout, errReply := parseTimeout(args[0])
+		if errReply != nil {
+			return 0, nil, errReply
+		}
+		mpop, errReply := parseMPopArgs(args[1:])
+		if errReply != nil {
+			return 0, nil, errReply
+		}
+		return timeout, mpop.keys, nil
+	},
+	try: func(db *DB, args [][]byte) protocol.Reply {
+		mpop, _ := parseMPopArgs(args[1:])
+		return mpopGeneric(db, mpop)
+	},
+	timeoutReply: func() protocol.Reply {
+		return protocol.MakeNullArrayReply()
+	},
+}
+
+func prepareBLMPop(args [][]byte) ([]string, []string) {
+	return prepareLMPop(args[1:])
+}
+
+func undoBLMPop(db *DB, args [][]byte) [][][]byte {
+	return undoLMPop(db, args[1:])
+}
+
+func init() {
+	registerCommand("LPush", -3, execLPush, writeFirstKey, rollbackFirstKey)
+	registerCommand("RPush", -3, execRPush, writeFirstKey, rollbackFirstKey)
+	registerCommand("LPushX", -3, execLPushX, writeFirstKey, rollbackFirstKey)
+	registerCommand("RPushX", -3, execRPushX, writeFirstKey, rollbackFirstKey)
+	registerCommand("LPop", -2, execLPop, writeFirstKey, rollbackFirstKey)
+	registerCommand("RPop", -2, execRPop, writeFirstKey, rollbackFirstKey)
+	registerCommand("LLen", 2, execLLen, readFirstKey, nil)
+	registerCommand("LRange", 4, execLRange, readFirstKey, nil)
+	registerCommand("LIndex", 3, execLIndex, readFirstKey, nil)
+	registerCommand("LSet", 4, execLSet, writeFirstKey, rollbackFirstKey)
+	registerCommand("LInsert", 5, execLInsert, writeFirstKey, rollbackFirstKey)
+	registerCommand("LRem", 4, execLRem, writeFirstKey, rollbackFirstKey)
+	registerCommand("LTrim", 4, execLTrim, writeFirstKey, rollbackFirstKey)
+	registerCommand("LPos", -3, execLPos, readFirstKey, nil)
+	registerCommand("LMove", 5, execLMove, prepareLMove, undoLMove)
+	registerCommand("RPopLPush", 3, execRPopLPush, prepareLMove, undoLMove)
+	registerCommand("LMPop", -4, execLMPop, prepareLMPop, undoLMPop)
+	registerBlockingCommand("BLPop", -3, blPopCommand, prepareBlockingPop, undoBlockingPop)
+	registerBlockingCommand("BRPop", -3, brPopCommand, prepareBlockingPop, undoBlockingPop)
+	registerBlockingCommand("BLMove", 6, blMoveCommand, prepareLMove, undoLMove)
+	registerBlockingCommand("BRPopLPush", 4, brPopLPushCommand, prepareLMove, undoLMove)
+	registerBlockingCommand("BLMPop", -5, blmPopCommand, prepareBLMPop, undoBLMPop)
+}
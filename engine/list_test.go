@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/utils/config"
+)
+
+func TestPushPop(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "lpushx", "l", "a"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "rpush", "l", "b", "c"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "lpush", "l", "a", "0"), ":4\r\n")
+	assertReply(t, execCmd(e, c, "rpushx", "l", "d"), ":5\r\n")
+	assertReply(t, execCmd(e, c, "type", "l"), "+list\r\n")
+	assertReply(t, execCmd(e, c, "llen", "l"), ":5\r\n")
+	assertReply(t, execCmd(e, c, "lrange", "l", "0", "-1"), "*5\r\n$1\r\n0\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n")
+
+	assertReply(t, execCmd(e, c, "lpop", "l"), "$1\r\n0\r\n")
+	assertReply(t, execCmd(e, c, "rpop", "l"), "$1\r\nd\r\n")
+	assertReply(t, execCmd(e, c, "lpop", "l", "0"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "rpop", "l", "2"), "*2\r\n$1\r\nc\r\n$1\r\nb\r\n")
+	assertReply(t, execCmd(e, c, "lpop", "l", "-1"), "-ERR value is out of range, must be positive\r\n")
+	assertReply(t, execCmd(e, c, "lpop", "l", "10"), "*1\r\n$1\r\na\r\n")
+	// an empty list is removed
+	assertReply(t, execCmd(e, c, "exists", "l"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "lpop", "l"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "lpop", "l", "1"), "*-1\r\n")
+	assertReply(t, execCmd(e, c, "lpop", "l", "1", "2"), "-ERR wrong number of arguments for 'lpop' command\r\n")
+}
+
+func TestListIndex(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "rpush", "l", "a", "b", "c", "d")
+	assertReply(t, execCmd(e, c, "lrange", "l", "1", "2"), "*2\r\n$1\r\nb\r\n$1\r\nc\r\n")
+	assertReply(t, execCmd(e, c, "lrange", "l", "-2", "100"), "*2\r\n$1\r\nc\r\n$1\r\nd\r\n")
+	assertReply(t, execCmd(e, c, "lrange", "l", "3", "1"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "lrange", "none", "0", "-1"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "lindex", "l", "0"), "$1\r\na\r\n")
+	assertReply(t, execCmd(e, c, "lindex", "l", "-1"), "$1\r\nd\r\n")
+	assertReply(t, execCmd(e, c, "lindex", "l", "4"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "lset", "l", "-2", "x"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "lindex", "l", "2"), "$1\r\nx\r\n")
+	assertReply(t, execCmd(e, c, "lset", "l", "4", "x"), "-ERR index out of range\r\n")
+	assertReply(t, execCmd(e, c, "lset", "none", "0", "x"), "-ERR no such key\r\n")
+
+	assertReply(t, execCmd(e, c, "linsert", "l", "before", "a", "0"), ":5\r\n")
+	assertReply(t, execCmd(e, c, "linsert", "l", "AFTER", "d", "e"), ":6\r\n")
+	assertReply(t, execCmd(e, c, "linsert", "l", "after", "z", "e"), ":-1\r\n")
+	assertReply(t, execCmd(e, c, "linsert", "none", "after", "z", "e"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "linsert", "l", "middle", "z", "e"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "lrange", "l", "0", "-1"), "*6\r\n$1\r\n0\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nx\r\n$1\r\nd\r\n$1\r\ne\r\n")
+
+	assertReply(t, execCmd(e, c, "ltrim", "l", "1", "-2"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "lrange", "l", "0", "-1"), "*4\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nx\r\n$1\r\nd\r\n")
+	assertReply(t, execCmd(e, c, "ltrim", "l", "2", "1"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "exists", "l"), ":0\r\n")
+}
+
+func TestLRemLPos(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "rpush", "l", "a", "b", "c", "a", "b", "c", "a")
+	assertReply(t, execCmd(e, c, "lpos", "l", "a"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "lpos", "l", "a", "rank", "2"), ":3\r\n")
+	assertReply(t, execCmd(e, c, "lpos", "l", "a", "rank", "-1"), ":6\r\n")
+	assertReply(t, execCmd(e, c, "lpos", "l", "a", "count", "0"), "*3\r\n:0\r\n:3\r\n:6\r\n")
+	assertReply(t, execCmd(e, c, "lpos", "l", "a", "rank", "-2", "count", "2"), "*2\r\n:3\r\n:0\r\n")
+	assertReply(t, execCmd(e, c, "lpos", "l", "a", "count", "0", "maxlen", "4"), "*2\r\n:0\r\n:3\r\n")
+	assertReply(t, execCmd(e, c, "lpos", "l", "z"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "lpos", "none", "z", "count", "1"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "lpos", "l", "a", "rank", "0"), "-ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list\r\n")
+	assertReply(t, execCmd(e, c, "lpos", "l", "a", "count", "-1"), "-ERR COUNT can't be negative\r\n")
+	assertReply(t, execCmd(e, c, "lpos", "l", "a", "maxlen", "-1"), "-ERR MAXLEN can't be negative\r\n")
+
+	assertReply(t, execCmd(e, c, "lrem", "l", "-1", "a"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "lrem", "l", "1", "b"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "lrange", "l", "0", "-1"), "*5\r\n$1\r\na\r\n$1\r\nc\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n")
+	assertReply(t, execCmd(e, c, "lrem", "l", "0", "c"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "lrem", "l", "0", "z"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "llen", "l"), ":3\r\n")
+}
+
+func TestLMove(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "rpush", "a", "1", "2", "3")
+	assertReply(t, execCmd(e, c, "lmove", "a", "b", "left", "right"), "$1\r\n1\r\n")
+	assertReply(t, execCmd(e, c, "rpoplpush", "a", "b"), "$1\r\n3\r\n")
+	assertReply(t, execCmd(e, c, "lrange", "b", "0", "-1"), "*2\r\n$1\r\n3\r\n$1\r\n1\r\n")
+	// rotate a list
+	assertReply(t, execCmd(e, c, "lmove", "b", "b", "left", "right"), "$1\r\n3\r\n")
+	assertReply(t, execCmd(e, c, "lrange", "b", "0", "-1"), "*2\r\n$1\r\n1\r\n$1\r\n3\r\n")
+	assertReply(t, execCmd(e, c, "lmove", "a", "b", "up", "right"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "lmove", "none", "b", "left", "right"), "$-1\r\n")
+
+	execCmd(e, c, "set", "s", "1")
+	assertReply(t, execCmd(e, c, "lmove", "a", "s", "left", "right"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	assertReply(t, execCmd(e, c, "llen", "a"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "lmove", "a", "b", "left", "left"), "$1\r\n2\r\n")
+	assertReply(t, execCmd(e, c, "exists", "a"), ":0\r\n")
+}
+
+func TestLMPop(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "rpush", "b", "1", "2", "3")
+	assertReply(t, execCmd(e, c, "lmpop", "2", "a", "b", "left"), "*2\r\n$1\r\nb\r\n*1\r\n$1\r\n1\r\n")
+	assertReply(t, execCmd(e, c, "lmpop", "2", "a", "b", "right", "count", "5"), "*2\r\n$1\r\nb\r\n*2\r\n$1\r\n3\r\n$1\r\n2\r\n")
+	assertReply(t, execCmd(e, c, "lmpop", "2", "a", "b", "right"), "*-1\r\n")
+	assertReply(t, execCmd(e, c, "lmpop", "0", "a", "left"), "-ERR numkeys should be greater than 0\r\n")
+	assertReply(t, execCmd(e, c, "lmpop", "3", "a", "left"), "-ERR Number of keys can't be greater than number of args\r\n")
+	assertReply(t, execCmd(e, c, "lmpop", "1", "a", "left", "count", "0"), "-ERR count should be greater than 0\r\n")
+	assertReply(t, execCmd(e, c, "lmpop", "1", "a", "left", "foo"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "lmpop", "2", "a", "left"), "-ERR syntax error\r\n")
+}
+
+func TestListWrongTypeAndCopy(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "s", "1")
+	wrongType := "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+	assertReply(t, execCmd(e, c, "lpush", "s", "1"), wrongType)
+	assertReply(t, execCmd(e, c, "lrange", "s", "0", "1"), wrongType)
+	assertReply(t, execCmd(e, c, "lpop", "s"), wrongType)
+	assertReply(t, execCmd(e, c, "lmpop", "1", "s", "left"), wrongType)
+
+	execCmd(e, c, "rpush", "l", "a", "b")
+	assertReply(t, execCmd(e, c, "copy", "l", "l2"), ":1\r\n")
+	execCmd(e, c, "rpush", "l2", "c")
+	assertReply(t, execCmd(e, c, "llen", "l"), ":2\r\n")
+}
+
+func TestListEncoding(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "rpush", "l", "a", "b")
+	assertReply(t, execCmd(e, c, "object", "encoding", "l"), "$10\r\nlinkedlist\r\n")
+	for i := 0; i < config.GlobalConfig.ListMaxLinkedEntries; i++ {
+		execCmd(e, c, "lpush", "l", strconv.Itoa(i))
+	}
+	assertReply(t, execCmd(e, c, "object", "encoding", "l"), "$9\r\nquicklist\r\n")
+	assertReply(t, execCmd(e, c, "llen", "l"), ":130\r\n")
+	assertReply(t, execCmd(e, c, "lindex", "l", "0"), "$3\r\n127\r\n")
+	assertReply(t, execCmd(e, c, "lrange", "l", "-2", "-1"), "*2\r\n$1\r\na\r\n$1\r\nb\r\n")
+	assertReply(t, execCmd(e, c, "copy", "l", "l2"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "object", "encoding", "l2"), "$9\r\nquicklist\r\n")
+
+	// a long element converts a small list
+	execCmd(e, c, "rpush", "m", "a")
+	execCmd(e, c, "lset", "m", "0", strings.Repeat("x", config.GlobalConfig.ListMaxLinkedValue+1))
+	assertReply(t, execCmd(e, c, "object", "encoding", "m"), "$9\r\nquicklist\r\n")
+	assertReply(t, execCmd(e, c, "strlen", "m"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	assertReply(t, execCmd(e, c, "lmove", "m", "n", "left", "left"), "$65\r\n"+strings.Repeat("x", config.GlobalConfig.ListMaxLinkedValue+1)+"\r\n")
+	assertReply(t, execCmd(e, c, "object", "encoding", "n"), "$9\r\nquicklist\r\n")
+
+	config.GlobalConfig.ListMaxLinkedEntries = 2
+	defer func() { config.GlobalConfig.ListMaxLinkedEntries = 128 }()
+	execCmd(e, c, "rpush", "o", "a", "b")
+	assertReply(t, execCmd(e, c, "object", "encoding", "o"), "$10\r\nlinkedlist\r\n")
+	execCmd(e, c, "rpush", "o", "c")
+	assertReply(t, execCmd(e, c, "object", "encoding", "o"), "$9\r\nquicklist\r\n")
+}
+
+func TestListRollback(t *testing.T) {
+	testRollback(t, rollbackCase{
+		name:  "list",
+		setup: [][]string{{"rpush", "l", "a", "b"}},
+		cmds:  [][]string{{"lpop", "l"}, {"rpush", "l", "c"}, {"lmove", "l", "m", "left", "left"}, {"lset", "l", "0", "z"}},
+		reads: [][]string{{"lrange", "l", "0", "-1"}, {"lrange", "m", "0", "-1"}},
+	})
+}
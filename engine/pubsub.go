@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"sync"
+
+	"github.com/issimo1/redis-issimo/abs"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+// Hub keeps the subscribers of every channel
+type Hub struct {
+	mu   sync.RWMutex
+	subs map[string]map[abs.Connection]struct{}
+}
+
+func newHub() *Hub {
+	return &Hub{
+		subs: make(map[string]map[abs.Connection]struct{}),
+	}
+}
+
+func makeSubReply(kind string, channel []byte, count int) protocol.Reply {
+	var ch protocol.Reply = protocol.MakeNullBulkReply()
+	if channel != nil {
+		ch = protocol.MakeBulkReply(channel)
+	}
+	return protocol.MakePushReply([]protocol.Reply{
+		protocol.MakeBulkReply([]byte(kind)),
+		ch,
+		protocol.MakeIntReply(int64(count)),
+	})
+}
+
+func writePush(c abs.Connection, reply protocol.Reply) {
+	_, _ = c.Write(protocol.Encode(reply, c.GetProtocol()))
+}
+
+// Subscribe subscribes channels and confirms every channel to the client
+func (h *Hub) Subscribe(c abs.Connection, args [][]byte) protocol.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("subscribe")
+	}
+	h.subscribe(c, args, pushTo(c))
+	return &protocol.NoReply{}
+}
+
+// pushTo returns a function writing push replies to c
+func pushTo(c abs.Connection) func(reply protocol.Reply) {
+	return func(reply protocol.Reply) {
+		writePush(c, reply)
+	}
+}
+
+// subscribe subscribes channels, the confirmations are given to push while the hub is locked,
+// so that they come before the messages of the channels
+func (h *Hub) subscribe(c abs.Connection, args [][]byte, push func(reply protocol.Reply)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, arg := range args {
+		channel := string(arg)
+		subscribers, ok := h.subs[channel]
+		if !ok {
+			subscribers = make(map[abs.Connection]struct{})
+			h.subs[channel] = subscribers
+		}
+		subscribers[c] = struct{}{}
+		c.Subscribe(channel)
+		push(makeSubReply("subscribe", arg, c.SubscribeCount()))
+	}
+}
+
+// UnSubscribe unsubscribes the given channels, or all channels if none is given
+func (h *Hub) UnSubscribe(c abs.Connection, args [][]byte) protocol.Reply {
+	h.unsubscribe(c, args, pushTo(c))
+	return &protocol.NoReply{}
+}
+
+func (h *Hub) unsubscribe(c abs.Connection, args [][]byte, push func(reply protocol.Reply)) {
+	var channels []string
+	if len(args) > 0 {
+		channels = make([]string, len(args))
+		for i, arg := range args {
+			channels[i] = string(arg)
+		}
+	} else {
+		channels = c.GetChannels()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(channels) == 0 {
+		push(makeSubReply("unsubscribe", nil, 0))
+		return
+	}
+	for _, channel := range channels {
+		h.unsubscribeChannel(c, channel)
+		push(makeSubReply("unsubscribe", []byte(channel), c.SubscribeCount()))
+	}
+}
+
+func (h *Hub) unsubscribeChannel(c abs.Connection, channel string) {
+	c.UnSubscribe(channel)
+	subscribers, ok := h.subs[channel]
+	if !ok {
+		return
+	}
+	delete(subscribers, c)
+	if len(subscribers) == 0 {
+		delete(h.subs, channel)
+	}
+}
+
+// UnSubscribeAll is called when the client is closed
+func (h *Hub) UnSubscribeAll(c abs.Connection) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, channel := range c.GetChannels() {
+		h.unsubscribeChannel(c, channel)
+	}
+}
+
+// Publish sends message to every subscriber and returns the number of receivers
+func (h *Hub) Publish(args [][]byte) protocol.Reply {
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("publish")
+	}
+	channel, message := args[0], args[1]
+	// the message is written after the lock is released, so a slow subscriber does not stall the others
+	h.mu.RLock()
+	subscribers := make([]abs.Connection, 0, len(h.subs[string(channel)]))
+	for c := range h.subs[string(channel)] {
+		subscribers = append(subscribers, c)
+	}
+	h.mu.RUnlock()
+	for _, c := range subscribers {
+		writePush(c, protocol.MakePushReply([]protocol.Reply{
+			protocol.MakeBulkReply([]byte("message")),
+			protocol.MakeBulkReply(channel),
+			protocol.MakeBulkReply(message),
+		}))
+	}
+	return protocol.MakeIntReply(int64(len(subscribers)))
+}
+
+// subscribeAllowed lists the commands a RESP2 client can send in subscribed state
+var subscribeAllowed = map[string]struct{}{
+	"subscribe":   {},
+	"unsubscribe": {},
+	"ping":        {},
+	"quit":        {},
+	"reset":       {},
+}
+
+func checkSubscribeContext(c abs.Connection, cmd string) protocol.Reply {
+	if c == nil || c.GetProtocol() == protocol.RESP3 || c.SubscribeCount() == 0 {
+		return nil
+	}
+	if _, ok := subscribeAllowed[cmd]; ok {
+		return nil
+	}
+	return protocol.MakeErrReply("ERR Can't execute '" + cmd +
+		"': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context")
+}
@@ -11,15 +11,25 @@ type UndoFunc func(db *DB, args [][]byte) [][][]byte
 
 var commandCenter map[string]*command = make(map[string]*command)
 
+const (
+	// lockKeys locks the keys returned by KeysFunc
+	lockKeys = iota
+	// lockAllRead read locks every shard for commands scanning the keyspace
+	lockAllRead
+	// lockAllWrite write locks every shard for commands changing the whole keyspace
+	lockAllWrite
+)
+
 type command struct {
 	commandName string
 	execFunc    ExecFunc
 	keyFunc     KeysFunc
 	undoFunc    UndoFunc
 	argsNum     int
+	lockMode    int
 }
 
-func registerCommand(name string, args int, execFunc ExecFunc, keysFunc KeysFunc, undoFunc UndoFunc) {
+func registerCommand(name string, args int, execFunc ExecFunc, keysFunc KeysFunc, undoFunc UndoFunc) *command {
 	name = strings.ToLower(name)
 	cmd := &command{}
 	cmd.commandName = name
@@ -28,4 +38,11 @@ func registerCommand(name string, args int, execFunc ExecFunc, keysFunc KeysFunc
 	cmd.keyFunc = keysFunc
 	cmd.undoFunc = undoFunc
 	commandCenter[name] = cmd
+	return cmd
+}
+
+// withLockMode sets how the keyspace is locked while the command runs
+func (cmd *command) withLockMode(mode int) *command {
+	cmd.lockMode = mode
+	return cmd
 }
@@ -0,0 +1,490 @@
+package engine
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/issimo1/redis-issimo/datastruct/set"
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/config"
+	"github.com/issimo1/redis-issimo/utils/wildcard"
+)
+
+// getAsSet returns the set of key, or nil if key does not exist
+func (db *DB) getAsSet(key string) (set.Set, protocol.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	s, ok := entity.RedisObject.(set.Set)
+	if !ok {
+		return nil, protocol.MakeWrongTypeErrReply()
+	}
+	return s, nil
+}
+
+// getAsSets returns the sets of keys, a key which does not exist is returned as nil
+func (db *DB) getAsSets(keys []string) ([]set.Set, protocol.ErrorReply) {
+	sets := make([]set.Set, len(keys))
+	for i, key := range keys {
+		s, errReply := db.getAsSet(key)
+		if errReply != nil {
+			return nil, errReply
+		}
+		sets[i] = s
+	}
+	return sets, nil
+}
+
+// newSet returns an IntSet if member may be held by an IntSet, otherwise a HashSet
+func newSet(member string) set.Set {
+	if _, ok := set.ParseInt(member); ok && config.GlobalConfig.SetMaxIntsetEntries > 0 {
+		return set.NewIntSet()
+	}
+	return set.NewHashSet()
+}
+
+// addMembers adds members to the set of key, the IntSet of key is converted to a HashSet once a member
+// is not an integer or the set exceeds set-max-intset-entries. It returns the set bound to key.
+func (db *DB) addMembers(key string, s set.Set, members ...string) (set.Set, int) {
+	if _, ok := s.(*set.IntSet); ok {
+		for _, member := range members {
+			if _, ok := set.ParseInt(member); !ok {
+				s = db.convertIntSet(key, s)
+				break
+			}
+		}
+	}
+	added := 0
+	for _, member := range members {
+		added += s.Add(member)
+	}
+	if _, ok := s.(*set.IntSet); ok && s.Len() > config.GlobalConfig.SetMaxIntsetEntries {
+		s = db.convertIntSet(key, s)
+	}
+	return s, added
+}
+
+func (db *DB) convertIntSet(key string, s set.Set) set.Set {
+	hs := set.NewHashSet()
+	s.ForEach(func(member string) bool {
+		hs.Add(member)
+		return true
+	})
+	db.PutEntity(key, &payload.DataEntity{RedisObject: hs})
+	return hs
+}
+
+// storeSet binds a set of members to key, key is removed if members is empty
+func (db *DB) storeSet(key string, members []string) {
+	db.Remove(key)
+	if len(members) == 0 {
+		return
+	}
+	s := newSet(members[0])
+	db.PutEntity(key, &payload.DataEntity{RedisObject: s})
+	db.addMembers(key, s, members...)
+}
+
+// removeSetIfEmpty deletes key once its set has no member
+func (db *DB) removeSetIfEmpty(key string, s set.Set) {
+	if s.Len() == 0 {
+		db.Remove(key)
+	}
+}
+
+func toStrings(args [][]byte) []string {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = string(arg)
+	}
+	return result
+}
+
+func toBulks(members []string) [][]byte {
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		result[i] = []byte(member)
+	}
+	return result
+}
+
+// makeSetReply returns members as a set in RESP3, and an array in RESP2
+func makeSetReply(members []string) protocol.Reply {
+	replies := make([]protocol.Reply, len(members))
+	for i, member := range members {
+		replies[i] = protocol.MakeBulkReply([]byte(member))
+	}
+	return protocol.MakeSetReply(replies)
+}
+
+// execSAdd adds members to a set, SADD key member [member ...]
+func execSAdd(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	s, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		s = newSet(string(args[1]))
+		db.PutEntity(key, &payload.DataEntity{RedisObject: s})
+	}
+	_, added := db.addMembers(key, s, toStrings(args[1:])...)
+	db.writeAof(toCmdLine3("sadd", args))
+	return protocol.MakeIntReply(int64(added))
+}
+
+// execSRem removes members from a set, SREM key member [member ...]
+func execSRem(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	s, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.MakeIntReply(0)
+	}
+	removed := 0
+	for _, member := range args[1:] {
+		removed += s.Remove(string(member))
+	}
+	if removed > 0 {
+		db.removeSetIfEmpty(key, s)
+		db.writeAof(toCmdLine3("srem", args))
+	}
+	return protocol.MakeIntReply(int64(removed))
+}
+
+// execSIsMember checks whether member is in a set, SISMEMBER key member
+func execSIsMember(db *DB, args [][]byte) protocol.Reply {
+	s, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s != nil && s.Contains(string(args[1])) {
+		return protocol.MakeIntReply(1)
+	}
+	return protocol.MakeIntReply(0)
+}
+
+// execSMIsMember checks whether each member is in a set, SMISMEMBER key member [member ...]
+func execSMIsMember(db *DB, args [][]byte) protocol.Reply {
+	s, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	results := make([]int64, len(args)-1)
+	for i, member := range args[1:] {
+		if s != nil && s.Contains(string(member)) {
+			results[i] = 1
+		}
+	}
+	return makeIntsReply(results)
+}
+
+// execSMembers returns every member of a set, SMEMBERS key
+func execSMembers(db *DB, args [][]byte) protocol.Reply {
+	s, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return makeSetReply(nil)
+	}
+	return makeSetReply(set.Members(s))
+}
+
+// execSCard returns the number of members, SCARD key
+func execSCard(db *DB, args [][]byte) protocol.Reply {
+	s, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(int64(s.Len()))
+}
+
+// execSPop removes and returns random members, SPOP key [count]
+func execSPop(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 2 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	withCount := len(args) == 2
+	count := int64(1)
+	if withCount {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count < 0 {
+			return protocol.MakeErrReply("ERR value is out of range, must be positive")
+		}
+	}
+	key := string(args[0])
+	s, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		if withCount {
+			return makeSetReply(nil)
+		}
+		return protocol.MakeNullBulkReply()
+	}
+	members := s.RandomDistinctMembers(int(min(count, int64(s.Len()))))
+	for _, member := range members {
+		s.Remove(member)
+	}
+	db.removeSetIfEmpty(key, s)
+	if len(members) > 0 {
+		// propagate the members popped rather than the random pop
+		db.writeAof(toCmdLine2("srem", append([][]byte{args[0]}, toBulks(members)...)...))
+	}
+	if !withCount {
+		return protocol.MakeBulkReply([]byte(members[0]))
+	}
+	return makeSetReply(members)
+}
+
+// execSRandMember returns random members, SRANDMEMBER key [count].
+// A positive count returns distinct members, a negative count may return a member several times.
+func execSRandMember(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 2 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	withCount := len(args) == 2
+	var count int64
+	if withCount {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if count == math.MinInt64 {
+			return protocol.MakeErrReply("ERR value is out of range")
+		}
+	}
+	s, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !withCount {
+		if s == nil {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeBulkReply([]byte(s.RandomMembers(1)[0]))
+	}
+	if s == nil || count == 0 {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	if count > 0 {
+		return protocol.MakeMultiBulkReply(toBulks(s.RandomDistinctMembers(int(min(count, int64(s.Len()))))))
+	}
+	return protocol.MakeMultiBulkReply(toBulks(s.RandomMembers(int(-count))))
+}
+
+// execSMove moves member from a set to another, SMOVE source destination member
+func execSMove(db *DB, args [][]byte) protocol.Reply {
+	src, dest := string(args[0]), string(args[1])
+	member := string(args[2])
+	srcSet, errReply := db.getAsSet(src)
+	if errReply != nil {
+		return errReply
+	}
+	destSet, errReply := db.getAsSet(dest)
+	if errReply != nil {
+		return errReply
+	}
+	if srcSet == nil || !srcSet.Contains(member) {
+		return protocol.MakeIntReply(0)
+	}
+	if src == dest {
+		return protocol.MakeIntReply(1)
+	}
+	srcSet.Remove(member)
+	db.removeSetIfEmpty(src, srcSet)
+	if destSet == nil {
+		destSet = newSet(member)
+		db.PutEntity(dest, &payload.DataEntity{RedisObject: destSet})
+	}
+	db.addMembers(dest, destSet, member)
+	db.writeAof(toCmdLine3("smove", args))
+	return protocol.MakeIntReply(1)
+}
+
+// intersect returns the members in every one of sets, limit stops the search once it is reached, 0 means no limit
+func intersect(sets []set.Set, limit int) []string {
+	members := make([]string, 0)
+	smallest := -1
+	for i, s := range sets {
+		if s == nil {
+			return members
+		}
+		if smallest < 0 || s.Len() < sets[smallest].Len() {
+			smallest = i
+		}
+	}
+	sets[smallest].ForEach(func(member string) bool {
+		for i, s := range sets {
+			if i != smallest && !s.Contains(member) {
+				return true
+			}
+		}
+		members = append(members, member)
+		return limit == 0 || len(members) < limit
+	})
+	return members
+}
+
+// union returns the members in any of sets
+func union(sets []set.Set) []string {
+	members := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, s := range sets {
+		if s == nil {
+			continue
+		}
+		s.ForEach(func(member string) bool {
+			if _, ok := seen[member]; !ok {
+				seen[member] = struct{}{}
+				members = append(members, member)
+			}
+			return true
+		})
+	}
+	return members
+}
+
+// diff returns the members of the first set which are not in the others
+func diff(sets []set.Set) []string {
+	members := make([]string, 0)
+	if sets[0] == nil {
+		return members
+	}
+	sets[0].ForEach(func(member string) bool {
+		for _, s := range sets[1:] {
+			if s != nil && s.Contains(member) {
+				return true
+			}
+		}
+		members = append(members, member)
+		return true
+	})
+	return members
+}
+
+// setAlgebra returns a command computing the members of the sets of keys by op
+func setAlgebra(op func(sets []set.Set) []string) ExecFunc {
+	return func(db *DB, args [][]byte) protocol.Reply {
+		sets, errReply := db.getAsSets(toStrings(args))
+		if errReply != nil {
+			return errReply
+		}
+		return makeSetReply(op(sets))
+	}
+}
+
+// setAlgebraStore returns a command storing the members of the sets of keys computed by op to destination
+func setAlgebraStore(cmd string, op func(sets []set.Set) []string) ExecFunc {
+	return func(db *DB, args [][]byte) protocol.Reply {
+		sets, errReply := db.getAsSets(toStrings(args[1:]))
+		if errReply != nil {
+			return errReply
+		}
+		members := op(sets)
+		db.storeSet(string(args[0]), members)
+		db.writeAof(toCmdLine3(cmd, args))
+		return protocol.MakeIntReply(int64(len(members)))
+	}
+}
+
+func intersectAll(sets []set.Set) []string {
+	return intersect(sets, 0)
+}
+
+// prepareSetStore locks destination for write and the source keys for read
+func prepareSetStore(args [][]byte) ([]string, []string) {
+	return toStrings(args[1:]), []string{string(args[0])}
+}
+
+func prepareSInterCard(args [][]byte) ([]string, []string) {
+	keys, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return keys, nil
+}
+
+// execSInterCard returns the size of the intersection, SINTERCARD numkeys key [key ...] [LIMIT limit]
+func execSInterCard(db *DB, args [][]byte) protocol.Reply {
+	keys, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return errReply
+	}
+	limit := int64(0)
+	for i := len(keys) + 1; i < len(args); i++ {
+		if strings.ToLower(string(args[i])) != "limit" || i+1 >= len(args) {
+			return protocol.MakeSyntaxErrReply()
+		}
+		var err error
+		limit, err = strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return protocol.MakeErrReply("ERR LIMIT can't be negative")
+		}
+		i++
+	}
+	sets, errReply := db.getAsSets(keys)
+	if errReply != nil {
+		return errReply
+	}
+	return protocol.MakeIntReply(int64(len(intersect(sets, int(min(limit, math.MaxInt32))))))
+}
+
+// execSScan iterates the members, SSCAN key cursor [MATCH pattern] [COUNT count]
+func execSScan(db *DB, args [][]byte) protocol.Reply {
+	options, errReply := parseScanArgs(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	s, errReply := db.getAsSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	items := make([][]byte, 0)
+	if s == nil {
+		return makeScanReply(0, items)
+	}
+	allMembers := options.pattern == "*"
+	cursor := s.Scan(options.cursor, options.count, func(member string) bool {
+		if allMembers || wildcard.Match(options.pattern, member) {
+			items = append(items, []byte(member))
+		}
+		return true
+	})
+	return makeScanReply(cursor, items)
+}
+
+func init() {
+	registerCommand("SAdd", -3, execSAdd, writeFirstKey, rollbackFirstKey)
+	registerCommand("SRem", -3, execSRem, writeFirstKey, rollbackFirstKey)
+	registerCommand("SIsMember", 3, execSIsMember, readFirstKey, nil)
+	registerCommand("SMIsMember", -3, execSMIsMember, readFirstKey, nil)
+	registerCommand("SMembers", 2, execSMembers, readFirstKey, nil)
+	registerCommand("SCard", 2, execSCard, readFirstKey, nil)
+	registerCommand("SPop", -2, execSPop, writeFirstKey, rollbackFirstKey)
+	registerCommand("SRandMember", -2, execSRandMember, readFirstKey, nil)
+	registerCommand("SMove", 4, execSMove, prepareLMove, undoLMove)
+	registerCommand("SInter", -2, setAlgebra(intersectAll), readAllKeys, nil)
+	registerCommand("SInterCard", -3, execSInterCard, prepareSInterCard, nil)
+	registerCommand("SUnion", -2, setAlgebra(union), readAllKeys, nil)
+	registerCommand("SDiff", -2, setAlgebra(diff), readAllKeys, nil)
+	registerCommand("SInterStore", -3, setAlgebraStore("sinterstore", intersectAll), prepareSetStore, rollbackFirstKey)
+	registerCommand("SUnionStore", -3, setAlgebraStore("sunionstore", union), prepareSetStore, rollbackFirstKey)
+	registerCommand("SDiffStore", -3, setAlgebraStore("sdiffstore", diff), prepareSetStore, rollbackFirstKey)
+	registerCommand("SScan", -3, execSScan, readFirstKey, nil)
+}
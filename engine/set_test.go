@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/config"
+)
+
+// setMembers returns the sorted members of a set reply
+func setMembers(t *testing.T, reply protocol.Reply) []string {
+	t.Helper()
+	var members []string
+	switch r := reply.(type) {
+	case *protocol.SetReply:
+		for _, member := range r.Members {
+			members = append(members, string(member.(*protocol.BulkReply).Arg))
+		}
+	case *protocol.MultiBulkReply:
+		for _, arg := range r.Args {
+			members = append(members, string(arg))
+		}
+	default:
+		t.Fatalf("got %q", reply.ToBytes())
+	}
+	sort.Strings(members)
+	return members
+}
+
+func assertMembers(t *testing.T, reply protocol.Reply, expected ...string) {
+	t.Helper()
+	members := setMembers(t, reply)
+	sort.Strings(expected)
+	if len(members) != len(expected) {
+		t.Fatalf("got %q, want %q", members, expected)
+	}
+	for i := range members {
+		if members[i] != expected[i] {
+			t.Fatalf("got %q, want %q", members, expected)
+		}
+	}
+}
+
+func TestSetAddRem(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "sadd", "s", "3", "1", "2", "1"), ":3\r\n")
+	assertReply(t, execCmd(e, c, "sadd", "s", "2", "4"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "type", "s"), "+set\r\n")
+	assertReply(t, execCmd(e, c, "smembers", "s"), "*4\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n$1\r\n4\r\n")
+	assertReply3(t, execCmd(e, c, "smembers", "s"), "~4\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n$1\r\n4\r\n")
+	assertReply(t, execCmd(e, c, "smembers", "none"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "scard", "s"), ":4\r\n")
+	assertReply(t, execCmd(e, c, "scard", "none"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "sismember", "s", "1"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "sismember", "s", "01"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "sismember", "none", "1"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "smismember", "s", "1", "x", "4"), "*3\r\n:1\r\n:0\r\n:1\r\n")
+	assertReply(t, execCmd(e, c, "smismember", "none", "1"), "*1\r\n:0\r\n")
+
+	assertReply(t, execCmd(e, c, "srem", "s", "1", "x", "5"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "srem", "none", "1"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "srem", "s", "2", "3", "4"), ":3\r\n")
+	// an empty set is removed
+	assertReply(t, execCmd(e, c, "exists", "s"), ":0\r\n")
+
+	execCmd(e, c, "set", "str", "1")
+	assertReply(t, execCmd(e, c, "sadd", "str", "a"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	assertReply(t, execCmd(e, c, "sismember", "str", "a"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	assertReply(t, execCmd(e, c, "sunion", "none", "str"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+}
+
+func TestSetEncoding(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "sadd", "ints", "1", "-70000", "9223372036854775807")
+	assertReply(t, execCmd(e, c, "object", "encoding", "ints"), "$6\r\nintset\r\n")
+	assertReply(t, execCmd(e, c, "smembers", "ints"),
+		"*3\r\n$6\r\n-70000\r\n$1\r\n1\r\n$19\r\n9223372036854775807\r\n")
+	// a member which is not in the canonical form is not an integer
+	execCmd(e, c, "sadd", "ints", "007")
+	assertReply(t, execCmd(e, c, "object", "encoding", "ints"), "$9\r\nhashtable\r\n")
+	assertMembers(t, execCmd(e, c, "smembers", "ints"), "1", "-70000", "9223372036854775807", "007")
+
+	execCmd(e, c, "sadd", "strs", "a")
+	assertReply(t, execCmd(e, c, "object", "encoding", "strs"), "$9\r\nhashtable\r\n")
+
+	for i := 0; i < 512; i++ {
+		execCmd(e, c, "sadd", "big", strconv.Itoa(i))
+	}
+	assertReply(t, execCmd(e, c, "object", "encoding", "big"), "$6\r\nintset\r\n")
+	execCmd(e, c, "sadd", "big", "512")
+	assertReply(t, execCmd(e, c, "object", "encoding", "big"), "$9\r\nhashtable\r\n")
+	assertReply(t, execCmd(e, c, "scard", "big"), ":513\r\n")
+
+	// the threshold is read from the config
+	config.GlobalConfig.SetMaxIntsetEntries = 2
+	defer func() { config.GlobalConfig.SetMaxIntsetEntries = 512 }()
+	execCmd(e, c, "sadd", "small", "1", "2")
+	assertReply(t, execCmd(e, c, "object", "encoding", "small"), "$6\r\nintset\r\n")
+	execCmd(e, c, "sadd", "small", "3")
+	assertReply(t, execCmd(e, c, "object", "encoding", "small"), "$9\r\nhashtable\r\n")
+
+	// copies keep the encoding and the members
+	execCmd(e, c, "sadd", "src", "1", "2")
+	execCmd(e, c, "copy", "src", "dst")
+	assertReply(t, execCmd(e, c, "object", "encoding", "dst"), "$6\r\nintset\r\n")
+	execCmd(e, c, "srem", "dst", "1")
+	assertReply(t, execCmd(e, c, "scard", "src"), ":2\r\n")
+}
+
+func TestSetPopRandMember(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "spop", "none"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "spop", "none", "2"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "srandmember", "none"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "srandmember", "none", "-2"), "*0\r\n")
+
+	execCmd(e, c, "sadd", "s", "a", "b", "c", "d")
+	members := map[string]bool{"a": true, "b": true, "c": true, "d": true}
+	reply := execCmd(e, c, "srandmember", "s")
+	if !members[string(reply.(*protocol.BulkReply).Arg)] {
+		t.Fatalf("got %q", reply.ToBytes())
+	}
+	assertReply(t, execCmd(e, c, "srandmember", "s", "0"), "*0\r\n")
+	assertMembers(t, execCmd(e, c, "srandmember", "s", "10"), "a", "b", "c", "d")
+	got := setMembers(t, execCmd(e, c, "srandmember", "s", "-10"))
+	if len(got) != 10 {
+		t.Fatalf("got %q", got)
+	}
+	for _, member := range got {
+		if !members[member] {
+			t.Fatalf("got %q", got)
+		}
+	}
+	assertReply(t, execCmd(e, c, "srandmember", "s", "x"), "-ERR value is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "srandmember", "s", "-9223372036854775808"), "-ERR value is out of range\r\n")
+	assertReply(t, execCmd(e, c, "srandmember", "s", "1", "2"), "-ERR syntax error\r\n")
+
+	var aof [][]byte
+	db, _ := e.selectDB(0)
+	db.writeAof = func(cmdLine [][]byte) { aof = cmdLine }
+	reply = execCmd(e, c, "spop", "s")
+	popped := string(reply.(*protocol.BulkReply).Arg)
+	if !members[popped] || string(protocol.MakeMultiBulkReply(aof).ToBytes()) !=
+		string(protocol.MakeMultiBulkReply(toCmdLine("srem", "s", popped)).ToBytes()) {
+		t.Fatalf("popped %s, aof %q", popped, aof)
+	}
+	assertReply(t, execCmd(e, c, "sismember", "s", popped), ":0\r\n")
+	if got := setMembers(t, execCmd(e, c, "spop", "s", "2")); len(got) != 2 {
+		t.Fatalf("got %q", got)
+	}
+	assertReply(t, execCmd(e, c, "spop", "s", "-1"), "-ERR value is out of range, must be positive\r\n")
+	assertReply(t, execCmd(e, c, "spop", "s", "0"), "*0\r\n")
+	if got := setMembers(t, execCmd(e, c, "spop", "s", "5")); len(got) != 1 {
+		t.Fatalf("got %q", got)
+	}
+	assertReply(t, execCmd(e, c, "exists", "s"), ":0\r\n")
+}
+
+func TestSetMove(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "sadd", "a", "1", "2")
+	execCmd(e, c, "sadd", "b", "x")
+	assertReply(t, execCmd(e, c, "smove", "a", "b", "1"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "smove", "a", "b", "3"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "smove", "none", "b", "1"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "smove", "a", "a", "2"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "smove", "a", "a", "3"), ":0\r\n")
+	assertMembers(t, execCmd(e, c, "smembers", "b"), "1", "x")
+	assertReply(t, execCmd(e, c, "smove", "a", "c", "2"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "exists", "a"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "object", "encoding", "c"), "$6\r\nintset\r\n")
+
+	execCmd(e, c, "set", "str", "1")
+	assertReply(t, execCmd(e, c, "smove", "c", "str", "2"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	assertReply(t, execCmd(e, c, "smove", "str", "c", "2"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	assertReply(t, execCmd(e, c, "sismember", "c", "2"), ":1\r\n")
+}
+
+func TestSetAlgebra(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "sadd", "a", "1", "2", "3", "4")
+	execCmd(e, c, "sadd", "b", "2", "3", "x")
+	execCmd(e, c, "sadd", "c", "3", "4", "x")
+	assertMembers(t, execCmd(e, c, "sinter", "a", "b"), "2", "3")
+	assertMembers(t, execCmd(e, c, "sinter", "a", "b", "c"), "3")
+	assertMembers(t, execCmd(e, c, "sinter", "a", "none"))
+	assertMembers(t, execCmd(e, c, "sunion", "a", "b", "none"), "1", "2", "3", "4", "x")
+	assertMembers(t, execCmd(e, c, "sdiff", "a", "b", "none"), "1", "4")
+	assertMembers(t, execCmd(e, c, "sdiff", "none", "a"))
+
+	assertReply(t, execCmd(e, c, "sintercard", "2", "a", "b"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "sintercard", "2", "a", "b", "limit", "1"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "sintercard", "2", "a", "b", "limit", "0"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "sintercard", "2", "a", "b", "limit", "-1"), "-ERR LIMIT can't be negative\r\n")
+	assertReply(t, execCmd(e, c, "sintercard", "2", "a", "b", "limit"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "sintercard", "2", "a", "b", "c"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "sintercard", "0", "a"), "-ERR numkeys should be greater than 0\r\n")
+	assertReply(t, execCmd(e, c, "sintercard", "3", "a", "b"), "-ERR Number of keys can't be greater than number of args\r\n")
+
+	assertReply(t, execCmd(e, c, "sinterstore", "d", "a", "b"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "object", "encoding", "d"), "$6\r\nintset\r\n")
+	assertMembers(t, execCmd(e, c, "smembers", "d"), "2", "3")
+	assertReply(t, execCmd(e, c, "sunionstore", "d", "d", "c"), ":4\r\n")
+	assertMembers(t, execCmd(e, c, "smembers", "d"), "2", "3", "4", "x")
+	assertReply(t, execCmd(e, c, "sdiffstore", "d", "a", "d"), ":1\r\n")
+	assertMembers(t, execCmd(e, c, "smembers", "d"), "1")
+
+	// an empty result removes destination along with its ttl
+	execCmd(e, c, "expire", "d", "100")
+	assertReply(t, execCmd(e, c, "sinterstore", "d", "a", "none"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "exists", "d"), ":0\r\n")
+	execCmd(e, c, "set", "str", "v", "ex", "100")
+	assertReply(t, execCmd(e, c, "sunionstore", "str", "a"), ":4\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "str"), ":-1\r\n")
+	assertReply(t, execCmd(e, c, "type", "str"), "+set\r\n")
+}
+
+func TestSetScan(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "sscan", "none", "0"), "*2\r\n$1\r\n0\r\n*0\r\n")
+	execCmd(e, c, "sadd", "ints", "3", "1", "12")
+	assertReply(t, execCmd(e, c, "sscan", "ints", "0", "match", "1*"), "*2\r\n$1\r\n0\r\n*2\r\n$1\r\n1\r\n$2\r\n12\r\n")
+	assertReply(t, execCmd(e, c, "sscan", "ints", "0", "novalues"), "-ERR syntax error\r\n")
+
+	for i := 0; i < 500; i++ {
+		execCmd(e, c, "sadd", "big", "m"+strconv.Itoa(i))
+	}
+	seen := make(map[string]bool)
+	cursor := "0"
+	calls := 0
+	for {
+		reply := execCmd(e, c, "sscan", "big", cursor, "count", "20").(*protocol.ArrayReply)
+		cursor = string(reply.Replies[0].(*protocol.BulkReply).Arg)
+		for _, member := range reply.Replies[1].(*protocol.MultiBulkReply).Args {
+			seen[string(member)] = true
+		}
+		calls++
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != 500 || calls < 10 {
+		t.Fatalf("%d members in %d calls", len(seen), calls)
+	}
+}
+
+func TestSetRollback(t *testing.T) {
+	testRollback(t, rollbackCase{
+		name:  "set",
+		setup: [][]string{{"sadd", "a", "1", "2"}, {"sadd", "d", "x"}},
+		cmds:  [][]string{{"smove", "a", "b", "1"}, {"sunionstore", "d", "a", "b"}, {"srem", "a", "2"}},
+		reads: [][]string{{"smembers", "a"}, {"smembers", "b"}, {"smembers", "d"}},
+	})
+}
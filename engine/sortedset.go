@@ -0,0 +1,1153 @@
+package engine
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/issimo1/redis-issimo/datastruct/set"
+	"github.com/issimo1/redis-issimo/datastruct/sortedset"
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+// getAsSortedSet returns the sorted set of key, or nil if key does not exist
+func (db *DB) getAsSortedSet(key string) (*sortedset.SortedSet, protocol.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	zs, ok := entity.RedisObject.(*sortedset.SortedSet)
+	if !ok {
+		return nil, protocol.MakeWrongTypeErrReply()
+	}
+	return zs, nil
+}
+
+// removeSortedSetIfEmpty deletes key once its sorted set has no element
+func (db *DB) removeSortedSetIfEmpty(key string, zs *sortedset.SortedSet) {
+	if zs.Len() == 0 {
+		db.Remove(key)
+	}
+}
+
+// storeSortedSet binds a sorted set of elements to key, key is removed if elements is empty
+func (db *DB) storeSortedSet(key string, elements []*sortedset.Element) {
+	db.Remove(key)
+	if len(elements) == 0 {
+		return
+	}
+	zs := sortedset.NewSortedSet()
+	for _, element := range elements {
+		zs.Add(element.Member, element.Score)
+	}
+	db.PutEntity(key, &payload.DataEntity{RedisObject: zs})
+}
+
+// parseScore parses a score, which is a float but not NaN
+func parseScore(arg []byte) (float64, bool) {
+	if len(arg) == 0 || arg[0] == ' ' {
+		return 0, false
+	}
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+func formatScore(score float64) []byte {
+	return []byte(protocol.FormatFloat(score))
+}
+
+// makeElementsReply returns the members of elements, followed by their scores if withScores
+func makeElementsReply(elements []*sortedset.Element, withScores bool) protocol.Reply {
+	if !withScores {
+		members := make([][]byte, len(elements))
+		for i, element := range elements {
+			members[i] = []byte(element.Member)
+		}
+		return protocol.MakeMultiBulkReply(members)
+	}
+	members := make([]protocol.Reply, len(elements))
+	scores := make([]protocol.Reply, len(elements))
+	for i, element := range elements {
+		members[i] = protocol.MakeBulkReply([]byte(element.Member))
+		scores[i] = protocol.MakeDoubleReply(element.Score)
+	}
+	return protocol.MakePairsReply(members, scores)
+}
+
+// zaddFlags are the options of ZADD
+type zaddFlags struct {
+	nx, xx, gt, lt, ch, incr bool
+}
+
+// parseZAddFlags parses the options of ZADD, it returns the index of the first score
+func parseZAddFlags(args [][]byte) (*zaddFlags, int, protocol.ErrorReply) {
+	flags := &zaddFlags{}
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			flags.nx = true
+		case "xx":
+			flags.xx = true
+		case "gt":
+			flags.gt = true
+		case "lt":
+			flags.lt = true
+		case "ch":
+			flags.ch = true
+		case "incr":
+			flags.incr = true
+		default:
+			goto done
+		}
+	}
+done:
+	elements := len(args) - i
+	if elements == 0 || elements%2 != 0 {
+		return nil, 0, protocol.MakeSyntaxErrReply()
+	}
+	if flags.incr && elements > 2 {
+		return nil, 0, protocol.MakeErrReply("ERR INCR option supports a single increment-element pair")
+	}
+	if flags.nx && flags.xx {
+		return nil, 0, protocol.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if flags.gt && flags.lt || (flags.gt || flags.lt) && flags.nx {
+		return nil, 0, protocol.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	return flags, i, nil
+}
+
+// execZAdd adds members with scores, ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func execZAdd(db *DB, args [][]byte) protocol.Reply {
+	flags, first, errReply := parseZAddFlags(args)
+	if errReply != nil {
+		return errReply
+	}
+	scores := make([]float64, 0, (len(args)-first)/2)
+	for i := first; i < len(args); i += 2 {
+		score, ok := parseScore(args[i])
+		if !ok {
+			return protocol.MakeErrReply("ERR value is not a valid float")
+		}
+		scores = append(scores, score)
+	}
+	key := string(args[0])
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	isNew := zs == nil
+	if isNew {
+		zs = sortedset.NewSortedSet()
+	}
+	added, changed := 0, 0
+	var result *sortedset.Element
+	for i, score := range scores {
+		member := string(args[first+2*i+1])
+		element, exists := zs.Get(member)
+		if exists && flags.nx || !exists && flags.xx {
+			continue
+		}
+		if flags.incr && exists {
+			score += element.Score
+			if math.IsNaN(score) {
+				db.removeSortedSetIfEmpty(key, zs)
+				return protocol.MakeErrReply("ERR resulting score is not a number (NaN)")
+			}
+		}
+		if exists && (flags.gt && score <= element.Score || flags.lt && score >= element.Score) {
+			continue
+		}
+		if !exists {
+			added++
+		} else if score != element.Score {
+			changed++
+		}
+		zs.Add(member, score)
+		result, _ = zs.Get(member)
+	}
+	if isNew && zs.Len() > 0 {
+		db.PutEntity(key, &payload.DataEntity{RedisObject: zs})
+	}
+	if added+changed > 0 {
+		if flags.incr {
+			// propagate the result rather than the increment as INCRBYFLOAT does
+			db.writeAof(toCmdLine2("zadd", args[0], formatScore(result.Score), []byte(result.Member)))
+		} else {
+			db.writeAof(toCmdLine3("zadd", args))
+		}
+	}
+	if flags.incr {
+		if result == nil {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeDoubleReply(result.Score)
+	}
+	if flags.ch {
+		return protocol.MakeIntReply(int64(added + changed))
+	}
+	return protocol.MakeIntReply(int64(added))
+}
+
+// execZIncrBy increments the score of member, ZINCRBY key increment member
+func execZIncrBy(db *DB, args [][]byte) protocol.Reply {
+	incr, ok := parseScore(args[1])
+	if !ok {
+		return protocol.MakeErrReply("ERR value is not a valid float")
+	}
+	key, member := string(args[0]), string(args[2])
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	score := incr
+	if zs == nil {
+		zs = sortedset.NewSortedSet()
+		db.PutEntity(key, &payload.DataEntity{RedisObject: zs})
+	} else if element, exists := zs.Get(member); exists {
+		score += element.Score
+		if math.IsNaN(score) {
+			return protocol.MakeErrReply("ERR resulting score is not a number (NaN)")
+		}
+	}
+	zs.Add(member, score)
+	db.writeAof(toCmdLine2("zadd", args[0], formatScore(score), args[2]))
+	return protocol.MakeDoubleReply(score)
+}
+
+// execZRem removes members, ZREM key member [member ...]
+func execZRem(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return protocol.MakeIntReply(0)
+	}
+	removed := 0
+	for _, member := range args[1:] {
+		if zs.Remove(string(member)) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		db.removeSortedSetIfEmpty(key, zs)
+		db.writeAof(toCmdLine3("zrem", args))
+	}
+	return protocol.MakeIntReply(int64(removed))
+}
+
+// execZScore returns the score of member, ZSCORE key member
+func execZScore(db *DB, args [][]byte) protocol.Reply {
+	zs, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	element, ok := zs.Get(string(args[1]))
+	if !ok {
+		return protocol.MakeNullBulkReply()
+	}
+	return protocol.MakeDoubleReply(element.Score)
+}
+
+// execZMScore returns the scores of members, ZMSCORE key member [member ...]
+func execZMScore(db *DB, args [][]byte) protocol.Reply {
+	zs, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	replies := make([]protocol.Reply, len(args)-1)
+	for i, member := range args[1:] {
+		replies[i] = protocol.MakeNullBulkReply()
+		if zs == nil {
+			continue
+		}
+		if element, ok := zs.Get(string(member)); ok {
+			replies[i] = protocol.MakeDoubleReply(element.Score)
+		}
+	}
+	return protocol.MakeArrayReply(replies)
+}
+
+// execZCard returns the number of elements, ZCARD key
+func execZCard(db *DB, args [][]byte) protocol.Reply {
+	zs, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(int64(zs.Len()))
+}
+
+func parseScoreBorders(minArg, maxArg []byte) (sortedset.Border, sortedset.Border, protocol.ErrorReply) {
+	min, ok := sortedset.ParseScoreBorder(string(minArg))
+	if !ok {
+		return nil, nil, protocol.MakeErrReply("ERR min or max is not a float")
+	}
+	max, ok := sortedset.ParseScoreBorder(string(maxArg))
+	if !ok {
+		return nil, nil, protocol.MakeErrReply("ERR min or max is not a float")
+	}
+	return min, max, nil
+}
+
+func parseLexBorders(minArg, maxArg []byte) (sortedset.Border, sortedset.Border, protocol.ErrorReply) {
+	min, ok := sortedset.ParseLexBorder(string(minArg))
+	if !ok {
+		return nil, nil, protocol.MakeErrReply("ERR min or max not valid string range item")
+	}
+	max, ok := sortedset.ParseLexBorder(string(maxArg))
+	if !ok {
+		return nil, nil, protocol.MakeErrReply("ERR min or max not valid string range item")
+	}
+	return min, max, nil
+}
+
+// execZCount returns the number of elements within the scores, ZCOUNT key min max
+func execZCount(db *DB, args [][]byte) protocol.Reply {
+	min, max, errReply := parseScoreBorders(args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	zs, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(int64(zs.RangeCount(min, max)))
+}
+
+// rankGeneric returns the rank of member, ZRANK|ZREVRANK key member [WITHSCORE]
+func rankGeneric(db *DB, args [][]byte, desc bool) protocol.Reply {
+	if len(args) > 3 || len(args) == 3 && strings.ToLower(string(args[2])) != "withscore" {
+		return protocol.MakeSyntaxErrReply()
+	}
+	withScore := len(args) == 3
+	zs, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	var rank int
+	ok := false
+	if zs != nil {
+		rank, ok = zs.GetRank(string(args[1]), desc)
+	}
+	if !ok {
+		if withScore {
+			return protocol.MakeNullArrayReply()
+		}
+		return protocol.MakeNullBulkReply()
+	}
+	if !withScore {
+		return protocol.MakeIntReply(int64(rank))
+	}
+	element, _ := zs.Get(string(args[1]))
+	return protocol.MakeArrayReply([]protocol.Reply{protocol.MakeIntReply(int64(rank)), protocol.MakeDoubleReply(element.Score)})
+}
+
+// execZRank returns the rank of member in ascending order, ZRANK key member [WITHSCORE]
+func execZRank(db *DB, args [][]byte) protocol.Reply {
+	return rankGeneric(db, args, false)
+}
+
+// execZRevRank returns the rank of member in descending order, ZREVRANK key member [WITHSCORE]
+func execZRevRank(db *DB, args [][]byte) protocol.Reply {
+	return rankGeneric(db, args, true)
+}
+
+const (
+	rangeByRank = iota
+	rangeByScore
+	rangeByLex
+)
+
+// zrangeSpec is the parsed range of ZRANGE: start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+type zrangeSpec struct {
+	by          int
+	rev         bool
+	start, stop int64
+	min, max    sortedset.Border
+	offset      int
+	// limit is the max number of elements, negative means no limit
+	limit      int
+	withScores bool
+}
+
+// parseZRange parses the range of ZRANGE from args, which starts with start and stop
+func parseZRange(args [][]byte, allowWithScores bool) (*zrangeSpec, protocol.ErrorReply) {
+	spec := &zrangeSpec{limit: -1}
+	hasLimit := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "byscore":
+			spec.by = rangeByScore
+		case "bylex":
+			spec.by = rangeByLex
+		case "rev":
+			spec.rev = true
+		case "withscores":
+			if !allowWithScores {
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			spec.withScores = true
+		case "limit":
+			if i+2 >= len(args) {
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			ints, errReply := parseInts(args[i+1], args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			spec.offset = int(max(min(ints[0], math.MaxInt32), -1))
+			spec.limit = int(max(min(ints[1], math.MaxInt32), -1))
+			hasLimit = true
+			i += 2
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+	}
+	if hasLimit && spec.by == rangeByRank {
+		return nil, protocol.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.by == rangeByLex {
+		return nil, protocol.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	minArg, maxArg := args[0], args[1]
+	if spec.rev {
+		// the max comes first with REV
+		minArg, maxArg = maxArg, minArg
+	}
+	var errReply protocol.ErrorReply
+	switch spec.by {
+	case rangeByRank:
+		var ints []int64
+		ints, errReply = parseInts(args[0], args[1])
+		if errReply == nil {
+			spec.start, spec.stop = ints[0], ints[1]
+		}
+	case rangeByScore:
+		spec.min, spec.max, errReply = parseScoreBorders(minArg, maxArg)
+	case rangeByLex:
+		spec.min, spec.max, errReply = parseLexBorders(minArg, maxArg)
+	}
+	if errReply != nil {
+		return nil, errReply
+	}
+	return spec, nil
+}
+
+// apply returns the elements of zs within the range
+func (spec *zrangeSpec) apply(zs *sortedset.SortedSet) []*sortedset.Element {
+	if spec.by == rangeByRank {
+		begin, end := normalizeRange(spec.start, spec.stop, zs.Len())
+		return zs.RangeByRank(begin, end, spec.rev)
+	}
+	if spec.offset < 0 {
+		return []*sortedset.Element{}
+	}
+	return zs.Range(spec.min, spec.max, spec.offset, spec.limit, spec.rev)
+}
+
+// execZRange returns the elements within a range,
+// ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func execZRange(db *DB, args [][]byte) protocol.Reply {
+	spec, errReply := parseZRange(args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+	zs, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	return makeElementsReply(spec.apply(zs), spec.withScores)
+}
+
+func prepareZRangeStore(args [][]byte) ([]string, []string) {
+	return []string{string(args[1])}, []string{string(args[0])}
+}
+
+// execZRangeStore stores the elements within a range to destination,
+// ZRANGESTORE dst src min max [BYSCORE|BYLEX] [REV] [LIMIT offset count]
+func execZRangeStore(db *DB, args [][]byte) protocol.Reply {
+	spec, errReply := parseZRange(args[2:], false)
+	if errReply != nil {
+		return errReply
+	}
+	zs, errReply := db.getAsSortedSet(string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	var elements []*sortedset.Element
+	if zs != nil {
+		elements = spec.apply(zs)
+	}
+	db.storeSortedSet(string(args[0]), elements)
+	db.writeAof(toCmdLine3("zrangestore", args))
+	return protocol.MakeIntReply(int64(len(elements)))
+}
+
+// popElements removes at most count elements of the lowest or the highest scores and propagates it
+func (db *DB) popElements(key string, zs *sortedset.SortedSet, count int, max bool) []*sortedset.Element {
+	var elements []*sortedset.Element
+	if max {
+		elements = zs.PopMax(count)
+	} else {
+		elements = zs.PopMin(count)
+	}
+	db.removeSortedSetIfEmpty(key, zs)
+	if len(elements) > 0 {
+		cmdLine := toCmdLine("zrem", key)
+		for _, element := range elements {
+			cmdLine = append(cmdLine, []byte(element.Member))
+		}
+		db.writeAof(cmdLine)
+	}
+	return elements
+}
+
+// zpopGeneric removes the elements of the lowest or the highest scores, ZPOPMIN|ZPOPMAX key [count]
+func zpopGeneric(db *DB, args [][]byte, max bool) protocol.Reply {
+	if len(args) > 2 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	withCount := len(args) == 2
+	count := int64(1)
+	if withCount {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count < 0 {
+			return protocol.MakeErrReply("ERR value is out of range, must be positive")
+		}
+	}
+	key := string(args[0])
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	elements := db.popElements(key, zs, int(min(count, math.MaxInt32)), max)
+	if !withCount {
+		// a single element is a flat [member, score] array
+		return protocol.MakeArrayReply([]protocol.Reply{
+			protocol.MakeBulkReply([]byte(elements[0].Member)),
+			protocol.MakeDoubleReply(elements[0].Score),
+		})
+	}
+	return makeElementsReply(elements, true)
+}
+
+// execZPopMin removes the elements of the lowest scores, ZPOPMIN key [count]
+func execZPopMin(db *DB, args [][]byte) protocol.Reply {
+	return zpopGeneric(db, args, false)
+}
+
+// execZPopMax removes the elements of the highest scores, ZPOPMAX key [count]
+func execZPopMax(db *DB, args [][]byte) protocol.Reply {
+	return zpopGeneric(db, args, true)
+}
+
+// execZRandMember returns random members, ZRANDMEMBER key [count [WITHSCORES]].
+// A positive count returns distinct members, a negative count may return a member several times.
+func execZRandMember(db *DB, args [][]byte) protocol.Reply {
+	if len(args) > 3 || (len(args) == 3 && strings.ToLower(string(args[2])) != "withscores") {
+		return protocol.MakeSyntaxErrReply()
+	}
+	withCount := len(args) >= 2
+	withScores := len(args) == 3
+	var count int64
+	if withCount {
+		var err error
+		count, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if count == math.MinInt64 || (withScores && count < -math.MaxInt64/2) {
+			return protocol.MakeErrReply("ERR value is out of range")
+		}
+	}
+	zs, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !withCount {
+		if zs == nil {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeBulkReply([]byte(zs.RandomMembers(1)[0]))
+	}
+	if zs == nil || count == 0 {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	var members []string
+	if count > 0 {
+		members = zs.RandomDistinctMembers(int(min(count, int64(zs.Len()))))
+	} else {
+		members = zs.RandomMembers(int(-count))
+	}
+	elements := make([]*sortedset.Element, len(members))
+	for i, member := range members {
+		elements[i], _ = zs.Get(member)
+	}
+	return makeElementsReply(elements, withScores)
+}
+
+const (
+	aggregateSum = iota
+	aggregateMin
+	aggregateMax
+)
+
+// zsetOpArgs are the parsed arguments of ZUNION, ZINTER, ZDIFF and their STORE variants:
+// numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+type zsetOpArgs struct {
+	keys       []string
+	weights    []float64
+	aggregate  int
+	withScores bool
+}
+
+// parseZSetOpKeys returns the keys given by numkeys, cmd is the command name used by the errors
+func parseZSetOpKeys(cmd string, args [][]byte) ([]string, protocol.ErrorReply) {
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys < 1 {
+		return nil, protocol.MakeErrReply("ERR at least 1 input key is needed for '" + cmd + "' command")
+	}
+	if numKeys > int64(len(args)-1) {
+		return nil, protocol.MakeSyntaxErrReply()
+	}
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(args[i+1])
+	}
+	return keys, nil
+}
+
+// parseZSetOpArgs parses the arguments of cmd starting with numkeys,
+// WEIGHTS and AGGREGATE are accepted only if allowAggregate, WITHSCORES only if allowWithScores
+func parseZSetOpArgs(cmd string, args [][]byte, allowAggregate, allowWithScores bool) (*zsetOpArgs, protocol.ErrorReply) {
+	keys, errReply := parseZSetOpKeys(cmd, args)
+	if errReply != nil {
+		return nil, errReply
+	}
+	op := &zsetOpArgs{keys: keys}
+	for i := len(keys) + 1; i < len(args); i++ {
+		switch option := strings.ToLower(string(args[i])); {
+		case option == "weights" && allowAggregate && i+len(keys) < len(args):
+			op.weights = make([]float64, len(keys))
+			for j := range keys {
+				weight, ok := parseScore(args[i+1+j])
+				if !ok {
+					return nil, protocol.MakeErrReply("ERR weight value is not a float")
+				}
+				op.weights[j] = weight
+			}
+			i += len(keys)
+		case option == "aggregate" && allowAggregate && i+1 < len(args):
+			switch strings.ToLower(string(args[i+1])) {
+			case "sum":
+				op.aggregate = aggregateSum
+			case "min":
+				op.aggregate = aggregateMin
+			case "max":
+				op.aggregate = aggregateMax
+			default:
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			i++
+		case option == "withscores" && allowWithScores:
+			op.withScores = true
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+	}
+	return op, nil
+}
+
+// getAsZSetSource returns the sorted set of key, a set is read as a sorted set whose scores are all 1
+func (db *DB) getAsZSetSource(key string) (*sortedset.SortedSet, protocol.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	switch val := entity.RedisObject.(type) {
+	case *sortedset.SortedSet:
+		return val, nil
+	case set.Set:
+		zs := sortedset.NewSortedSet()
+		val.ForEach(func(member string) bool {
+			zs.Add(member, 1)
+			return true
+		})
+		return zs, nil
+	default:
+		return nil, protocol.MakeWrongTypeErrReply()
+	}
+}
+
+// weightedScore multiplies score by weight, NaN caused by 0 * inf is 0 as redis does
+func weightedScore(score, weight float64) float64 {
+	result := score * weight
+	if math.IsNaN(result) {
+		return 0
+	}
+	return result
+}
+
+func aggregateScore(aggregate int, a, b float64) float64 {
+	switch aggregate {
+	case aggregateMin:
+		return min(a, b)
+	case aggregateMax:
+		return max(a, b)
+	default:
+		result := a + b
+		// inf + -inf
+		if math.IsNaN(result) {
+			return 0
+		}
+		return result
+	}
+}
+
+func (op *zsetOpArgs) weight(i int) float64 {
+	if op.weights == nil {
+		return 1
+	}
+	return op.weights[i]
+}
+
+// zunion merges the elements of sources into a new sorted set
+func zunion(sources []*sortedset.SortedSet, op *zsetOpArgs) *sortedset.SortedSet {
+	result := sortedset.NewSortedSet()
+	for i, zs := range sources {
+		if zs == nil {
+			continue
+		}
+		zs.ForEachByRank(0, zs.Len(), false, func(element *sortedset.Element) bool {
+			score := weightedScore(element.Score, op.weight(i))
+			if existing, ok := result.Get(element.Member); ok {
+				score = aggregateScore(op.aggregate, existing.Score, score)
+			}
+			result.Add(element.Member, score)
+			return true
+		})
+	}
+	return result
+}
+
+// zinter returns a new sorted set of the members in all of sources
+func zinter(sources []*sortedset.SortedSet, op *zsetOpArgs) *sortedset.SortedSet {
+	result := sortedset.NewSortedSet()
+	smallest := -1
+	for i, zs := range sources {
+		if zs == nil {
+			return result
+		}
+		if smallest < 0 || zs.Len() < sources[smallest].Len() {
+			smallest = i
+		}
+	}
+	sources[smallest].ForEachByRank(0, sources[smallest].Len(), false, func(element *sortedset.Element) bool {
+		var score float64
+		for i, zs := range sources {
+			other, ok := zs.Get(element.Member)
+			if !ok {
+				return true
+			}
+			if i == 0 {
+				score = weightedScore(other.Score, op.weight(i))
+			} else {
+				score = aggregateScore(op.aggregate, score, weightedScore(other.Score, op.weight(i)))
+			}
+		}
+		result.Add(element.Member, score)
+		return true
+	})
+	return result
+}
+
+// zdiff returns a new sorted set of the elements of the first source which are not in the others
+func zdiff(sources []*sortedset.SortedSet, op *zsetOpArgs) *sortedset.SortedSet {
+	result := sortedset.NewSortedSet()
+	if sources[0] == nil {
+		return result
+	}
+	sources[0].ForEachByRank(0, sources[0].Len(), false, func(element *sortedset.Element) bool {
+		for _, zs := range sources[1:] {
+			if zs == nil {
+				continue
+			}
+			if _, ok := zs.Get(element.Member); ok {
+				return true
+			}
+		}
+		result.Add(element.Member, element.Score)
+		return true
+	})
+	return result
+}
+
+type zsetOpFunc func(sources []*sortedset.SortedSet, op *zsetOpArgs) *sortedset.SortedSet
+
+func (db *DB) zsetOpGeneric(keys []string, op *zsetOpArgs, fn zsetOpFunc) (*sortedset.SortedSet, protocol.ErrorReply) {
+	sources := make([]*sortedset.SortedSet, len(keys))
+	for i, key := range keys {
+		zs, errReply := db.getAsZSetSource(key)
+		if errReply != nil {
+			return nil, errReply
+		}
+		sources[i] = zs
+	}
+	return fn(sources, op), nil
+}
+
+// zsetOp returns a command replying the result of fn, cmd is the command name used by the errors.
+// ZDIFF does not accept WEIGHTS and AGGREGATE, so allowAggregate is false for it.
+func zsetOp(cmd string, fn zsetOpFunc, allowAggregate bool) ExecFunc {
+	return func(db *DB, args [][]byte) protocol.Reply {
+		op, errReply := parseZSetOpArgs(cmd, args, allowAggregate, true)
+		if errReply != nil {
+			return errReply
+		}
+		result, errReply := db.zsetOpGeneric(op.keys, op, fn)
+		if errReply != nil {
+			return errReply
+		}
+		return makeElementsReply(result.RangeByRank(0, result.Len(), false), op.withScores)
+	}
+}
+
+// zsetOpStore returns a command storing the result of fn to destination
+func zsetOpStore(cmd string, fn zsetOpFunc, allowAggregate bool) ExecFunc {
+	return func(db *DB, args [][]byte) protocol.Reply {
+		op, errReply := parseZSetOpArgs(cmd, args[1:], allowAggregate, false)
+		if errReply != nil {
+			return errReply
+		}
+		result, errReply := db.zsetOpGeneric(op.keys, op, fn)
+		if errReply != nil {
+			return errReply
+		}
+		dest := string(args[0])
+		db.Remove(dest)
+		if result.Len() > 0 {
+			db.PutEntity(dest, &payload.DataEntity{RedisObject: result})
+		}
+		db.writeAof(toCmdLine3(cmd, args))
+		return protocol.MakeIntReply(int64(result.Len()))
+	}
+}
+
+func prepareZSetOp(args [][]byte) ([]string, []string) {
+	keys, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return keys, nil
+}
+
+// prepareZSetOpStore locks destination for write and the source keys for read
+func prepareZSetOpStore(args [][]byte) ([]string, []string) {
+	keys, _ := prepareZSetOp(args[1:])
+	return keys, []string{string(args[0])}
+}
+
+// execZInterCard returns the size of the intersection, ZINTERCARD numkeys key [key ...] [LIMIT limit]
+func execZInterCard(db *DB, args [][]byte) protocol.Reply {
+	keys, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return errReply
+	}
+	limit := int64(0)
+	for i := len(keys) + 1; i < len(args); i++ {
+		if strings.ToLower(string(args[i])) != "limit" || i+1 >= len(args) {
+			return protocol.MakeSyntaxErrReply()
+		}
+		var err error
+		limit, err = strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return protocol.MakeErrReply("ERR LIMIT can't be negative")
+		}
+		i++
+	}
+	result, errReply := db.zsetOpGeneric(keys, &zsetOpArgs{}, zinter)
+	if errReply != nil {
+		return errReply
+	}
+	count := int64(result.Len())
+	if limit > 0 {
+		count = min(count, limit)
+	}
+	return protocol.MakeIntReply(count)
+}
+
+// execZLexCount returns the number of elements within the members, ZLEXCOUNT key min max
+func execZLexCount(db *DB, args [][]byte) protocol.Reply {
+	min, max, errReply := parseLexBorders(args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	zs, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(int64(zs.RangeCount(min, max)))
+}
+
+// removeRangeGeneric removes the elements within the borders parsed by parse, ZREMRANGEBYSCORE|ZREMRANGEBYLEX key min max
+func removeRangeGeneric(db *DB, cmd string, args [][]byte,
+	parse func(minArg, maxArg []byte) (sortedset.Border, sortedset.Border, protocol.ErrorReply)) protocol.Reply {
+	min, max, errReply := parse(args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return protocol.MakeIntReply(0)
+	}
+	removed := zs.RemoveRange(min, max)
+	if len(removed) > 0 {
+		db.removeSortedSetIfEmpty(key, zs)
+		db.writeAof(toCmdLine3(cmd, args))
+	}
+	return protocol.MakeIntReply(int64(len(removed)))
+}
+
+// execZRemRangeByScore removes the elements within the scores, ZREMRANGEBYSCORE key min max
+func execZRemRangeByScore(db *DB, args [][]byte) protocol.Reply {
+	return removeRangeGeneric(db, "zremrangebyscore", args, parseScoreBorders)
+}
+
+// execZRemRangeByLex removes the elements within the members, ZREMRANGEBYLEX key min max
+func execZRemRangeByLex(db *DB, args [][]byte) protocol.Reply {
+	return removeRangeGeneric(db, "zremrangebylex", args, parseLexBorders)
+}
+
+// execZRemRangeByRank removes the elements between start and stop, ZREMRANGEBYRANK key start stop
+func execZRemRangeByRank(db *DB, args [][]byte) protocol.Reply {
+	ints, errReply := parseInts(args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	zs, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if zs == nil {
+		return protocol.MakeIntReply(0)
+	}
+	begin, end := normalizeRange(ints[0], ints[1], zs.Len())
+	removed := zs.RemoveByRank(begin, end)
+	if len(removed) > 0 {
+		db.removeSortedSetIfEmpty(key, zs)
+		db.writeAof(toCmdLine3("zremrangebyrank", args))
+	}
+	return protocol.MakeIntReply(int64(len(removed)))
+}
+
+// zmpopArgs is the parsed arguments of ZMPOP: numkeys key [key ...] MIN|MAX [COUNT count]
+type zmpopArgs struct {
+	keys  []string
+	max   bool
+	count int64
+}
+
+func parseZMPopArgs(args [][]byte) (*zmpopArgs, protocol.ErrorReply) {
+	keys, errReply := parseNumKeys(args)
+	if errReply != nil {
+		return nil, errReply
+	}
+	rest := args[len(keys)+1:]
+	if len(rest) == 0 {
+		return nil, protocol.MakeSyntaxErrReply()
+	}
+	result := &zmpopArgs{keys: keys, count: 1}
+	switch strings.ToLower(string(rest[0])) {
+	case "min":
+	case "max":
+		result.max = true
+	default:
+		return nil, protocol.MakeSyntaxErrReply()
+	}
+	rest = rest[1:]
+	if len(rest) == 0 {
+		return result, nil
+	}
+	if len(rest) != 2 || strings.ToLower(string(rest[0])) != "count" {
+		return nil, protocol.MakeSyntaxErrReply()
+	}
+	count, err := strconv.ParseInt(string(rest[1]), 10, 64)
+	if err != nil || count <= 0 {
+		return nil, protocol.MakeErrReply("ERR count should be greater than 0")
+	}
+	result.count = count
+	return result, nil
+}
+
+// zmpopGeneric pops from the first non-empty sorted set of keys, and returns nil if all of them are empty
+func zmpopGeneric(db *DB, zmpop *zmpopArgs) protocol.Reply {
+	for _, key := range zmpop.keys {
+		zs, errReply := db.getAsSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if zs == nil {
+			continue
+		}
+		elements := db.popElements(key, zs, int(min(zmpop.count, math.MaxInt32)), zmpop.max)
+		replies := make([]protocol.Reply, len(elements))
+		for i, element := range elements {
+			replies[i] = protocol.MakeArrayReply([]protocol.Reply{
+				protocol.MakeBulkReply([]byte(element.Member)),
+				protocol.MakeDoubleReply(element.Score),
+			})
+		}
+		return protocol.MakeArrayReply([]protocol.Reply{
+			protocol.MakeBulkReply([]byte(key)),
+			protocol.MakeArrayReply(replies),
+		})
+	}
+	return nil
+}
+
+// execZMPop pops elements from the first non-empty sorted set, ZMPOP numkeys key [key ...] MIN|MAX [COUNT count]
+func execZMPop(db *DB, args [][]byte) protocol.Reply {
+	zmpop, errReply := parseZMPopArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	if reply := zmpopGeneric(db, zmpop); reply != nil {
+		return reply
+	}
+	return protocol.MakeNullArrayReply()
+}
+
+// blockingZPopGeneric pops an element from the first non-empty sorted set, and returns nil if all of them are empty
+func blockingZPopGeneric(db *DB, args [][]byte, max bool) protocol.Reply {
+	for _, arg := range args[:len(args)-1] {
+		key := string(arg)
+		zs, errReply := db.getAsSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if zs == nil {
+			continue
+		}
+		element := db.popElements(key, zs, 1, max)[0]
+		return protocol.MakeArrayReply([]protocol.Reply{
+			protocol.MakeBulkReply(arg),
+			protocol.MakeBulkReply([]byte(element.Member)),
+			protocol.MakeDoubleReply(element.Score),
+		})
+	}
+	return nil
+}
+
+// BZPOPMIN key [key ...] timeout
+var bzPopMinCommand = &blockingCommand{
+	parse: parseBlockingPop,
+	try: func(db *DB, args [][]byte) protocol.Reply {
+		return blockingZPopGeneric(db, args, false)
+	},
+	timeoutReply: func() protocol.Reply {
+		return protocol.MakeNullArrayReply()
+	},
+}
+
+// BZPOPMAX key [key ...] timeout
+var bzPopMaxCommand = &blockingCommand{
+	parse: parseBlockingPop,
+	try: func(db *DB, args [][]byte) protocol.Reply {
+		return blockingZPopGeneric(db, args, true)
+	},
+	timeoutReply: func() protocol.Reply {
+		return protocol.MakeNullArrayReply()
+	},
+}
+
+// BZMPOP timeout numkeys key [key ...] MIN|MAX [COUNT count]
+var bzmPopCommand = &blockingCommand{
+	parse: func(args [][]byte) (time.Duration, []string, protocol.ErrorReply) {
+		timeout, errReply := parseTimeout(args[0])
+		if errReply != nil {
+			return 0, nil, errReply
+		}
+		zmpop, errReply := parseZMPopArgs(args[1:])
+		if errReply != nil {
+			return 0, nil, errReply
+		}
+		return timeout, zmpop.keys, nil
+	},
+	try: func(db *DB, args [][]byte) protocol.Reply {
+		zmpop, _ := parseZMPopArgs(args[1:])
+		return zmpopGeneric(db, zmpop)
+	},
+	timeoutReply: func() protocol.Reply {
+		return protocol.MakeNullArrayReply()
+	},
+}
+
+func init() {
+	registerCommand("ZAdd", -4, execZAdd, writeFirstKey, rollbackFirstKey)
+	registerCommand("ZIncrBy", 4, execZIncrBy, writeFirstKey, rollbackFirstKey)
+	registerCommand("ZRem", -3, execZRem, writeFirstKey, rollbackFirstKey)
+	registerCommand("ZScore", 3, execZScore, readFirstKey, nil)
+	registerCommand("ZMScore", -3, execZMScore, readFirstKey, nil)
+	registerCommand("ZCard", 2, execZCard, readFirstKey, nil)
+	registerCommand("ZCount", 4, execZCount, readFirstKey, nil)
+	registerCommand("ZRank", -3, execZRank, readFirstKey, nil)
+	registerCommand("ZRevRank", -3, execZRevRank, readFirstKey, nil)
+	registerCommand("ZRange", -4, execZRange, readFirstKey, nil)
+	registerCommand("ZRangeStore", -5, execZRangeStore, prepareZRangeStore, rollbackFirstKey)
+	registerCommand("ZPopMin", -2, execZPopMin, writeFirstKey, rollbackFirstKey)
+	registerCommand("ZPopMax", -2, execZPopMax, writeFirstKey, rollbackFirstKey)
+	registerCommand("ZRandMember", -2, execZRandMember, readFirstKey, nil)
+	registerCommand("ZUnion", -3, zsetOp("zunion", zunion, true), prepareZSetOp, nil)
+	registerCommand("ZInter", -3, zsetOp("zinter", zinter, true), prepareZSetOp, nil)
+	registerCommand("ZDiff", -3, zsetOp("zdiff", zdiff, false), prepareZSetOp, nil)
+	registerCommand("ZUnionStore", -4, zsetOpStore("zunionstore", zunion, true), prepareZSetOpStore, rollbackFirstKey)
+	registerCommand("ZInterStore", -4, zsetOpStore("zinterstore", zinter, true), prepareZSetOpStore, rollbackFirstKey)
+	registerCommand("ZDiffStore", -4, zsetOpStore("zdiffstore", zdiff, false), prepareZSetOpStore, rollbackFirstKey)
+	registerCommand("ZInterCard", -3, execZInterCard, prepareZSetOp, nil)
+	registerCommand("ZLexCount", 4, execZLexCount, readFirstKey, nil)
+	registerCommand("ZRemRangeByScore", 4, execZRemRangeByScore, writeFirstKey, rollbackFirstKey)
+	registerCommand("ZRemRangeByLex", 4, execZRemRangeByLex, writeFirstKey, rollbackFirstKey)
+	registerCommand("ZRemRangeByRank", 4, execZRemRangeByRank, writeFirstKey, rollbackFirstKey)
+	registerCommand("ZMPop", -4, execZMPop, prepareLMPop, undoLMPop)
+	registerBlockingCommand("BZPopMin", -3, bzPopMinCommand, prepareBlockingPop, undoBlockingPop)
+	registerBlockingCommand("BZPopMax", -3, bzPopMaxCommand, prepareBlockingPop, undoBlockingPop)
+	registerBlockingCommand("BZMPop", -5, bzmPopCommand, prepareBLMPop, undoBLMPop)
+}
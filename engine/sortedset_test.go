@@ -0,0 +1,361 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+func TestZAdd(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "zadd", "z", "1", "a", "2", "b", "3", "c"), ":3\r\n")
+	assertReply(t, execCmd(e, c, "type", "z"), "+zset\r\n")
+	assertReply(t, execCmd(e, c, "object", "encoding", "z"), "$8\r\nskiplist\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "5", "a", "4", "d"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "ch", "5", "a", "6", "b", "7", "e"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "nx", "1", "a", "8", "f"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "xx", "ch", "1", "a", "1", "g"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "zscore", "z", "g"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "gt", "ch", "0", "a", "9", "b"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "lt", "ch", "0", "a", "10", "b"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "zmscore", "z", "a", "b", "x"), "*3\r\n$1\r\n0\r\n$1\r\n9\r\n$-1\r\n")
+	assertReply3(t, execCmd(e, c, "zmscore", "z", "a", "x"), "*2\r\n,0\r\n_\r\n")
+
+	assertReply(t, execCmd(e, c, "zadd", "z", "incr", "1.5", "a"), "$3\r\n1.5\r\n")
+	assertReply3(t, execCmd(e, c, "zadd", "z", "incr", "1", "a"), ",2.5\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "nx", "incr", "1", "a"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "gt", "incr", "-1", "a"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "incr", "1", "a", "2", "b"), "-ERR INCR option supports a single increment-element pair\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "nx", "xx", "1", "a"), "-ERR XX and NX options at the same time are not compatible\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "gt", "lt", "1", "a"), "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "nx", "gt", "1", "a"), "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "1", "a", "2"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "x", "a"), "-ERR value is not a valid float\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "nan", "a"), "-ERR value is not a valid float\r\n")
+	assertReply(t, execCmd(e, c, "zadd", "z", "1", "a", "x", "b"), "-ERR value is not a valid float\r\n")
+
+	execCmd(e, c, "zadd", "inf", "inf", "a")
+	assertReply(t, execCmd(e, c, "zadd", "inf", "incr", "-inf", "a"), "-ERR resulting score is not a number (NaN)\r\n")
+	assertReply(t, execCmd(e, c, "zincrby", "inf", "-inf", "a"), "-ERR resulting score is not a number (NaN)\r\n")
+	assertReply(t, execCmd(e, c, "zscore", "inf", "a"), "$3\r\ninf\r\n")
+	// nothing is created when no member is added
+	assertReply(t, execCmd(e, c, "zadd", "none", "xx", "1", "a"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "exists", "none"), ":0\r\n")
+
+	assertReply(t, execCmd(e, c, "zincrby", "n", "2", "a"), "$1\r\n2\r\n")
+	assertReply(t, execCmd(e, c, "zincrby", "n", "0.5", "a"), "$3\r\n2.5\r\n")
+	assertReply(t, execCmd(e, c, "zincrby", "n", "x", "a"), "-ERR value is not a valid float\r\n")
+	assertReply(t, execCmd(e, c, "zcard", "n"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "zcard", "none"), ":0\r\n")
+
+	assertReply(t, execCmd(e, c, "zrem", "n", "a", "x"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "exists", "n"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "zrem", "none", "a"), ":0\r\n")
+
+	execCmd(e, c, "set", "str", "1")
+	assertReply(t, execCmd(e, c, "zadd", "str", "1", "a"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "str", "0", "-1"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+}
+
+func TestZAddPropagation(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	var aof []string
+	db, _ := e.selectDB(0)
+	db.writeAof = func(cmdLine [][]byte) { aof = append(aof, string(protocol.MakeMultiBulkReply(cmdLine).ToBytes())) }
+	execCmd(e, c, "zadd", "z", "1", "a")
+	execCmd(e, c, "zadd", "z", "nx", "2", "a")
+	execCmd(e, c, "zadd", "z", "incr", "0.1", "a")
+	execCmd(e, c, "zincrby", "z", "1", "b")
+	execCmd(e, c, "zpopmin", "z", "5")
+	expected := []string{
+		string(protocol.MakeMultiBulkReply(toCmdLine("zadd", "z", "1", "a")).ToBytes()),
+		string(protocol.MakeMultiBulkReply(toCmdLine("zadd", "z", "1.1", "a")).ToBytes()),
+		string(protocol.MakeMultiBulkReply(toCmdLine("zadd", "z", "1", "b")).ToBytes()),
+		string(protocol.MakeMultiBulkReply(toCmdLine("zrem", "z", "b", "a")).ToBytes()),
+	}
+	if len(aof) != len(expected) {
+		t.Fatalf("aof %q", aof)
+	}
+	for i := range aof {
+		if aof[i] != expected[i] {
+			t.Fatalf("aof %d is %q, want %q", i, aof[i], expected[i])
+		}
+	}
+}
+
+func TestZRange(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "zadd", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e")
+	assertReply(t, execCmd(e, c, "zrange", "z", "0", "-1"), "*5\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n$1\r\ne\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "1", "2", "withscores"), "*4\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\nc\r\n$1\r\n3\r\n")
+	assertReply3(t, execCmd(e, c, "zrange", "z", "1", "2", "withscores"), "*2\r\n*2\r\n$1\r\nb\r\n,2\r\n*2\r\n$1\r\nc\r\n,3\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "0", "1", "rev"), "*2\r\n$1\r\ne\r\n$1\r\nd\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "3", "1"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "-100", "100"), "*5\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n$1\r\ne\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "none", "0", "-1"), "*0\r\n")
+
+	assertReply(t, execCmd(e, c, "zrange", "z", "(1", "3", "byscore"), "*2\r\n$1\r\nb\r\n$1\r\nc\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "-inf", "+inf", "byscore", "limit", "1", "2"), "*2\r\n$1\r\nb\r\n$1\r\nc\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "-inf", "+inf", "byscore", "limit", "3", "-1"), "*2\r\n$1\r\nd\r\n$1\r\ne\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "-inf", "+inf", "byscore", "limit", "-1", "2"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "(5", "2", "byscore", "rev", "withscores"),
+		"*6\r\n$1\r\nd\r\n$1\r\n4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nb\r\n$1\r\n2\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "2", "(5", "byscore", "rev"), "*0\r\n")
+
+	execCmd(e, c, "zadd", "lex", "0", "a", "0", "b", "0", "c", "0", "d")
+	assertReply(t, execCmd(e, c, "zrange", "lex", "[b", "(d", "bylex"), "*2\r\n$1\r\nb\r\n$1\r\nc\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "lex", "+", "(b", "bylex", "rev", "limit", "0", "1"), "*1\r\n$1\r\nd\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "lex", "-", "+", "bylex", "limit", "3", "5"), "*1\r\n$1\r\nd\r\n")
+
+	assertReply(t, execCmd(e, c, "zrange", "z", "0", "1", "limit", "0", "1"),
+		"-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "-", "+", "bylex", "withscores"),
+		"-ERR syntax error, WITHSCORES not supported in combination with BYLEX\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "a", "1"), "-ERR value is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "a", "1", "byscore"), "-ERR min or max is not a float\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "a", "+", "bylex"), "-ERR min or max not valid string range item\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "0", "1", "limit", "0"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "0", "1", "foo"), "-ERR syntax error\r\n")
+
+	assertReply(t, execCmd(e, c, "zcount", "z", "(1", "+inf"), ":4\r\n")
+	assertReply(t, execCmd(e, c, "zcount", "z", "3", "(3"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "zcount", "z", "x", "1"), "-ERR min or max is not a float\r\n")
+	assertReply(t, execCmd(e, c, "zcount", "none", "0", "1"), ":0\r\n")
+
+	assertReply(t, execCmd(e, c, "zrank", "z", "c"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "zrevrank", "z", "c", "withscore"), "*2\r\n:2\r\n$1\r\n3\r\n")
+	assertReply3(t, execCmd(e, c, "zrank", "z", "e", "withscore"), "*2\r\n:4\r\n,5\r\n")
+	assertReply(t, execCmd(e, c, "zrank", "z", "x"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "zrank", "z", "x", "withscore"), "*-1\r\n")
+	assertReply(t, execCmd(e, c, "zrank", "none", "x"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "zrank", "z", "a", "foo"), "-ERR syntax error\r\n")
+}
+
+func TestZRangeStore(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "zadd", "z", "1", "a", "2", "b", "3", "c")
+	assertReply(t, execCmd(e, c, "zrangestore", "dst", "z", "2", "+inf", "byscore"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "dst", "0", "-1", "withscores"), "*4\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\nc\r\n$1\r\n3\r\n")
+	assertReply(t, execCmd(e, c, "zrangestore", "dst", "z", "0", "0", "rev"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "dst", "0", "-1"), "*1\r\n$1\r\nc\r\n")
+	assertReply(t, execCmd(e, c, "zrangestore", "dst", "z", "0", "1", "withscores"), "-ERR syntax error\r\n")
+	// an empty result removes destination
+	execCmd(e, c, "expire", "dst", "100")
+	assertReply(t, execCmd(e, c, "zrangestore", "dst", "none", "0", "-1"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "exists", "dst"), ":0\r\n")
+	execCmd(e, c, "set", "str", "1", "ex", "100")
+	assertReply(t, execCmd(e, c, "zrangestore", "str", "z", "0", "-1"), ":3\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "str"), ":-1\r\n")
+}
+
+func TestZPop(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "zadd", "z", "1", "a", "2", "b", "3", "c", "4", "d")
+	assertReply(t, execCmd(e, c, "zpopmin", "z"), "*2\r\n$1\r\na\r\n$1\r\n1\r\n")
+	assertReply3(t, execCmd(e, c, "zpopmax", "z"), "*2\r\n$1\r\nd\r\n,4\r\n")
+	assertReply(t, execCmd(e, c, "zpopmax", "z", "0"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "zpopmax", "z", "-1"), "-ERR value is out of range, must be positive\r\n")
+	assertReply3(t, execCmd(e, c, "zpopmax", "z", "5"), "*2\r\n*2\r\n$1\r\nc\r\n,3\r\n*2\r\n$1\r\nb\r\n,2\r\n")
+	assertReply(t, execCmd(e, c, "exists", "z"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "zpopmin", "z"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "zpopmin", "z", "1", "2"), "-ERR syntax error\r\n")
+}
+
+func TestZRandMember(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "zrandmember", "none"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "zrandmember", "none", "2"), "*0\r\n")
+	execCmd(e, c, "zadd", "z", "1", "a", "2", "b", "3", "c")
+	scores := map[string]string{"a": "1", "b": "2", "c": "3"}
+	reply := execCmd(e, c, "zrandmember", "z")
+	if _, ok := scores[string(reply.(*protocol.BulkReply).Arg)]; !ok {
+		t.Fatalf("got %q", reply.ToBytes())
+	}
+	args := execCmd(e, c, "zrandmember", "z", "5").(*protocol.MultiBulkReply).Args
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		seen[string(arg)] = true
+	}
+	if len(args) != 3 || len(seen) != 3 {
+		t.Fatalf("got %q", args)
+	}
+	pairs := execCmd(e, c, "zrandmember", "z", "-6", "withscores").(*protocol.PairsReply)
+	if len(pairs.Keys) != 6 {
+		t.Fatalf("got %d members", len(pairs.Keys))
+	}
+	for i, key := range pairs.Keys {
+		member := string(key.(*protocol.BulkReply).Arg)
+		if scores[member] != string(pairs.Values[i].ToBytes()[4:5]) {
+			t.Fatalf("score of %s is %q", member, pairs.Values[i].ToBytes())
+		}
+	}
+	assertReply(t, execCmd(e, c, "zrandmember", "z", "x"), "-ERR value is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "zrandmember", "z", "-9223372036854775807", "withscores"), "-ERR value is out of range\r\n")
+	assertReply(t, execCmd(e, c, "zrandmember", "z", "1", "foo"), "-ERR syntax error\r\n")
+}
+
+func TestZSetCopy(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "zadd", "z", "1", "a", "2.5", "b")
+	execCmd(e, c, "copy", "z", "z2")
+	execCmd(e, c, "zadd", "z2", "3", "a")
+	assertReply(t, execCmd(e, c, "zscore", "z", "a"), "$1\r\n1\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z2", "0", "-1"), "*2\r\n$1\r\nb\r\n$1\r\na\r\n")
+}
+
+func TestZSetOps(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "zadd", "a", "1", "x", "2", "y", "3", "z")
+	execCmd(e, c, "zadd", "b", "10", "y", "20", "z", "30", "w")
+	execCmd(e, c, "sadd", "s", "x", "w")
+	assertReply(t, execCmd(e, c, "zunion", "2", "a", "b", "withscores"),
+		"*8\r\n$1\r\nx\r\n$1\r\n1\r\n$1\r\ny\r\n$2\r\n12\r\n$1\r\nz\r\n$2\r\n23\r\n$1\r\nw\r\n$2\r\n30\r\n")
+	assertReply(t, execCmd(e, c, "zunion", "2", "a", "b", "weights", "2", "0.5", "aggregate", "max", "withscores"),
+		"*8\r\n$1\r\nx\r\n$1\r\n2\r\n$1\r\ny\r\n$1\r\n5\r\n$1\r\nz\r\n$2\r\n10\r\n$1\r\nw\r\n$2\r\n15\r\n")
+	assertReply(t, execCmd(e, c, "zinter", "2", "a", "b", "aggregate", "min", "withscores"),
+		"*4\r\n$1\r\ny\r\n$1\r\n2\r\n$1\r\nz\r\n$1\r\n3\r\n")
+	assertReply(t, execCmd(e, c, "zinter", "2", "a", "none"), "*0\r\n")
+	assertReply(t, execCmd(e, c, "zdiff", "2", "a", "b", "withscores"), "*2\r\n$1\r\nx\r\n$1\r\n1\r\n")
+	// a set is read as a sorted set whose scores are 1
+	assertReply(t, execCmd(e, c, "zinter", "2", "s", "b", "withscores"), "*2\r\n$1\r\nw\r\n$2\r\n31\r\n")
+	assertReply3(t, execCmd(e, c, "zunion", "1", "s", "withscores"), "*2\r\n*2\r\n$1\r\nw\r\n,1\r\n*2\r\n$1\r\nx\r\n,1\r\n")
+	// 0 * inf is 0, inf + -inf is 0
+	execCmd(e, c, "zadd", "inf", "inf", "x")
+	execCmd(e, c, "zadd", "ninf", "-inf", "x")
+	assertReply(t, execCmd(e, c, "zunion", "1", "inf", "weights", "0", "withscores"), "*2\r\n$1\r\nx\r\n$1\r\n0\r\n")
+	assertReply(t, execCmd(e, c, "zunion", "2", "inf", "ninf", "withscores"), "*2\r\n$1\r\nx\r\n$1\r\n0\r\n")
+
+	assertReply(t, execCmd(e, c, "zunion", "0", "a"), "-ERR at least 1 input key is needed for 'zunion' command\r\n")
+	assertReply(t, execCmd(e, c, "zunion", "3", "a", "b"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "zunion", "2", "a", "b", "weights", "1"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "zunion", "2", "a", "b", "weights", "1", "x"), "-ERR weight value is not a float\r\n")
+	assertReply(t, execCmd(e, c, "zunion", "2", "a", "b", "aggregate", "avg"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "zdiff", "2", "a", "b", "weights", "1", "1"), "-ERR syntax error\r\n")
+	execCmd(e, c, "set", "str", "1")
+	assertReply(t, execCmd(e, c, "zunion", "2", "a", "str"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	assertReply(t, execCmd(e, c, "zunionstore", "dst", "2", "a", "b"), ":4\r\n")
+	assertReply(t, execCmd(e, c, "zscore", "dst", "z"), "$2\r\n23\r\n")
+	assertReply(t, execCmd(e, c, "zinterstore", "dst", "2", "dst", "s", "weights", "1", "100"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "dst", "0", "-1", "withscores"),
+		"*4\r\n$1\r\nx\r\n$3\r\n101\r\n$1\r\nw\r\n$3\r\n130\r\n")
+	assertReply(t, execCmd(e, c, "zinterstore", "dst", "2", "a", "b", "withscores"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "zdiffstore", "dst", "0", "a"), "-ERR at least 1 input key is needed for 'zdiffstore' command\r\n")
+	assertReply(t, execCmd(e, c, "zdiffstore", "dst", "2", "a", "a"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "exists", "dst"), ":0\r\n")
+
+	assertReply(t, execCmd(e, c, "zintercard", "2", "a", "b"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "zintercard", "2", "a", "b", "limit", "1"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "zintercard", "2", "a", "s"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "zintercard", "1", "a", "limit", "-1"), "-ERR LIMIT can't be negative\r\n")
+	assertReply(t, execCmd(e, c, "zintercard", "0", "a"), "-ERR numkeys should be greater than 0\r\n")
+}
+
+func TestZRemRange(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "zadd", "lex", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e")
+	assertReply(t, execCmd(e, c, "zlexcount", "lex", "-", "+"), ":5\r\n")
+	assertReply(t, execCmd(e, c, "zlexcount", "lex", "(a", "[c"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "zlexcount", "lex", "a", "[c"), "-ERR min or max not valid string range item\r\n")
+	assertReply(t, execCmd(e, c, "zlexcount", "none", "-", "+"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "zremrangebylex", "lex", "[b", "(d"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "lex", "0", "-1"), "*3\r\n$1\r\na\r\n$1\r\nd\r\n$1\r\ne\r\n")
+	assertReply(t, execCmd(e, c, "zremrangebylex", "lex", "-", "+"), ":3\r\n")
+	assertReply(t, execCmd(e, c, "exists", "lex"), ":0\r\n")
+
+	execCmd(e, c, "zadd", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e")
+	assertReply(t, execCmd(e, c, "zremrangebyscore", "z", "(1", "2"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "zremrangebyscore", "z", "x", "2"), "-ERR min or max is not a float\r\n")
+	assertReply(t, execCmd(e, c, "zremrangebyscore", "z", "10", "+inf"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "zremrangebyrank", "z", "-2", "-1"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "zremrangebyrank", "z", "5", "10"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "zremrangebyrank", "z", "0", "x"), "-ERR value is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "zrange", "z", "0", "-1"), "*2\r\n$1\r\na\r\n$1\r\nc\r\n")
+	assertReply(t, execCmd(e, c, "zremrangebyrank", "z", "0", "-1"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "exists", "z"), ":0\r\n")
+}
+
+func TestZMPop(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "zadd", "b", "1", "x", "2", "y", "3", "z")
+	assertReply(t, execCmd(e, c, "zmpop", "2", "a", "b", "min"), "*2\r\n$1\r\nb\r\n*1\r\n*2\r\n$1\r\nx\r\n$1\r\n1\r\n")
+	assertReply3(t, execCmd(e, c, "zmpop", "1", "b", "max", "count", "5"),
+		"*2\r\n$1\r\nb\r\n*2\r\n*2\r\n$1\r\nz\r\n,3\r\n*2\r\n$1\r\ny\r\n,2\r\n")
+	assertReply(t, execCmd(e, c, "zmpop", "1", "b", "max"), "*-1\r\n")
+	assertReply(t, execCmd(e, c, "zmpop", "1", "b", "left"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "zmpop", "1", "b", "min", "count", "0"), "-ERR count should be greater than 0\r\n")
+
+	execCmd(e, c, "zadd", "b", "1", "x", "2", "y")
+	assertReply(t, execCmd(e, c, "bzpopmin", "a", "b", "0"), "*3\r\n$1\r\nb\r\n$1\r\nx\r\n$1\r\n1\r\n")
+	assertReply3(t, execCmd(e, c, "bzpopmax", "b", "0"), "*3\r\n$1\r\nb\r\n$1\r\ny\r\n,2\r\n")
+	assertReply(t, execCmd(e, c, "bzmpop", "0", "1", "b", "maximum"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "bzpopmin", "a", "-1"), "-ERR timeout is negative\r\n")
+	execCmd(e, c, "rpush", "l", "1")
+	assertReply(t, execCmd(e, c, "bzpopmin", "l", "0"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	// never blocks inside MULTI
+	execCmd(e, c, "multi")
+	execCmd(e, c, "bzpopmin", "a", "0")
+	execCmd(e, c, "bzmpop", "0", "1", "a", "min")
+	assertReply(t, execCmd(e, c, "exec"), "*2\r\n*-1\r\n*-1\r\n")
+}
+
+func TestBlockingZPop(t *testing.T) {
+	e := NewEngine()
+	c1 := connection.NewFakeConn()
+	c2 := connection.NewFakeConn()
+	c := connection.NewFakeConn()
+
+	r1 := execAsync(e, c1, "bzpopmax", "a", "b", "0")
+	waitBlocked(t, e, 1)
+	r2 := execAsync(e, c2, "bzmpop", "0", "1", "b", "min", "count", "2")
+	waitBlocked(t, e, 2)
+	execCmd(e, c, "zadd", "b", "1", "x", "2", "y", "3", "z")
+	assertReply(t, receive(t, r1, time.Second), "*3\r\n$1\r\nb\r\n$1\r\nz\r\n$1\r\n3\r\n")
+	assertReply(t, receive(t, r2, time.Second),
+		"*2\r\n$1\r\nb\r\n*2\r\n*2\r\n$1\r\nx\r\n$1\r\n1\r\n*2\r\n$1\r\ny\r\n$1\r\n2\r\n")
+	waitBlocked(t, e, 0)
+	assertReply(t, execCmd(e, c, "exists", "b"), ":0\r\n")
+
+	// a sorted set stored by another command serves the blocked clients as well
+	execCmd(e, c, "zadd", "src", "5", "m")
+	r1 = execAsync(e, c1, "bzpopmin", "dst", "0.1")
+	waitBlocked(t, e, 1)
+	execCmd(e, c, "zunionstore", "dst", "1", "src")
+	assertReply(t, receive(t, r1, time.Second), "*3\r\n$3\r\ndst\r\n$1\r\nm\r\n$1\r\n5\r\n")
+
+	r1 = execAsync(e, c1, "bzpopmin", "dst", "0.1")
+	assertReply(t, receive(t, r1, 3*time.Second), "*-1\r\n")
+}
+
+func TestZSetRollback(t *testing.T) {
+	testRollback(t, rollbackCase{
+		name:  "sorted set",
+		setup: [][]string{{"zadd", "z", "1", "a", "2.5", "b"}},
+		cmds: [][]string{{"zadd", "z", "5", "a", "1", "c"}, {"zincrby", "z", "2", "b"}, {"zpopmax", "z"},
+			{"zrangestore", "r", "z", "0", "0"}, {"zrem", "z", "c"}},
+		reads: [][]string{{"zrange", "z", "0", "-1", "withscores"}, {"zrange", "r", "0", "-1"}},
+	})
+}
+
+func TestZSetStoreRollback(t *testing.T) {
+	testRollback(t, rollbackCase{
+		name:  "sorted set store",
+		setup: [][]string{{"zadd", "a", "1", "x", "2", "y", "3", "z"}, {"zadd", "d", "5", "w"}},
+		cmds: [][]string{{"zunionstore", "d", "1", "a"}, {"zinterstore", "i", "2", "a", "d"},
+			{"zremrangebyrank", "a", "0", "0"}, {"zmpop", "1", "a", "max"}},
+		reads: [][]string{{"zrange", "a", "0", "-1"}, {"zrange", "d", "0", "-1", "withscores"}, {"zrange", "i", "0", "-1"}},
+	})
+}
@@ -0,0 +1,1633 @@
+package engine
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/issimo1/redis-issimo/datastruct/stream"
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/config"
+)
+
+const (
+	errInvalidStreamID = "ERR Invalid stream ID specified as stream command argument"
+	errXAddSmallerID   = "ERR The ID specified in XADD is equal or smaller than the target stream top item"
+	errXGroupNoKey     = "ERR The XGROUP subcommand requires the key to exist. " +
+		"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."
+)
+
+// getAsStream returns the stream of key, or nil if key does not exist
+func (db *DB) getAsStream(key string) (*stream.Stream, protocol.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	s, ok := entity.RedisObject.(*stream.Stream)
+	if !ok {
+		return nil, protocol.MakeWrongTypeErrReply()
+	}
+	return s, nil
+}
+
+// getStreamGroup returns the stream of key and its consumer group, errReply is NOGROUP if either is missing
+func (db *DB) getStreamGroup(key, name string) (*stream.Stream, *stream.Group, protocol.ErrorReply) {
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if s != nil {
+		if g, ok := s.Group(name); ok {
+			return s, g, nil
+		}
+	}
+	return nil, nil, protocol.MakeErrReply("NOGROUP No such key '" + key + "' or consumer group '" + name + "'")
+}
+
+func newStream() *stream.Stream {
+	return stream.NewStream(config.GlobalConfig.StreamNodeMaxEntries, config.GlobalConfig.StreamNodeMaxBytes)
+}
+
+func formatStreamID(id stream.ID) []byte {
+	return []byte(id.String())
+}
+
+// parseStrictStreamID parses ms-seq or ms, whose sequence is missingSeq
+func parseStrictStreamID(arg []byte, missingSeq uint64) (stream.ID, protocol.ErrorReply) {
+	id, ok := stream.ParseID(string(arg), missingSeq)
+	if !ok {
+		return id, protocol.MakeErrReply(errInvalidStreamID)
+	}
+	return id, nil
+}
+
+// parseStreamID parses an ID as parseStrictStreamID, besides - and + for the least and the greatest IDs
+func parseStreamID(arg []byte, missingSeq uint64) (stream.ID, protocol.ErrorReply) {
+	switch string(arg) {
+	case "-":
+		return stream.MinID, nil
+	case "+":
+		return stream.MaxID, nil
+	}
+	return parseStrictStreamID(arg, missingSeq)
+}
+
+// parseRangeID parses a bound of an interval, a missing sequence is the least for start and the greatest for end,
+// and an ID prefixed by ( excludes itself
+func parseRangeID(arg []byte, isEnd bool) (stream.ID, protocol.ErrorReply) {
+	missingSeq := uint64(0)
+	if isEnd {
+		missingSeq = math.MaxUint64
+	}
+	if len(arg) == 0 || arg[0] != '(' {
+		return parseStreamID(arg, missingSeq)
+	}
+	id, errReply := parseStrictStreamID(arg[1:], missingSeq)
+	if errReply != nil {
+		return id, errReply
+	}
+	if isEnd {
+		if id, ok := id.Prev(); ok {
+			return id, nil
+		}
+		return id, protocol.MakeErrReply("ERR invalid end ID for the interval")
+	}
+	if id, ok := id.Next(); ok {
+		return id, nil
+	}
+	return id, protocol.MakeErrReply("ERR invalid start ID for the interval")
+}
+
+// parseCount parses the COUNT option of stream commands, a negative count is 0
+func parseCount(arg []byte) (int, protocol.ErrorReply) {
+	count, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return int(max(count, 0)), nil
+}
+
+func makeEntryReply(entry *stream.Entry) protocol.Reply {
+	return protocol.MakeArrayReply([]protocol.Reply{
+		protocol.MakeBulkReply(formatStreamID(entry.ID)),
+		protocol.MakeMultiBulkReply(entry.Fields),
+	})
+}
+
+func makeEntriesReply(entries []*stream.Entry) protocol.Reply {
+	replies := make([]protocol.Reply, len(entries))
+	for i, entry := range entries {
+		replies[i] = makeEntryReply(entry)
+	}
+	return protocol.MakeArrayReply(replies)
+}
+
+func makeIDsReply(ids []stream.ID) protocol.Reply {
+	args := make([][]byte, len(ids))
+	for i, id := range ids {
+		args[i] = formatStreamID(id)
+	}
+	return protocol.MakeMultiBulkReply(args)
+}
+
+// streamTrim is the trimming option of XADD and XTRIM, MAXLEN|MINID [=|~] threshold [LIMIT count]
+type streamTrim struct {
+	byMinID bool
+	approx  bool
+	maxLen  int
+	minID   stream.ID
+	// limit is -1 if LIMIT is absent
+	limit int
+}
+
+// parseStreamTrim parses the trimming option starting at args[i], it returns the index after the option
+func parseStreamTrim(args [][]byte, i int) (*streamTrim, int, protocol.ErrorReply) {
+	trim := &streamTrim{byMinID: strings.EqualFold(string(args[i]), "minid"), limit: -1}
+	i++
+	if i < len(args) {
+		switch string(args[i]) {
+		case "~":
+			trim.approx = true
+			i++
+		case "=":
+			i++
+		}
+	}
+	if i >= len(args) {
+		return nil, 0, protocol.MakeSyntaxErrReply()
+	}
+	if trim.byMinID {
+		id, errReply := parseStrictStreamID(args[i], 0)
+		if errReply != nil {
+			return nil, 0, errReply
+		}
+		trim.minID = id
+	} else {
+		maxLen, err := strconv.ParseInt(string(args[i]), 10, 64)
+		if err != nil {
+			return nil, 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if maxLen < 0 {
+			return nil, 0, protocol.MakeErrReply("ERR The MAXLEN argument must be >= 0.")
+		}
+		trim.maxLen = int(maxLen)
+	}
+	i++
+	if i+1 < len(args) && strings.EqualFold(string(args[i]), "limit") {
+		limit, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return nil, 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return nil, 0, protocol.MakeErrReply("ERR The LIMIT argument must be >= 0.")
+		}
+		if !trim.approx {
+			return nil, 0, protocol.MakeErrReply("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		trim.limit = int(limit)
+		i += 2
+	}
+	return trim, i, nil
+}
+
+// apply trims s and returns the number of removed entries
+func (trim *streamTrim) apply(s *stream.Stream) int {
+	limit := 0
+	if trim.approx {
+		limit = trim.limit
+		if limit < 0 {
+			// redis limits the work of an approximate trimming by default
+			limit = 100 * config.GlobalConfig.StreamNodeMaxEntries
+		}
+	}
+	if trim.byMinID {
+		return s.TrimByMinID(trim.minID, trim.approx, limit)
+	}
+	return s.TrimByLen(trim.maxLen, trim.approx, limit)
+}
+
+// propagateTrim writes the result of trimming key as an exact XTRIM, since approximate trimming depends on the nodes
+func (db *DB) propagateTrim(key []byte, s *stream.Stream) {
+	if first, ok := s.FirstID(); ok {
+		db.writeAof(toCmdLine2("xtrim", key, []byte("MINID"), formatStreamID(first)))
+	} else {
+		db.writeAof(toCmdLine2("xtrim", key, []byte("MAXLEN"), []byte("0")))
+	}
+}
+
+// xaddID is the ID argument of XADD, which is *, ms-* or ms-seq
+type xaddID struct {
+	id     stream.ID
+	autoMs bool
+	// autoSeq is true if the sequence is generated
+	autoSeq bool
+}
+
+func parseXAddID(arg []byte) (*xaddID, protocol.ErrorReply) {
+	if string(arg) == "*" {
+		return &xaddID{autoMs: true, autoSeq: true}, nil
+	}
+	if msPart, ok := strings.CutSuffix(string(arg), "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return nil, protocol.MakeErrReply(errInvalidStreamID)
+		}
+		return &xaddID{id: stream.ID{Ms: ms}, autoSeq: true}, nil
+	}
+	id, errReply := parseStrictStreamID(arg, 0)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if id == stream.MinID {
+		return nil, protocol.MakeErrReply("ERR The ID specified in XADD must be greater than 0-0")
+	}
+	return &xaddID{id: id}, nil
+}
+
+// resolve returns the ID to add after last
+func (x *xaddID) resolve(last stream.ID) (stream.ID, protocol.ErrorReply) {
+	if x.autoMs {
+		ms := uint64(time.Now().UnixMilli())
+		if ms > last.Ms {
+			return stream.ID{Ms: ms}, nil
+		}
+		id, ok := last.Next()
+		if !ok {
+			return id, protocol.MakeErrReply("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, nil
+	}
+	id := x.id
+	if x.autoSeq && id.Ms == last.Ms {
+		if last.Seq == math.MaxUint64 {
+			return id, protocol.MakeErrReply(errXAddSmallerID)
+		}
+		id.Seq = last.Seq + 1
+	}
+	if !last.Less(id) {
+		return id, protocol.MakeErrReply(errXAddSmallerID)
+	}
+	return id, nil
+}
+
+// execXAdd appends an entry,
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func execXAdd(db *DB, args [][]byte) protocol.Reply {
+	noMkStream := false
+	var trim *streamTrim
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		if opt == "nomkstream" {
+			noMkStream = true
+			continue
+		}
+		if opt != "maxlen" && opt != "minid" {
+			break
+		}
+		if trim != nil {
+			return protocol.MakeErrReply("ERR syntax error, MAXLEN and MINID options at the same time are not compatible")
+		}
+		next, errReply := 0, protocol.ErrorReply(nil)
+		trim, next, errReply = parseStreamTrim(args, i)
+		if errReply != nil {
+			return errReply
+		}
+		i = next - 1
+	}
+	fields := len(args) - i - 1
+	if fields <= 0 || fields%2 != 0 {
+		return protocol.MakeArgNumErrReply("xadd")
+	}
+	x, errReply := parseXAddID(args[i])
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil && noMkStream {
+		return protocol.MakeNullBulkReply()
+	}
+	isNew := s == nil
+	if isNew {
+		s = newStream()
+	}
+	id, errReply := x.resolve(s.LastID())
+	if errReply != nil {
+		return errReply
+	}
+	s.Add(id, args[i+1:])
+	if isNew {
+		db.PutEntity(key, &payload.DataEntity{RedisObject: s})
+	}
+	cmdLine := toCmdLine2("xadd", args[0], formatStreamID(id))
+	db.writeAof(append(cmdLine, args[i+1:]...))
+	if trim != nil && trim.apply(s) > 0 {
+		db.propagateTrim(args[0], s)
+	}
+	return protocol.MakeBulkReply(formatStreamID(id))
+}
+
+// execXLen returns the number of entries, XLEN key
+func execXLen(db *DB, args [][]byte) protocol.Reply {
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(int64(s.Len()))
+}
+
+// xrangeGeneric replies the entries in an interval, args are key start end [COUNT count] for XRANGE
+// and key end start [COUNT count] for XREVRANGE
+func xrangeGeneric(db *DB, args [][]byte, rev bool) protocol.Reply {
+	startArg, endArg := args[1], args[2]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, errReply := parseRangeID(startArg, false)
+	if errReply != nil {
+		return errReply
+	}
+	end, errReply := parseRangeID(endArg, true)
+	if errReply != nil {
+		return errReply
+	}
+	count := -1
+	if len(args) > 3 {
+		if len(args) != 5 || !strings.EqualFold(string(args[3]), "count") {
+			return protocol.MakeSyntaxErrReply()
+		}
+		if count, errReply = parseCount(args[4]); errReply != nil {
+			return errReply
+		}
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if count == 0 {
+		return protocol.MakeNullArrayReply()
+	}
+	if s == nil {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	return makeEntriesReply(s.Range(start, end, count, rev))
+}
+
+// execXRange returns the entries between start and end, XRANGE key start end [COUNT count]
+func execXRange(db *DB, args [][]byte) protocol.Reply {
+	return xrangeGeneric(db, args, false)
+}
+
+// execXRevRange returns the entries between end and start in reverse order, XREVRANGE key end start [COUNT count]
+func execXRevRange(db *DB, args [][]byte) protocol.Reply {
+	return xrangeGeneric(db, args, true)
+}
+
+// execXDel deletes entries, XDEL key id [id ...]
+func execXDel(db *DB, args [][]byte) protocol.Reply {
+	ids := make([]stream.ID, len(args)-1)
+	for i, arg := range args[1:] {
+		id, errReply := parseStrictStreamID(arg, 0)
+		if errReply != nil {
+			return errReply
+		}
+		ids[i] = id
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.MakeIntReply(0)
+	}
+	deleted := 0
+	for _, id := range ids {
+		if s.Delete(id) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		db.writeAof(toCmdLine3("xdel", args))
+	}
+	return protocol.MakeIntReply(int64(deleted))
+}
+
+// execXTrim removes the oldest entries, XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func execXTrim(db *DB, args [][]byte) protocol.Reply {
+	opt := strings.ToLower(string(args[1]))
+	if opt != "maxlen" && opt != "minid" {
+		return protocol.MakeSyntaxErrReply()
+	}
+	trim, next, errReply := parseStreamTrim(args, 1)
+	if errReply != nil {
+		return errReply
+	}
+	if next != len(args) {
+		return protocol.MakeSyntaxErrReply()
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.MakeIntReply(0)
+	}
+	removed := trim.apply(s)
+	if removed > 0 {
+		db.propagateTrim(args[0], s)
+	}
+	return protocol.MakeIntReply(int64(removed))
+}
+
+// execXSetID sets the metadata of a stream,
+// XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func execXSetID(db *DB, args [][]byte) protocol.Reply {
+	lastID, errReply := parseStrictStreamID(args[1], 0)
+	if errReply != nil {
+		return errReply
+	}
+	entriesAdded := int64(-1)
+	var maxDeletedID *stream.ID
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return protocol.MakeSyntaxErrReply()
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "entriesadded":
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if n < 0 {
+				return protocol.MakeErrReply("ERR entries_added must be positive")
+			}
+			entriesAdded = n
+		case "maxdeletedid":
+			id, errReply := parseStrictStreamID(args[i+1], 0)
+			if errReply != nil {
+				return errReply
+			}
+			if lastID.Less(id) {
+				return protocol.MakeErrReply("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+			}
+			maxDeletedID = &id
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.MakeErrReply("ERR no such key")
+	}
+	if last := s.Range(stream.MinID, stream.MaxID, 1, true); len(last) > 0 && lastID.Less(last[0].ID) {
+		return protocol.MakeErrReply("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	if entriesAdded >= 0 && entriesAdded < int64(s.Len()) {
+		return protocol.MakeErrReply("ERR The entries_added specified in XSETID is smaller than the target stream length")
+	}
+	added, deleted := s.EntriesAdded(), s.MaxDeletedID()
+	if entriesAdded >= 0 {
+		added = uint64(entriesAdded)
+	}
+	if maxDeletedID != nil {
+		deleted = *maxDeletedID
+	}
+	s.SetLastID(lastID, added, deleted)
+	db.writeAof(toCmdLine3("xsetid", args))
+	return protocol.MakeOkReply()
+}
+
+// xreadArgs are the arguments of XREAD and XREADGROUP
+type xreadArgs struct {
+	group    string
+	consumer string
+	count    int
+	// block is negative if BLOCK is absent
+	block time.Duration
+	noAck bool
+	keys  []string
+	ids   [][]byte
+}
+
+// parseXReadArgs parses XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...],
+// or XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func parseXReadArgs(args [][]byte, withGroup bool) (*xreadArgs, protocol.ErrorReply) {
+	cmd := "xread"
+	if withGroup {
+		cmd = "xreadgroup"
+	}
+	xa := &xreadArgs{block: -1}
+	i := 0
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		more := len(args) - i - 1
+		if opt == "streams" && more > 0 {
+			break
+		}
+		switch {
+		case opt == "count" && more > 0:
+			count, errReply := parseCount(args[i+1])
+			if errReply != nil {
+				return nil, errReply
+			}
+			xa.count = count
+			i++
+		case opt == "block" && more > 0:
+			ms, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return nil, protocol.MakeErrReply("ERR timeout is negative")
+			}
+			if ms > math.MaxInt64/int64(time.Millisecond) {
+				return nil, protocol.MakeErrReply("ERR timeout is out of range")
+			}
+			xa.block = time.Duration(ms) * time.Millisecond
+			i++
+		case opt == "group" && more > 1:
+			if !withGroup {
+				return nil, protocol.MakeErrReply("ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead.")
+			}
+			xa.group, xa.consumer = string(args[i+1]), string(args[i+2])
+			i += 2
+		case opt == "noack" && withGroup:
+			xa.noAck = true
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+	}
+	if i == len(args) {
+		return nil, protocol.MakeSyntaxErrReply()
+	}
+	streams := args[i+1:]
+	if len(streams)%2 != 0 {
+		return nil, protocol.MakeErrReply("ERR Unbalanced '" + cmd +
+			"' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+	if withGroup && xa.group == "" {
+		return nil, protocol.MakeErrReply("ERR Missing GROUP option for XREADGROUP")
+	}
+	n := len(streams) / 2
+	xa.keys = make([]string, n)
+	xa.ids = streams[n:]
+	for j := 0; j < n; j++ {
+		xa.keys[j] = string(streams[j])
+		switch id := string(xa.ids[j]); {
+		case id == "$" && withGroup:
+			return nil, protocol.MakeErrReply("ERR The $ ID is meaningless in the context of XREADGROUP: " +
+				"you want to read the history of this consumer by specifying a proper ID, " +
+				"or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		case id == ">" && !withGroup:
+			return nil, protocol.MakeErrReply("ERR The > ID can be specified only when calling XREADGROUP " +
+				"using the GROUP <group> <consumer> option.")
+		case id == "$" || id == ">":
+		default:
+			if _, errReply := parseStrictStreamID(xa.ids[j], 0); errReply != nil {
+				return nil, errReply
+			}
+		}
+	}
+	return xa, nil
+}
+
+func prepareXRead(args [][]byte) ([]string, []string) {
+	xa, errReply := parseXReadArgs(args, false)
+	if errReply != nil {
+		return nil, nil
+	}
+	return xa.keys, nil
+}
+
+func prepareXReadGroup(args [][]byte) ([]string, []string) {
+	xa, errReply := parseXReadArgs(args, true)
+	if errReply != nil {
+		return nil, nil
+	}
+	return nil, xa.keys
+}
+
+func undoXReadGroup(db *DB, args [][]byte) [][][]byte {
+	_, keys := prepareXReadGroup(args)
+	return rollbackGivenKeys(db, keys...)
+}
+
+// execXRead replies the entries after the given IDs, or nil if there is none
+func execXRead(db *DB, args [][]byte) protocol.Reply {
+	xa, errReply := parseXReadArgs(args, false)
+	if errReply != nil {
+		return errReply
+	}
+	var keys, values []protocol.Reply
+	for i, key := range xa.keys {
+		s, errReply := db.getAsStream(key)
+		if errReply != nil {
+			return errReply
+		}
+		if s == nil {
+			continue
+		}
+		after := s.LastID()
+		if string(xa.ids[i]) != "$" {
+			after, _ = parseStrictStreamID(xa.ids[i], 0)
+		}
+		start, ok := after.Next()
+		if !ok {
+			continue
+		}
+		entries := s.Range(start, stream.MaxID, xa.count, false)
+		if len(entries) == 0 {
+			continue
+		}
+		keys = append(keys, protocol.MakeBulkReply([]byte(key)))
+		values = append(values, makeEntriesReply(entries))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return protocol.MakeKeyedArraysReply(keys, values)
+}
+
+// pinLastIDs replaces $ by the last IDs of the streams, so that a blocked XREAD waits for the entries added later
+func pinLastIDs(db *DB, args [][]byte) [][]byte {
+	xa, errReply := parseXReadArgs(args, false)
+	if errReply != nil {
+		return args
+	}
+	pinned := make([][]byte, len(args))
+	copy(pinned, args)
+	first := len(args) - len(xa.ids)
+	for i, id := range xa.ids {
+		if string(id) != "$" {
+			continue
+		}
+		lastID := stream.MinID
+		if s, _ := db.getAsStream(xa.keys[i]); s != nil {
+			lastID = s.LastID()
+		}
+		pinned[first+i] = formatStreamID(lastID)
+	}
+	return pinned
+}
+
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+var xreadCommand = &blockingCommand{
+	parse: func(args [][]byte) (time.Duration, []string, protocol.ErrorReply) {
+		xa, errReply := parseXReadArgs(args, false)
+		if errReply != nil {
+			return 0, nil, errReply
+		}
+		return xa.block, xa.keys, nil
+	},
+	prepare: pinLastIDs,
+	try:     execXRead,
+	timeoutReply: func() protocol.Reply {
+		return protocol.MakeNullArrayReply()
+	},
+}
+
+// propagateClaim writes pe as XCLAIM, which restores the pending entry whoever owns it
+func (db *DB) propagateClaim(key string, g *stream.Group, pe *stream.PendingEntry) {
+	db.writeAof(toCmdLine("xclaim", key, g.Name, pe.Consumer.Name, "0", pe.ID.String(),
+		"TIME", strconv.FormatInt(pe.DeliveryTime, 10), "RETRYCOUNT", strconv.FormatUint(pe.DeliveryCount, 10),
+		"FORCE", "JUSTID", "LASTID", g.LastID.String()))
+}
+
+// propagateGroupID writes the last delivered ID of g and the entries read by it
+func (db *DB) propagateGroupID(key string, g *stream.Group) {
+	db.writeAof(toCmdLine("xgroup", "setid", key, g.Name, g.LastID.String(),
+		"ENTRIESREAD", strconv.FormatInt(g.EntriesRead, 10)))
+}
+
+// getOrCreateConsumer returns the consumer of g named name, which is created if absent
+func (db *DB) getOrCreateConsumer(key string, g *stream.Group, name string, now int64) *stream.Consumer {
+	c, created := g.CreateConsumer(name, now)
+	if created {
+		db.writeAof(toCmdLine("xgroup", "createconsumer", key, g.Name, name))
+	}
+	return c
+}
+
+// readNewEntries delivers at most count entries never delivered to g, they become pending for c unless noAck
+func (db *DB) readNewEntries(key string, s *stream.Stream, g *stream.Group, c *stream.Consumer,
+	count int, noAck bool, now int64) []*stream.Entry {
+	start, ok := g.LastID.Next()
+	if !ok {
+		return nil
+	}
+	entries := s.Range(start, stream.MaxID, count, false)
+	for _, entry := range entries {
+		s.Deliver(g, entry.ID)
+		if !noAck {
+			db.propagateClaim(key, g, g.Assign(entry.ID, c, now, 1))
+		}
+	}
+	if len(entries) > 0 {
+		db.propagateGroupID(key, g)
+	}
+	return entries
+}
+
+// readPendingEntries delivers again at most count entries pending for c after id, a deleted entry has nil fields
+func (db *DB) readPendingEntries(key string, s *stream.Stream, g *stream.Group, c *stream.Consumer,
+	after stream.ID, count int, now int64) protocol.Reply {
+	replies := make([]protocol.Reply, 0)
+	start, ok := after.Next()
+	if !ok {
+		return protocol.MakeArrayReply(replies)
+	}
+	for _, pe := range g.PendingRange(start, stream.MaxID, count, c) {
+		entry, ok := s.Get(pe.ID)
+		if !ok {
+			replies = append(replies, protocol.MakeArrayReply([]protocol.Reply{
+				protocol.MakeBulkReply(formatStreamID(pe.ID)),
+				protocol.MakeNullArrayReply(),
+			}))
+			continue
+		}
+		pe.DeliveryTime = now
+		pe.DeliveryCount++
+		db.propagateClaim(key, g, pe)
+		replies = append(replies, makeEntryReply(entry))
+	}
+	return protocol.MakeArrayReply(replies)
+}
+
+// execXReadGroup reads the entries for a consumer of groups, it returns nil if there is no new entry
+func execXReadGroup(db *DB, args [][]byte) protocol.Reply {
+	xa, errReply := parseXReadArgs(args, true)
+	if errReply != nil {
+		return errReply
+	}
+	streams := make([]*stream.Stream, len(xa.keys))
+	groups := make([]*stream.Group, len(xa.keys))
+	for i, key := range xa.keys {
+		s, errReply := db.getAsStream(key)
+		if errReply != nil {
+			return errReply
+		}
+		var g *stream.Group
+		if s != nil {
+			g, _ = s.Group(xa.group)
+		}
+		if g == nil {
+			return protocol.MakeErrReply("NOGROUP No such key '" + key + "' or consumer group '" +
+				xa.group + "' in XREADGROUP with GROUP option")
+		}
+		streams[i], groups[i] = s, g
+	}
+	now := time.Now().UnixMilli()
+	var keys, values []protocol.Reply
+	for i, key := range xa.keys {
+		s, g := streams[i], groups[i]
+		c := db.getOrCreateConsumer(key, g, xa.consumer, now)
+		c.SeenTime = now
+		if string(xa.ids[i]) != ">" {
+			// the history of a consumer is always replied even if it is empty
+			after, _ := parseStrictStreamID(xa.ids[i], 0)
+			keys = append(keys, protocol.MakeBulkReply([]byte(key)))
+			values = append(values, db.readPendingEntries(key, s, g, c, after, xa.count, now))
+			continue
+		}
+		entries := db.readNewEntries(key, s, g, c, xa.count, xa.noAck, now)
+		if len(entries) == 0 {
+			continue
+		}
+		c.ActiveTime = now
+		keys = append(keys, protocol.MakeBulkReply([]byte(key)))
+		values = append(values, makeEntriesReply(entries))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return protocol.MakeKeyedArraysReply(keys, values)
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+var xreadGroupCommand = &blockingCommand{
+	parse: func(args [][]byte) (time.Duration, []string, protocol.ErrorReply) {
+		xa, errReply := parseXReadArgs(args, true)
+		if errReply != nil {
+			return 0, nil, errReply
+		}
+		return xa.block, xa.keys, nil
+	},
+	try: execXReadGroup,
+	timeoutReply: func() protocol.Reply {
+		return protocol.MakeNullArrayReply()
+	},
+}
+
+func prepareXGroup(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return nil, []string{string(args[1])}
+}
+
+func undoXGroup(db *DB, args [][]byte) [][][]byte {
+	if len(args) < 2 {
+		return nil
+	}
+	return rollbackGivenKeys(db, string(args[1]))
+}
+
+// xgroupArity is the least and the most number of arguments of the XGROUP subcommands, including the subcommand
+var xgroupArity = map[string][2]int{
+	"create":         {4, 7},
+	"setid":          {4, 6},
+	"destroy":        {3, 3},
+	"createconsumer": {4, 4},
+	"delconsumer":    {4, 4},
+}
+
+func makeNoGroupErr(key, name string) protocol.ErrorReply {
+	return protocol.MakeErrReply("NOGROUP No such consumer group '" + name + "' for key name '" + key + "'")
+}
+
+// parseEntriesRead parses the ENTRIESREAD option of XGROUP CREATE and XGROUP SETID
+func parseEntriesRead(arg []byte) (int64, protocol.ErrorReply) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if n < stream.InvalidEntriesRead {
+		return 0, protocol.MakeErrReply("ERR value for ENTRIESREAD must be positive or -1")
+	}
+	return n, nil
+}
+
+// execXGroup manages consumer groups, XGROUP CREATE|SETID|DESTROY|CREATECONSUMER|DELCONSUMER|HELP key group ...
+func execXGroup(db *DB, args [][]byte) protocol.Reply {
+	sub := strings.ToLower(string(args[0]))
+	if sub == "help" && len(args) == 1 {
+		return protocol.MakeMultiBulkReply([][]byte{
+			[]byte("XGROUP <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			[]byte("CREATE <key> <groupname> <id|$> [option]"),
+			[]byte("    Create a new consumer group. Options are:"),
+			[]byte("    * MKSTREAM"),
+			[]byte("      Create the empty stream if it does not exist."),
+			[]byte("    * ENTRIESREAD entries_read"),
+			[]byte("      Set the group's entries_read counter (internal use)."),
+			[]byte("CREATECONSUMER <key> <groupname> <consumer>"),
+			[]byte("    Create a new consumer in the specified group."),
+			[]byte("DELCONSUMER <key> <groupname> <consumer>"),
+			[]byte("    Remove the specified consumer."),
+			[]byte("DESTROY <key> <groupname>"),
+			[]byte("    Remove the specified group."),
+			[]byte("SETID <key> <groupname> <id|$> [ENTRIESREAD entries_read]"),
+			[]byte("    Set the current group ID and entries_read counter."),
+			[]byte("HELP"),
+			[]byte("    Print this help."),
+		})
+	}
+	arity, ok := xgroupArity[sub]
+	if !ok {
+		return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try XGROUP HELP.")
+	}
+	if len(args) < arity[0] || len(args) > arity[1] {
+		return protocol.MakeArgNumErrReply("xgroup|" + sub)
+	}
+	key, name := string(args[1]), string(args[2])
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sub == "create" {
+		return db.xgroupCreate(s, args)
+	}
+	if s == nil {
+		return protocol.MakeErrReply(errXGroupNoKey)
+	}
+	if sub == "destroy" {
+		if !s.DestroyGroup(name) {
+			return protocol.MakeIntReply(0)
+		}
+		db.writeAof(toCmdLine3("xgroup", args))
+		return protocol.MakeIntReply(1)
+	}
+	g, ok := s.Group(name)
+	if !ok {
+		return makeNoGroupErr(key, name)
+	}
+	switch sub {
+	case "setid":
+		return db.xgroupSetID(s, g, args)
+	case "createconsumer":
+		if _, created := g.CreateConsumer(string(args[3]), time.Now().UnixMilli()); !created {
+			return protocol.MakeIntReply(0)
+		}
+		db.writeAof(toCmdLine3("xgroup", args))
+		return protocol.MakeIntReply(1)
+	default:
+		pending := g.DeleteConsumer(string(args[3]))
+		if pending < 0 {
+			return protocol.MakeIntReply(0)
+		}
+		db.writeAof(toCmdLine3("xgroup", args))
+		return protocol.MakeIntReply(int64(pending))
+	}
+}
+
+// xgroupCreate runs XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read], s is nil if key does not exist
+func (db *DB) xgroupCreate(s *stream.Stream, args [][]byte) protocol.Reply {
+	key, name := string(args[1]), string(args[2])
+	mkStream := false
+	entriesRead := int64(stream.InvalidEntriesRead)
+	for i := 4; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "mkstream":
+			mkStream = true
+		case "entriesread":
+			if i+1 >= len(args) {
+				return protocol.MakeSyntaxErrReply()
+			}
+			n, errReply := parseEntriesRead(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			entriesRead = n
+			i++
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	lastID := stream.MinID
+	if string(args[3]) == "$" {
+		if s != nil {
+			lastID = s.LastID()
+		}
+	} else {
+		id, errReply := parseStreamID(args[3], 0)
+		if errReply != nil {
+			return errReply
+		}
+		lastID = id
+	}
+	if s == nil && !mkStream {
+		return protocol.MakeErrReply(errXGroupNoKey)
+	}
+	created := s == nil
+	if created {
+		s = newStream()
+	}
+	if _, ok := s.CreateGroup(name, lastID, entriesRead); !ok {
+		return protocol.MakeErrReply("BUSYGROUP Consumer Group name already exists")
+	}
+	cmdLine := toCmdLine("xgroup", "create", key, name, lastID.String(),
+		"ENTRIESREAD", strconv.FormatInt(entriesRead, 10))
+	if created {
+		db.PutEntity(key, &payload.DataEntity{RedisObject: s})
+		cmdLine = append(cmdLine, []byte("MKSTREAM"))
+	}
+	db.writeAof(cmdLine)
+	return protocol.MakeOkReply()
+}
+
+// xgroupSetID runs XGROUP SETID key group id|$ [ENTRIESREAD entries-read]
+func (db *DB) xgroupSetID(s *stream.Stream, g *stream.Group, args [][]byte) protocol.Reply {
+	entriesRead := int64(stream.InvalidEntriesRead)
+	if len(args) > 4 {
+		if len(args) != 6 || !strings.EqualFold(string(args[4]), "entriesread") {
+			return protocol.MakeSyntaxErrReply()
+		}
+		n, errReply := parseEntriesRead(args[5])
+		if errReply != nil {
+			return errReply
+		}
+		entriesRead = n
+	}
+	lastID := s.LastID()
+	if string(args[3]) != "$" {
+		id, errReply := parseStreamID(args[3], 0)
+		if errReply != nil {
+			return errReply
+		}
+		lastID = id
+	}
+	g.LastID, g.EntriesRead = lastID, entriesRead
+	db.propagateGroupID(string(args[1]), g)
+	return protocol.MakeOkReply()
+}
+
+// execXAck acknowledges pending entries, XACK key group id [id ...]
+func execXAck(db *DB, args [][]byte) protocol.Reply {
+	ids := make([]stream.ID, len(args)-2)
+	for i, arg := range args[2:] {
+		id, errReply := parseStrictStreamID(arg, 0)
+		if errReply != nil {
+			return errReply
+		}
+		ids[i] = id
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.MakeIntReply(0)
+	}
+	g, ok := s.Group(string(args[1]))
+	if !ok {
+		return protocol.MakeIntReply(0)
+	}
+	acked := 0
+	for _, id := range ids {
+		if g.Ack(id) {
+			acked++
+		}
+	}
+	if acked > 0 {
+		db.writeAof(toCmdLine3("xack", args))
+	}
+	return protocol.MakeIntReply(int64(acked))
+}
+
+// execXPending inspects the pending entries of a group,
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func execXPending(db *DB, args [][]byte) protocol.Reply {
+	rest := args[2:]
+	minIdle := int64(0)
+	if len(rest) > 0 && strings.EqualFold(string(rest[0]), "idle") {
+		if len(rest) < 2 {
+			return protocol.MakeSyntaxErrReply()
+		}
+		n, err := strconv.ParseInt(string(rest[1]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		minIdle = n
+		rest = rest[2:]
+		if len(rest) == 0 {
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	extended := len(rest) > 0
+	var start, end stream.ID
+	count := 0
+	if extended {
+		if len(rest) != 3 && len(rest) != 4 {
+			return protocol.MakeSyntaxErrReply()
+		}
+		var errReply protocol.ErrorReply
+		if start, errReply = parseRangeID(rest[0], false); errReply != nil {
+			return errReply
+		}
+		if end, errReply = parseRangeID(rest[1], true); errReply != nil {
+			return errReply
+		}
+		if count, errReply = parseCount(rest[2]); errReply != nil {
+			return errReply
+		}
+	}
+	_, g, errReply := db.getStreamGroup(string(args[0]), string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	if !extended {
+		return makePendingSummary(g)
+	}
+	var c *stream.Consumer
+	if len(rest) == 4 {
+		var ok bool
+		if c, ok = g.Consumer(string(rest[3])); !ok {
+			return protocol.MakeEmptyMultiBulkReply()
+		}
+	}
+	now := time.Now().UnixMilli()
+	replies := make([]protocol.Reply, 0)
+	for _, pe := range g.PendingRange(start, end, 0, c) {
+		if len(replies) >= count {
+			break
+		}
+		idle := now - pe.DeliveryTime
+		if idle < minIdle {
+			continue
+		}
+		replies = append(replies, protocol.MakeArrayReply([]protocol.Reply{
+			protocol.MakeBulkReply(formatStreamID(pe.ID)),
+			protocol.MakeBulkReply([]byte(pe.Consumer.Name)),
+			protocol.MakeIntReply(max(idle, 0)),
+			protocol.MakeIntReply(int64(pe.DeliveryCount)),
+		}))
+	}
+	return protocol.MakeArrayReply(replies)
+}
+
+// makePendingSummary replies the number of pending entries, the least and the greatest IDs,
+// and the number of pending entries of every consumer
+func makePendingSummary(g *stream.Group) protocol.Reply {
+	pending := g.PendingRange(stream.MinID, stream.MaxID, 0, nil)
+	if len(pending) == 0 {
+		return protocol.MakeArrayReply([]protocol.Reply{
+			protocol.MakeIntReply(0),
+			protocol.MakeNullBulkReply(),
+			protocol.MakeNullBulkReply(),
+			protocol.MakeNullArrayReply(),
+		})
+	}
+	consumers := make([]protocol.Reply, 0)
+	for _, c := range g.Consumers() {
+		if c.PendingLen() == 0 {
+			continue
+		}
+		consumers = append(consumers, protocol.MakeMultiBulkReply([][]byte{
+			[]byte(c.Name),
+			[]byte(strconv.Itoa(c.PendingLen())),
+		}))
+	}
+	return protocol.MakeArrayReply([]protocol.Reply{
+		protocol.MakeIntReply(int64(len(pending))),
+		protocol.MakeBulkReply(formatStreamID(pending[0].ID)),
+		protocol.MakeBulkReply(formatStreamID(pending[len(pending)-1].ID)),
+		protocol.MakeArrayReply(consumers),
+	})
+}
+
+// parseMinIdle parses the min-idle-time of XCLAIM and XAUTOCLAIM, a negative time is 0
+func parseMinIdle(cmd string, arg []byte) (int64, protocol.ErrorReply) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, protocol.MakeErrReply("ERR Invalid min-idle-time argument for " + cmd)
+	}
+	return max(n, 0), nil
+}
+
+// claimEntry transfers a pending entry to c, dropping it if the entry is deleted, and returns whether it exists
+func (db *DB) claimEntry(key string, s *stream.Stream, g *stream.Group, c *stream.Consumer,
+	id stream.ID, deliveryTime int64, deliveryCount uint64) (*stream.Entry, bool) {
+	entry, ok := s.Get(id)
+	if !ok {
+		if g.Ack(id) {
+			db.writeAof(toCmdLine("xack", key, g.Name, id.String()))
+		}
+		return nil, false
+	}
+	db.propagateClaim(key, g, g.Assign(id, c, deliveryTime, deliveryCount))
+	return entry, true
+}
+
+// execXClaim transfers pending entries to a consumer,
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count]
+// [FORCE] [JUSTID] [LASTID lastid]
+func execXClaim(db *DB, args [][]byte) protocol.Reply {
+	key, name, consumerName := string(args[0]), string(args[1]), string(args[2])
+	minIdle, errReply := parseMinIdle("XCLAIM", args[3])
+	if errReply != nil {
+		return errReply
+	}
+	i := 4
+	var ids []stream.ID
+	for ; i < len(args); i++ {
+		id, ok := stream.ParseID(string(args[i]), 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	now := time.Now().UnixMilli()
+	deliveryTime, retryCount := now, int64(-1)
+	force, justID := false, false
+	var lastID *stream.ID
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		more := i+1 < len(args)
+		var n int64
+		var err error
+		if more && (opt == "idle" || opt == "time" || opt == "retrycount") {
+			if n, err = strconv.ParseInt(string(args[i+1]), 10, 64); err != nil {
+				return protocol.MakeErrReply("ERR Invalid " + strings.ToUpper(opt) + " option argument for XCLAIM")
+			}
+		}
+		switch {
+		case opt == "force":
+			force = true
+		case opt == "justid":
+			justID = true
+		case opt == "idle" && more:
+			deliveryTime = now - n
+			i++
+		case opt == "time" && more:
+			deliveryTime = n
+			i++
+		case opt == "retrycount" && more:
+			retryCount = n
+			i++
+		case opt == "lastid" && more:
+			id, errReply := parseStrictStreamID(args[i+1], 0)
+			if errReply != nil {
+				return errReply
+			}
+			lastID = &id
+			i++
+		default:
+			return protocol.MakeErrReply("ERR Unrecognized XCLAIM option '" + string(args[i]) + "'")
+		}
+	}
+	// a delivery time in the future is now
+	deliveryTime = min(deliveryTime, now)
+	s, g, errReply := db.getStreamGroup(key, name)
+	if errReply != nil {
+		return errReply
+	}
+	movedLastID := lastID != nil && g.LastID.Less(*lastID)
+	if movedLastID {
+		g.LastID = *lastID
+	}
+	var c *stream.Consumer
+	entries := make([]*stream.Entry, 0)
+	for _, id := range ids {
+		pe, pending := g.Pending(id)
+		if !pending && !force {
+			continue
+		}
+		if pending && minIdle > 0 && now-pe.DeliveryTime < minIdle {
+			continue
+		}
+		// an entry forced into the pending entries has been delivered once as redis does
+		deliveryCount := uint64(1)
+		if pending {
+			deliveryCount = pe.DeliveryCount
+		}
+		if retryCount >= 0 {
+			deliveryCount = uint64(retryCount)
+		} else if !justID {
+			deliveryCount++
+		}
+		if c == nil {
+			c = db.getOrCreateConsumer(key, g, consumerName, now)
+			c.SeenTime = now
+		}
+		if entry, ok := db.claimEntry(key, s, g, c, id, deliveryTime, deliveryCount); ok {
+			c.ActiveTime = now
+			entries = append(entries, entry)
+		}
+	}
+	if movedLastID && len(entries) == 0 {
+		db.propagateGroupID(key, g)
+	}
+	if justID {
+		claimed := make([]stream.ID, len(entries))
+		for i, entry := range entries {
+			claimed[i] = entry.ID
+		}
+		return makeIDsReply(claimed)
+	}
+	return makeEntriesReply(entries)
+}
+
+// execXAutoClaim transfers the pending entries idle for long to a consumer,
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func execXAutoClaim(db *DB, args [][]byte) protocol.Reply {
+	key, name, consumerName := string(args[0]), string(args[1]), string(args[2])
+	minIdle, errReply := parseMinIdle("XAUTOCLAIM", args[3])
+	if errReply != nil {
+		return errReply
+	}
+	start, errReply := parseRangeID(args[4], false)
+	if errReply != nil {
+		return errReply
+	}
+	count, justID := 100, false
+	for i := 5; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); {
+		case opt == "count" && i+1 < len(args):
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			// redis scans at most 10 times of count entries
+			if n < 1 || n > math.MaxInt64/10 {
+				return protocol.MakeErrReply("ERR COUNT must be > 0")
+			}
+			count = int(n)
+			i++
+		case opt == "justid":
+			justID = true
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	s, g, errReply := db.getStreamGroup(key, name)
+	if errReply != nil {
+		return errReply
+	}
+	attempts := count * 10
+	pending := g.PendingRange(start, stream.MaxID, attempts+1, nil)
+	now := time.Now().UnixMilli()
+	var c *stream.Consumer
+	entries := make([]*stream.Entry, 0)
+	deleted := make([]stream.ID, 0)
+	i := 0
+	for ; i < len(pending) && i < attempts && len(entries) < count; i++ {
+		pe := pending[i]
+		if _, ok := s.Get(pe.ID); ok && now-pe.DeliveryTime < minIdle {
+			continue
+		}
+		deliveryCount := pe.DeliveryCount
+		if !justID {
+			deliveryCount++
+		}
+		if c == nil {
+			c = db.getOrCreateConsumer(key, g, consumerName, now)
+			c.SeenTime = now
+		}
+		entry, ok := db.claimEntry(key, s, g, c, pe.ID, now, deliveryCount)
+		if !ok {
+			deleted = append(deleted, pe.ID)
+			continue
+		}
+		c.ActiveTime = now
+		entries = append(entries, entry)
+	}
+	next := stream.MinID
+	if i < len(pending) {
+		next = pending[i].ID
+	}
+	var claimed protocol.Reply
+	if justID {
+		ids := make([]stream.ID, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.ID
+		}
+		claimed = makeIDsReply(ids)
+	} else {
+		claimed = makeEntriesReply(entries)
+	}
+	return protocol.MakeArrayReply([]protocol.Reply{
+		protocol.MakeBulkReply(formatStreamID(next)),
+		claimed,
+		makeIDsReply(deleted),
+	})
+}
+
+func prepareXInfo(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return []string{string(args[1])}, nil
+}
+
+// makeFieldsReply replies a map of fields given in pairs of names and values
+func makeFieldsReply(pairs ...any) protocol.Reply {
+	keys := make([]protocol.Reply, 0, len(pairs)/2)
+	values := make([]protocol.Reply, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		keys = append(keys, protocol.MakeBulkReply([]byte(pairs[i].(string))))
+		var value protocol.Reply
+		switch v := pairs[i+1].(type) {
+		case int:
+			value = protocol.MakeIntReply(int64(v))
+		case int64:
+			value = protocol.MakeIntReply(v)
+		case uint64:
+			value = protocol.MakeIntReply(int64(v))
+		case string:
+			value = protocol.MakeBulkReply([]byte(v))
+		case stream.ID:
+			value = protocol.MakeBulkReply(formatStreamID(v))
+		case protocol.Reply:
+			value = v
+		}
+		values = append(values, value)
+	}
+	return protocol.MakeMapReply(keys, values)
+}
+
+// execXInfo inspects streams, XINFO STREAM key [FULL [COUNT count]] | GROUPS key | CONSUMERS key group | HELP
+func execXInfo(db *DB, args [][]byte) protocol.Reply {
+	sub := strings.ToLower(string(args[0]))
+	if sub == "help" && len(args) == 1 {
+		return protocol.MakeMultiBulkReply([][]byte{
+			[]byte("XINFO <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			[]byte("CONSUMERS <key> <groupname>"),
+			[]byte("    Show consumers of <groupname>."),
+			[]byte("GROUPS <key>"),
+			[]byte("    Show the stream consumer groups."),
+			[]byte("STREAM <key> [FULL [COUNT <count>]"),
+			[]byte("    Show information about the stream."),
+			[]byte("HELP"),
+			[]byte("    Print this help."),
+		})
+	}
+	if sub != "stream" && sub != "groups" && sub != "consumers" {
+		return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try XINFO HELP.")
+	}
+	if sub == "stream" && len(args) < 2 || sub == "groups" && len(args) != 2 || sub == "consumers" && len(args) != 3 {
+		return protocol.MakeArgNumErrReply("xinfo|" + sub)
+	}
+	key := string(args[1])
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.MakeErrReply("ERR no such key")
+	}
+	switch sub {
+	case "stream":
+		return xinfoStream(s, args[2:])
+	case "groups":
+		groups := make([]protocol.Reply, 0)
+		for _, g := range s.Groups() {
+			groups = append(groups, makeFieldsReply(
+				"name", g.Name,
+				"consumers", len(g.Consumers()),
+				"pending", g.PendingLen(),
+				"last-delivered-id", g.LastID,
+				"entries-read", makeEntriesReadReply(g),
+				"lag", makeLagReply(s, g),
+			))
+		}
+		return protocol.MakeArrayReply(groups)
+	default:
+		g, ok := s.Group(string(args[2]))
+		if !ok {
+			return makeNoGroupErr(key, string(args[2]))
+		}
+		now := time.Now().UnixMilli()
+		consumers := make([]protocol.Reply, 0)
+		for _, c := range g.Consumers() {
+			inactive := int64(-1)
+			if c.ActiveTime >= 0 {
+				inactive = max(now-c.ActiveTime, 0)
+			}
+			consumers = append(consumers, makeFieldsReply(
+				"name", c.Name,
+				"pending", c.PendingLen(),
+				"idle", max(now-c.SeenTime, 0),
+				"inactive", inactive,
+			))
+		}
+		return protocol.MakeArrayReply(consumers)
+	}
+}
+
+func makeEntriesReadReply(g *stream.Group) protocol.Reply {
+	if g.EntriesRead == stream.InvalidEntriesRead {
+		return protocol.MakeNullBulkReply()
+	}
+	return protocol.MakeIntReply(g.EntriesRead)
+}
+
+func makeLagReply(s *stream.Stream, g *stream.Group) protocol.Reply {
+	lag, ok := s.Lag(g)
+	if !ok {
+		return protocol.MakeNullBulkReply()
+	}
+	return protocol.MakeIntReply(lag)
+}
+
+// xinfoStream replies XINFO STREAM, opts are [FULL [COUNT count]]
+func xinfoStream(s *stream.Stream, opts [][]byte) protocol.Reply {
+	full, count := false, 10
+	if len(opts) > 0 {
+		if !strings.EqualFold(string(opts[0]), "full") {
+			return protocol.MakeSyntaxErrReply()
+		}
+		full = true
+		if len(opts) > 1 {
+			if len(opts) != 3 || !strings.EqualFold(string(opts[1]), "count") {
+				return protocol.MakeSyntaxErrReply()
+			}
+			n, errReply := parseCount(opts[2])
+			if errReply != nil {
+				return errReply
+			}
+			count = n
+		}
+	}
+	first, _ := s.FirstID()
+	fields := []any{
+		"length", s.Len(),
+		"radix-tree-keys", s.NodeCount(),
+		"radix-tree-nodes", s.NodeCount(),
+		"last-generated-id", s.LastID(),
+		"max-deleted-entry-id", s.MaxDeletedID(),
+		"entries-added", s.EntriesAdded(),
+		"recorded-first-entry-id", first,
+	}
+	if full {
+		groups := make([]protocol.Reply, 0)
+		for _, g := range s.Groups() {
+			groups = append(groups, makeGroupFullReply(s, g, count))
+		}
+		fields = append(fields,
+			"entries", makeEntriesReply(s.Range(stream.MinID, stream.MaxID, count, false)),
+			"groups", protocol.MakeArrayReply(groups),
+		)
+		return makeFieldsReply(fields...)
+	}
+	var firstEntry, lastEntry protocol.Reply = protocol.MakeNullBulkReply(), protocol.MakeNullBulkReply()
+	if entries := s.Range(stream.MinID, stream.MaxID, 1, false); len(entries) > 0 {
+		firstEntry = makeEntryReply(entries[0])
+	}
+	if entries := s.Range(stream.MinID, stream.MaxID, 1, true); len(entries) > 0 {
+		lastEntry = makeEntryReply(entries[0])
+	}
+	fields = append(fields,
+		"groups", len(s.Groups()),
+		"first-entry", firstEntry,
+		"last-entry", lastEntry,
+	)
+	return makeFieldsReply(fields...)
+}
+
+// makeGroupFullReply replies a group of XINFO STREAM FULL, with at most count pending entries of it and its consumers
+func makeGroupFullReply(s *stream.Stream, g *stream.Group, count int) protocol.Reply {
+	pending := make([]protocol.Reply, 0)
+	for _, pe := range g.PendingRange(stream.MinID, stream.MaxID, count, nil) {
+		pending = append(pending, protocol.MakeArrayReply([]protocol.Reply{
+			protocol.MakeBulkReply(formatStreamID(pe.ID)),
+			protocol.MakeBulkReply([]byte(pe.Consumer.Name)),
+			protocol.MakeIntReply(pe.DeliveryTime),
+			protocol.MakeIntReply(int64(pe.DeliveryCount)),
+		}))
+	}
+	consumers := make([]protocol.Reply, 0)
+	for _, c := range g.Consumers() {
+		consumerPending := make([]protocol.Reply, 0)
+		for _, pe := range g.PendingRange(stream.MinID, stream.MaxID, count, c) {
+			consumerPending = append(consumerPending, protocol.MakeArrayReply([]protocol.Reply{
+				protocol.MakeBulkReply(formatStreamID(pe.ID)),
+				protocol.MakeIntReply(pe.DeliveryTime),
+				protocol.MakeIntReply(int64(pe.DeliveryCount)),
+			}))
+		}
+		consumers = append(consumers, makeFieldsReply(
+			"name", c.Name,
+			"seen-time", c.SeenTime,
+			"active-time", c.ActiveTime,
+			"pel-count", c.PendingLen(),
+			"pending", protocol.MakeArrayReply(consumerPending),
+		))
+	}
+	return makeFieldsReply(
+		"name", g.Name,
+		"last-delivered-id", g.LastID,
+		"entries-read", makeEntriesReadReply(g),
+		"lag", makeLagReply(s, g),
+		"pel-count", g.PendingLen(),
+		"pending", protocol.MakeArrayReply(pending),
+		"consumers", protocol.MakeArrayReply(consumers),
+	)
+}
+
+// streamToCmds returns the commands which rebuild s, as redis rewrites a stream in AOF
+func streamToCmds(key string, s *stream.Stream) [][][]byte {
+	var cmds [][][]byte
+	entries := s.Range(stream.MinID, stream.MaxID, 0, false)
+	if len(entries) == 0 {
+		// XADD MAXLEN 0 creates an empty stream, whose last ID is restored by XSETID below
+		cmds = append(cmds, toCmdLine("XADD", key, "MAXLEN", "0", "0-1", "x", "y"))
+	}
+	for _, entry := range entries {
+		cmdLine := toCmdLine2("XADD", []byte(key), formatStreamID(entry.ID))
+		cmds = append(cmds, append(cmdLine, entry.Fields...))
+	}
+	cmds = append(cmds, toCmdLine("XSETID", key, s.LastID().String(),
+		"ENTRIESADDED", strconv.FormatUint(s.EntriesAdded(), 10), "MAXDELETEDID", s.MaxDeletedID().String()))
+	for _, g := range s.Groups() {
+		cmds = append(cmds, toCmdLine("XGROUP", "CREATE", key, g.Name, g.LastID.String(),
+			"ENTRIESREAD", strconv.FormatInt(g.EntriesRead, 10)))
+		for _, c := range g.Consumers() {
+			cmds = append(cmds, toCmdLine("XGROUP", "CREATECONSUMER", key, g.Name, c.Name))
+		}
+		for _, pe := range g.PendingRange(stream.MinID, stream.MaxID, 0, nil) {
+			cmds = append(cmds, toCmdLine("XCLAIM", key, g.Name, pe.Consumer.Name, "0", pe.ID.String(),
+				"TIME", strconv.FormatInt(pe.DeliveryTime, 10),
+				"RETRYCOUNT", strconv.FormatUint(pe.DeliveryCount, 10), "JUSTID", "FORCE"))
+		}
+	}
+	return cmds
+}
+
+func init() {
+	registerCommand("XAdd", -5, execXAdd, writeFirstKey, rollbackFirstKey)
+	registerCommand("XLen", 2, execXLen, readFirstKey, nil)
+	registerCommand("XRange", -4, execXRange, readFirstKey, nil)
+	registerCommand("XRevRange", -4, execXRevRange, readFirstKey, nil)
+	registerCommand("XDel", -3, execXDel, writeFirstKey, rollbackFirstKey)
+	registerCommand("XTrim", -4, execXTrim, writeFirstKey, rollbackFirstKey)
+	registerCommand("XSetID", -3, execXSetID, writeFirstKey, rollbackFirstKey)
+	registerBlockingCommand("XRead", -4, xreadCommand, prepareXRead, nil)
+	registerBlockingCommand("XReadGroup", -7, xreadGroupCommand, prepareXReadGroup, undoXReadGroup)
+	registerCommand("XGroup", -2, execXGroup, prepareXGroup, undoXGroup)
+	registerCommand("XAck", -4, execXAck, writeFirstKey, rollbackFirstKey)
+	registerCommand("XPending", -3, execXPending, readFirstKey, nil)
+	registerCommand("XClaim", -6, execXClaim, writeFirstKey, rollbackFirstKey)
+	registerCommand("XAutoClaim", -6, execXAutoClaim, writeFirstKey, rollbackFirstKey)
+	registerCommand("XInfo", -2, execXInfo, prepareXInfo, nil)
+}
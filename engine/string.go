@@ -0,0 +1,726 @@
+package engine
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+// maxStringSize is the max length of a string value, the same as proto-max-bulk-len
+const maxStringSize = 512 * 1024 * 1024
+
+// maxIntEncodedLen is the max length of a string which may be stored as int64, the same as MAX_LONG_DOUBLE_CHARS
+const maxIntEncodedLen = 20
+
+// parseStrictInt parses bytes only if it is the canonical form of an int64, so that the value can be restored exactly
+func parseStrictInt(bytes []byte) (int64, bool) {
+	if len(bytes) == 0 || len(bytes) > maxIntEncodedLen {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(string(bytes), 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != string(bytes) {
+		return 0, false
+	}
+	return n, true
+}
+
+// newStringEntity stores integer strings as int64 and others as []byte
+func newStringEntity(value []byte) *payload.DataEntity {
+	if n, ok := parseStrictInt(value); ok {
+		return &payload.DataEntity{RedisObject: n}
+	}
+	return &payload.DataEntity{RedisObject: value}
+}
+
+// getAsString returns the string value of key, or nil if key does not exist
+func (db *DB) getAsString(key string) ([]byte, protocol.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	switch val := entity.RedisObject.(type) {
+	case []byte:
+		return val, nil
+	case int64:
+		return strconv.AppendInt(nil, val, 10), nil
+	default:
+		return nil, protocol.MakeWrongTypeErrReply()
+	}
+}
+
+// putString binds value to key, the old ttl of key is removed
+func (db *DB) putString(key string, value []byte) {
+	db.PutEntity(key, newStringEntity(value))
+	db.Persist(key)
+}
+
+func stringExceedsErr() protocol.ErrorReply {
+	return protocol.MakeErrReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+}
+
+// execGet returns the value of key, GET key
+func execGet(db *DB, args [][]byte) protocol.Reply {
+	bytes, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if bytes == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	return protocol.MakeBulkReply(bytes)
+}
+
+const (
+	upsertPolicy = iota // default
+	insertPolicy        // set nx
+	updatePolicy        // set xx
+)
+
+// expireOption is the parsed EX|PX|EXAT|PXAT|KEEPTTL|PERSIST option of SET and GETEX
+type expireOption struct {
+	set        bool
+	expireTime time.Time
+	keepTTL    bool
+	persist    bool
+}
+
+// parseExpireOption parses the expire option at args[i] and returns how many args it consumes
+func parseExpireOption(cmd string, args [][]byte, i int, option *expireOption) (int, protocol.ErrorReply) {
+	arg := strings.ToLower(string(args[i]))
+	var unit time.Duration
+	relative := false
+	switch arg {
+	case "keepttl", "persist":
+		if option.set || option.keepTTL || option.persist {
+			return 0, protocol.MakeSyntaxErrReply()
+		}
+		option.keepTTL = arg == "keepttl"
+		option.persist = arg == "persist"
+		return 1, nil
+	case "ex":
+		unit, relative = time.Second, true
+	case "px":
+		unit, relative = time.Millisecond, true
+	case "exat":
+		unit = time.Second
+	case "pxat":
+		unit = time.Millisecond
+	default:
+		return 0, protocol.MakeSyntaxErrReply()
+	}
+	if option.set || option.keepTTL || option.persist || i+1 >= len(args) {
+		return 0, protocol.MakeSyntaxErrReply()
+	}
+	raw, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+	if err != nil {
+		return 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if raw <= 0 {
+		return 0, protocol.MakeErrReply("ERR invalid expire time in '" + cmd + "' command")
+	}
+	when, errReply := parseExpireTime(cmd, args[i+1], unit, relative)
+	if errReply != nil {
+		return 0, errReply
+	}
+	option.set = true
+	option.expireTime = time.UnixMilli(when)
+	return 2, nil
+}
+
+// execSet sets the value of key, SET key value [NX | XX] [GET] [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+func execSet(db *DB, args [][]byte) protocol.Reply {
+	key, value := string(args[0]), args[1]
+	policy := upsertPolicy
+	returnOld := false
+	option := &expireOption{}
+	for i := 2; i < len(args); {
+		arg := strings.ToLower(string(args[i]))
+		switch arg {
+		case "nx", "xx":
+			if policy != upsertPolicy {
+				return protocol.MakeSyntaxErrReply()
+			}
+			policy = insertPolicy
+			if arg == "xx" {
+				policy = updatePolicy
+			}
+			i++
+		case "get":
+			returnOld = true
+			i++
+		default:
+			n, errReply := parseExpireOption("set", args, i, option)
+			if errReply != nil {
+				return errReply
+			}
+			if option.persist {
+				return protocol.MakeSyntaxErrReply()
+			}
+			i += n
+		}
+	}
+
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		if returnOld {
+			return errReply
+		}
+		old = nil
+	}
+	_, exists := db.GetEntity(key)
+	var oldReply protocol.Reply = protocol.MakeNullBulkReply()
+	if returnOld && old != nil {
+		oldReply = protocol.MakeBulkReply(old)
+	}
+	if (policy == insertPolicy && exists) || (policy == updatePolicy && !exists) {
+		return oldReply
+	}
+
+	if option.keepTTL {
+		db.PutEntity(key, newStringEntity(value))
+		db.writeAof(toCmdLine2("set", args[0], value, []byte("keepttl")))
+	} else {
+		db.putString(key, value)
+		if option.set {
+			db.Expire(key, option.expireTime)
+			db.writeAof(toCmdLine2("set", args[0], value, []byte("pxat"), []byte(strconv.FormatInt(option.expireTime.UnixMilli(), 10))))
+		} else {
+			db.writeAof(toCmdLine2("set", args[0], value))
+		}
+	}
+	if returnOld {
+		return oldReply
+	}
+	return protocol.MakeOkReply()
+}
+
+// execSetNX sets key only if it does not exist, SETNX key value
+func execSetNX(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	if _, exists := db.GetEntity(key); exists {
+		return protocol.MakeIntReply(0)
+	}
+	db.putString(key, args[1])
+	db.writeAof(toCmdLine3("set", args))
+	return protocol.MakeIntReply(1)
+}
+
+func setWithTTL(db *DB, cmd string, args [][]byte, unit time.Duration) protocol.Reply {
+	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if raw <= 0 {
+		return protocol.MakeErrReply("ERR invalid expire time in '" + cmd + "' command")
+	}
+	when, errReply := parseExpireTime(cmd, args[1], unit, true)
+	if errReply != nil {
+		return errReply
+	}
+	key, value := string(args[0]), args[2]
+	expireTime := time.UnixMilli(when)
+	db.putString(key, value)
+	db.Expire(key, expireTime)
+	db.writeAof(toCmdLine2("set", args[0], value, []byte("pxat"), []byte(strconv.FormatInt(when, 10))))
+	return protocol.MakeOkReply()
+}
+
+// execSetEX sets key with ttl in seconds, SETEX key seconds value
+func execSetEX(db *DB, args [][]byte) protocol.Reply {
+	return setWithTTL(db, "setex", args, time.Second)
+}
+
+// execPSetEX sets key with ttl in milliseconds, PSETEX key milliseconds value
+func execPSetEX(db *DB, args [][]byte) protocol.Reply {
+	return setWithTTL(db, "psetex", args, time.Millisecond)
+}
+
+// execGetSet sets key and returns the old value, GETSET key value
+func execGetSet(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	db.putString(key, args[1])
+	db.writeAof(toCmdLine3("set", args))
+	if old == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	return protocol.MakeBulkReply(old)
+}
+
+// execGetDel returns the value of key and deletes it, GETDEL key
+func execGetDel(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if old == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	db.Remove(key)
+	db.writeAof(toCmdLine3("del", args))
+	return protocol.MakeBulkReply(old)
+}
+
+// execGetEX returns the value of key and updates its ttl,
+// GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]
+func execGetEX(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	option := &expireOption{}
+	for i := 1; i < len(args); {
+		n, errReply := parseExpireOption("getex", args, i, option)
+		if errReply != nil {
+			return errReply
+		}
+		if option.keepTTL {
+			return protocol.MakeSyntaxErrReply()
+		}
+		i += n
+	}
+	value, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if value == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	if option.set {
+		db.Expire(key, option.expireTime)
+		db.writeAof(makeExpireCmd(key, option.expireTime))
+	} else if option.persist && db.Persist(key) {
+		db.writeAof(toCmdLine("persist", key))
+	}
+	return protocol.MakeBulkReply(value)
+}
+
+// execMGet returns the values of keys, nil for keys missing or not holding a string, MGET key [key ...]
+func execMGet(db *DB, args [][]byte) protocol.Reply {
+	result := make([][]byte, len(args))
+	for i, arg := range args {
+		bytes, errReply := db.getAsString(string(arg))
+		if errReply != nil {
+			continue
+		}
+		result[i] = bytes
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+func prepareMSet(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args)/2)
+	for i := range keys {
+		keys[i] = string(args[2*i])
+	}
+	return nil, keys
+}
+
+func undoMSet(db *DB, args [][]byte) [][][]byte {
+	_, keys := prepareMSet(args)
+	return rollbackGivenKeys(db, keys...)
+}
+
+// execMSet sets multiple keys, MSET key value [key value ...]
+func execMSet(db *DB, args [][]byte) protocol.Reply {
+	if len(args)%2 != 0 {
+		return protocol.MakeArgNumErrReply("mset")
+	}
+	for i := 0; i < len(args); i += 2 {
+		db.putString(string(args[i]), args[i+1])
+	}
+	db.writeAof(toCmdLine3("mset", args))
+	return protocol.MakeOkReply()
+}
+
+// execMSetNX sets multiple keys only if none of them exists, MSETNX key value [key value ...]
+func execMSetNX(db *DB, args [][]byte) protocol.Reply {
+	if len(args)%2 != 0 {
+		return protocol.MakeArgNumErrReply("msetnx")
+	}
+	for i := 0; i < len(args); i += 2 {
+		if _, exists := db.GetEntity(string(args[i])); exists {
+			return protocol.MakeIntReply(0)
+		}
+	}
+	for i := 0; i < len(args); i += 2 {
+		db.putString(string(args[i]), args[i+1])
+	}
+	db.writeAof(toCmdLine3("msetnx", args))
+	return protocol.MakeIntReply(1)
+}
+
+// execAppend appends value to key and returns the new length, APPEND key value
+func execAppend(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if len(old)+len(args[1]) > maxStringSize {
+		return stringExceedsErr()
+	}
+	// always allocate a new slice, the old one may be held by replies not yet sent
+	value := make([]byte, 0, len(old)+len(args[1]))
+	value = append(value, old...)
+	value = append(value, args[1]...)
+	db.PutEntity(key, &payload.DataEntity{RedisObject: value})
+	db.writeAof(toCmdLine3("append", args))
+	return protocol.MakeIntReply(int64(len(value)))
+}
+
+// execStrLen returns the length of the value, STRLEN key
+func execStrLen(db *DB, args [][]byte) protocol.Reply {
+	bytes, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	return protocol.MakeIntReply(int64(len(bytes)))
+}
+
+// execGetRange returns the substring between start and end, both inclusive and may be negative, GETRANGE key start end
+func execGetRange(db *DB, args [][]byte) protocol.Reply {
+	start, err1 := strconv.ParseInt(string(args[1]), 10, 64)
+	end, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+	if err1 != nil || err2 != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	bytes, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	size := int64(len(bytes))
+	if start < 0 && end < 0 && start > end {
+		return protocol.MakeBulkReply([]byte{})
+	}
+	if start < 0 {
+		start = max(size+start, 0)
+	}
+	if end < 0 {
+		end = max(size+end, 0)
+	}
+	end = min(end, size-1)
+	if start > end || size == 0 {
+		return protocol.MakeBulkReply([]byte{})
+	}
+	return protocol.MakeBulkReply(bytes[start : end+1])
+}
+
+// execSetRange overwrites the value from offset and returns the new length, SETRANGE key offset value
+func execSetRange(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if offset < 0 {
+		return protocol.MakeErrReply("ERR offset is out of range")
+	}
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	value := args[2]
+	if len(value) == 0 {
+		// nothing to write, an absent key is not created
+		return protocol.MakeIntReply(int64(len(old)))
+	}
+	if offset+int64(len(value)) > maxStringSize {
+		return stringExceedsErr()
+	}
+	size := max(int64(len(old)), offset+int64(len(value)))
+	bytes := make([]byte, size)
+	copy(bytes, old)
+	copy(bytes[offset:], value)
+	db.PutEntity(key, &payload.DataEntity{RedisObject: bytes})
+	db.writeAof(toCmdLine3("setrange", args))
+	return protocol.MakeIntReply(size)
+}
+
+// execLCS finds the longest common subsequence of two strings,
+// LCS key1 key2 [LEN] [IDX] [MINMATCHLEN min-match-len] [WITHMATCHLEN]
+func execLCS(db *DB, args [][]byte) protocol.Reply {
+	getLen, getIdx, withMatchLen := false, false, false
+	minMatchLen := int64(0)
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "len":
+			getLen = true
+		case "idx":
+			getIdx = true
+		case "withmatchlen":
+			withMatchLen = true
+		case "minmatchlen":
+			if i+1 >= len(args) {
+				return protocol.MakeSyntaxErrReply()
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			minMatchLen = max(n, 0)
+			i++
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	if getLen && getIdx {
+		return protocol.MakeErrReply("ERR If you want both the length and indexes, please just use IDX.")
+	}
+	a, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return protocol.MakeErrReply("ERR The specified keys must contain string values")
+	}
+	b, errReply := db.getAsString(string(args[1]))
+	if errReply != nil {
+		return protocol.MakeErrReply("ERR The specified keys must contain string values")
+	}
+	if uint64(len(a)+1)*uint64(len(b)+1) > math.MaxUint32 ||
+		uint64(len(a)+1)*uint64(len(b)+1)*4 > maxStringSize {
+		return protocol.MakeErrReply("ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+	}
+
+	// dp[i][j] is the lcs length of a[:i] and b[:j]
+	width := len(b) + 1
+	dp := make([]uint32, (len(a)+1)*width)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				dp[i*width+j] = dp[(i-1)*width+j-1] + 1
+			} else {
+				dp[i*width+j] = max(dp[(i-1)*width+j], dp[i*width+j-1])
+			}
+		}
+	}
+	lcsLen := int(dp[len(a)*width+len(b)])
+	if getLen {
+		return protocol.MakeIntReply(int64(lcsLen))
+	}
+
+	// walk back from the end, the matched ranges are found from the tail to the head
+	result := make([]byte, lcsLen)
+	matches := make([]protocol.Reply, 0)
+	idx := lcsLen
+	i, j := len(a), len(b)
+	aStart, aEnd, bStart, bEnd := len(a), 0, 0, 0 // aStart == len(a) means no range
+	for i > 0 && j > 0 {
+		emit := false
+		if a[i-1] == b[j-1] {
+			result[idx-1] = a[i-1]
+			if aStart == len(a) {
+				aStart, aEnd, bStart, bEnd = i-1, i-1, j-1, j-1
+			} else if aStart == i && bStart == j {
+				aStart--
+				bStart--
+			} else {
+				emit = true
+			}
+			if aStart == 0 || bStart == 0 {
+				emit = true
+			}
+			idx--
+			i--
+			j--
+		} else {
+			if dp[(i-1)*width+j] > dp[i*width+j-1] {
+				i--
+			} else {
+				j--
+			}
+			if aStart != len(a) {
+				emit = true
+			}
+		}
+		if emit && getIdx {
+			matchLen := int64(aEnd - aStart + 1)
+			if minMatchLen == 0 || matchLen >= minMatchLen {
+				match := []protocol.Reply{
+					protocol.MakeArrayReply([]protocol.Reply{
+						protocol.MakeIntReply(int64(aStart)), protocol.MakeIntReply(int64(aEnd)),
+					}),
+					protocol.MakeArrayReply([]protocol.Reply{
+						protocol.MakeIntReply(int64(bStart)), protocol.MakeIntReply(int64(bEnd)),
+					}),
+				}
+				if withMatchLen {
+					match = append(match, protocol.MakeIntReply(matchLen))
+				}
+				matches = append(matches, protocol.MakeArrayReply(match))
+			}
+		}
+		if emit {
+			aStart = len(a)
+		}
+	}
+	if getIdx {
+		return protocol.MakeMapReply(
+			[]protocol.Reply{protocol.MakeBulkReply([]byte("matches")), protocol.MakeBulkReply([]byte("len"))},
+			[]protocol.Reply{protocol.MakeArrayReply(matches), protocol.MakeIntReply(int64(lcsLen))},
+		)
+	}
+	return protocol.MakeBulkReply(result)
+}
+
+// getAsInt returns the integer value of key, or 0 if key does not exist
+func (db *DB) getAsInt(key string) (int64, protocol.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return 0, nil
+	}
+	switch val := entity.RedisObject.(type) {
+	case int64:
+		return val, nil
+	case []byte:
+		n, ok := parseStrictInt(val)
+		if !ok {
+			return 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		return n, nil
+	default:
+		return 0, protocol.MakeWrongTypeErrReply()
+	}
+}
+
+// incrGeneric adds delta to the integer value of key, the ttl of key is kept
+func incrGeneric(db *DB, cmd string, args [][]byte, delta int64) protocol.Reply {
+	key := string(args[0])
+	n, errReply := db.getAsInt(key)
+	if errReply != nil {
+		return errReply
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return protocol.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	n += delta
+	db.PutEntity(key, &payload.DataEntity{RedisObject: n})
+	db.writeAof(toCmdLine3(cmd, args))
+	return protocol.MakeIntReply(n)
+}
+
+func parseIncrement(arg []byte) (int64, protocol.ErrorReply) {
+	delta, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return delta, nil
+}
+
+// execIncr increments the integer value of key by one, INCR key
+func execIncr(db *DB, args [][]byte) protocol.Reply {
+	return incrGeneric(db, "incr", args, 1)
+}
+
+// execDecr decrements the integer value of key by one, DECR key
+func execDecr(db *DB, args [][]byte) protocol.Reply {
+	return incrGeneric(db, "decr", args, -1)
+}
+
+// execIncrBy increments the integer value of key, INCRBY key increment
+func execIncrBy(db *DB, args [][]byte) protocol.Reply {
+	delta, errReply := parseIncrement(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	return incrGeneric(db, "incrby", args, delta)
+}
+
+// execDecrBy decrements the integer value of key, DECRBY key decrement
+func execDecrBy(db *DB, args [][]byte) protocol.Reply {
+	delta, errReply := parseIncrement(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	if delta == math.MinInt64 {
+		return protocol.MakeErrReply("ERR decrement would overflow")
+	}
+	return incrGeneric(db, "decrby", args, -delta)
+}
+
+// longDoublePrec is the mantissa bits of the x87 long double used by redis for INCRBYFLOAT
+const longDoublePrec = 64
+
+// parseLongDouble parses bytes like strtold, leading spaces and NaN are rejected
+func parseLongDouble(bytes []byte) (*big.Float, bool) {
+	if len(bytes) == 0 || unicode.IsSpace(rune(bytes[0])) {
+		return nil, false
+	}
+	f, _, err := big.ParseFloat(string(bytes), 10, longDoublePrec, big.ToNearestEven)
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// formatLongDouble formats f in the human friendly way of redis, with 17 decimal digits and no trailing zeros
+func formatLongDouble(f *big.Float) string {
+	s := f.Text('f', 17)
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
+
+// execIncrByFloat increments the float value of key, INCRBYFLOAT key increment
+func execIncrByFloat(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	incr, ok := parseLongDouble(args[1])
+	if !ok {
+		return protocol.MakeErrReply("ERR value is not a valid float")
+	}
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	value := new(big.Float).SetPrec(longDoublePrec)
+	if old != nil {
+		value, ok = parseLongDouble(old)
+		if !ok {
+			return protocol.MakeErrReply("ERR value is not a valid float")
+		}
+	}
+	if value.IsInf() || incr.IsInf() {
+		return protocol.MakeErrReply("ERR increment would produce NaN or Infinity")
+	}
+	value.Add(value, incr)
+	result := []byte(formatLongDouble(value))
+	db.PutEntity(key, &payload.DataEntity{RedisObject: result})
+	// propagate the result rather than the increment, so that replaying is not affected by float rounding
+	db.writeAof(toCmdLine2("set", args[0], result, []byte("keepttl")))
+	return protocol.MakeBulkReply(result)
+}
+
+func init() {
+	registerCommand("Get", 2, execGet, readFirstKey, nil)
+	registerCommand("Set", -3, execSet, writeFirstKey, rollbackFirstKey)
+	registerCommand("SetNX", 3, execSetNX, writeFirstKey, rollbackFirstKey)
+	registerCommand("SetEX", 4, execSetEX, writeFirstKey, rollbackFirstKey)
+	registerCommand("PSetEX", 4, execPSetEX, writeFirstKey, rollbackFirstKey)
+	registerCommand("GetSet", 3, execGetSet, writeFirstKey, rollbackFirstKey)
+	registerCommand("GetDel", 2, execGetDel, writeFirstKey, rollbackFirstKey)
+	registerCommand("GetEX", -2, execGetEX, writeFirstKey, rollbackFirstKey)
+	registerCommand("MGet", -2, execMGet, readAllKeys, nil)
+	registerCommand("MSet", -3, execMSet, prepareMSet, undoMSet)
+	registerCommand("MSetNX", -3, execMSetNX, prepareMSet, undoMSet)
+	registerCommand("Append", 3, execAppend, writeFirstKey, rollbackFirstKey)
+	registerCommand("StrLen", 2, execStrLen, readFirstKey, nil)
+	registerCommand("GetRange", 4, execGetRange, readFirstKey, nil)
+	registerCommand("SetRange", 4, execSetRange, writeFirstKey, rollbackFirstKey)
+	registerCommand("Incr", 2, execIncr, writeFirstKey, rollbackFirstKey)
+	registerCommand("Decr", 2, execDecr, writeFirstKey, rollbackFirstKey)
+	registerCommand("IncrBy", 3, execIncrBy, writeFirstKey, rollbackFirstKey)
+	registerCommand("DecrBy", 3, execDecrBy, writeFirstKey, rollbackFirstKey)
+	registerCommand("IncrByFloat", 3, execIncrByFloat, writeFirstKey, rollbackFirstKey)
+	registerCommand("LCS", -3, execLCS, func(args [][]byte) ([]string, []string) {
+		return []string{string(args[0]), string(args[1])}, nil
+	}, nil)
+}
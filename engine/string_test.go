@@ -0,0 +1,258 @@
+package engine
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/issimo1/redis-issimo/engine/payload"
+	"github.com/issimo1/redis-issimo/redis/connection"
+)
+
+// newTestEntity returns an entity which is not a string
+func newTestEntity() *payload.DataEntity {
+	return &payload.DataEntity{RedisObject: struct{}{}}
+}
+
+func TestSetGet(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "get", "a"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "set", "a", "a\r\nb\x00"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "get", "a"), "$5\r\na\r\nb\x00\r\n")
+	assertReply(t, execCmd(e, c, "type", "a"), "+string\r\n")
+
+	assertReply(t, execCmd(e, c, "set", "a", "1", "nx"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "set", "b", "1", "xx"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "set", "b", "1", "nx"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "set", "b", "2", "xx", "get"), "$1\r\n1\r\n")
+	assertReply(t, execCmd(e, c, "set", "b", "3", "nx", "get"), "$1\r\n2\r\n")
+	assertReply(t, execCmd(e, c, "get", "b"), "$1\r\n2\r\n")
+	assertReply(t, execCmd(e, c, "set", "c", "1", "get"), "$-1\r\n")
+
+	assertReply(t, execCmd(e, c, "set", "a", "1", "nx", "xx"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "set", "a", "1", "ex", "10", "px", "10"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "set", "a", "1", "ex", "10", "keepttl"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "set", "a", "1", "ex"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "set", "a", "1", "persist"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "set", "a", "1", "ex", "0"), "-ERR invalid expire time in 'set' command\r\n")
+	assertReply(t, execCmd(e, c, "set", "a", "1", "px", "x"), "-ERR value is not an integer or out of range\r\n")
+}
+
+func TestSetTTL(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "set", "a", "1", "ex", "100"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "a"), ":100\r\n")
+	assertReply(t, execCmd(e, c, "set", "a", "2", "keepttl"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "a"), ":100\r\n")
+	assertReply(t, execCmd(e, c, "set", "a", "3"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "a"), ":-1\r\n")
+
+	at := time.Now().Add(time.Hour).UnixMilli()
+	assertReply(t, execCmd(e, c, "set", "a", "1", "pxat", strconv.FormatInt(at, 10)), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "pexpiretime", "a"), ":"+strconv.FormatInt(at, 10)+"\r\n")
+	assertReply(t, execCmd(e, c, "set", "a", "1", "exat", strconv.FormatInt(at/1000, 10)), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "expiretime", "a"), ":"+strconv.FormatInt(at/1000, 10)+"\r\n")
+
+	assertReply(t, execCmd(e, c, "setex", "b", "100", "1"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "b"), ":100\r\n")
+	assertReply(t, execCmd(e, c, "psetex", "b", "100000", "1"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "b"), ":100\r\n")
+	assertReply(t, execCmd(e, c, "setex", "b", "0", "1"), "-ERR invalid expire time in 'setex' command\r\n")
+	assertReply(t, execCmd(e, c, "getset", "b", "2"), "$1\r\n1\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "b"), ":-1\r\n")
+
+	assertReply(t, execCmd(e, c, "psetex", "c", "20", "1"), "+OK\r\n")
+	time.Sleep(30 * time.Millisecond)
+	assertReply(t, execCmd(e, c, "get", "c"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "setnx", "c", "2"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "c"), ":-1\r\n")
+	assertReply(t, execCmd(e, c, "setnx", "c", "3"), ":0\r\n")
+}
+
+func TestGetDelGetEX(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "getdel", "a"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "getex", "a", "ex", "10"), "$-1\r\n")
+	execCmd(e, c, "set", "a", "1")
+	assertReply(t, execCmd(e, c, "getex", "a", "ex", "100"), "$1\r\n1\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "a"), ":100\r\n")
+	assertReply(t, execCmd(e, c, "getex", "a"), "$1\r\n1\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "a"), ":100\r\n")
+	assertReply(t, execCmd(e, c, "getex", "a", "persist"), "$1\r\n1\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "a"), ":-1\r\n")
+	assertReply(t, execCmd(e, c, "getex", "a", "keepttl"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "getex", "a", "ex", "1", "persist"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "getdel", "a"), "$1\r\n1\r\n")
+	assertReply(t, execCmd(e, c, "exists", "a"), ":0\r\n")
+}
+
+func TestMSetMGet(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "mset", "a", "1", "b"), "-ERR wrong number of arguments for 'mset' command\r\n")
+	assertReply(t, execCmd(e, c, "mset", "a", "1", "b", "2"), "+OK\r\n")
+	db, _ := e.selectDB(0)
+	db.dataDict.Put("t", newTestEntity())
+	assertReply(t, execCmd(e, c, "mget", "a", "b", "c", "t"), "*4\r\n$1\r\n1\r\n$1\r\n2\r\n$-1\r\n$-1\r\n")
+	assertReply(t, execCmd(e, c, "msetnx", "c", "3", "a", "4"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "exists", "c"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "msetnx", "c", "3", "d", "4"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "mget", "c", "d"), "*2\r\n$1\r\n3\r\n$1\r\n4\r\n")
+}
+
+func TestAppendRange(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "append", "a", "Hello"), ":5\r\n")
+	assertReply(t, execCmd(e, c, "append", "a", " World"), ":11\r\n")
+	assertReply(t, execCmd(e, c, "strlen", "a"), ":11\r\n")
+	assertReply(t, execCmd(e, c, "strlen", "b"), ":0\r\n")
+
+	assertReply(t, execCmd(e, c, "getrange", "a", "0", "4"), "$5\r\nHello\r\n")
+	assertReply(t, execCmd(e, c, "getrange", "a", "-5", "-1"), "$5\r\nWorld\r\n")
+	assertReply(t, execCmd(e, c, "getrange", "a", "-100", "100"), "$11\r\nHello World\r\n")
+	assertReply(t, execCmd(e, c, "getrange", "a", "5", "3"), "$0\r\n\r\n")
+	assertReply(t, execCmd(e, c, "getrange", "a", "-1", "-5"), "$0\r\n\r\n")
+	assertReply(t, execCmd(e, c, "getrange", "b", "0", "-1"), "$0\r\n\r\n")
+
+	assertReply(t, execCmd(e, c, "setrange", "a", "6", "Redis"), ":11\r\n")
+	assertReply(t, execCmd(e, c, "get", "a"), "$11\r\nHello Redis\r\n")
+	assertReply(t, execCmd(e, c, "setrange", "b", "3", "x"), ":4\r\n")
+	assertReply(t, execCmd(e, c, "get", "b"), "$4\r\n\x00\x00\x00x\r\n")
+	assertReply(t, execCmd(e, c, "setrange", "c", "3", ""), ":0\r\n")
+	assertReply(t, execCmd(e, c, "exists", "c"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "setrange", "a", "-1", "x"), "-ERR offset is out of range\r\n")
+	assertReply(t, execCmd(e, c, "setrange", "a", "536870911", "xx"), "-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n")
+}
+
+func TestLCS(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "mset", "key1", "ohmytext", "key2", "mynewtext")
+	assertReply(t, execCmd(e, c, "lcs", "key1", "key2"), "$6\r\nmytext\r\n")
+	assertReply(t, execCmd(e, c, "lcs", "key1", "key2", "len"), ":6\r\n")
+	assertReply(t, execCmd(e, c, "lcs", "key1", "key2", "idx"),
+		"*4\r\n$7\r\nmatches\r\n*2\r\n"+
+			"*2\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n"+
+			"*2\r\n*2\r\n:2\r\n:3\r\n*2\r\n:0\r\n:1\r\n"+
+			"$3\r\nlen\r\n:6\r\n")
+	assertReply(t, execCmd(e, c, "lcs", "key1", "key2", "idx", "minmatchlen", "4", "withmatchlen"),
+		"*4\r\n$7\r\nmatches\r\n*1\r\n"+
+			"*3\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n:4\r\n"+
+			"$3\r\nlen\r\n:6\r\n")
+	assertReply(t, execCmd(e, c, "lcs", "key1", "key2", "len", "idx"), "-ERR If you want both the length and indexes, please just use IDX.\r\n")
+	assertReply(t, execCmd(e, c, "lcs", "key1", "none"), "$0\r\n\r\n")
+}
+
+func TestStringWrongType(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	db, _ := e.selectDB(0)
+	db.dataDict.Put("a", newTestEntity())
+	wrongType := "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+	for _, cmd := range [][]string{
+		{"get", "a"},
+		{"set", "a", "1", "get"},
+		{"getset", "a", "1"},
+		{"getdel", "a"},
+		{"getex", "a"},
+		{"append", "a", "1"},
+		{"strlen", "a"},
+		{"getrange", "a", "0", "1"},
+		{"setrange", "a", "0", "1"},
+	} {
+		assertReply(t, execCmd(e, c, cmd...), wrongType)
+	}
+	assertReply(t, execCmd(e, c, "lcs", "a", "b"), "-ERR The specified keys must contain string values\r\n")
+	// SET overwrites a key of any type
+	assertReply(t, execCmd(e, c, "set", "a", "1"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "get", "a"), "$1\r\n1\r\n")
+}
+
+func TestIncrDecr(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "incr", "a"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "incrby", "a", "10"), ":11\r\n")
+	assertReply(t, execCmd(e, c, "decr", "a"), ":10\r\n")
+	assertReply(t, execCmd(e, c, "decrby", "a", "-5"), ":15\r\n")
+	assertReply(t, execCmd(e, c, "get", "a"), "$2\r\n15\r\n")
+	assertReply(t, execCmd(e, c, "incrby", "a", "x"), "-ERR value is not an integer or out of range\r\n")
+
+	execCmd(e, c, "set", "b", "9223372036854775806")
+	assertReply(t, execCmd(e, c, "incr", "b"), ":9223372036854775807\r\n")
+	assertReply(t, execCmd(e, c, "incr", "b"), "-ERR increment or decrement would overflow\r\n")
+	execCmd(e, c, "set", "b", "-9223372036854775808")
+	assertReply(t, execCmd(e, c, "decr", "b"), "-ERR increment or decrement would overflow\r\n")
+	assertReply(t, execCmd(e, c, "decrby", "a", "-9223372036854775808"), "-ERR decrement would overflow\r\n")
+
+	for _, v := range []string{"abc", "1.5", " 1", "01", "+1", "-0", "", "99999999999999999999"} {
+		execCmd(e, c, "set", "c", v)
+		assertReply(t, execCmd(e, c, "incr", "c"), "-ERR value is not an integer or out of range\r\n")
+	}
+
+	// INCR keeps the ttl
+	execCmd(e, c, "set", "d", "1", "ex", "100")
+	assertReply(t, execCmd(e, c, "incr", "d"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "d"), ":100\r\n")
+}
+
+func TestIncrByFloat(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	db, _ := e.selectDB(0)
+	var aof [][]byte
+	db.writeAof = func(cmdLine [][]byte) {
+		aof = cmdLine
+	}
+	execCmd(e, c, "set", "a", "10.50")
+	assertReply(t, execCmd(e, c, "incrbyfloat", "a", "0.1"), "$4\r\n10.6\r\n")
+	assertReply(t, execCmd(e, c, "incrbyfloat", "a", "-5"), "$3\r\n5.6\r\n")
+	if len(aof) != 4 || string(aof[0]) != "set" || string(aof[2]) != "5.6" || string(aof[3]) != "keepttl" {
+		t.Errorf("expect INCRBYFLOAT propagated as SET, got %q", aof)
+	}
+	execCmd(e, c, "set", "b", "5.0e3")
+	assertReply(t, execCmd(e, c, "incrbyfloat", "b", "2.0e2"), "$4\r\n5200\r\n")
+	assertReply(t, execCmd(e, c, "incrbyfloat", "c", "0.1"), "$3\r\n0.1\r\n")
+	assertReply(t, execCmd(e, c, "incrbyfloat", "c", "0.2"), "$3\r\n0.3\r\n")
+	assertReply(t, execCmd(e, c, "incrbyfloat", "c", "-0.3"), "$1\r\n0\r\n")
+	assertReply(t, execCmd(e, c, "incrbyfloat", "c", "x"), "-ERR value is not a valid float\r\n")
+	assertReply(t, execCmd(e, c, "incrbyfloat", "c", "nan"), "-ERR value is not a valid float\r\n")
+	assertReply(t, execCmd(e, c, "incrbyfloat", "c", "inf"), "-ERR increment would produce NaN or Infinity\r\n")
+	execCmd(e, c, "set", "d", "abc")
+	assertReply(t, execCmd(e, c, "incrbyfloat", "d", "1"), "-ERR value is not a valid float\r\n")
+	assertReply(t, execCmd(e, c, "incr", "c"), ":1\r\n")
+}
+
+func TestObjectEncoding(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "a", "12345")
+	assertReply(t, execCmd(e, c, "object", "encoding", "a"), "$3\r\nint\r\n")
+	execCmd(e, c, "set", "a", "012345")
+	assertReply(t, execCmd(e, c, "object", "encoding", "a"), "$6\r\nembstr\r\n")
+	execCmd(e, c, "set", "a", strings.Repeat("x", 45))
+	assertReply(t, execCmd(e, c, "object", "encoding", "a"), "$3\r\nraw\r\n")
+	execCmd(e, c, "incr", "b")
+	assertReply(t, execCmd(e, c, "object", "encoding", "b"), "$3\r\nint\r\n")
+	assertReply(t, execCmd(e, c, "object", "encoding", "none"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "object", "refcount", "b"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "object", "foo", "b"), "-ERR unknown subcommand 'foo'. Try OBJECT HELP.\r\n")
+	assertReply(t, execCmd(e, c, "object", "encoding"), "-ERR wrong number of arguments for 'object|encoding' command\r\n")
+	assertReply(t, execCmd(e, c, "append", "b", "1"), ":2\r\n")
+	assertReply(t, execCmd(e, c, "get", "b"), "$2\r\n11\r\n")
+}
+
+func TestStringRollback(t *testing.T) {
+	testRollback(t, rollbackCase{
+		name:  "string",
+		setup: [][]string{{"set", "a", "1", "ex", "100"}, {"set", "n", "10"}},
+		cmds: [][]string{{"set", "a", "2"}, {"append", "a", "3"}, {"set", "b", "1"}, {"incrby", "n", "5"},
+			{"getdel", "n"}, {"msetnx", "c", "1", "d", "2"}},
+		reads: [][]string{{"mget", "a", "b", "c", "d", "n"}, {"ttl", "a"}},
+	})
+}
@@ -1,6 +1,9 @@
 package engine
 
 import (
+	"strconv"
+	"strings"
+
 	"github.com/issimo1/redis-issimo/abs"
 	"github.com/issimo1/redis-issimo/redis/protocol"
 	"github.com/issimo1/redis-issimo/utils/config"
@@ -8,28 +11,26 @@ import (
 
 func Auth(c abs.Connection, pwd [][]byte) protocol.Reply {
 	if len(pwd) != 1 {
-		//err
+		return protocol.MakeArgNumErrReply("auth")
 	}
 	if config.GlobalConfig.RequiredPwd == "" {
-		// err
+		return protocol.MakeErrReply("ERR Client sent AUTH, but no password is set")
 	}
 	pwds := string(pwd[0])
 	if config.GlobalConfig.RequiredPwd != pwds {
-		// err
+		return protocol.MakeErrReply("ERR invalid password")
 	}
 	c.SetDBPwd(pwds)
-	return nil
+	return protocol.MakeOkReply()
 }
 
-// Ping todo protocol
 func Ping(ping [][]byte) protocol.Reply {
 	if len(ping) == 0 {
-		return nil
+		return protocol.MakePongReply()
 	} else if len(ping) == 1 {
-		//return ping[0]
+		return protocol.MakeBulkReply(ping[0])
 	}
-	// err
-	return nil
+	return protocol.MakeArgNumErrReply("ping")
 }
 
 func checkPassword(c abs.Connection) bool {
@@ -38,3 +39,71 @@ func checkPassword(c abs.Connection) bool {
 	}
 	return c.GetDBPwd() == config.GlobalConfig.RequiredPwd
 }
+
+const serverVersion = "7.2.0"
+
+// Hello switches the protocol version, HELLO [protover [AUTH username password] [SETNAME clientname]]
+func Hello(c abs.Connection, args [][]byte) protocol.Reply {
+	protover := c.GetProtocol()
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			return protocol.MakeErrReply("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != protocol.RESP2 && ver != protocol.RESP3 {
+			return protocol.MakeErrReply("NOPROTO unsupported protocol version")
+		}
+		protover = ver
+	}
+	var auth, name []byte
+	authGiven, nameGiven := false, false
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		if opt == "auth" && i+2 < len(args) {
+			if string(args[i+1]) != "default" {
+				return protocol.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+			}
+			auth, authGiven = args[i+2], true
+			i += 2
+		} else if opt == "setname" && i+1 < len(args) {
+			name, nameGiven = args[i+1], true
+			i++
+		} else {
+			return protocol.MakeErrReply("ERR Syntax error in HELLO option '" + string(args[i]) + "'")
+		}
+	}
+	if authGiven && config.GlobalConfig.RequiredPwd != "" {
+		if string(auth) != config.GlobalConfig.RequiredPwd {
+			return protocol.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+		}
+		c.SetDBPwd(string(auth))
+	}
+	if !checkPassword(c) {
+		return protocol.MakeErrReply("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+			"and select the RESP protocol version at the same time")
+	}
+	if nameGiven {
+		if strings.ContainsAny(string(name), " \n") {
+			return protocol.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		c.SetName(string(name))
+	}
+	c.SetProtocol(protover)
+
+	keys := []string{"server", "version", "proto", "id", "mode", "role", "modules"}
+	values := []protocol.Reply{
+		protocol.MakeBulkReply([]byte("redis")),
+		protocol.MakeBulkReply([]byte(serverVersion)),
+		protocol.MakeIntReply(int64(protover)),
+		protocol.MakeIntReply(c.GetID()),
+		protocol.MakeBulkReply([]byte("standalone")),
+		protocol.MakeBulkReply([]byte("master")),
+		protocol.MakeEmptyMultiBulkReply(),
+	}
+	keyReplies := make([]protocol.Reply, len(keys))
+	for i, key := range keys {
+		keyReplies[i] = protocol.MakeBulkReply([]byte(key))
+	}
+	return protocol.MakeMapReply(keyReplies, values)
+}
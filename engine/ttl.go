@@ -0,0 +1,220 @@
+package engine
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+const (
+	expireNX = 1 << iota
+	expireXX
+	expireGT
+	expireLT
+)
+
+// parseExpireFlags parses [NX | XX | GT | LT] of the EXPIRE family
+func parseExpireFlags(args [][]byte) (int, protocol.ErrorReply) {
+	flags := 0
+	for _, arg := range args {
+		switch strings.ToLower(string(arg)) {
+		case "nx":
+			flags |= expireNX
+		case "xx":
+			flags |= expireXX
+		case "gt":
+			flags |= expireGT
+		case "lt":
+			flags |= expireLT
+		default:
+			return 0, protocol.MakeErrReply("ERR Unsupported option " + string(arg))
+		}
+	}
+	if flags&expireNX != 0 && flags&(expireXX|expireGT|expireLT) != 0 {
+		return 0, protocol.MakeErrReply("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if flags&expireGT != 0 && flags&expireLT != 0 {
+		return 0, protocol.MakeErrReply("ERR GT and LT options at the same time are not compatible")
+	}
+	return flags, nil
+}
+
+// parseExpireTime converts the time argument of cmd to an absolute unix time in milliseconds
+func parseExpireTime(cmd string, arg []byte, unit time.Duration, relative bool) (int64, protocol.ErrorReply) {
+	raw, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	invalid := protocol.MakeErrReply("ERR invalid expire time in '" + cmd + "' command")
+	scale := int64(unit / time.Millisecond)
+	if raw > math.MaxInt64/scale || raw < math.MinInt64/scale {
+		return 0, invalid
+	}
+	when := raw * scale
+	if relative {
+		now := time.Now().UnixMilli()
+		if when > math.MaxInt64-now {
+			return 0, invalid
+		}
+		when += now
+	}
+	return when, nil
+}
+
+// expireGeneric implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT with when in unix milliseconds
+func expireGeneric(db *DB, cmd string, args [][]byte, unit time.Duration, relative bool) protocol.Reply {
+	key := string(args[0])
+	when, errReply := parseExpireTime(cmd, args[1], unit, relative)
+	if errReply != nil {
+		return errReply
+	}
+	flags, errReply := parseExpireFlags(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	if _, exists := db.GetEntity(key); !exists {
+		return protocol.MakeIntReply(0)
+	}
+
+	current, hasTTL := db.GetExpireTime(key)
+	if !expireAllowed(flags, current, hasTTL, when) {
+		return protocol.MakeIntReply(0)
+	}
+
+	expireTime := time.UnixMilli(when)
+	if !expireTime.After(time.Now()) {
+		db.Remove(key)
+		db.writeAof(toCmdLine("del", key))
+		return protocol.MakeIntReply(1)
+	}
+	db.Expire(key, expireTime)
+	db.writeAof(makeExpireCmd(key, expireTime))
+	return protocol.MakeIntReply(1)
+}
+
+// expireAllowed checks the NX, XX, GT and LT flags against the current ttl before the expire time is set to when
+func expireAllowed(flags int, current time.Time, hasTTL bool, when int64) bool {
+	currentMs := current.UnixMilli()
+	// a key without ttl is treated as having an infinite ttl by GT and LT
+	if flags&expireNX != 0 && hasTTL {
+		return false
+	}
+	if flags&expireXX != 0 && !hasTTL {
+		return false
+	}
+	if flags&expireGT != 0 && (!hasTTL || when <= currentMs) {
+		return false
+	}
+	if flags&expireLT != 0 && hasTTL && when >= currentMs {
+		return false
+	}
+	return true
+}
+
+// makeExpireCmd returns a PEXPIREAT command, relative ttl is propagated as absolute time to survive AOF replay
+func makeExpireCmd(key string, expireTime time.Time) [][]byte {
+	return toCmdLine("pexpireat", key, strconv.FormatInt(expireTime.UnixMilli(), 10))
+}
+
+// execExpire sets ttl in seconds, EXPIRE key seconds [NX | XX | GT | LT]
+func execExpire(db *DB, args [][]byte) protocol.Reply {
+	return expireGeneric(db, "expire", args, time.Second, true)
+}
+
+// execPExpire sets ttl in milliseconds, PEXPIRE key milliseconds [NX | XX | GT | LT]
+func execPExpire(db *DB, args [][]byte) protocol.Reply {
+	return expireGeneric(db, "pexpire", args, time.Millisecond, true)
+}
+
+// execExpireAt sets expire time in unix seconds, EXPIREAT key unix-time-seconds [NX | XX | GT | LT]
+func execExpireAt(db *DB, args [][]byte) protocol.Reply {
+	return expireGeneric(db, "expireat", args, time.Second, false)
+}
+
+// execPExpireAt sets expire time in unix milliseconds, PEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT]
+func execPExpireAt(db *DB, args [][]byte) protocol.Reply {
+	return expireGeneric(db, "pexpireat", args, time.Millisecond, false)
+}
+
+// execPersist removes the ttl of key, PERSIST key
+func execPersist(db *DB, args [][]byte) protocol.Reply {
+	key := string(args[0])
+	if _, exists := db.GetEntity(key); !exists {
+		return protocol.MakeIntReply(0)
+	}
+	if !db.Persist(key) {
+		return protocol.MakeIntReply(0)
+	}
+	db.writeAof(toCmdLine3("persist", args))
+	return protocol.MakeIntReply(1)
+}
+
+// ttlGeneric returns -2 if key does not exist, -1 if key has no ttl, otherwise the result of convert
+func ttlGeneric(db *DB, args [][]byte, convert func(expireTime time.Time) int64) protocol.Reply {
+	key := string(args[0])
+	if _, exists := db.GetEntity(key); !exists {
+		return protocol.MakeIntReply(-2)
+	}
+	expireTime, ok := db.GetExpireTime(key)
+	if !ok {
+		return protocol.MakeIntReply(-1)
+	}
+	return protocol.MakeIntReply(convert(expireTime))
+}
+
+// remainingMs returns the milliseconds before expireTime, never negative
+func remainingMs(expireTime time.Time) int64 {
+	ms := time.Until(expireTime).Milliseconds()
+	if ms < 0 {
+		ms = 0
+	}
+	return ms
+}
+
+// remainingSeconds returns the seconds before expireTime, rounded to the nearest
+func remainingSeconds(expireTime time.Time) int64 {
+	return (remainingMs(expireTime) + 500) / 1000
+}
+
+func unixSeconds(expireTime time.Time) int64 {
+	return expireTime.Unix()
+}
+
+func unixMs(expireTime time.Time) int64 {
+	return expireTime.UnixMilli()
+}
+
+// execTTL returns the remaining ttl in seconds, TTL key
+func execTTL(db *DB, args [][]byte) protocol.Reply {
+	return ttlGeneric(db, args, remainingSeconds)
+}
+
+// execPTTL returns the remaining ttl in milliseconds, PTTL key
+func execPTTL(db *DB, args [][]byte) protocol.Reply {
+	return ttlGeneric(db, args, remainingMs)
+}
+
+// execExpireTime returns the expire time in unix seconds, EXPIRETIME key
+func execExpireTime(db *DB, args [][]byte) protocol.Reply {
+	return ttlGeneric(db, args, unixSeconds)
+}
+
+// execPExpireTime returns the expire time in unix milliseconds, PEXPIRETIME key
+func execPExpireTime(db *DB, args [][]byte) protocol.Reply {
+	return ttlGeneric(db, args, unixMs)
+}
+
+func init() {
+	registerCommand("Expire", -3, execExpire, writeFirstKey, rollbackFirstKey)
+	registerCommand("PExpire", -3, execPExpire, writeFirstKey, rollbackFirstKey)
+	registerCommand("ExpireAt", -3, execExpireAt, writeFirstKey, rollbackFirstKey)
+	registerCommand("PExpireAt", -3, execPExpireAt, writeFirstKey, rollbackFirstKey)
+	registerCommand("Persist", 2, execPersist, writeFirstKey, rollbackFirstKey)
+	registerCommand("TTL", 2, execTTL, readFirstKey, nil)
+	registerCommand("PTTL", 2, execPTTL, readFirstKey, nil)
+	registerCommand("ExpireTime", 2, execExpireTime, readFirstKey, nil)
+	registerCommand("PExpireTime", 2, execPExpireTime, readFirstKey, nil)
+}
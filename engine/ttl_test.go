@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+func TestExpireAndTTL(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "expire", "a", "100"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "a"), ":-2\r\n")
+	execCmd(e, c, "set", "a", "1")
+	assertReply(t, execCmd(e, c, "ttl", "a"), ":-1\r\n")
+	assertReply(t, execCmd(e, c, "expiretime", "a"), ":-1\r\n")
+	assertReply(t, execCmd(e, c, "expire", "a", "100"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "a"), ":100\r\n")
+	pttl := execCmd(e, c, "pttl", "a").(*protocol.IntReply).Code
+	if pttl <= 99000 || pttl > 100000 {
+		t.Errorf("unexpected pttl %d", pttl)
+	}
+
+	at := time.Now().Add(time.Hour).Unix()
+	assertReply(t, execCmd(e, c, "expireat", "a", strconv.FormatInt(at, 10)), ":1\r\n")
+	assertReply(t, execCmd(e, c, "expiretime", "a"), ":"+strconv.FormatInt(at, 10)+"\r\n")
+	assertReply(t, execCmd(e, c, "pexpiretime", "a"), ":"+strconv.FormatInt(at*1000, 10)+"\r\n")
+
+	assertReply(t, execCmd(e, c, "persist", "a"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "persist", "a"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "a"), ":-1\r\n")
+
+	assertReply(t, execCmd(e, c, "expire", "a", "x"), "-ERR value is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "expire", "a", "9223372036854775807"), "-ERR invalid expire time in 'expire' command\r\n")
+	assertReply(t, execCmd(e, c, "expire", "a", "10", "foo"), "-ERR Unsupported option foo\r\n")
+
+	// an expire time in the past deletes the key at once
+	assertReply(t, execCmd(e, c, "pexpire", "a", "-1"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "exists", "a"), ":0\r\n")
+}
+
+func TestExpireFlags(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "a", "1")
+	assertReply(t, execCmd(e, c, "expire", "a", "100", "nx", "xx"), "-ERR NX and XX, GT or LT options at the same time are not compatible\r\n")
+	assertReply(t, execCmd(e, c, "expire", "a", "100", "gt", "lt"), "-ERR GT and LT options at the same time are not compatible\r\n")
+
+	assertReply(t, execCmd(e, c, "expire", "a", "100", "xx"), ":0\r\n")
+	// no ttl means infinite ttl, so GT never succeeds and LT always succeeds
+	assertReply(t, execCmd(e, c, "expire", "a", "100", "gt"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "expire", "a", "100", "lt"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "expire", "a", "200", "nx"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "expire", "a", "200", "xx"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "expire", "a", "100", "gt"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "expire", "a", "300", "gt"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "expire", "a", "400", "lt"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "expire", "a", "50", "LT"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "a"), ":50\r\n")
+}
+
+func TestLazyExpire(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "a", "1")
+	execCmd(e, c, "set", "b", "2")
+	assertReply(t, execCmd(e, c, "pexpire", "a", "20"), ":1\r\n")
+	time.Sleep(30 * time.Millisecond)
+	// the time wheel has not run yet, reads must not see the expired key
+	assertReply(t, execCmd(e, c, "exists", "a"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "a"), ":-2\r\n")
+	assertReply(t, execCmd(e, c, "keys", "*"), "*1\r\n$1\r\nb\r\n")
+	assertReply(t, execCmd(e, c, "del", "a"), ":0\r\n")
+	assertReply(t, execCmd(e, c, "dbsize"), ":1\r\n")
+}
+
+func TestActiveExpire(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	db, _ := e.selectDB(0)
+	var mu sync.Mutex
+	var aof [][][]byte
+	db.writeAof = func(cmdLine [][]byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		aof = append(aof, cmdLine)
+	}
+	execCmd(e, c, "set", "a", "1")
+	execCmd(e, c, "set", "b", "1")
+	assertReply(t, execCmd(e, c, "pexpire", "a", "100"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "pexpire", "b", "100"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "persist", "b"), ":1\r\n")
+
+	deadline := time.Now().Add(3 * time.Second)
+	for db.dataDict.Count() != 1 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, ok := db.dataDict.Get("a"); ok {
+		t.Fatal("expired key is not removed by the time wheel")
+	}
+	if _, ok := db.dataDict.Get("b"); !ok {
+		t.Fatal("persisted key is removed")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	last := aof[len(aof)-1]
+	if len(last) != 2 || string(last[0]) != "del" || string(last[1]) != "a" {
+		t.Errorf("expect expiration propagated as DEL, got %q", last)
+	}
+}
+
+func TestRenameKeepsTTL(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "a", "1")
+	execCmd(e, c, "expire", "a", "100")
+	assertReply(t, execCmd(e, c, "rename", "a", "b"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "b"), ":100\r\n")
+	assertReply(t, execCmd(e, c, "copy", "b", "c"), ":1\r\n")
+	assertReply(t, execCmd(e, c, "ttl", "c"), ":100\r\n")
+	assertReply(t, execCmd(e, c, "del", "b"), ":1\r\n")
+	execCmd(e, c, "set", "b", "1")
+	assertReply(t, execCmd(e, c, "ttl", "b"), ":-1\r\n")
+}
+
+func TestExpireRollback(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "a", "1")
+	execCmd(e, c, "expire", "a", "100")
+	undo := e.GetUndoLogs(0, toArgs("persist", "a"))
+	last := undo[len(undo)-1]
+	if string(last[0]) != "pexpireat" || string(last[1]) != "a" {
+		t.Errorf("expect undo log restoring the ttl, got %q", undo)
+	}
+
+	testRollback(t, rollbackCase{
+		name:  "ttl",
+		setup: [][]string{{"set", "a", "1", "ex", "100"}, {"set", "b", "2"}, {"set", "c", "3"}},
+		cmds:  [][]string{{"persist", "a"}, {"expire", "b", "10"}, {"pexpireat", "c", "1"}},
+		reads: [][]string{{"ttl", "a"}, {"ttl", "b"}, {"get", "c"}},
+	})
+}
@@ -0,0 +1,258 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/issimo1/redis-issimo/abs"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/config"
+)
+
+var execAbortReply = protocol.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+
+// StartMulti starts a transaction, commands are queued until EXEC.
+// MULTI ROLLBACK, or the txRollback config, undoes the executed commands if any queued command fails.
+func StartMulti(c abs.Connection, args [][]byte) protocol.Reply {
+	if c.IsTransaction() {
+		return protocol.MakeErrReply("ERR MULTI calls can not be nested")
+	}
+	rollback := config.GlobalConfig.TxRollback
+	if len(args) == 1 {
+		if strings.ToLower(string(args[0])) != "rollback" {
+			return protocol.MakeSyntaxErrReply()
+		}
+		rollback = true
+	}
+	c.SetTransaction(true)
+	c.SetTxRollback(rollback)
+	return protocol.MakeOkReply()
+}
+
+// DiscardMulti drops the queued commands and the watched keys
+func DiscardMulti(c abs.Connection) protocol.Reply {
+	if !c.IsTransaction() {
+		return protocol.MakeErrReply("ERR DISCARD without MULTI")
+	}
+	c.SetTransaction(false)
+	return protocol.MakeOkReply()
+}
+
+// EnqueueCmd validates the command before queueing it, an invalid command makes EXEC abort
+func EnqueueCmd(c abs.Connection, cmdLine [][]byte) protocol.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	arity := 0
+	if cmd, ok := commandCenter[cmdName]; ok {
+		arity = cmd.argsNum
+	} else if cmd, ok := engineCommands[cmdName]; ok {
+		arity = cmd.arity
+	} else {
+		errReply := protocol.MakeUnknownCommandErrReply(cmdName, cmdLine[1:])
+		c.AddTxErr(errReply)
+		return errReply
+	}
+	if !validateArity(arity, cmdLine) {
+		errReply := protocol.MakeArgNumErrReply(cmdName)
+		c.AddTxErr(errReply)
+		return errReply
+	}
+	c.EnqueueCmd(cmdLine)
+	return protocol.MakeQueuedReply()
+}
+
+// Watch records the current version of keys, EXEC is aborted if any of them is modified
+func Watch(db *DB, c abs.Connection, args [][]byte) protocol.Reply {
+	if c.IsTransaction() {
+		return protocol.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
+	watching := c.GetWatchKey()
+	for _, arg := range args {
+		key := string(arg)
+		watching[key] = db.GetVersion(key)
+	}
+	return protocol.MakeOkReply()
+}
+
+// UnWatch forgets all watched keys
+func UnWatch(c abs.Connection) protocol.Reply {
+	c.CleanWatchKey()
+	return protocol.MakeOkReply()
+}
+
+func isWatchingChanged(db *DB, watching map[string]int64) bool {
+	for key, ver := range watching {
+		if db.GetVersion(key) != ver {
+			return true
+		}
+	}
+	return false
+}
+
+// execMulti executes the queued commands of c
+func (e *Engine) execMulti(c abs.Connection) protocol.Reply {
+	if !c.IsTransaction() {
+		return protocol.MakeErrReply("ERR EXEC without MULTI")
+	}
+	defer c.SetTransaction(false)
+	if len(c.GetTxErr()) > 0 {
+		return execAbortReply
+	}
+	db, errReply := e.selectDB(c.GetDBIdx())
+	if errReply != nil {
+		return errReply
+	}
+	return e.ExecMulti(c, db, c.GetWatchKey(), c.GetQueueCmd(), c.IsTxRollback())
+}
+
+// undoLog is the commands which restore db
+type undoLog struct {
+	db       *DB
+	cmdLines [][][]byte
+}
+
+// ExecMulti locks every key used by cmdLines and the watched keys in db, then runs cmdLines atomically.
+// COPY to another db also locks its destination key in that db, dbs are locked in the order of index
+// as COPY outside MULTI does. FLUSHALL locks every db in the order of index instead.
+// If rollback is set, the undo logs are collected before each command and replayed in reverse order
+// once a command fails, so the keyspace is left as it was before EXEC.
+func (e *Engine) ExecMulti(c abs.Connection, db *DB, watching map[string]int64, cmdLines [][][]byte, rollback bool) protocol.Reply {
+	writeKeys := make([]string, 0)
+	readKeys := make([]string, 0, len(cmdLines)+len(watching))
+	lockAll, lockDBs := false, false
+	// destination keys of COPY to another db, grouped by their dbs
+	copyKeys := make(map[*DB][]string)
+	for _, cmdLine := range cmdLines {
+		name := strings.ToLower(string(cmdLine[0]))
+		if name == "flushall" {
+			lockDBs = true
+		}
+		if name == "copy" {
+			copyArgs, destDB, _ := e.crossDBCopy(db, cmdLine[1:])
+			if destDB != nil {
+				readKeys = append(readKeys, copyArgs.src)
+				copyKeys[destDB] = append(copyKeys[destDB], copyArgs.dest)
+				continue
+			}
+		}
+		cmd, ok := commandCenter[name]
+		if !ok {
+			continue
+		}
+		read, write := cmd.keyFunc(cmdLine[1:])
+		readKeys = append(readKeys, read...)
+		writeKeys = append(writeKeys, write...)
+		lockAll = lockAll || cmd.lockMode != lockKeys
+	}
+	for key := range watching {
+		readKeys = append(readKeys, key)
+	}
+	defer db.serveBlocked(writeKeys...)
+	for destDB, keys := range copyKeys {
+		defer destDB.serveBlocked(keys...)
+	}
+	for _, holder := range e.dbSet {
+		other := holder.Load().(*DB)
+		if lockDBs {
+			other.dataDict.LockAll(true)
+			defer other.dataDict.UnLockAll(true)
+		} else if other == db && lockAll {
+			db.dataDict.LockAll(true)
+			defer db.dataDict.UnLockAll(true)
+		} else if other == db {
+			db.RWLock(readKeys, writeKeys)
+			defer db.RWUnLock(readKeys, writeKeys)
+		} else if keys, ok := copyKeys[other]; ok {
+			other.RWLock(nil, keys)
+			defer other.RWUnLock(nil, keys)
+		}
+	}
+
+	if isWatchingChanged(db, watching) {
+		return protocol.MakeNullArrayReply()
+	}
+	results := make([]protocol.Reply, 0, len(cmdLines))
+	undoLogs := make([]undoLog, 0, len(cmdLines))
+	for _, cmdLine := range cmdLines {
+		if rollback {
+			undoLogs = append(undoLogs, e.getTxUndoLogs(db, cmdLine)...)
+		}
+		result := e.execQueued(c, db, cmdLine)
+		if rollback && protocol.IsErrorReply(result) {
+			rollbackUndoLogs(undoLogs)
+			errMsg := "EXECABORT Transaction rollback because of errors"
+			if errReply, ok := result.(protocol.ErrorReply); ok {
+				errMsg += ": " + errReply.Error()
+			}
+			return protocol.MakeErrReply(errMsg)
+		}
+		results = append(results, result)
+	}
+	db.addVersion(writeKeys...)
+	for destDB, keys := range copyKeys {
+		destDB.addVersion(keys...)
+	}
+	return protocol.MakeArrayReply(results)
+}
+
+// execQueued runs a command queued in MULTI, the keys it uses are locked by caller
+func (e *Engine) execQueued(c abs.Connection, db *DB, cmdLine [][]byte) protocol.Reply {
+	name := strings.ToLower(string(cmdLine[0]))
+	switch name {
+	case "flushall":
+		return e.flushAll(cmdLine[1:], true)
+	case "copy":
+		copyArgs, destDB, errReply := e.crossDBCopy(db, cmdLine[1:])
+		if errReply != nil {
+			return errReply
+		}
+		if destDB != nil {
+			return copyKey(db, destDB, copyArgs.src, copyArgs.dest, copyArgs.replace)
+		}
+	case "subscribe", "unsubscribe":
+		// the confirmations are the reply of the command in EXEC rather than pushed out of band
+		var replies []protocol.Reply
+		collect := func(reply protocol.Reply) {
+			replies = append(replies, reply)
+		}
+		if name == "subscribe" {
+			e.hub.subscribe(c, cmdLine[1:], collect)
+		} else {
+			e.hub.unsubscribe(c, cmdLine[1:], collect)
+		}
+		if len(replies) == 1 {
+			return replies[0]
+		}
+		return protocol.MakeArrayReply(replies)
+	}
+	if _, ok := engineCommands[name]; ok {
+		return e.execEngineCommand(c, cmdLine)
+	}
+	return db.execWithLock(cmdLine)
+}
+
+// getTxUndoLogs returns the undo logs of a command queued in MULTI, the keys it uses are locked by caller
+func (e *Engine) getTxUndoLogs(db *DB, cmdLine [][]byte) []undoLog {
+	switch strings.ToLower(string(cmdLine[0])) {
+	case "flushall":
+		flushDB := toCmdLine3("flushdb", cmdLine[1:])
+		logs := make([]undoLog, 0, len(e.dbSet))
+		for _, holder := range e.dbSet {
+			other := holder.Load().(*DB)
+			logs = append(logs, undoLog{db: other, cmdLines: other.GetUndoLogs(flushDB)})
+		}
+		return logs
+	case "copy":
+		if copyArgs, destDB, _ := e.crossDBCopy(db, cmdLine[1:]); destDB != nil {
+			return []undoLog{{db: destDB, cmdLines: rollbackGivenKeys(destDB, copyArgs.dest)}}
+		}
+	}
+	return []undoLog{{db: db, cmdLines: db.GetUndoLogs(cmdLine)}}
+}
+
+// rollbackUndoLogs replays undo logs from the last command to the first one, keys must be locked by caller
+func rollbackUndoLogs(undoLogs []undoLog) {
+	for i := len(undoLogs) - 1; i >= 0; i-- {
+		for _, cmdLine := range undoLogs[i].cmdLines {
+			undoLogs[i].db.execWithLock(cmdLine)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package engine
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/redis/protocol"
+	"github.com/issimo1/redis-issimo/utils/config"
+)
+
+func TestMultiExec(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "exec"), "-ERR EXEC without MULTI\r\n")
+	assertReply(t, execCmd(e, c, "discard"), "-ERR DISCARD without MULTI\r\n")
+	assertReply(t, execCmd(e, c, "multi"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "multi"), "-ERR MULTI calls can not be nested\r\n")
+	assertReply(t, execCmd(e, c, "watch", "a"), "-ERR WATCH inside MULTI is not allowed\r\n")
+	assertReply(t, execCmd(e, c, "set", "a", "1"), "+QUEUED\r\n")
+	assertReply(t, execCmd(e, c, "get", "a"), "+QUEUED\r\n")
+	assertReply(t, execCmd(e, c, "exec"), "*2\r\n+OK\r\n$1\r\n1\r\n")
+	if c.IsTransaction() {
+		t.Error("transaction should be finished")
+	}
+
+	assertReply(t, execCmd(e, c, "multi"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "set", "a", "2"), "+QUEUED\r\n")
+	assertReply(t, execCmd(e, c, "discard"), "+OK\r\n")
+	assertReply(t, execCmd(e, c, "get", "a"), "$1\r\n1\r\n")
+}
+
+func TestExecAbort(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "multi")
+	assertReply(t, execCmd(e, c, "set", "a", "1"), "+QUEUED\r\n")
+	assertReply(t, execCmd(e, c, "set", "a"), "-ERR wrong number of arguments for 'set' command\r\n")
+	assertReply(t, execCmd(e, c, "nope"), "-ERR unknown command 'nope', with args beginning with: \r\n")
+	assertReply(t, execCmd(e, c, "exec"), "-EXECABORT Transaction discarded because of previous errors.\r\n")
+	assertReply(t, execCmd(e, c, "get", "a"), "$-1\r\n")
+
+	// errors of the aborted transaction must not leak into the next one
+	execCmd(e, c, "multi")
+	execCmd(e, c, "set", "a", "1")
+	assertReply(t, execCmd(e, c, "exec"), "*1\r\n+OK\r\n")
+}
+
+func TestWatch(t *testing.T) {
+	e := NewEngine()
+	c1 := connection.NewFakeConn()
+	c2 := connection.NewFakeConn()
+
+	assertReply(t, execCmd(e, c1, "watch", "a", "b"), "+OK\r\n")
+	assertReply(t, execCmd(e, c2, "set", "a", "changed"), "+OK\r\n")
+	execCmd(e, c1, "multi")
+	execCmd(e, c1, "set", "a", "mine")
+	assertReply(t, execCmd(e, c1, "exec"), "*-1\r\n")
+	assertReply(t, execCmd(e, c1, "get", "a"), "$7\r\nchanged\r\n")
+
+	// watched keys are forgotten after EXEC
+	execCmd(e, c1, "multi")
+	execCmd(e, c1, "set", "a", "mine")
+	assertReply(t, execCmd(e, c1, "exec"), "*1\r\n+OK\r\n")
+
+	// UNWATCH drops the watched keys
+	execCmd(e, c1, "watch", "a")
+	execCmd(e, c2, "set", "a", "changed")
+	assertReply(t, execCmd(e, c1, "unwatch"), "+OK\r\n")
+	execCmd(e, c1, "multi")
+	execCmd(e, c1, "set", "a", "mine")
+	assertReply(t, execCmd(e, c1, "exec"), "*1\r\n+OK\r\n")
+
+	// a write in another db does not touch the watched key
+	execCmd(e, c1, "watch", "a")
+	execCmd(e, c2, "select", "1")
+	execCmd(e, c2, "set", "a", "other db")
+	execCmd(e, c1, "multi")
+	execCmd(e, c1, "get", "a")
+	assertReply(t, execCmd(e, c1, "exec"), "*1\r\n$4\r\nmine\r\n")
+}
+
+// TestConcurrentWatch increases a counter with WATCH/MULTI/EXEC from many clients,
+// no increment may be lost if conflicting transactions are aborted
+func TestConcurrentWatch(t *testing.T) {
+	e := NewEngine()
+	const clients, rounds = 8, 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	aborted := 0
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := connection.NewFakeConn()
+			for j := 0; j < rounds; {
+				execCmd(e, c, "watch", "counter")
+				n := 0
+				if reply, ok := execCmd(e, c, "get", "counter").(*protocol.BulkReply); ok {
+					n, _ = strconv.Atoi(string(reply.Arg))
+				}
+				execCmd(e, c, "multi")
+				execCmd(e, c, "set", "counter", strconv.Itoa(n+1))
+				if _, ok := execCmd(e, c, "exec").(*protocol.NullArrayReply); ok {
+					mu.Lock()
+					aborted++
+					mu.Unlock()
+					continue
+				}
+				j++
+			}
+		}()
+	}
+	wg.Wait()
+	c := connection.NewFakeConn()
+	assertReply(t, execCmd(e, c, "get", "counter"), "$3\r\n400\r\n")
+	t.Logf("%d transactions aborted by conflicts", aborted)
+}
+
+func TestMultiRollback(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "a", "old")
+	execCmd(e, c, "set", "bad", "not a number")
+
+	assertReply(t, execCmd(e, c, "multi", "undo"), "-ERR syntax error\r\n")
+	assertReply(t, execCmd(e, c, "multi", "rollback"), "+OK\r\n")
+	execCmd(e, c, "set", "a", "new")
+	execCmd(e, c, "incr", "counter")
+	execCmd(e, c, "del", "a")
+	execCmd(e, c, "incr", "counter")
+	execCmd(e, c, "incr", "bad")
+	execCmd(e, c, "set", "never", "1")
+	assertReply(t, execCmd(e, c, "exec"),
+		"-EXECABORT Transaction rollback because of errors: ERR value is not an integer or out of range\r\n")
+
+	// the half-failed transaction leaves no partial writes
+	assertReply(t, execCmd(e, c, "get", "a"), "$3\r\nold\r\n")
+	assertReply(t, execCmd(e, c, "get", "counter"), "$-1\r\n")
+	assertReply(t, execCmd(e, c, "get", "bad"), "$12\r\nnot a number\r\n")
+	assertReply(t, execCmd(e, c, "get", "never"), "$-1\r\n")
+	if c.IsTransaction() || c.IsTxRollback() {
+		t.Error("transaction state should be reset")
+	}
+
+	// without rollback the commands before the failure are kept, like redis
+	execCmd(e, c, "multi")
+	execCmd(e, c, "set", "a", "new")
+	execCmd(e, c, "incr", "bad")
+	assertReply(t, execCmd(e, c, "exec"), "*2\r\n+OK\r\n-ERR value is not an integer or out of range\r\n")
+	assertReply(t, execCmd(e, c, "get", "a"), "$3\r\nnew\r\n")
+}
+
+func TestRollbackConfig(t *testing.T) {
+	config.GlobalConfig.TxRollback = true
+	defer func() { config.GlobalConfig.TxRollback = false }()
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	execCmd(e, c, "set", "bad", "x")
+	execCmd(e, c, "multi")
+	execCmd(e, c, "set", "a", "1")
+	execCmd(e, c, "incr", "bad")
+	if reply := execCmd(e, c, "exec"); !protocol.IsErrorReply(reply) {
+		t.Fatalf("exec should fail, got %q", reply.ToBytes())
+	}
+	assertReply(t, execCmd(e, c, "get", "a"), "$-1\r\n")
+}
+
+func TestGetUndoLogs(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	logs := e.GetUndoLogs(0, toArgs("set", "k", "v"))
+	if len(logs) != 1 || string(logs[0][0]) != "DEL" {
+		t.Fatalf("unexpected undo logs %q", logs)
+	}
+	execCmd(e, c, "set", "k", "v")
+	logs = e.GetUndoLogs(0, toArgs("set", "k", "v2"))
+	if len(logs) != 2 || string(logs[1][0]) != "SET" || string(logs[1][2]) != "v" {
+		t.Fatalf("unexpected undo logs %q", logs)
+	}
+	if logs := e.GetUndoLogs(0, toArgs("get", "k")); logs != nil {
+		t.Fatalf("read command should have no undo logs, got %q", logs)
+	}
+}
+
+// rollbackCase are write commands run by MULTI ROLLBACK, reads must see the same replies after
+// the transaction is rolled back, and different replies once the commands are executed
+type rollbackCase struct {
+	name  string
+	setup [][]string
+	cmds  [][]string
+	reads [][]string
+}
+
+// testRollback checks that the undo logs of the commands of every case restore what reads see
+func testRollback(t *testing.T, cases ...rollbackCase) {
+	t.Helper()
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine()
+			c := connection.NewFakeConn()
+			for _, cmd := range tt.setup {
+				if reply := execCmd(e, c, cmd...); protocol.IsErrorReply(reply) {
+					t.Fatalf("%q: %q", cmd, reply.ToBytes())
+				}
+			}
+			read := func() []string {
+				replies := make([]string, len(tt.reads))
+				for i, cmd := range tt.reads {
+					replies[i] = string(execCmd(e, c, cmd...).ToBytes())
+				}
+				return replies
+			}
+			before := read()
+
+			execCmd(e, c, "multi", "rollback")
+			for _, cmd := range tt.cmds {
+				assertReply(t, execCmd(e, c, cmd...), "+QUEUED\r\n")
+			}
+			// the increment is checked at runtime, so the transaction fails after every write
+			execCmd(e, c, "incrby", "rollback:failed", "x")
+			if reply := execCmd(e, c, "exec"); !protocol.IsErrorReply(reply) {
+				t.Fatalf("expect rollback, got %q", reply.ToBytes())
+			}
+			after := read()
+			for i := range before {
+				if before[i] != after[i] {
+					t.Errorf("%q: got %q after rollback, want %q", tt.reads[i], after[i], before[i])
+				}
+			}
+
+			// the commands must change what is read, or the rollback above proves nothing
+			execCmd(e, c, "multi")
+			for _, cmd := range tt.cmds {
+				execCmd(e, c, cmd...)
+			}
+			execCmd(e, c, "exec")
+			if strings.Join(read(), "") == strings.Join(before, "") {
+				t.Errorf("the commands change nothing")
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	testRollback(t,
+		rollbackCase{
+			name:  "ttl",
+			setup: [][]string{{"set", "a", "1", "ex", "100"}, {"set", "b", "2", "ex", "200"}},
+			cmds:  [][]string{{"set", "a", "2"}, {"del", "b"}, {"set", "b", "3"}},
+			reads: [][]string{{"mget", "a", "b"}, {"ttl", "a"}, {"ttl", "b"}},
+		},
+		rollbackCase{
+			name:  "flushdb",
+			setup: [][]string{{"set", "a", "1", "ex", "100"}, {"rpush", "l", "x", "y"}},
+			cmds:  [][]string{{"flushdb"}, {"set", "b", "1"}},
+			reads: [][]string{{"mget", "a", "b"}, {"ttl", "a"}, {"lrange", "l", "0", "-1"}},
+		},
+	)
+}
+
+func TestEngineCommandsInMulti(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	sub := connection.NewFakeConn()
+	execCmd(e, sub, "subscribe", "ch")
+	sub.Bytes()
+
+	execCmd(e, c, "multi")
+	assertReply(t, execCmd(e, c, "ping"), "+QUEUED\r\n")
+	assertReply(t, execCmd(e, c, "publish", "ch", "m"), "+QUEUED\r\n")
+	if got := sub.Bytes(); len(got) != 0 {
+		t.Fatalf("message is published before EXEC: %q", got)
+	}
+	assertReply(t, execCmd(e, c, "ping", "hi"), "+QUEUED\r\n")
+	assertReply(t, execCmd(e, c, "exec"), "*3\r\n+PONG\r\n:1\r\n$2\r\nhi\r\n")
+	if got := string(sub.Bytes()); got != "*3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$1\r\nm\r\n" {
+		t.Errorf("unexpected message %q", got)
+	}
+
+	execCmd(e, c, "multi")
+	assertReply(t, execCmd(e, c, "publish", "ch"), "-ERR wrong number of arguments for 'publish' command\r\n")
+	assertReply(t, execCmd(e, c, "exec"), "-EXECABORT Transaction discarded because of previous errors.\r\n")
+
+	execCmd(e, c, "multi")
+	assertReply(t, execCmd(e, c, "subscribe", "a", "b"), "+QUEUED\r\n")
+	assertReply(t, execCmd(e, c, "unsubscribe", "a"), "+QUEUED\r\n")
+	assertReply(t, execCmd(e, c, "exec"), "*2\r\n"+
+		"*2\r\n*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$1\r\nb\r\n:2\r\n"+
+		"*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:1\r\n")
+	if got := c.Bytes(); len(got) != 0 {
+		t.Errorf("confirmations are pushed out of EXEC: %q", got)
+	}
+	execCmd(e, c, "unsubscribe")
+}
+
+func TestFlushAllInMulti(t *testing.T) {
+	e := NewEngine()
+	c := connection.NewFakeConn()
+	other := connection.NewFakeConn()
+	execCmd(e, other, "select", "1")
+	execCmd(e, c, "set", "a", "1")
+	execCmd(e, other, "set", "b", "2")
+	execCmd(e, other, "pexpire", "b", "100000")
+
+	execCmd(e, c, "multi")
+	assertReply(t, execCmd(e, c, "flushall"), "+QUEUED\r\n")
+	if reply := execCmd(e, other, "exists", "b"); protocol.IsErrorReply(reply) || string(reply.ToBytes()) != ":1\r\n" {
+		t.Fatalf("FLUSHALL is executed before EXEC")
+	}
+	assertReply(t, execCmd(e, c, "set", "c", "3"), "+QUEUED\r\n")
+	assertReply(t, execCmd(e, c, "exec"), "*2\r\n+OK\r\n+OK\r\n")
+	assertReply(t, execCmd(e, c, "exists", "a", "c"), ":1\r\n")
+	assertReply(t, execCmd(e, other, "exists", "b"), ":0\r\n")
+
+	// the keys of every db are restored by rollback
+	execCmd(e, other, "set", "b", "2")
+	execCmd(e, other, "pexpire", "b", "100000")
+	execCmd(e, c, "multi", "rollback")
+	execCmd(e, c, "flushall")
+	execCmd(e, c, "incr", "counter")
+	execCmd(e, c, "hincrby", "h", "x", "y")
+	if reply := execCmd(e, c, "exec"); !protocol.IsErrorReply(reply) {
+		t.Fatalf("expect rollback, got %q", reply.ToBytes())
+	}
+	assertReply(t, execCmd(e, c, "get", "c"), "$1\r\n3\r\n")
+	assertReply(t, execCmd(e, c, "exists", "counter"), ":0\r\n")
+	assertReply(t, execCmd(e, other, "get", "b"), "$1\r\n2\r\n")
+}
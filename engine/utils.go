@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"strconv"
+
+	"github.com/issimo1/redis-issimo/datastruct/hash"
+	"github.com/issimo1/redis-issimo/datastruct/json"
+	"github.com/issimo1/redis-issimo/datastruct/list"
+	"github.com/issimo1/redis-issimo/datastruct/set"
+	"github.com/issimo1/redis-issimo/datastruct/sortedset"
+	"github.com/issimo1/redis-issimo/datastruct/stream"
+	"github.com/issimo1/redis-issimo/engine/payload"
+)
+
+func toCmdLine(cmd string, args ...string) [][]byte {
+	result := make([][]byte, len(args)+1)
+	result[0] = []byte(cmd)
+	for i, s := range args {
+		result[i+1] = []byte(s)
+	}
+	return result
+}
+
+func toCmdLine2(cmd string, args ...[]byte) [][]byte {
+	result := make([][]byte, len(args)+1)
+	result[0] = []byte(cmd)
+	copy(result[1:], args)
+	return result
+}
+
+// toCmdLine3 prepends the command name to args
+func toCmdLine3(cmd string, args [][]byte) [][]byte {
+	return toCmdLine2(cmd, args...)
+}
+
+/* ---- KeysFunc ---- */
+
+func noPrepare(args [][]byte) ([]string, []string) {
+	return nil, nil
+}
+
+func readFirstKey(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, nil
+}
+
+func writeFirstKey(args [][]byte) ([]string, []string) {
+	return nil, []string{string(args[0])}
+}
+
+func readAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	return keys, nil
+}
+
+func writeAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	return nil, keys
+}
+
+/* ---- UndoFunc ---- */
+
+// rollbackFirstKey restores the first key of args
+func rollbackFirstKey(db *DB, args [][]byte) [][][]byte {
+	return rollbackGivenKeys(db, string(args[0]))
+}
+
+// rollbackAllKeys restores every key of args
+func rollbackAllKeys(db *DB, args [][]byte) [][][]byte {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	return rollbackGivenKeys(db, keys...)
+}
+
+// rollbackGivenKeys returns the commands which rebuild keys from their current state
+func rollbackGivenKeys(db *DB, keys ...string) [][][]byte {
+	var undoCmdLines [][][]byte
+	for _, key := range keys {
+		entity, ok := db.GetEntity(key)
+		undoCmdLines = append(undoCmdLines, toCmdLine("DEL", key))
+		if !ok {
+			continue
+		}
+		undoCmdLines = append(undoCmdLines, entityToCmds(key, entity)...)
+		if expireTime, hasTTL := db.GetExpireTime(key); hasTTL {
+			undoCmdLines = append(undoCmdLines, makeExpireCmd(key, expireTime))
+		}
+	}
+	return undoCmdLines
+}
+
+// entityToCmds returns the commands which create key holding entity
+func entityToCmds(key string, entity *payload.DataEntity) [][][]byte {
+	switch val := entity.RedisObject.(type) {
+	case []byte:
+		return [][][]byte{toCmdLine2("SET", []byte(key), val)}
+	case int64:
+		return [][][]byte{toCmdLine("SET", key, strconv.FormatInt(val, 10))}
+	case list.List:
+		cmdLine := make([][]byte, 0, val.Len()+2)
+		cmdLine = append(cmdLine, []byte("RPUSH"), []byte(key))
+		val.ForEach(func(i int, element interface{}) bool {
+			cmdLine = append(cmdLine, element.([]byte))
+			return true
+		})
+		return [][][]byte{cmdLine}
+	case hash.Hash:
+		cmdLine := make([][]byte, 0, 2*val.Len()+2)
+		cmdLine = append(cmdLine, []byte("HSET"), []byte(key))
+		var expireCmds [][][]byte
+		val.ForEach(func(field string, v []byte) bool {
+			cmdLine = append(cmdLine, []byte(field), v)
+			if expireTime, ok := val.GetExpireTime(field); ok {
+				expireCmds = append(expireCmds, makeFieldExpireCmd(key, expireTime, []byte(field)))
+			}
+			return true
+		})
+		return append([][][]byte{cmdLine}, expireCmds...)
+	case set.Set:
+		cmdLine := make([][]byte, 0, val.Len()+2)
+		cmdLine = append(cmdLine, []byte("SADD"), []byte(key))
+		val.ForEach(func(member string) bool {
+			cmdLine = append(cmdLine, []byte(member))
+			return true
+		})
+		return [][][]byte{cmdLine}
+	case *sortedset.SortedSet:
+		cmdLine := make([][]byte, 0, 2*val.Len()+2)
+		cmdLine = append(cmdLine, []byte("ZADD"), []byte(key))
+		val.ForEachByRank(0, val.Len(), false, func(element *sortedset.Element) bool {
+			cmdLine = append(cmdLine, formatScore(element.Score), []byte(element.Member))
+			return true
+		})
+		return [][][]byte{cmdLine}
+	case *stream.Stream:
+		return streamToCmds(key, val)
+	case *json.Document:
+		return [][][]byte{toCmdLine2("JSON.SET", []byte(key), []byte("$"), json.Marshal(val.Root))}
+	default:
+		return nil
+	}
+}
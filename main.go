@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 
+	"github.com/issimo1/redis-issimo/redis"
 	"github.com/issimo1/redis-issimo/tcpserver"
 	"github.com/issimo1/redis-issimo/utils/config"
 	"github.com/issimo1/redis-issimo/utils/logger"
@@ -15,7 +16,7 @@ func main() {
 	logger.Init()
 	logger.Info("Starting issimo1 redis server...")
 	tcp := tcpserver.NewTCPServer(tcpserver.TCPConfig{
-		Addr: fmt.Sprintf("%s:%d", config.GlobalConfig.Bind, config.GlobalConfig.Port)}, "redis")
+		Addr: fmt.Sprintf("%s:%d", config.GlobalConfig.Bind, config.GlobalConfig.Port)}, redis.NewDefaultHandler())
 	if err := tcp.Start(); err != nil {
 		log.Printf("%+v", err)
 		os.Exit(1)
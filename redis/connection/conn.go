@@ -1,12 +1,24 @@
 package connection
 
 import (
+	"bytes"
+	"github.com/issimo1/redis-issimo/redis/protocol"
 	"github.com/issimo1/redis-issimo/utils/logger"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+const (
+	closeWaitTimeout = 10 * time.Second
+	// flushThreshold is the size of buffered replies which forces a flush, the same as PROTO_REPLY_CHUNK_BYTES
+	flushThreshold = 16 * 1024
+)
+
+// connID generates the client id reported by HELLO
+var connID atomic.Int64
+
 var connPoll = sync.Pool{
 	New: func() interface{} {
 		return &KeepConnection{
@@ -21,15 +33,29 @@ var connPoll = sync.Pool{
 
 type KeepConnection struct {
 	C                  net.Conn
+	id                 int64
 	password           string
 	dbIdx              int
+	protover           int
+	name               string
 	WriteDateWaitGroup sync.WaitGroup
+	// closeMu orders WriteDateWaitGroup.Add of StartWrite before the Wait of Close
+	closeMu sync.Mutex
+
+	// wmu guards writes to C and the replies buffered by WriteBuffered
+	wmu     sync.Mutex
+	pending bytes.Buffer
 
 	mu     sync.Mutex
 	subs   map[string]struct{}
 	closed atomic.Bool
 
+	// done is closed once the client is gone, so that a blocked command can give up
+	done         chan struct{}
+	disconnected atomic.Bool
+
 	tx        atomic.Bool
+	rollback  bool
 	queue     [][][]byte
 	watchKey  map[string]int64
 	txErrList []error
@@ -41,18 +67,28 @@ func NewKeepConnection(c net.Conn) *KeepConnection {
 		logger.Error("connection pool make wrong type")
 		return &KeepConnection{
 			dbIdx:    0,
-			C:        nil,
+			C:        c,
+			id:       connID.Add(1),
+			protover: protocol.RESP2,
 			password: "",
 			closed:   atomic.Bool{},
 			tx:       atomic.Bool{},
+			done:     make(chan struct{}),
 		}
 	}
 	conn.C = c
+	conn.id = connID.Add(1)
+	conn.protover = protocol.RESP2
+	conn.name = ""
 	conn.closed.Store(false)
 	conn.tx.Store(false)
+	conn.rollback = false
 	conn.queue = nil
 	conn.txErrList = nil
 	conn.watchKey = nil
+	conn.pending.Reset()
+	conn.done = make(chan struct{})
+	conn.disconnected.Store(false)
 	return conn
 }
 
@@ -60,19 +96,113 @@ func (k *KeepConnection) GetDBPwd() string {
 	return k.password
 }
 
+// StartWrite tracks a command whose reply is going to be written, Close waits for it until FinishWrite.
+// It returns false if the connection is closed, then the command must not be executed.
+func (k *KeepConnection) StartWrite() bool {
+	k.closeMu.Lock()
+	defer k.closeMu.Unlock()
+	if k.closed.Load() {
+		return false
+	}
+	k.WriteDateWaitGroup.Add(1)
+	return true
+}
+
+// FinishWrite marks the reply of a command tracked by StartWrite written
+func (k *KeepConnection) FinishWrite() {
+	k.WriteDateWaitGroup.Done()
+}
+
+// Write sends data at once, replies buffered before are sent first to keep the order.
+// The writes are serialized by wmu, so Close sends what is buffered after the last of them.
 func (k *KeepConnection) Write(bytes []byte) (int, error) {
 	if len(bytes) == 0 {
 		return 0, nil
 	}
-	k.WriteDateWaitGroup.Add(1)
-	defer k.WriteDateWaitGroup.Done()
+	k.wmu.Lock()
+	defer k.wmu.Unlock()
+	if k.pending.Len() > 0 {
+		k.pending.Write(bytes)
+		return len(bytes), k.flushLocked()
+	}
 	return k.C.Write(bytes)
 }
 
+// WriteBuffered appends data to the reply buffer, which is sent by Flush or once it reaches flushThreshold.
+// It is used for pipelined commands to batch their replies into fewer syscalls.
+func (k *KeepConnection) WriteBuffered(bytes []byte) error {
+	if len(bytes) == 0 {
+		return nil
+	}
+	k.wmu.Lock()
+	defer k.wmu.Unlock()
+	k.pending.Write(bytes)
+	if k.pending.Len() >= flushThreshold {
+		return k.flushLocked()
+	}
+	return nil
+}
+
+// Flush sends the buffered replies
+func (k *KeepConnection) Flush() error {
+	k.wmu.Lock()
+	defer k.wmu.Unlock()
+	return k.flushLocked()
+}
+
+func (k *KeepConnection) flushLocked() error {
+	if k.pending.Len() == 0 {
+		return nil
+	}
+	_, err := k.C.Write(k.pending.Bytes())
+	k.pending.Reset()
+	return err
+}
+
+// Close waits for the commands tracked by StartWrite and then closes the underlying connection
+func (k *KeepConnection) Close() error {
+	k.closeMu.Lock()
+	if !k.closed.CompareAndSwap(false, true) {
+		k.closeMu.Unlock()
+		return nil
+	}
+	k.closeMu.Unlock()
+	k.MarkDisconnected()
+	done := make(chan struct{})
+	go func() {
+		k.WriteDateWaitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(closeWaitTimeout):
+		logger.Warnf("wait pending writes of %s timeout", k.C.RemoteAddr().String())
+	}
+	k.wmu.Lock()
+	defer k.wmu.Unlock()
+	if err := k.flushLocked(); err != nil {
+		logger.Warn("flush replies before close err: " + err.Error())
+	}
+	return k.C.Close()
+}
+
 func (k *KeepConnection) IsClosed() bool {
 	return k.closed.Load()
 }
 
+// MarkDisconnected closes the channel returned by Done. It is called as soon as reading from the client fails,
+// which may happen while the handler is still waiting for a blocked command of the client.
+func (k *KeepConnection) MarkDisconnected() {
+	if k.disconnected.CompareAndSwap(false, true) {
+		close(k.done)
+	}
+}
+
+// Done returns a channel which is closed once the client is disconnected
+func (k *KeepConnection) Done() <-chan struct{} {
+	return k.done
+}
+
 func (k *KeepConnection) Subscribe(channel string) {
 	k.mu.Lock()
 	defer k.mu.Unlock()
@@ -116,10 +246,20 @@ func (k *KeepConnection) SetTransaction(b bool) {
 		k.queue = nil
 		k.watchKey = nil
 		k.txErrList = nil
+		k.rollback = false
 	}
 	k.tx.Store(b)
 }
 
+// IsTxRollback returns true if the queued commands must be undone when any of them fails
+func (k *KeepConnection) IsTxRollback() bool {
+	return k.rollback
+}
+
+func (k *KeepConnection) SetTxRollback(b bool) {
+	k.rollback = b
+}
+
 func (k *KeepConnection) EnqueueCmd(i [][]byte) {
 	k.queue = append(k.queue, i)
 }
@@ -140,13 +280,11 @@ func (k *KeepConnection) CleanWatchKey() {
 }
 
 func (k *KeepConnection) AddTxErr(err error) {
-	//TODO implement me
-	panic("implement me")
+	k.txErrList = append(k.txErrList, err)
 }
 
-func (k *KeepConnection) GetTxErr(err error) {
-	//TODO implement me
-	panic("implement me")
+func (k *KeepConnection) GetTxErr() []error {
+	return k.txErrList
 }
 
 func (k *KeepConnection) GetDBIdx() int {
@@ -160,3 +298,24 @@ func (k *KeepConnection) SetDBIdx(idx int) {
 func (k *KeepConnection) SetDBPwd(pwd string) {
 	k.password = pwd
 }
+
+func (k *KeepConnection) GetID() int64 {
+	return k.id
+}
+
+// GetProtocol returns the RESP version negotiated by HELLO
+func (k *KeepConnection) GetProtocol() int {
+	return k.protover
+}
+
+func (k *KeepConnection) SetProtocol(protover int) {
+	k.protover = protover
+}
+
+func (k *KeepConnection) GetName() string {
+	return k.name
+}
+
+func (k *KeepConnection) SetName(name string) {
+	k.name = name
+}
@@ -0,0 +1,44 @@
+package connection
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+// FakeConn is a connection without network which records the written data, it is used in tests
+type FakeConn struct {
+	KeepConnection
+	wmu sync.Mutex
+	buf bytes.Buffer
+}
+
+func NewFakeConn() *FakeConn {
+	c := &FakeConn{}
+	c.id = connID.Add(1)
+	c.protover = protocol.RESP2
+	c.done = make(chan struct{})
+	return c
+}
+
+func (c *FakeConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.buf.Write(b)
+}
+
+// Bytes returns and clears the data written so far
+func (c *FakeConn) Bytes() []byte {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	b := append([]byte(nil), c.buf.Bytes()...)
+	c.buf.Reset()
+	return b
+}
+
+func (c *FakeConn) Close() error {
+	c.closed.Store(true)
+	c.MarkDisconnected()
+	return nil
+}
@@ -3,57 +3,143 @@ package redis
 import (
 	"context"
 	"errors"
-	"github.com/issimo1/redis-issimo/abs"
-	"github.com/issimo1/redis-issimo/redis/connection"
-	"github.com/issimo1/redis-issimo/redis/parser"
 	"io"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 
+	"github.com/issimo1/redis-issimo/abs"
+	"github.com/issimo1/redis-issimo/engine"
+	"github.com/issimo1/redis-issimo/redis/connection"
+	"github.com/issimo1/redis-issimo/redis/parser"
+	"github.com/issimo1/redis-issimo/redis/protocol"
 	"github.com/issimo1/redis-issimo/utils/config"
 	"github.com/issimo1/redis-issimo/utils/logger"
 )
 
 type Handler struct {
-	activeConn *sync.Map
+	activeConn sync.Map
 	engine     abs.Engine
+	closing    atomic.Bool
+	// bufferReplies batches the replies of pipelined commands
+	bufferReplies bool
 }
 
 func NewDefaultHandler() *Handler {
-	var e abs.Engine
 	if len(config.GlobalConfig.Cluster) > 0 {
-		logger.Info("cluster mode")
-
+		logger.Warn("cluster mode is not supported yet, fallback to single mode")
 	} else {
 		logger.Info("single mode")
 	}
 
 	return &Handler{
-		engine: e,
+		engine:        engine.NewEngine(),
+		bufferReplies: true,
 	}
 }
 
-func (h *Handler) Handle(c context.Context, conn net.Conn) {
+func (h *Handler) closeClient(keepConn *connection.KeepConnection) {
+	_ = keepConn.Close()
+	h.engine.AfterClientClose(keepConn)
+	h.activeConn.Delete(keepConn)
+}
+
+func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
+	if h.closing.Load() {
+		_ = conn.Close()
+		return
+	}
 	keepConn := connection.NewKeepConnection(conn)
-	h.activeConn.Store(conn, struct{}{})
-	outChan := parser.ParseStream(conn)
-	for payload := range outChan {
-		if payload.Err != nil {
-			if payload.Err == io.EOF || errors.Is(payload.Err, io.ErrUnexpectedEOF) ||
-				strings.Contains(payload.Err.Error(), "use of closed network connection") {
-				h.activeConn.Delete(conn)
-				logger.Warn("client closed:" + keepConn.C.RemoteAddr().String())
-				keepConn.C.Close()
+	h.activeConn.Store(keepConn, struct{}{})
+	outChan := parser.ParseStream(&disconnectNotifier{Reader: conn, conn: keepConn})
+	for {
+		select {
+		case <-ctx.Done():
+			h.closeClient(keepConn)
+			return
+		case payload, ok := <-outChan:
+			if !ok || h.closing.Load() {
+				h.closeClient(keepConn)
 				return
 			}
+			if payload.Err != nil {
+				if parser.IsProtocolError(payload.Err) {
+					// the stream can not be recovered after a protocol error
+					errReply := protocol.MakeProtocolErrReply(payload.Err.Error())
+					_, _ = keepConn.Write(errReply.ToBytes())
+				} else if payload.Err == io.EOF || errors.Is(payload.Err, io.ErrUnexpectedEOF) ||
+					strings.Contains(payload.Err.Error(), "use of closed network connection") {
+					logger.Warn("client closed:" + conn.RemoteAddr().String())
+				} else {
+					logger.Warn("read from client err: " + payload.Err.Error())
+				}
+				h.closeClient(keepConn)
+				return
+			}
+			if payload.Data == nil {
+				continue
+			}
+			r, ok := payload.Data.(*protocol.MultiBulkReply)
+			if !ok {
+				logger.Error("require multi bulk protocol")
+				continue
+			}
+			logger.Debugf("%q", r.Args)
+			// in-flight commands are tracked so that Close waits for their replies
+			if !keepConn.StartWrite() {
+				h.closeClient(keepConn)
+				return
+			}
+			result := h.engine.Exec(keepConn, r.Args)
+			h.reply(keepConn, result, len(outChan) > 0)
+			keepConn.FinishWrite()
 		}
-		logger.Debug("%q")
-		result := h.engine.Exec(keepConn, nil)
-		if result != nil {
-			keepConn.Write(result.ToBytes())
-		} else {
-			keepConn.Write(nil)
-		}
 	}
 }
+
+// disconnectNotifier marks the connection disconnected once reading fails. The handler does not read
+// the parsed commands while a command such as BLPOP is blocked, so the error can not wait for the handler.
+type disconnectNotifier struct {
+	io.Reader
+	conn *connection.KeepConnection
+}
+
+func (r *disconnectNotifier) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil {
+		r.conn.MarkDisconnected()
+	}
+	return n, err
+}
+
+// reply buffers the result while more commands of a pipeline are waiting
+func (h *Handler) reply(keepConn *connection.KeepConnection, result protocol.Reply, pipelining bool) {
+	var data []byte
+	if result != nil {
+		data = protocol.Encode(result, keepConn.GetProtocol())
+	}
+	var err error
+	if !h.bufferReplies {
+		_, err = keepConn.Write(data)
+	} else if err = keepConn.WriteBuffered(data); err == nil && !pipelining {
+		err = keepConn.Flush()
+	}
+	if err != nil {
+		logger.Warn("write reply err: " + err.Error())
+	}
+}
+
+// Close stops serving new commands, closes every active connection once
+// its pending writes are done and then shuts the engine down
+func (h *Handler) Close() error {
+	logger.Info("handler shutting down...")
+	h.closing.Store(true)
+	h.activeConn.Range(func(key, value any) bool {
+		keepConn := key.(*connection.KeepConnection)
+		_ = keepConn.Close()
+		return true
+	})
+	h.engine.Close()
+	return nil
+}
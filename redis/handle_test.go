@@ -0,0 +1,200 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/issimo1/redis-issimo/engine"
+	"github.com/issimo1/redis-issimo/utils/config"
+	"github.com/issimo1/redis-issimo/utils/logger"
+)
+
+func TestMain(m *testing.M) {
+	config.GlobalConfig = config.NewDefaultConfig()
+	logger.SetLoggerLevel(logger.WARN)
+	testEngine = engine.NewEngine()
+	os.Exit(m.Run())
+}
+
+var testEngine *engine.Engine
+
+// startServer serves h on a random local port until the test ends
+func startServer(tb testing.TB, h *Handler) string {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go h.Handle(ctx, conn)
+		}
+	}()
+	tb.Cleanup(func() {
+		cancel()
+		_ = listener.Close()
+	})
+	return listener.Addr().String()
+}
+
+func pipeline(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		buf.WriteString("*1\r\n$4\r\nPING\r\n")
+	}
+	return buf.Bytes()
+}
+
+func TestPipelineReplies(t *testing.T) {
+	for _, buffered := range []bool{false, true} {
+		h := &Handler{engine: testEngine, bufferReplies: buffered}
+		conn, err := net.Dial("tcp", startServer(t, h))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// more replies than flushThreshold so that both the threshold and the final flush are used
+		n := 5000
+		if _, err := conn.Write(pipeline(n)); err != nil {
+			t.Fatal(err)
+		}
+		want := bytes.Repeat([]byte("+PONG\r\n"), n)
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(bufio.NewReader(conn), got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("buffered %v: unexpected replies", buffered)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestHandlerClose(t *testing.T) {
+	h := &Handler{engine: engine.NewEngine(), bufferReplies: true}
+	conn, err := net.Dial("tcp", startServer(t, h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "+PONG\r\n" {
+		t.Fatalf("got %q %v", line, err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("connection should be closed by server, got %v", err)
+	}
+}
+
+func TestBlockedClientDisconnect(t *testing.T) {
+	h := &Handler{engine: engine.NewEngine(), bufferReplies: true}
+	addr := startServer(t, h)
+	blocked, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blocked.Write([]byte("BLPOP queue 0\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	_ = blocked.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("RPUSH queue x\r\nLLEN queue\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	// the element must not be given to the gone client
+	want := ":1\r\n:1\r\n"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != want {
+		t.Fatalf("got %q %v", got, err)
+	}
+}
+
+func TestPipelineBeforeBlocking(t *testing.T) {
+	h := &Handler{engine: engine.NewEngine(), bufferReplies: true}
+	addr := startServer(t, h)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("SET a 1\r\nBLPOP q 0\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	// the reply of SET is buffered while BLPOP is pending, it must be sent once the client blocks
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "+OK\r\n" {
+		t.Fatalf("got %q %v", line, err)
+	}
+
+	pusher, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pusher.Close()
+	if _, err := pusher.Write([]byte("RPUSH q x\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	want := "*2\r\n$1\r\nq\r\n$1\r\nx\r\n"
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(reader, got); err != nil || string(got) != want {
+		t.Fatalf("got %q %v", got, err)
+	}
+}
+
+func benchmarkPipeline(b *testing.B, buffered bool) {
+	h := &Handler{engine: testEngine, bufferReplies: buffered}
+	conn, err := net.Dial("tcp", startServer(b, h))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	const depth = 1000
+	request := pipeline(depth)
+	reply := make([]byte, depth*len("+PONG\r\n"))
+	reader := bufio.NewReader(conn)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(request); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(reader, reply); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N*depth)/b.Elapsed().Seconds(), "cmds/s")
+}
+
+// BenchmarkPipelineWriteThrough writes every reply with its own syscall
+func BenchmarkPipelineWriteThrough(b *testing.B) {
+	benchmarkPipeline(b, false)
+}
+
+// BenchmarkPipelineBuffered batches the replies while the pipeline is not drained
+func BenchmarkPipelineBuffered(b *testing.B) {
+	benchmarkPipeline(b, true)
+}
@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"errors"
+
+	"github.com/issimo1/redis-issimo/redis/protocol"
+)
+
+// maxInlineSize is the same as PROTO_INLINE_MAX_SIZE of redis, it also limits every RESP header line
+const maxInlineSize = 64 * 1024
+
+var errUnbalancedQuotes = errors.New("unbalanced quotes in request")
+
+// parseInline parses a telnet style command, e.g. `SET key "hello world"`
+func parseInline(line []byte) (protocol.Reply, error) {
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	args, err := splitArgs(line)
+	if err != nil {
+		return nil, protocolError(err.Error())
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+	return protocol.MakeMultiBulkReply(args), nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\v' || c == '\f'
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexDigitToInt(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// splitArgs splits a line into arguments like sdssplitargs of redis.
+// Double quoted strings support \xHH, \n, \r, \t, \b, \a and escaped chars,
+// single quoted strings only support \'. A closing quote must be followed by a space.
+func splitArgs(line []byte) ([][]byte, error) {
+	args := make([][]byte, 0)
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+		inDoubleQuotes, inSingleQuotes, done := false, false, false
+		current := make([]byte, 0)
+		for !done {
+			if inDoubleQuotes {
+				if i >= len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				c := line[i]
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					current = append(current, hexDigitToInt(line[i+2])*16+hexDigitToInt(line[i+3]))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						current = append(current, '\n')
+					case 'r':
+						current = append(current, '\r')
+					case 't':
+						current = append(current, '\t')
+					case 'b':
+						current = append(current, '\b')
+					case 'a':
+						current = append(current, '\a')
+					default:
+						current = append(current, line[i])
+					}
+				} else if c == '"' {
+					// closing quote must be followed by a space or nothing at all
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					current = append(current, c)
+				}
+			} else if inSingleQuotes {
+				if i >= len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				c := line[i]
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					current = append(current, '\'')
+				} else if c == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					current = append(current, c)
+				}
+			} else {
+				if i >= len(line) {
+					break
+				}
+				switch c := line[i]; {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inDoubleQuotes = true
+				case c == '\'':
+					inSingleQuotes = true
+				default:
+					current = append(current, c)
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, current)
+	}
+}
@@ -3,96 +3,288 @@ package parser
 import (
 	"bufio"
 	"bytes"
-	"fmt"
+	"errors"
+	"github.com/issimo1/redis-issimo/redis/protocol"
 	"github.com/issimo1/redis-issimo/utils/logger"
 	"io"
 	"runtime/debug"
 	"strconv"
 )
 
+const (
+	// maxBulkLen is the same as proto-max-bulk-len of redis
+	maxBulkLen = 512 * 1024 * 1024
+	// maxArrayLen limits the element count of a single array
+	maxArrayLen = 1024 * 1024
+	// maxNestDepth limits the depth of nested arrays
+	maxNestDepth = 32
+	// payloadBufferSize is the number of parsed commands that can wait for execution,
+	// the consumer knows a pipeline is in progress if the channel is not empty
+	payloadBufferSize = 1024
+)
+
+// Payload is a parsed request, Data is a *protocol.MultiBulkReply for a normal command
 type Payload struct {
-	Err error
-	//Reply
+	Data protocol.Reply
+	Err  error
+}
+
+// ProtocolError means the stream does not follow RESP, the connection should be closed
+type ProtocolError struct {
+	Msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Msg
+}
+
+func protocolError(msg string) error {
+	return &ProtocolError{Msg: msg}
+}
+
+// IsProtocolError returns true if err is caused by a malformed request
+func IsProtocolError(err error) bool {
+	var pe *ProtocolError
+	return errors.As(err, &pe)
 }
 
 func ParseStream(reader io.Reader) <-chan *Payload {
-	dataStream := make(chan *Payload)
+	dataStream := make(chan *Payload, payloadBufferSize)
 	// 启动协程
 	go parse(reader, dataStream)
 	return dataStream
 }
 
+// ParseBytes parses all replies in data
+func ParseBytes(data []byte) ([]protocol.Reply, error) {
+	reader := bufio.NewReader(bytes.NewReader(data))
+	var result []protocol.Reply
+	for {
+		reply, err := readReply(reader)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		if reply != nil {
+			result = append(result, reply)
+		}
+	}
+}
+
+// ParseOne parses the first reply in data
+func ParseOne(data []byte) (protocol.Reply, error) {
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		reply, err := readReply(reader)
+		if err != nil {
+			return nil, err
+		}
+		if reply != nil {
+			return reply, nil
+		}
+	}
+}
+
 func parse(r io.Reader, ds chan<- *Payload) {
 	defer func() {
 		if err := recover(); err != nil {
 			logger.Error(err, string(debug.Stack()))
 		}
 	}()
+	defer close(ds)
 	reader := bufio.NewReader(r)
 	for {
-
-		line, err := reader.ReadBytes('\n')
+		reply, err := readReply(reader)
 		if err != nil {
 			ds <- &Payload{Err: err}
-			close(ds)
 			return
 		}
+		if reply == nil {
+			continue
+		}
+		ds <- &Payload{Data: reply}
+	}
+}
 
-		length := len(line)
-		if length <= 2 || line[length-2] != '\r' {
+// readRawLine reads a line terminated by LF and drops the LF, the line can not exceed maxInlineSize
+func readRawLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > maxInlineSize {
+			return nil, protocolError("too big inline request")
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			return line[:len(line)-1], nil
+		}
+		if err == bufio.ErrBufferFull {
 			continue
 		}
-		line = bytes.TrimSuffix(line, []byte{'\r', '\n'})
-		switch line[0] {
-		case '*':
-			err := parseArrays(line, reader, ds)
-			if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+}
 
-			}
-		case '-':
-		case '+':
-		case ':':
-		case '$':
+// readLine reads a line terminated by CRLF and returns it without the terminator
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := readRawLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	length := len(line)
+	if length == 0 || line[length-1] != '\r' {
+		return nil, protocolError("line should end with CRLF")
+	}
+	return line[:length-1], nil
+}
+
+// readReply reads a whole reply, a nil reply with nil error means an empty line was skipped.
+// A line not starting with a RESP type byte is parsed as an inline command.
+func readReply(reader *bufio.Reader) (protocol.Reply, error) {
+	line, err := readRawLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	switch line[0] {
+	case '*', '$', '+', '-', ':':
+		if line[len(line)-1] != '\r' {
+			return nil, protocolError("line should end with CRLF")
+		}
+		return parseElement(line[:len(line)-1], reader, 0)
+	}
+	return parseInline(line)
+}
+
+func parseLength(header []byte, max int64) (int64, error) {
+	length, err := strconv.ParseInt(string(header[1:]), 10, 64)
+	if err != nil || length < -1 {
+		return 0, protocolError("illegal length " + strconv.Quote(string(header)))
+	}
+	if length > max {
+		return 0, protocolError("length is too large " + strconv.Quote(string(header)))
+	}
+	return length, nil
+}
+
+func parseBulkString(header []byte, reader *bufio.Reader) (protocol.Reply, error) {
+	body, err := readBulkBody(header, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return protocol.MakeNullBulkReply(), nil
+	}
+	return protocol.MakeBulkReply(body), nil
+}
 
+// readBulkBody reads the content following a $ header, returns nil for $-1
+func readBulkBody(header []byte, reader *bufio.Reader) ([]byte, error) {
+	dataLen, err := parseLength(header, maxBulkLen)
+	if err != nil {
+		return nil, err
+	}
+	if dataLen == -1 {
+		return nil, nil
+	}
+	body := make([]byte, dataLen+2)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
 		}
+		return nil, err
 	}
+	if body[dataLen] != '\r' || body[dataLen+1] != '\n' {
+		return nil, protocolError("bulk string should end with CRLF")
+	}
+	return body[:dataLen], nil
 }
 
-func parseArrays(header []byte, reader *bufio.Reader, out chan<- *Payload) error {
-	bodyCnt, err := strconv.ParseInt(string(header[1]), 10, 64)
-	if err != nil || bodyCnt < 0 {
-		out <- &Payload{Err: fmt.Errorf("illegal array header: %s", string(header[1]))}
-		return nil
+// parseArrays returns a MultiBulkReply if every element is a bulk string, otherwise an ArrayReply
+func parseArrays(header []byte, reader *bufio.Reader, depth int) (protocol.Reply, error) {
+	if depth >= maxNestDepth {
+		return nil, protocolError("too many nested arrays")
+	}
+	bodyCnt, err := parseLength(header, maxArrayLen)
+	if err != nil {
+		return nil, err
+	}
+	if bodyCnt == -1 {
+		return protocol.MakeNullArrayReply(), nil
+	}
+	if bodyCnt == 0 {
+		return protocol.MakeEmptyMultiBulkReply(), nil
 	}
 	lines := make([][]byte, 0, bodyCnt)
+	var replies []protocol.Reply
 	for i := int64(0); i < bodyCnt; i++ {
-		line, err := reader.ReadBytes('\n')
+		line, err := readLine(reader)
 		if err != nil {
-			return err
-		}
-		//*5/r/n
-		length := len(line)
-		if length < 4 || line[length-2] != '\r' || line[0] != '$' {
-			out <- &Payload{Err: fmt.Errorf("illegal string syntax: %s", string(line))}
-			return nil
-		}
-
-		// 字符串长度为length[1]
-		dataLen, err := strconv.ParseInt(string(line[1]), 10, 64)
-		if err != nil || dataLen < -1 {
-			out <- &Payload{Err: fmt.Errorf("illegal string length: %s", string(line[1]))}
-			return nil
-		} else if dataLen == -1 {
-			lines = append(lines, nil)
-		} else {
-			body := make([]byte, 0, dataLen+2)
-			_, err := io.ReadFull(reader, body)
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if len(line) > 0 && line[0] == '$' && replies == nil {
+			body, err := readBulkBody(line, reader)
 			if err != nil {
-				return err
+				return nil, err
+			}
+			lines = append(lines, body)
+			continue
+		}
+		if replies == nil {
+			// switch to a generic array, keep the bulk strings read so far
+			replies = make([]protocol.Reply, 0, bodyCnt)
+			for _, l := range lines {
+				replies = append(replies, bulkOrNull(l))
 			}
-			lines = append(lines, body[:len(body)-2])
 		}
+		element, err := parseElement(line, reader, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, element)
+	}
+	if replies != nil {
+		return protocol.MakeArrayReply(replies), nil
+	}
+	return protocol.MakeMultiBulkReply(lines), nil
+}
+
+func parseElement(line []byte, reader *bufio.Reader, depth int) (protocol.Reply, error) {
+	if len(line) == 0 {
+		return nil, protocolError("unexpected empty line")
+	}
+	switch line[0] {
+	case '*':
+		return parseArrays(line, reader, depth)
+	case '$':
+		return parseBulkString(line, reader)
+	case '+':
+		return protocol.MakeStatusReply(string(line[1:])), nil
+	case '-':
+		return protocol.MakeErrReply(string(line[1:])), nil
+	case ':':
+		value, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, protocolError("illegal number " + strconv.Quote(string(line[1:])))
+		}
+		return protocol.MakeIntReply(value), nil
+	}
+	return nil, protocolError("unknown reply type " + strconv.Quote(string(line[:1])))
+}
+
+func bulkOrNull(body []byte) protocol.Reply {
+	if body == nil {
+		return protocol.MakeNullBulkReply()
 	}
-	out <- &Payload{Err: nil}
-	return nil
+	return protocol.MakeBulkReply(body)
 }
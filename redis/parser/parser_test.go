@@ -1,44 +1,264 @@
 package parser
 
 import (
-	"bufio"
 	"bytes"
-	"fmt"
 	"io"
 	"strings"
 	"testing"
+
+	"github.com/issimo1/redis-issimo/redis/protocol"
 )
 
-func TestParse(t *testing.T) {
+func TestParseBytes(t *testing.T) {
+	longValue := strings.Repeat("v", 1024)
+	manyArgs := make([][]byte, 12)
+	for i := range manyArgs {
+		manyArgs[i] = []byte{byte('a' + i)}
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  []protocol.Reply
+	}{
+		{
+			name:  "set command",
+			input: "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n",
+			want:  []protocol.Reply{protocol.MakeMultiBulkReply([][]byte{[]byte("SET"), []byte("key"), []byte("value")})},
+		},
+		{
+			name:  "more than 9 args",
+			input: string(protocol.MakeMultiBulkReply(manyArgs).ToBytes()),
+			want:  []protocol.Reply{protocol.MakeMultiBulkReply(manyArgs)},
+		},
+		{
+			name:  "long bulk string",
+			input: "*2\r\n$3\r\nGET\r\n$1024\r\n" + longValue + "\r\n",
+			want:  []protocol.Reply{protocol.MakeMultiBulkReply([][]byte{[]byte("GET"), []byte(longValue)})},
+		},
+		{
+			name:  "binary safe bulk",
+			input: "*2\r\n$3\r\nGET\r\n$6\r\na\r\n\x00b\n\r\n",
+			want:  []protocol.Reply{protocol.MakeMultiBulkReply([][]byte{[]byte("GET"), []byte("a\r\n\x00b\n")})},
+		},
+		{
+			name:  "empty bulk in array",
+			input: "*2\r\n$3\r\nGET\r\n$0\r\n\r\n",
+			want:  []protocol.Reply{protocol.MakeMultiBulkReply([][]byte{[]byte("GET"), {}})},
+		},
+		{
+			name:  "null bulk in array",
+			input: "*2\r\n$3\r\nGET\r\n$-1\r\n",
+			want:  []protocol.Reply{protocol.MakeMultiBulkReply([][]byte{[]byte("GET"), nil})},
+		},
+		{
+			name:  "nested arrays",
+			input: "*3\r\n$1\r\na\r\n*2\r\n:1\r\n*1\r\n+x\r\n$-1\r\n",
+			want: []protocol.Reply{protocol.MakeArrayReply([]protocol.Reply{
+				protocol.MakeBulkReply([]byte("a")),
+				protocol.MakeArrayReply([]protocol.Reply{
+					protocol.MakeIntReply(1),
+					protocol.MakeArrayReply([]protocol.Reply{protocol.MakeStatusReply("x")}),
+				}),
+				protocol.MakeNullBulkReply(),
+			})},
+		},
+		{
+			name:  "empty and null array",
+			input: "*0\r\n*-1\r\n",
+			want:  []protocol.Reply{protocol.MakeEmptyMultiBulkReply(), protocol.MakeNullArrayReply()},
+		},
+		{
+			name:  "status",
+			input: "+OK\r\n",
+			want:  []protocol.Reply{protocol.MakeOkReply()},
+		},
+		{
+			name:  "error",
+			input: "-ERR unknown\r\n",
+			want:  []protocol.Reply{protocol.MakeErrReply("ERR unknown")},
+		},
+		{
+			name:  "integer",
+			input: ":-12345\r\n",
+			want:  []protocol.Reply{protocol.MakeIntReply(-12345)},
+		},
+		{
+			name:  "bulk string",
+			input: "$12\r\nhello\r\nworld\r\n",
+			want:  []protocol.Reply{protocol.MakeBulkReply([]byte("hello\r\nworld"))},
+		},
+		{
+			name:  "null bulk",
+			input: "$-1\r\n",
+			want:  []protocol.Reply{protocol.MakeNullBulkReply()},
+		},
+		{
+			name:  "empty lines are skipped",
+			input: "\r\n:1\r\n\r\n:2\r\n",
+			want:  []protocol.Reply{protocol.MakeIntReply(1), protocol.MakeIntReply(2)},
+		},
+		{
+			name:  "pipeline",
+			input: "*1\r\n$4\r\nPING\r\n*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\n",
+			want: []protocol.Reply{
+				protocol.MakeMultiBulkReply([][]byte{[]byte("PING")}),
+				protocol.MakeMultiBulkReply([][]byte{[]byte("ECHO"), []byte("hi")}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBytes([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d replies, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i].ToBytes(), tt.want[i].ToBytes()) {
+					t.Errorf("reply %d: got %q, want %q", i, got[i].ToBytes(), tt.want[i].ToBytes())
+				}
+			}
+		})
+	}
+}
 
-	var k kk
-	fmt.Printf("%+v", k.getMap())
-	re := strings.NewReader("qwwe\r\nweqwe\nqq\nwqewq\r\n12321\n")
-	reader := bufio.NewReader(re)
-	for {
-		line, err := reader.ReadBytes('\n')
-		if err == io.EOF {
-			return
-		}
-		t.Log(line)
-		line = bytes.TrimSuffix(line, []byte{'\r', '\n'})
-		t.Log(line)
+func TestParseBytesError(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		protoErr bool
+	}{
+		{"bad array length", "*x\r\n", true},
+		{"negative array length", "*-2\r\n", true},
+		{"bad bulk length", "*1\r\n$a\r\n", true},
+		{"bulk length mismatch", "*1\r\n$3\r\nabcd\r\n", true},
+		{"missing CR", "*1\n", true},
+		{"bad integer", ":12a\r\n", true},
+		{"unknown type", "*1\r\n!3\r\n", true},
+		{"array too large", "*999999999\r\n", true},
+		{"truncated array", "*2\r\n$3\r\nGET\r\n", false},
+		{"truncated bulk", "*1\r\n$5\r\nab", false},
+		{"truncated line", "*1\r\n$3", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseBytes([]byte(tt.input))
+			if err == nil {
+				t.Fatal("expect error")
+			}
+			if IsProtocolError(err) != tt.protoErr {
+				t.Errorf("got err %v, protocol error expected: %v", err, tt.protoErr)
+			}
+			if !tt.protoErr && err != io.ErrUnexpectedEOF {
+				t.Errorf("got err %v, want unexpected EOF", err)
+			}
+		})
 	}
+}
 
+func TestParseStream(t *testing.T) {
+	input := "*2\r\n$3\r\nGET\r\n$1\r\na\r\n*1\r\n$4\r\nPING\r\n"
+	ch := ParseStream(strings.NewReader(input))
+	var payloads []*Payload
+	for payload := range ch {
+		payloads = append(payloads, payload)
+	}
+	if len(payloads) != 3 {
+		t.Fatalf("got %d payloads, want 3", len(payloads))
+	}
+	if string(payloads[0].Data.ToBytes()) != "*2\r\n$3\r\nGET\r\n$1\r\na\r\n" {
+		t.Errorf("unexpected first payload %q", payloads[0].Data.ToBytes())
+	}
+	if string(payloads[1].Data.ToBytes()) != "*1\r\n$4\r\nPING\r\n" {
+		t.Errorf("unexpected second payload %q", payloads[1].Data.ToBytes())
+	}
+	if payloads[2].Err != io.EOF {
+		t.Errorf("expect EOF, got %v", payloads[2].Err)
+	}
+}
+
+func TestParseStreamProtocolError(t *testing.T) {
+	ch := ParseStream(strings.NewReader("*1\r\n$x\r\n*1\r\n$4\r\nPING\r\n"))
+	var payloads []*Payload
+	for payload := range ch {
+		payloads = append(payloads, payload)
+	}
+	if len(payloads) != 1 || !IsProtocolError(payloads[0].Err) {
+		t.Fatalf("expect a single protocol error, got %+v", payloads)
+	}
 }
 
-func read(reader *bufio.Reader) {
-	line, err := reader.ReadBytes('\n')
-	if err == io.EOF {
-		return
+func TestParseOne(t *testing.T) {
+	reply, err := ParseOne([]byte("\r\n*1\r\n$4\r\nPING\r\n:1\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply.ToBytes()) != "*1\r\n$4\r\nPING\r\n" {
+		t.Errorf("got %q", reply.ToBytes())
 	}
-	fmt.Println(line)
 }
 
-type kk struct {
-	p map[string]string
+func TestParseInline(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  [][]string
+	}{
+		{"ping", "PING\r\n", [][]string{{"PING"}}},
+		{"lf only", "SET a b\n", [][]string{{"SET", "a", "b"}}},
+		{"extra spaces", "  SET \t a   b  \r\n", [][]string{{"SET", "a", "b"}}},
+		{"double quotes", "SET a \"hello world\"\r\n", [][]string{{"SET", "a", "hello world"}}},
+		{"escapes", "SET a \"a\\x00b\\n\\t\\\"\\\\\"\r\n", [][]string{{"SET", "a", "a\x00b\n\t\"\\"}}},
+		{"single quotes", "SET a 'single \\' quote \\n'\r\n", [][]string{{"SET", "a", "single ' quote \\n"}}},
+		{"empty quotes", "SET a \"\"\r\n", [][]string{{"SET", "a", ""}}},
+		{"blank line", "\r\n\n   \r\nPING\n", [][]string{{"PING"}}},
+		{"mixed with resp", "PING\r\n*1\r\n$4\r\nPING\r\nECHO x\n", [][]string{{"PING"}, {"PING"}, {"ECHO", "x"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replies, err := ParseBytes([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			if len(replies) != len(tt.want) {
+				t.Fatalf("got %d replies, want %d", len(replies), len(tt.want))
+			}
+			for i, reply := range replies {
+				mb, ok := reply.(*protocol.MultiBulkReply)
+				if !ok {
+					t.Fatalf("reply %d is %T, want multi bulk", i, reply)
+				}
+				if len(mb.Args) != len(tt.want[i]) {
+					t.Fatalf("reply %d: got %q, want %q", i, mb.Args, tt.want[i])
+				}
+				for j, arg := range mb.Args {
+					if string(arg) != tt.want[i][j] {
+						t.Errorf("reply %d arg %d: got %q, want %q", i, j, arg, tt.want[i][j])
+					}
+				}
+			}
+		})
+	}
 }
 
-func (k *kk) getMap() map[string]string {
-	return k.p
+func TestParseInlineError(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unclosed double quotes", "SET a \"b\r\n"},
+		{"unclosed single quotes", "SET a 'b\r\n"},
+		{"no space after quote", "SET a \"b\"c\r\n"},
+		{"too big", strings.Repeat("a", maxInlineSize+1) + "\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseBytes([]byte(tt.input))
+			if !IsProtocolError(err) {
+				t.Errorf("expect protocol error, got %v", err)
+			}
+		})
+	}
 }
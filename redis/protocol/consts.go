@@ -0,0 +1,92 @@
+package protocol
+
+// PongReply is +PONG
+type PongReply struct{}
+
+var pongBytes = []byte("+PONG" + CRLF)
+
+func (r *PongReply) ToBytes() []byte {
+	return pongBytes
+}
+
+func MakePongReply() *PongReply {
+	return &PongReply{}
+}
+
+// OkReply is +OK
+type OkReply struct{}
+
+var okBytes = []byte("+OK" + CRLF)
+
+func (r *OkReply) ToBytes() []byte {
+	return okBytes
+}
+
+var theOkReply = new(OkReply)
+
+func MakeOkReply() *OkReply {
+	return theOkReply
+}
+
+// QueuedReply is +QUEUED
+type QueuedReply struct{}
+
+var queuedBytes = []byte("+QUEUED" + CRLF)
+
+func (r *QueuedReply) ToBytes() []byte {
+	return queuedBytes
+}
+
+var theQueuedReply = new(QueuedReply)
+
+func MakeQueuedReply() *QueuedReply {
+	return theQueuedReply
+}
+
+// NullBulkReply is $-1, an empty bulk string
+type NullBulkReply struct{}
+
+var nullBulkBytes = []byte("$-1" + CRLF)
+
+func (r *NullBulkReply) ToBytes() []byte {
+	return nullBulkBytes
+}
+
+func MakeNullBulkReply() *NullBulkReply {
+	return &NullBulkReply{}
+}
+
+// EmptyMultiBulkReply is *0, an empty list
+type EmptyMultiBulkReply struct{}
+
+var emptyMultiBulkBytes = []byte("*0" + CRLF)
+
+func (r *EmptyMultiBulkReply) ToBytes() []byte {
+	return emptyMultiBulkBytes
+}
+
+func MakeEmptyMultiBulkReply() *EmptyMultiBulkReply {
+	return &EmptyMultiBulkReply{}
+}
+
+// NullArrayReply is *-1, e.g. EXEC aborted by WATCH or a timed out blocking pop
+type NullArrayReply struct{}
+
+var nullArrayBytes = []byte("*-1" + CRLF)
+
+func (r *NullArrayReply) ToBytes() []byte {
+	return nullArrayBytes
+}
+
+func MakeNullArrayReply() *NullArrayReply {
+	return &NullArrayReply{}
+}
+
+// NoReply writes nothing, used by commands which answer by themselves
+type NoReply struct{}
+
+var noBytes = []byte("")
+
+func (r *NoReply) ToBytes() []byte {
+	return noBytes
+}
@@ -0,0 +1,140 @@
+package protocol
+
+// UnknownErrReply is returned when an unexpected error happens
+type UnknownErrReply struct{}
+
+var unknownErrBytes = []byte("-ERR unknown" + CRLF)
+
+func (r *UnknownErrReply) ToBytes() []byte {
+	return unknownErrBytes
+}
+
+func (r *UnknownErrReply) Error() string {
+	return "ERR unknown"
+}
+
+// StandardErrReply is a server error, Status carries the error code prefix, e.g. "ERR xxx"
+type StandardErrReply struct {
+	Status string
+}
+
+func MakeErrReply(status string) *StandardErrReply {
+	return &StandardErrReply{Status: status}
+}
+
+func (r *StandardErrReply) ToBytes() []byte {
+	return []byte("-" + r.Status + CRLF)
+}
+
+func (r *StandardErrReply) Error() string {
+	return r.Status
+}
+
+// ArgNumErrReply is returned when a command gets a wrong number of arguments
+type ArgNumErrReply struct {
+	Cmd string
+}
+
+func MakeArgNumErrReply(cmd string) *ArgNumErrReply {
+	return &ArgNumErrReply{Cmd: cmd}
+}
+
+func (r *ArgNumErrReply) ToBytes() []byte {
+	return []byte("-" + r.Error() + CRLF)
+}
+
+func (r *ArgNumErrReply) Error() string {
+	return "ERR wrong number of arguments for '" + r.Cmd + "' command"
+}
+
+// SyntaxErrReply is returned when the arguments can not be parsed
+type SyntaxErrReply struct{}
+
+var syntaxErrBytes = []byte("-ERR syntax error" + CRLF)
+var theSyntaxErrReply = &SyntaxErrReply{}
+
+func MakeSyntaxErrReply() *SyntaxErrReply {
+	return theSyntaxErrReply
+}
+
+func (r *SyntaxErrReply) ToBytes() []byte {
+	return syntaxErrBytes
+}
+
+func (r *SyntaxErrReply) Error() string {
+	return "ERR syntax error"
+}
+
+// WrongTypeErrReply is returned when operating a key holding another data type
+type WrongTypeErrReply struct{}
+
+var wrongTypeErrBytes = []byte("-WRONGTYPE Operation against a key holding the wrong kind of value" + CRLF)
+var theWrongTypeErrReply = &WrongTypeErrReply{}
+
+func MakeWrongTypeErrReply() *WrongTypeErrReply {
+	return theWrongTypeErrReply
+}
+
+func (r *WrongTypeErrReply) ToBytes() []byte {
+	return wrongTypeErrBytes
+}
+
+func (r *WrongTypeErrReply) Error() string {
+	return "WRONGTYPE Operation against a key holding the wrong kind of value"
+}
+
+// UnknownCommandErrReply is returned when the command is not registered
+type UnknownCommandErrReply struct {
+	Cmd  string
+	Args [][]byte
+}
+
+func MakeUnknownCommandErrReply(cmd string, args [][]byte) *UnknownCommandErrReply {
+	return &UnknownCommandErrReply{Cmd: cmd, Args: args}
+}
+
+func (r *UnknownCommandErrReply) ToBytes() []byte {
+	return []byte("-" + r.Error() + CRLF)
+}
+
+func (r *UnknownCommandErrReply) Error() string {
+	msg := "ERR unknown command '" + r.Cmd + "', with args beginning with: "
+	for _, arg := range r.Args {
+		msg += "'" + string(arg) + "' "
+	}
+	return msg
+}
+
+// NoAuthErrReply is returned when the client has not passed AUTH
+type NoAuthErrReply struct{}
+
+var noAuthErrBytes = []byte("-NOAUTH Authentication required." + CRLF)
+
+func MakeNoAuthErrReply() *NoAuthErrReply {
+	return &NoAuthErrReply{}
+}
+
+func (r *NoAuthErrReply) ToBytes() []byte {
+	return noAuthErrBytes
+}
+
+func (r *NoAuthErrReply) Error() string {
+	return "NOAUTH Authentication required."
+}
+
+// ProtocolErrReply is returned when the request can not be parsed
+type ProtocolErrReply struct {
+	Msg string
+}
+
+func MakeProtocolErrReply(msg string) *ProtocolErrReply {
+	return &ProtocolErrReply{Msg: msg}
+}
+
+func (r *ProtocolErrReply) ToBytes() []byte {
+	return []byte("-" + r.Error() + CRLF)
+}
+
+func (r *ProtocolErrReply) Error() string {
+	return "ERR Protocol error: " + r.Msg
+}
@@ -3,3 +3,21 @@ package protocol
 type Reply interface {
 	ToBytes() []byte
 }
+
+// ErrorReply is a Reply which also represents an error
+type ErrorReply interface {
+	Error() string
+	ToBytes() []byte
+}
+
+// IsErrorReply returns true if the given reply is an error
+func IsErrorReply(reply Reply) bool {
+	if reply == nil {
+		return false
+	}
+	if _, ok := reply.(ErrorReply); ok {
+		return true
+	}
+	bs := reply.ToBytes()
+	return len(bs) > 0 && bs[0] == '-'
+}
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"bytes"
+	"strconv"
+)
+
+const CRLF = "\r\n"
+
+// StatusReply is a simple string, e.g. +OK
+type StatusReply struct {
+	Status string
+}
+
+func MakeStatusReply(status string) *StatusReply {
+	return &StatusReply{Status: status}
+}
+
+func (r *StatusReply) ToBytes() []byte {
+	return []byte("+" + r.Status + CRLF)
+}
+
+// IsOKReply returns true if the given reply is +OK
+func IsOKReply(reply Reply) bool {
+	return string(reply.ToBytes()) == "+OK\r\n"
+}
+
+// IntReply is an integer, e.g. :1
+type IntReply struct {
+	Code int64
+}
+
+func MakeIntReply(code int64) *IntReply {
+	return &IntReply{Code: code}
+}
+
+func (r *IntReply) ToBytes() []byte {
+	return []byte(":" + strconv.FormatInt(r.Code, 10) + CRLF)
+}
+
+// BulkReply is a binary safe string, a nil Arg is sent as null bulk
+type BulkReply struct {
+	Arg []byte
+}
+
+func MakeBulkReply(arg []byte) *BulkReply {
+	return &BulkReply{Arg: arg}
+}
+
+func (r *BulkReply) ToBytes() []byte {
+	if r.Arg == nil {
+		return nullBulkBytes
+	}
+	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
+}
+
+// MultiBulkReply is an array of bulk strings
+type MultiBulkReply struct {
+	Args [][]byte
+}
+
+func MakeMultiBulkReply(args [][]byte) *MultiBulkReply {
+	return &MultiBulkReply{Args: args}
+}
+
+func (r *MultiBulkReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(r.Args)) + CRLF)
+	for _, arg := range r.Args {
+		if arg == nil {
+			buf.WriteString("$-1" + CRLF)
+		} else {
+			buf.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + string(arg) + CRLF)
+		}
+	}
+	return buf.Bytes()
+}
+
+// ArrayReply is an array of any replies, which may be arrays themselves
+type ArrayReply struct {
+	Replies []Reply
+}
+
+func MakeArrayReply(replies []Reply) *ArrayReply {
+	return &ArrayReply{Replies: replies}
+}
+
+func (r *ArrayReply) ToBytes() []byte {
+	return aggregateBytes('*', r.Replies, RESP2)
+}
@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"math"
+	"testing"
+)
+
+func TestReplyToBytes(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply Reply
+		want  string
+	}{
+		{"ok", MakeOkReply(), "+OK\r\n"},
+		{"pong", MakePongReply(), "+PONG\r\n"},
+		{"queued", MakeQueuedReply(), "+QUEUED\r\n"},
+		{"status", MakeStatusReply("string"), "+string\r\n"},
+		{"int", MakeIntReply(-12), ":-12\r\n"},
+		{"bulk", MakeBulkReply([]byte("a\r\nb")), "$4\r\na\r\nb\r\n"},
+		{"empty bulk", MakeBulkReply([]byte{}), "$0\r\n\r\n"},
+		{"nil bulk", MakeBulkReply(nil), "$-1\r\n"},
+		{"null bulk", MakeNullBulkReply(), "$-1\r\n"},
+		{"empty array", MakeEmptyMultiBulkReply(), "*0\r\n"},
+		{"null array", MakeNullArrayReply(), "*-1\r\n"},
+		{"multi bulk", MakeMultiBulkReply([][]byte{[]byte("a"), nil, []byte("bc")}), "*3\r\n$1\r\na\r\n$-1\r\n$2\r\nbc\r\n"},
+		{"nested array", MakeArrayReply([]Reply{
+			MakeIntReply(1),
+			MakeMultiBulkReply([][]byte{[]byte("x")}),
+			MakeNullArrayReply(),
+		}), "*3\r\n:1\r\n*1\r\n$1\r\nx\r\n*-1\r\n"},
+		{"err", MakeErrReply("ERR boom"), "-ERR boom\r\n"},
+		{"wrong type", MakeWrongTypeErrReply(), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"arg num", MakeArgNumErrReply("get"), "-ERR wrong number of arguments for 'get' command\r\n"},
+		{"syntax", MakeSyntaxErrReply(), "-ERR syntax error\r\n"},
+		{"no auth", MakeNoAuthErrReply(), "-NOAUTH Authentication required.\r\n"},
+		{"unknown command", MakeUnknownCommandErrReply("foo", [][]byte{[]byte("a")}), "-ERR unknown command 'foo', with args beginning with: 'a' \r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(tt.reply.ToBytes()); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsErrorReply(t *testing.T) {
+	if !IsErrorReply(MakeErrReply("ERR x")) || !IsErrorReply(MakeWrongTypeErrReply()) {
+		t.Error("expect error reply")
+	}
+	if IsErrorReply(MakeOkReply()) || IsErrorReply(MakeBulkReply([]byte("-x"))) || IsErrorReply(nil) {
+		t.Error("expect non error reply")
+	}
+}
+
+func TestEncodeRESP3(t *testing.T) {
+	bulk := func(s string) Reply { return MakeBulkReply([]byte(s)) }
+	tests := []struct {
+		name  string
+		reply Reply
+		want2 string
+		want3 string
+	}{
+		{"null bulk", MakeNullBulkReply(), "$-1\r\n", "_\r\n"},
+		{"null array", MakeNullArrayReply(), "*-1\r\n", "_\r\n"},
+		{"map", MakeMapReply([]Reply{bulk("a")}, []Reply{MakeIntReply(1)}), "*2\r\n$1\r\na\r\n:1\r\n", "%1\r\n$1\r\na\r\n:1\r\n"},
+		{"pairs", MakePairsReply([]Reply{bulk("a"), bulk("a")}, []Reply{bulk("1"), bulk("1")}),
+			"*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\na\r\n$1\r\n1\r\n", "*2\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{"set", MakeSetReply([]Reply{bulk("a"), bulk("b")}), "*2\r\n$1\r\na\r\n$1\r\nb\r\n", "~2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"double", MakeDoubleReply(1.5), "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{"large double", MakeDoubleReply(3479099956230698), "$16\r\n3479099956230698\r\n", ",3479099956230698\r\n"},
+		{"exponent", MakeDoubleReply(1e20), "$5\r\n1e+20\r\n", ",1e+20\r\n"},
+		{"inf", MakeDoubleReply(math.Inf(-1)), "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{"human double", MakeHumanDoubleReply(13.5), "$4\r\n13.5\r\n", ",13.5\r\n"},
+		{"bool", MakeBoolReply(true), ":1\r\n", "#t\r\n"},
+		{"big number", MakeBigNumberReply("3492890328409238509324850943850943825024385"),
+			"$43\r\n3492890328409238509324850943850943825024385\r\n", "(3492890328409238509324850943850943825024385\r\n"},
+		{"verbatim", MakeVerbatimReply("txt", []byte("Some string")), "$11\r\nSome string\r\n", "=15\r\ntxt:Some string\r\n"},
+		{"push", MakePushReply([]Reply{bulk("message"), MakeNullBulkReply()}), "*2\r\n$7\r\nmessage\r\n$-1\r\n", ">2\r\n$7\r\nmessage\r\n_\r\n"},
+		{"attribute", MakeAttributeReply([]Reply{bulk("ttl")}, []Reply{MakeIntReply(3)}, MakeIntReply(1)),
+			":1\r\n", "|1\r\n$3\r\nttl\r\n:3\r\n:1\r\n"},
+		{"keyed arrays", MakeKeyedArraysReply([]Reply{bulk("a")}, []Reply{MakeIntReply(1)}),
+			"*1\r\n*2\r\n$1\r\na\r\n:1\r\n", "%1\r\n$1\r\na\r\n:1\r\n"},
+		{"nested", MakeArrayReply([]Reply{MakeMapReply([]Reply{bulk("k")}, []Reply{MakeNullBulkReply()})}),
+			"*1\r\n*2\r\n$1\r\nk\r\n$-1\r\n", "*1\r\n%1\r\n$1\r\nk\r\n_\r\n"},
+		{"multi bulk with nil", MakeMultiBulkReply([][]byte{nil}), "*1\r\n$-1\r\n", "*1\r\n_\r\n"},
+		{"int is the same", MakeIntReply(7), ":7\r\n", ":7\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(Encode(tt.reply, RESP2)); got != tt.want2 {
+			t.Errorf("%s RESP2: got %q, want %q", tt.name, got, tt.want2)
+		}
+		if got := string(Encode(tt.reply, RESP3)); got != tt.want3 {
+			t.Errorf("%s RESP3: got %q, want %q", tt.name, got, tt.want3)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package protocol
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// RESP3Reply is a Reply which has a different form in RESP3
+type RESP3Reply interface {
+	Reply
+	ToRESP3Bytes() []byte
+}
+
+// Encode serializes reply in the given protocol version, RESP2 is used unless protover is RESP3
+func Encode(reply Reply, protover int) []byte {
+	if reply == nil {
+		return nil
+	}
+	if protover == RESP3 {
+		if r, ok := reply.(RESP3Reply); ok {
+			return r.ToRESP3Bytes()
+		}
+	}
+	return reply.ToBytes()
+}
+
+var nullBytes = []byte("_" + CRLF)
+
+func (r *NullBulkReply) ToRESP3Bytes() []byte {
+	return nullBytes
+}
+
+func (r *NullArrayReply) ToRESP3Bytes() []byte {
+	return nullBytes
+}
+
+func (r *BulkReply) ToRESP3Bytes() []byte {
+	if r.Arg == nil {
+		return nullBytes
+	}
+	return r.ToBytes()
+}
+
+func (r *MultiBulkReply) ToRESP3Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(r.Args)) + CRLF)
+	for _, arg := range r.Args {
+		if arg == nil {
+			buf.Write(nullBytes)
+		} else {
+			buf.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + string(arg) + CRLF)
+		}
+	}
+	return buf.Bytes()
+}
+
+func (r *ArrayReply) ToRESP3Bytes() []byte {
+	return aggregateBytes('*', r.Replies, RESP3)
+}
+
+func aggregateBytes(prefix byte, replies []Reply, protover int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(string(prefix) + strconv.Itoa(len(replies)) + CRLF)
+	for _, reply := range replies {
+		if reply == nil {
+			buf.Write(Encode(MakeNullBulkReply(), protover))
+			continue
+		}
+		buf.Write(Encode(reply, protover))
+	}
+	return buf.Bytes()
+}
+
+func pairs(keys, values []Reply) []Reply {
+	result := make([]Reply, 0, 2*len(keys))
+	for i := range keys {
+		result = append(result, keys[i], values[i])
+	}
+	return result
+}
+
+// MapReply is a RESP3 map, it is a flat array of keys and values in RESP2
+type MapReply struct {
+	Keys   []Reply
+	Values []Reply
+}
+
+func MakeMapReply(keys, values []Reply) *MapReply {
+	return &MapReply{Keys: keys, Values: values}
+}
+
+func (r *MapReply) ToBytes() []byte {
+	return aggregateBytes('*', pairs(r.Keys, r.Values), RESP2)
+}
+
+func (r *MapReply) ToRESP3Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%" + strconv.Itoa(len(r.Keys)) + CRLF)
+	for i := range r.Keys {
+		buf.Write(Encode(r.Keys[i], RESP3))
+		buf.Write(Encode(r.Values[i], RESP3))
+	}
+	return buf.Bytes()
+}
+
+// PairsReply is an array of [key, value] arrays in RESP3, it is a flat array of keys and values in RESP2.
+// Unlike MapReply, keys may repeat, e.g. the reply of HRANDFIELD with a negative count.
+type PairsReply struct {
+	Keys   []Reply
+	Values []Reply
+}
+
+func MakePairsReply(keys, values []Reply) *PairsReply {
+	return &PairsReply{Keys: keys, Values: values}
+}
+
+func (r *PairsReply) ToBytes() []byte {
+	return aggregateBytes('*', pairs(r.Keys, r.Values), RESP2)
+}
+
+func (r *PairsReply) ToRESP3Bytes() []byte {
+	replies := make([]Reply, len(r.Keys))
+	for i := range r.Keys {
+		replies[i] = MakeArrayReply([]Reply{r.Keys[i], r.Values[i]})
+	}
+	return aggregateBytes('*', replies, RESP3)
+}
+
+// KeyedArraysReply is a RESP3 map, it is an array of [key, value] arrays in RESP2, e.g. the reply of XREAD
+type KeyedArraysReply struct {
+	Keys   []Reply
+	Values []Reply
+}
+
+func MakeKeyedArraysReply(keys, values []Reply) *KeyedArraysReply {
+	return &KeyedArraysReply{Keys: keys, Values: values}
+}
+
+func (r *KeyedArraysReply) ToBytes() []byte {
+	replies := make([]Reply, len(r.Keys))
+	for i := range r.Keys {
+		replies[i] = MakeArrayReply([]Reply{r.Keys[i], r.Values[i]})
+	}
+	return aggregateBytes('*', replies, RESP2)
+}
+
+func (r *KeyedArraysReply) ToRESP3Bytes() []byte {
+	return MakeMapReply(r.Keys, r.Values).ToRESP3Bytes()
+}
+
+// SetReply is a RESP3 set, it is an array in RESP2
+type SetReply struct {
+	Members []Reply
+}
+
+func MakeSetReply(members []Reply) *SetReply {
+	return &SetReply{Members: members}
+}
+
+func (r *SetReply) ToBytes() []byte {
+	return aggregateBytes('*', r.Members, RESP2)
+}
+
+func (r *SetReply) ToRESP3Bytes() []byte {
+	return aggregateBytes('~', r.Members, RESP3)
+}
+
+// PushReply is an out of band RESP3 message, e.g. pub/sub messages, it is an array in RESP2
+type PushReply struct {
+	Replies []Reply
+}
+
+func MakePushReply(replies []Reply) *PushReply {
+	return &PushReply{Replies: replies}
+}
+
+func (r *PushReply) ToBytes() []byte {
+	return aggregateBytes('*', r.Replies, RESP2)
+}
+
+func (r *PushReply) ToRESP3Bytes() []byte {
+	return aggregateBytes('>', r.Replies, RESP3)
+}
+
+// FormatFloat formats a float the way redis replies it, e.g. 1.5, 3, 1000000, 1e+20, inf
+func FormatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "inf"
+	}
+	if math.IsInf(value, -1) {
+		return "-inf"
+	}
+	// like %.17g of redis, the exponent is used only if it is less than -4 or at least 17
+	s := strconv.FormatFloat(value, 'e', -1, 64)
+	exp, _ := strconv.Atoi(s[strings.IndexByte(s, 'e')+1:])
+	if exp < -4 || exp >= 17 {
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// DoubleReply is a RESP3 double, it is a bulk string in RESP2
+type DoubleReply struct {
+	Value float64
+}
+
+func MakeDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{Value: value}
+}
+
+func (r *DoubleReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(FormatFloat(r.Value))).ToBytes()
+}
+
+func (r *DoubleReply) ToRESP3Bytes() []byte {
+	if math.IsNaN(r.Value) {
+		return []byte(",nan" + CRLF)
+	}
+	return []byte("," + FormatFloat(r.Value) + CRLF)
+}
+
+// HumanDoubleReply is a double with 17 digits after the point, the trailing zeros are trimmed.
+// Redis replies coordinates this way
+type HumanDoubleReply struct {
+	Value float64
+}
+
+func MakeHumanDoubleReply(value float64) *HumanDoubleReply {
+	return &HumanDoubleReply{Value: value}
+}
+
+func (r *HumanDoubleReply) format() string {
+	s := strconv.FormatFloat(r.Value, 'f', 17, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func (r *HumanDoubleReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(r.format())).ToBytes()
+}
+
+func (r *HumanDoubleReply) ToRESP3Bytes() []byte {
+	return []byte("," + r.format() + CRLF)
+}
+
+// BoolReply is a RESP3 boolean, it is 1 or 0 in RESP2
+type BoolReply struct {
+	Value bool
+}
+
+func MakeBoolReply(value bool) *BoolReply {
+	return &BoolReply{Value: value}
+}
+
+func (r *BoolReply) ToBytes() []byte {
+	if r.Value {
+		return []byte(":1" + CRLF)
+	}
+	return []byte(":0" + CRLF)
+}
+
+func (r *BoolReply) ToRESP3Bytes() []byte {
+	if r.Value {
+		return []byte("#t" + CRLF)
+	}
+	return []byte("#f" + CRLF)
+}
+
+// BigNumberReply is a RESP3 big number, it is a bulk string in RESP2
+type BigNumberReply struct {
+	Value string
+}
+
+func MakeBigNumberReply(value string) *BigNumberReply {
+	return &BigNumberReply{Value: value}
+}
+
+func (r *BigNumberReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(r.Value)).ToBytes()
+}
+
+func (r *BigNumberReply) ToRESP3Bytes() []byte {
+	return []byte("(" + r.Value + CRLF)
+}
+
+// VerbatimReply is a RESP3 verbatim string with a 3 bytes format, e.g. txt, it is a bulk string in RESP2
+type VerbatimReply struct {
+	Format string
+	Text   []byte
+}
+
+func MakeVerbatimReply(format string, text []byte) *VerbatimReply {
+	return &VerbatimReply{Format: format, Text: text}
+}
+
+func (r *VerbatimReply) ToBytes() []byte {
+	return MakeBulkReply(r.Text).ToBytes()
+}
+
+func (r *VerbatimReply) ToRESP3Bytes() []byte {
+	return []byte("=" + strconv.Itoa(len(r.Format)+1+len(r.Text)) + CRLF + r.Format + ":" + string(r.Text) + CRLF)
+}
+
+// AttributeReply sends auxiliary attributes before Reply in RESP3, they are dropped in RESP2
+type AttributeReply struct {
+	Keys   []Reply
+	Values []Reply
+	Reply  Reply
+}
+
+func MakeAttributeReply(keys, values []Reply, reply Reply) *AttributeReply {
+	return &AttributeReply{Keys: keys, Values: values, Reply: reply}
+}
+
+func (r *AttributeReply) ToBytes() []byte {
+	return Encode(r.Reply, RESP2)
+}
+
+func (r *AttributeReply) ToRESP3Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("|" + strconv.Itoa(len(r.Keys)) + CRLF)
+	for i := range r.Keys {
+		buf.Write(Encode(r.Keys[i], RESP3))
+		buf.Write(Encode(r.Values[i], RESP3))
+	}
+	buf.Write(Encode(r.Reply, RESP3))
+	return buf.Bytes()
+}
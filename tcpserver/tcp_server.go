@@ -1,6 +1,7 @@
 package tcpserver
 
 import (
+	"context"
 	"net"
 	"os"
 	"os/signal"
@@ -9,6 +10,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/issimo1/redis-issimo/abs"
 	"github.com/issimo1/redis-issimo/utils/logger"
 )
 
@@ -24,18 +26,23 @@ type TCPServer struct {
 	conf          TCPConfig
 	closeTcp      int32
 	quit          chan os.Signal
+	// ctx is the parent of the connection contexts, cancelled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
 
-	//待补充
-	redisHandler string
+	handler abs.Handler
 }
 
-func NewTCPServer(conf TCPConfig, handler string) *TCPServer {
+func NewTCPServer(conf TCPConfig, handler abs.Handler) *TCPServer {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &TCPServer{
 		conf:          conf,
 		closeTcp:      0,
 		clientCounter: 0,
 		quit:          make(chan os.Signal, 1),
-		redisHandler:  handler,
+		ctx:           ctx,
+		cancel:        cancel,
+		handler:       handler,
 	}
 }
 
@@ -85,13 +92,18 @@ func (t *TCPServer) handleConn(conn net.Conn) {
 		atomic.AddInt64(&t.clientCounter, -1)
 	}()
 
-	t.redisHandler = "11"
+	ctx, cancel := context.WithCancel(t.ctx)
+	defer cancel()
+	t.handler.Handle(ctx, conn)
 }
 
 func (t *TCPServer) Close() {
 	logger.Info("graceful shutdown issimo1 redis server")
 	atomic.CompareAndSwapInt32(&t.closeTcp, 0, 1)
 	t.listener.Close()
-	t.redisHandler = ""
+	t.cancel()
+	if err := t.handler.Close(); err != nil {
+		logger.Warn("close handler err: " + err.Error())
+	}
 	t.waitDone.Wait()
 }
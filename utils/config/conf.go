@@ -29,8 +29,30 @@ type RedisConfig struct {
 	AppendFileName string   `conf:"appendFileName"`
 	AppendFsync    string   `conf:"appendFsync"`
 	RequiredPwd    string   `conf:"requiredPwd,omitempty"`
+	TxRollback     bool     `conf:"txRollback"`
 	Cluster        []string `conf:"cluster"`
 	Self           string   `conf:"self"`
+
+	// ListMaxListpackSize is the fill of quicklist nodes, positive for entries and -1 to -5 for 4KB to 64KB
+	ListMaxListpackSize int `conf:"list-max-listpack-size"`
+	// ListMaxLinkedEntries is the most elements a list keeps in the linked list encoding before it becomes a quicklist
+	ListMaxLinkedEntries int `conf:"list-max-linked-entries"`
+	// ListMaxLinkedValue is the longest element a list keeps in the linked list encoding
+	ListMaxLinkedValue int `conf:"list-max-linked-value"`
+	// ListCompressDepth is the number of quicklist nodes kept uncompressed at each end, 0 disables compression
+	ListCompressDepth int `conf:"list-compress-depth"`
+	// HashMaxListpackEntries is the most fields a hash keeps in the listpack encoding, 0 always uses a hashtable
+	HashMaxListpackEntries int `conf:"hash-max-listpack-entries"`
+	// HashMaxListpackValue is the longest field or value a hash keeps in the listpack encoding
+	HashMaxListpackValue int `conf:"hash-max-listpack-value"`
+	// SetMaxIntsetEntries is the most members a set of integers keeps in the intset encoding
+	SetMaxIntsetEntries int `conf:"set-max-intset-entries"`
+	// StreamNodeMaxEntries is the most entries a stream node keeps, 0 means no limit
+	StreamNodeMaxEntries int `conf:"stream-node-max-entries"`
+	// StreamNodeMaxBytes is the most bytes a stream node keeps, 0 means no limit
+	StreamNodeMaxBytes int `conf:"stream-node-max-bytes"`
+	// HllSparseMaxBytes is the longest a HyperLogLog keeps in the sparse representation, including the header
+	HllSparseMaxBytes int `conf:"hll-sparse-max-bytes"`
 }
 
 var (
@@ -39,13 +61,35 @@ var (
 )
 
 func defaultRedisConfig() {
-	GlobalConfig = &RedisConfig{
-		Bind:       "127.0.0.1",
-		Port:       6379,
-		Dir:        ".",
-		RunId:      rand.RandString(runidMaxLen),
-		DBCount:    defaultDatabaseCount,
-		AppendOnly: false,
+	GlobalConfig = NewDefaultConfig()
+}
+
+// NewDefaultConfig returns the config used for the options absent in the config file
+func NewDefaultConfig() *RedisConfig {
+	return &RedisConfig{
+		Bind:           "127.0.0.1",
+		Port:           6379,
+		Dir:            ".",
+		RunId:          rand.RandString(runidMaxLen),
+		DBCount:        defaultDatabaseCount,
+		AppendOnly:     false,
+		AppendFileName: "appendonly.aof",
+		AppendFsync:    "everysec",
+
+		ListMaxListpackSize:  -2,
+		ListMaxLinkedEntries: 128,
+		ListMaxLinkedValue:   64,
+		ListCompressDepth:    0,
+
+		HashMaxListpackEntries: 128,
+		HashMaxListpackValue:   64,
+
+		SetMaxIntsetEntries: 512,
+
+		StreamNodeMaxEntries: 100,
+		StreamNodeMaxBytes:   4096,
+
+		HllSparseMaxBytes: 3000,
 	}
 }
 
@@ -62,7 +106,7 @@ func LoadConfig(config string) {
 }
 
 func parse(r io.Reader) *RedisConfig {
-	newRedisConf := &RedisConfig{}
+	newRedisConf := NewDefaultConfig()
 
 	lineMap := make(map[string]string)
 
@@ -3,6 +3,7 @@ package timewheel
 import (
 	"container/list"
 	"github.com/issimo1/redis-issimo/utils/logger"
+	"sync"
 	"time"
 )
 
@@ -28,6 +29,7 @@ type TimeWheel struct {
 	addChan    chan *task
 	cancelChan chan string
 	stopChan   chan struct{}
+	stopOnce   sync.Once
 }
 
 func New(interval time.Duration, slotNum int) *TimeWheel {
@@ -59,6 +61,8 @@ func (tw *TimeWheel) doTask() {
 		case key := <-tw.cancelChan:
 			tw.cancelTask(key)
 		case <-tw.stopChan:
+			tw.ticker.Stop()
+			return
 		}
 	}
 }
@@ -70,7 +74,8 @@ func (tw *TimeWheel) execTask() {
 	} else {
 		tw.curSlotPos++
 	}
-	go tw.scanList(l)
+	// scan in the goroutine of doTask, so that tw.m is never accessed concurrently
+	tw.scanList(l)
 }
 
 func (tw *TimeWheel) scanList(l *list.List) {
@@ -78,6 +83,7 @@ func (tw *TimeWheel) scanList(l *list.List) {
 		t := e.Value.(*task)
 		if t.circle > 0 {
 			t.circle--
+			e = e.Next()
 			continue
 		}
 
@@ -92,7 +98,7 @@ func (tw *TimeWheel) scanList(l *list.List) {
 		}()
 
 		next := e.Next()
-		l.Remove(next)
+		l.Remove(e)
 		if t.key != "" {
 			delete(tw.m, t.key)
 		}
@@ -101,11 +107,11 @@ func (tw *TimeWheel) scanList(l *list.List) {
 
 }
 
+// posAndCircle rounds d up to whole intervals, so a task never runs earlier than its delay
 func (tw *TimeWheel) posAndCircle(d time.Duration) (pos, circle int) {
-	delaySecond := int(d.Seconds())
-	intervalSecond := int(tw.interval.Seconds())
-	pos = (tw.curSlotPos + delaySecond/intervalSecond) % tw.slotNum
-	circle = (delaySecond / intervalSecond) / tw.slotNum
+	steps := int((d + tw.interval - 1) / tw.interval)
+	pos = (tw.curSlotPos + steps) % tw.slotNum
+	circle = steps / tw.slotNum
 	return
 }
 
@@ -113,11 +119,12 @@ func (tw *TimeWheel) addTask(t *task) {
 	pos, circle := tw.posAndCircle(t.delay)
 	t.circle = circle
 
+	if t.key != "" {
+		// a task replaces the pending one with the same key
+		tw.cancelTask(t.key)
+	}
 	element := tw.slots[pos].PushBack(t)
 	if t.key != "" {
-		if _, ok := tw.m[t.key]; ok {
-
-		}
 		tw.m[t.key] = &taskPos{pos: pos, ele: element}
 	}
 }
@@ -136,22 +143,31 @@ func (tw *TimeWheel) Start() {
 	go tw.doTask()
 }
 
+// Stop stops the time wheel, the pending tasks are dropped and later Add or Cancel calls do nothing
 func (tw *TimeWheel) Stop() {
-	tw.stopChan <- struct{}{}
+	tw.stopOnce.Do(func() {
+		close(tw.stopChan)
+	})
 }
 
 func (tw *TimeWheel) Add(delay time.Duration, key string, call func()) {
 	if delay < 0 {
-		return
+		delay = 0
 	}
 	t := task{
 		delay:    delay,
 		key:      key,
 		callback: call,
 	}
-	tw.addChan <- &t
+	select {
+	case tw.addChan <- &t:
+	case <-tw.stopChan:
+	}
 }
 
 func (tw *TimeWheel) Cancel(key string) {
-	tw.cancelChan <- key
+	select {
+	case tw.cancelChan <- key:
+	case <-tw.stopChan:
+	}
 }
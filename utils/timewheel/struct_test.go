@@ -1 +1,53 @@
 package timewheel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimeWheelAdd(t *testing.T) {
+	tw := New(10*time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	done := make(chan time.Time, 1)
+	start := time.Now()
+	// longer than a round, the task must survive one circle
+	tw.Add(120*time.Millisecond, "k", func() {
+		done <- time.Now()
+	})
+	select {
+	case at := <-done:
+		if at.Sub(start) < 120*time.Millisecond {
+			t.Errorf("task runs too early: %s", at.Sub(start))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("task not run")
+	}
+}
+
+func TestTimeWheelCancel(t *testing.T) {
+	tw := New(10*time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+
+	var called atomic.Int32
+	tw.Add(30*time.Millisecond, "a", func() {
+		called.Add(1)
+	})
+	tw.Cancel("a")
+	// the second task with the same key replaces the first one
+	tw.Add(30*time.Millisecond, "b", func() {
+		called.Add(10)
+	})
+	tw.Add(40*time.Millisecond, "b", func() {
+		called.Add(100)
+	})
+	tw.Add(20*time.Millisecond, "", func() {})
+	tw.Add(20*time.Millisecond, "", func() {})
+	time.Sleep(150 * time.Millisecond)
+	if n := called.Load(); n != 100 {
+		t.Errorf("expect only the replacing task run, got %d", n)
+	}
+}
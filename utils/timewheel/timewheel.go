@@ -7,8 +7,13 @@ type Delay struct {
 }
 
 func NewDelay() *Delay {
+	return NewDelayWithInterval(1*time.Second, 3600)
+}
+
+// NewDelayWithInterval creates a Delay whose tasks run at most one interval late
+func NewDelayWithInterval(interval time.Duration, slotNum int) *Delay {
 	delay := &Delay{}
-	delay.tw = New(1*time.Second, 3600)
+	delay.tw = New(interval, slotNum)
 	delay.tw.Start()
 	return delay
 }
@@ -25,3 +30,7 @@ func (d *Delay) Add(expire time.Duration, key string, call func()) {
 func (d *Delay) Cancel(key string) {
 	d.tw.Cancel(key)
 }
+
+func (d *Delay) Stop() {
+	d.tw.Stop()
+}
@@ -0,0 +1,131 @@
+package wildcard
+
+// maxNesting limits the recursion of '*', the same as stringmatchlen of redis
+const maxNesting = 1000
+
+// Match reports whether str matches the glob-style pattern like KEYS of redis:
+//
+//   - matches any sequence of characters
+//     ?       matches any single character
+//     [abc]   matches one of the characters, [^abc] matches any other character
+//     [a-c]   matches a range of characters
+//     \x      matches x literally
+func Match(pattern, str string) bool {
+	skipLongerMatches := false
+	return match(pattern, str, false, &skipLongerMatches, 0)
+}
+
+// MatchNoCase is Match ignoring ASCII case
+func MatchNoCase(pattern, str string) bool {
+	skipLongerMatches := false
+	return match(pattern, str, true, &skipLongerMatches, 0)
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func match(pattern, str string, nocase bool, skipLongerMatches *bool, nesting int) bool {
+	if nesting > maxNesting {
+		return false
+	}
+	for len(pattern) > 0 && len(str) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for len(str) > 0 {
+				if match(pattern[1:], str, nocase, skipLongerMatches, nesting+1) {
+					return true
+				}
+				if *skipLongerMatches {
+					// the rest of pattern can not match a shorter string either
+					return false
+				}
+				str = str[1:]
+			}
+			*skipLongerMatches = true
+			return false
+		case '?':
+			str = str[1:]
+		case '[':
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			matched := false
+			for {
+				if len(pattern) > 1 && pattern[0] == '\\' {
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						matched = true
+					}
+				} else if len(pattern) == 0 {
+					// unterminated class, the last char is treated as ']'
+					break
+				} else if pattern[0] == ']' {
+					break
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end := pattern[0], pattern[2]
+					c := str[0]
+					if start > end {
+						start, end = end, start
+					}
+					if nocase {
+						start, end, c = lower(start), lower(end), lower(c)
+					}
+					pattern = pattern[2:]
+					if c >= start && c <= end {
+						matched = true
+					}
+				} else if nocase && lower(pattern[0]) == lower(str[0]) {
+					matched = true
+				} else if pattern[0] == str[0] {
+					matched = true
+				}
+				pattern = pattern[1:]
+			}
+			if not {
+				matched = !matched
+			}
+			if !matched {
+				return false
+			}
+			str = str[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if nocase {
+				if lower(pattern[0]) != lower(str[0]) {
+					return false
+				}
+			} else if pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		if len(pattern) == 0 {
+			// an unterminated class consumed the whole pattern
+			break
+		}
+		pattern = pattern[1:]
+		if len(str) == 0 {
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			break
+		}
+	}
+	return len(pattern) == 0 && len(str) == 0
+}
@@ -0,0 +1,64 @@
+package wildcard
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		want    bool
+	}{
+		{"*", "", false},
+		{"*", "anything", true},
+		{"", "", true},
+		{"", "a", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h[b-a]llo", "hallo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"h[\\]]llo", "h]llo", true},
+		{"user:*:name", "user:42:name", true},
+		{"user:*:name", "user:42:age", false},
+		{"a*b*c", "abbbc", true},
+		{"a*b*c", "acb", false},
+		{"**a", "a", true},
+		{"a*", "a", true},
+		{"a**", "a", true},
+		{"[abc", "a", true},
+		{"[abc", "d", false},
+		{"\\", "\\", true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.str); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.str, got, tt.want)
+		}
+	}
+}
+
+func TestMatchNoCase(t *testing.T) {
+	if !MatchNoCase("H[A-C]llo*", "hbLLO world") {
+		t.Error("expect match ignoring case")
+	}
+	if Match("H[A-C]llo*", "hbLLO world") {
+		t.Error("expect no match with case")
+	}
+}
+
+func TestMatchLongPattern(t *testing.T) {
+	// exponential patterns must be cut off instead of hanging
+	pattern := "a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*b"
+	str := "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	if Match(pattern, str) {
+		t.Error("expect no match")
+	}
+}